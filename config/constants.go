@@ -5,10 +5,12 @@
 package config
 
 import (
+	"fmt"
 	"net/url"
 	"os"
 	"reflect"
 	"text/template"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/kelseyhightower/envconfig"
@@ -47,6 +49,8 @@ type constants struct {
 	DbUsername string `envconfig:"DATABASE_USERNAME" default:"tf2stadium" doc:"Database username"`
 	DbPassword string `envconfig:"DATABASE_PASSWORD" default:"dickbutt" doc:"Database password"`
 
+	AutoMigrate bool `envconfig:"AUTO_MIGRATE" default:"true" doc:"Create tables and apply pending migrations on startup"`
+
 	SteamDevAPIKey string `envconfig:"STEAM_API_KEY" doc:"Steam API Key"`
 
 	ProfilerAddr string `envconfig:"PROFILER_ADDR" doc:"Address to serve the web-based profiler over"`
@@ -58,11 +62,30 @@ type constants struct {
 	Environment        string   `envconfig:"DEPLOYED_ENV" default:"development" doc:"Deployment environment"`
 	TwitchClientID     string   `envconfig:"TWITCH_CLIENT_ID" doc:"Twitch API Client ID"`
 	TwitchClientSecret string   `envconfig:"TWITCH_CLIENT_SECRET" doc:"Twitch API Client Secret"`
+	TFTVStreamStatus   bool     `envconfig:"TFTV_STREAM_STATUS" default:"false" doc:"Announce in the global chat when twitch.tv/teamfortresstv goes live"`
 	ServemeAPIKey      string   `envconfig:"SERVEME_API_KEY" doc:"serveme.tf API Key"`
 	HealthChecks       bool     `envconfig:"HEALTH_CHECKS" default:"false" doc:"Enable health checks"`
 	SecureCookies      bool     `envconfig:"SECURE_COOKIE" doc:"Enable 'secure' flag on cookies" default:"false"`
 	FilteredWords      []string `envconfig:"FILTERED_WORDS"`
 	DemosFolder        string   `envconfig:"DEMOS_FOLDER" doc:"Folder to store STV demos in" default:"demos"`
+
+	AccessTokenLifetime time.Duration `envconfig:"ACCESS_TOKEN_LIFETIME" default:"15m" doc:"How long auth-jwt access tokens are valid for"`
+	SessionLifetime     time.Duration `envconfig:"SESSION_LIFETIME" default:"720h" doc:"How long an unused login session stays valid for"`
+
+	// running multiple instances
+	Cluster           bool   `envconfig:"CLUSTER" default:"false" doc:"Enable when running multiple instances against the same database and RabbitMQ server"`
+	InstanceID        string `envconfig:"INSTANCE_ID" doc:"Name of this instance, has to be unique in the cluster. Defaults to hostname-pid"`
+	BroadcastExchange string `envconfig:"BROADCAST_EXCHANGE" default:"helen_broadcast" doc:"Name of the fanout exchange over which instances share websocket messages"`
+
+	JobWorkers int `envconfig:"JOB_WORKERS" default:"4" doc:"Number of background jobs run at the same time by this instance"`
+
+	HealthDrainDelay time.Duration `envconfig:"HEALTH_DRAIN_DELAY" default:"5s" doc:"How long /readyz fails on shutdown before websocket connections are closed"`
+
+	// archiving old lobbies and chat
+	RetentionAge            time.Duration `envconfig:"RETENTION_AGE" default:"0" doc:"Archive ended lobbies and global chat older than this, 0 disables archiving"`
+	RetentionDeletedChatAge time.Duration `envconfig:"RETENTION_DELETED_CHAT_AGE" default:"168h" doc:"Purge deleted chat messages older than this"`
+	RetentionInterval       time.Duration `envconfig:"RETENTION_INTERVAL" default:"24h" doc:"How often old lobbies and chat are archived"`
+	RetentionExportDir      string        `envconfig:"RETENTION_EXPORT_DIR" default:"archive" doc:"Folder to write archived lobbies and chat to"`
 }
 
 var Constants = constants{}
@@ -92,6 +115,14 @@ func init() {
 		logrus.Fatal("Couldn't parse HELEN_SERVER_REDIRECT_PATH - ", err)
 	}
 
+	if Constants.InstanceID == "" {
+		hostname, _ := os.Hostname()
+		Constants.InstanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if Constants.Cluster {
+		logrus.Info("Running in cluster mode as instance ", Constants.InstanceID)
+	}
+
 	if Constants.GeoIP {
 		logrus.Info("GeoIP support enabled")
 	}
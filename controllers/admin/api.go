@@ -0,0 +1,436 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/models/gameserver"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/TF2Stadium/Helen/models/player"
+	"golang.org/x/net/xsrftoken"
+)
+
+// The JSON API mirrors the HTML admin pages under /api/admin/v1/. Every
+// endpoint is wrapped with chelpers.FilterAPIRequest, and responds with
+// {"success": true, "data": ...} or {"success": false, "message": ...}.
+
+type apiPlayer struct {
+	SteamID string `json:"steamid"`
+	Name    string `json:"name"`
+}
+
+func newAPIPlayer(p player.Player) *apiPlayer {
+	if p.ID == 0 {
+		return nil
+	}
+	return &apiPlayer{p.SteamID, p.Name}
+}
+
+type apiBan struct {
+	ID        uint       `json:"id"`
+	Player    *apiPlayer `json:"player"`
+	Type      string     `json:"type"`
+	Reason    string     `json:"reason"`
+	Active    bool       `json:"active"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Until     time.Time  `json:"until"`
+	BannedBy  *apiPlayer `json:"bannedBy"` // null for automatic bans
+}
+
+type apiChatMessage struct {
+	ID        uint       `json:"id"`
+	Player    *apiPlayer `json:"player"`
+	Room      int        `json:"room"`
+	Message   string     `json:"message"`
+	Deleted   bool       `json:"deleted"`
+	Bot       bool       `json:"bot"`
+	InGame    bool       `json:"ingame"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+type apiServer struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Used    bool   `json:"used"`
+}
+
+type apiLobby struct {
+	ID           uint      `json:"id"`
+	Map          string    `json:"map"`
+	Type         string    `json:"type"`
+	Server       string    `json:"server"`
+	RconPassword string    `json:"rconPassword"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type apiJob struct {
+	ID          uint      `json:"id"`
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	State       string    `json:"state"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"maxAttempts"`
+	RunAt       time.Time `json:"runAt"`
+	LockedBy    string    `json:"lockedBy"` // instance running the job
+	LastError   string    `json:"lastError"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// APIGetXSRFToken returns the XSRF token required for cookie-authenticated
+// POST requests to the API.
+func APIGetXSRFToken(w http.ResponseWriter, r *http.Request) {
+	chelpers.WriteJSON(w, http.StatusOK, map[string]string{
+		"token": xsrftoken.Generate(config.Constants.CookieStoreSecret, "admin", "POST"),
+	})
+}
+
+func APIBanPlayer(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		SteamID string    `json:"steamid"`
+		Type    string    `json:"type"`
+		Reason  string    `json:"reason"`
+		Until   time.Time `json:"until"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	ban, ok := banTypes[args.Type]
+	if !ok {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "Invalid ban type")
+		return
+	}
+	if args.Until.Before(time.Now()) {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "invalid time")
+		return
+	}
+
+	player, err := player.GetPlayerBySteamID(args.SteamID)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	token, _ := chelpers.GetToken(r)
+	bannedByPlayer := chelpers.GetPlayer(token)
+
+	err = player.BanUntil(args.Until, ban, args.Reason, bannedByPlayer.ID)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	afterBan(player, ban)
+
+	chelpers.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"player": newAPIPlayer(*player),
+		"type":   ban.String(),
+		"until":  args.Until,
+	})
+}
+
+func APIUnbanPlayer(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		SteamID string `json:"steamid"`
+		Type    string `json:"type"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	ban, ok := banTypes[args.Type]
+	if !ok {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "Invalid ban type")
+		return
+	}
+
+	player, err := player.GetPlayerBySteamID(args.SteamID)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	err = player.Unban(ban)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	chelpers.ForceReauth(player.SteamID, 0)
+
+	chelpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"player": newAPIPlayer(*player),
+		"type":   ban.String(),
+	})
+}
+
+func APIGetBanLogs(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	all, err := parseAll(values)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bans, err := getBans(values.Get("steamid"), all)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	resp := make([]apiBan, len(bans))
+	for i, ban := range bans {
+		resp[i] = apiBan{
+			ID:        ban.ID,
+			Player:    newAPIPlayer(ban.Player),
+			Type:      ban.Type.String(),
+			Reason:    ban.Reason,
+			Active:    ban.Active,
+			CreatedAt: ban.CreatedAt,
+			Until:     ban.Until,
+			BannedBy:  newAPIPlayer(ban.BannedByPlayer),
+		}
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, resp)
+}
+
+func APIGetChatLogs(w http.ResponseWriter, r *http.Request) {
+	messages, status, err := queryChatLogs(r.URL.Query(), "Descending")
+	if err != nil {
+		chelpers.WriteJSONError(w, status, err.Error())
+		return
+	}
+
+	resp := make([]apiChatMessage, len(messages))
+	for i, message := range messages {
+		resp[i] = apiChatMessage{
+			ID:        message.ID,
+			Room:      message.Room,
+			Message:   message.Message,
+			Deleted:   message.Deleted,
+			Bot:       message.Bot,
+			InGame:    message.InGame,
+			Timestamp: message.CreatedAt,
+		}
+		if !message.Bot {
+			resp[i].Player = newAPIPlayer(message.Player)
+		}
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, resp)
+}
+
+func APIChangeRole(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		SteamID string `json:"steamid"`
+		Role    string `json:"role"`
+		Remove  bool   `json:"remove"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	role, ok := roleTypes[args.Role]
+	if !ok {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "invalid role")
+		return
+	}
+
+	player, err := player.GetPlayerBySteamID(args.SteamID)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if args.Remove {
+		player.Role = helpers.RolePlayer
+	} else {
+		player.Role = role
+	}
+	player.Save()
+	chelpers.ForceReauth(player.SteamID, 0)
+
+	chelpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"player": newAPIPlayer(*player),
+		"role":   helpers.RoleNames[player.Role],
+	})
+}
+
+func APIGetServers(w http.ResponseWriter, r *http.Request) {
+	servers := gameserver.GetAllStoredServers()
+	resp := make([]apiServer, len(servers))
+	for i, server := range servers {
+		resp[i] = apiServer{server.ID, server.Name, server.Address, server.Used}
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, resp)
+}
+
+func APIAddServer(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		Name     string `json:"name"`
+		Address  string `json:"address"`
+		Password string `json:"password"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	switch {
+	case args.Name == "":
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "Empty name not allowed")
+		return
+	case args.Address == "":
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "Empty address not allowed")
+		return
+	case args.Password == "":
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "Empty password not allowed")
+		return
+	}
+
+	server, err := gameserver.NewStoredServer(args.Name, args.Address, args.Password)
+	if err == gameserver.ErrServerAlreadyExists {
+		chelpers.WriteJSONError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusCreated, apiServer{server.ID, server.Name, server.Address, server.Used})
+}
+
+func APIRemoveServer(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		Address string `json:"address"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	if args.Address == "" {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "Empty address not allowed")
+		return
+	}
+
+	gameserver.RemoveStoredServer(args.Address)
+	chelpers.WriteJSON(w, http.StatusOK, map[string]string{"address": args.Address})
+}
+
+func APIGetLobbies(w http.ResponseWriter, r *http.Request) {
+	lobbies := getLobbiesInProgress()
+	resp := make([]apiLobby, len(lobbies))
+	for i, lob := range lobbies {
+		resp[i] = apiLobby{
+			ID:           lob.ID,
+			Map:          lob.MapName,
+			Type:         format.FriendlyName(lob.Type),
+			Server:       lob.ServerInfo.Host,
+			RconPassword: lob.ServerInfo.RconPassword,
+			CreatedAt:    lob.CreatedAt,
+		}
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, resp)
+}
+
+func APIGetAPITokens(w http.ResponseWriter, r *http.Request) {
+	player, err := player.GetPlayerBySteamID(r.URL.Query().Get("steamid"))
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	tokens, err := player.GetAPITokens()
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, tokens)
+}
+
+func APIRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		ID uint `json:"id"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	err := player.RevokeAPIToken(args.ID)
+	if err == player.ErrAPITokenNotFound {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, map[string]uint{"id": args.ID})
+}
+
+func APIGetSessions(w http.ResponseWriter, r *http.Request) {
+	player, err := player.GetPlayerBySteamID(r.URL.Query().Get("steamid"))
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	sessions, err := player.GetSessions()
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, sessions)
+}
+
+// APIRevokeSessions revokes one of the player's sessions, or all of them if
+// no ID is given.
+func APIRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		SteamID string `json:"steamid"`
+		ID      uint   `json:"id"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	player, err := player.GetPlayerBySteamID(args.SteamID)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if args.ID == 0 {
+		err = player.RevokeAllSessions()
+	} else {
+		err = player.RevokeSession(args.ID)
+	}
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	chelpers.ForceReauth(player.SteamID, args.ID)
+	chelpers.WriteJSON(w, http.StatusOK, struct{}{})
+}
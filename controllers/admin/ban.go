@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -19,6 +21,14 @@ import (
 
 var banlogsTempl *template.Template
 
+var banTypes = map[string]player.BanType{
+	"joinLobby":       player.BanJoin,
+	"joinMumbleLobby": player.BanJoinMumble,
+	"createLobby":     player.BanCreate,
+	"chat":            player.BanChat,
+	"full":            player.BanFull,
+}
+
 func BanPlayer(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -36,13 +46,7 @@ func BanPlayer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ban, ok := map[string]player.BanType{
-		"joinLobby":       player.BanJoin,
-		"joinMumbleLobby": player.BanJoinMumble,
-		"createLobby":     player.BanCreate,
-		"chat":            player.BanChat,
-		"full":            player.BanFull,
-	}[banType]
+	ban, ok := banTypes[banType]
 	if !ok {
 		http.Error(w, "Invalid ban type", http.StatusBadRequest)
 		return
@@ -59,6 +63,7 @@ func BanPlayer(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		} else {
+			chelpers.ForceReauth(player.SteamID, 0)
 			fmt.Fprintf(w, "Player %s (%s) has been unbanned (%s)", player.Name, player.SteamID, ban.String())
 		}
 		return
@@ -81,6 +86,7 @@ func BanPlayer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	afterBan(player, ban)
 
 	fmt.Fprintf(w, "Player %s (%s) has been banned (%s) till %v", player.Name, player.SteamID, ban.String(), until)
 }
@@ -92,35 +98,62 @@ func GetBanLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var bans []*player.PlayerBan
+	all, err := parseAll(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	all := values.Get("all")
+	bans, err := getBans(values.Get("steamid"), all)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 
-	steamid := values.Get("steamid")
-	if steamid == "" {
-		if all == "" {
-			bans = player.GetAllActiveBans()
-		} else {
-			bans = player.GetAllBans()
-		}
+	err = banlogsTempl.Execute(w, bans)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
 
-	} else {
-		player, err := player.GetPlayerBySteamID(steamid)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
+// afterBan logs out fully banned players everywhere, and makes the banned
+// player's sockets re-authenticate so the ban applies to them immediately.
+func afterBan(p *player.Player, ban player.BanType) {
+	if ban == player.BanFull {
+		if err := p.RevokeAllSessions(); err != nil {
+			logrus.Error(err)
 		}
+	}
+	chelpers.ForceReauth(p.SteamID, 0)
+}
 
-		if all == "" {
-			bans, _ = player.GetActiveBans()
-		} else {
-			bans, _ = player.GetAllBans()
-		}
+// getBans returns the active bans of the player with the given steamid, or of
+// all players if steamid is empty. If all is true, inactive and expired bans
+// are included.
+// parseAll parses the all query parameter of the ban logs, which is false if
+// it isn't given
+func parseAll(values url.Values) (bool, error) {
+	if values.Get("all") == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(values.Get("all"))
+}
 
+func getBans(steamid string, all bool) ([]*player.PlayerBan, error) {
+	if steamid == "" {
+		if all {
+			return player.GetAllBans(), nil
+		}
+		return player.GetAllActiveBans(), nil
 	}
 
-	err := banlogsTempl.Execute(w, bans)
+	player, err := player.GetPlayerBySteamID(steamid)
 	if err != nil {
-		logrus.Error(err)
+		return nil, err
+	}
+
+	if all {
+		return player.GetAllBans()
 	}
+	return player.GetActiveBans()
 }
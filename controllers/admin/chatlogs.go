@@ -5,9 +5,11 @@
 package admin
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"time"
@@ -30,13 +32,30 @@ func getPlayerID(steamID string) (playerID uint) {
 }
 
 func GetChatLogs(w http.ResponseWriter, r *http.Request) {
+	// the page doesn't show any messages until an order is picked
+	messages, status, err := queryChatLogs(r.URL.Query(), "")
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	err = chatLogsTempl.Execute(w, messages)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// queryChatLogs fetches the chat messages matching the room, steamid, from, to
+// and order query parameters. defaultOrder is used when order isn't given,
+// no messages are fetched if neither is "Ascending" or "Descending". On
+// failure, it also returns the HTTP status code that should be sent to the
+// client.
+func queryChatLogs(values url.Values, defaultOrder string) ([]*chat.ChatMessage, int, error) {
 	var messages []*chat.ChatMessage
-	values := r.URL.Query()
 
 	room, err := strconv.Atoi(values.Get("room"))
 	if err != nil && values.Get("room") != "" {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, http.StatusBadRequest, err
 	}
 
 	steamID := values.Get("steamid")
@@ -45,8 +64,7 @@ func GetChatLogs(w http.ResponseWriter, r *http.Request) {
 	if values.Get("from") != "" { //2006-01-02
 		from, err = time.Parse("2006-01-02", values.Get("from"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return nil, http.StatusBadRequest, err
 		}
 	} else {
 		from = time.Time{}
@@ -55,26 +73,22 @@ func GetChatLogs(w http.ResponseWriter, r *http.Request) {
 	if values.Get("to") != "" {
 		to, err = time.Parse("2006-01-02", values.Get("to"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return nil, http.StatusBadRequest, err
 		}
 	} else {
 		to = time.Now()
 	}
 
-	order := values.Get("order")
 	var results *gorm.DB
 
 	if values.Get("room") == "" { //Retrieve all messages sent by a specific player
 		if steamID == "" {
-			http.Error(w, "No Steam ID given.", http.StatusBadRequest)
-			return
+			return nil, http.StatusBadRequest, errors.New("No Steam ID given.")
 		}
 
 		playerID := getPlayerID(steamID)
 		if playerID == 0 {
-			http.Error(w, fmt.Sprintf("Couldn't find player with Steam ID %s", steamID), http.StatusNotFound)
-			return
+			return nil, http.StatusNotFound, fmt.Errorf("Couldn't find player with Steam ID %s", steamID)
 		}
 
 		results = db.DB.Preload("Player").Where("player_id = ? AND room = ? AND created_at >= ? AND created_at <= ?", playerID, room, from, to)
@@ -83,13 +97,16 @@ func GetChatLogs(w http.ResponseWriter, r *http.Request) {
 	} else { //Retrieve all messages sent to a specific room and a speficic player
 		playerID := getPlayerID(steamID)
 		if playerID == 0 {
-			http.Error(w, fmt.Sprintf("Couldn't find player with Steam ID %s", steamID), http.StatusNotFound)
-			return
+			return nil, http.StatusNotFound, fmt.Errorf("Couldn't find player with Steam ID %s", steamID)
 		}
 
 		results = db.DB.Preload("Player").Where("player_id = ? AND room = ? AND created_at >= ? AND created_at <= ?", playerID, room, from, to)
 	}
 
+	order := values.Get("order")
+	if order == "" {
+		order = defaultOrder
+	}
 	if order == "Ascending" {
 		err = results.Order("id").Find(&messages).Error
 	} else if order == "Descending" {
@@ -97,12 +114,8 @@ func GetChatLogs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, http.StatusBadRequest, err
 	}
 
-	err = chatLogsTempl.Execute(w, messages)
-	if err != nil {
-		logrus.Error(err)
-	}
+	return messages, http.StatusOK, nil
 }
@@ -0,0 +1,146 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package admin
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/TF2Stadium/Helen/config"
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/models/job"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/xsrftoken"
+)
+
+var jobsTempl *template.Template
+
+func ViewJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := job.GetJobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = jobsTempl.Execute(w, map[string]interface{}{
+		"Jobs":      jobs,
+		"XSRFToken": xsrftoken.Generate(config.Constants.CookieStoreSecret, "admin", "POST"),
+	})
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// jobForm returns the ID of the job in a retry or delete form
+func jobForm(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	r.ParseForm()
+
+	if !xsrftoken.Valid(r.Form.Get("xsrf-token"), config.Constants.CookieStoreSecret, "admin", "POST") {
+		http.Error(w, "invalid xsrf token", http.StatusBadRequest)
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(r.Form.Get("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid job ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+func RetryJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobForm(w, r)
+	if !ok {
+		return
+	}
+
+	if err := job.Retry(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "Job #%d will be retried.", id)
+}
+
+func DeleteJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobForm(w, r)
+	if !ok {
+		return
+	}
+
+	if err := job.Delete(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "Job #%d deleted.", id)
+}
+
+func APIGetJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := job.GetJobs()
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := make([]apiJob, len(jobs))
+	for i, j := range jobs {
+		resp[i] = apiJob{
+			ID:          j.ID,
+			Kind:        j.Kind,
+			Key:         j.UniqueKey,
+			State:       string(j.State),
+			Attempts:    j.Attempts,
+			MaxAttempts: j.MaxAttempts,
+			RunAt:       j.RunAt,
+			LockedBy:    j.LockedBy,
+			LastError:   j.LastError,
+			CreatedAt:   j.CreatedAt,
+		}
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, resp)
+}
+
+func APIRetryJob(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		ID uint `json:"id"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	err := job.Retry(args.ID)
+	if err == job.ErrNotFound {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		chelpers.WriteJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, map[string]uint{"id": args.ID})
+}
+
+func APIDeleteJob(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		ID uint `json:"id"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	err := job.Delete(args.ID)
+	if err == job.ErrNotFound {
+		chelpers.WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, map[string]uint{"id": args.ID})
+}
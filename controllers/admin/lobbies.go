@@ -13,13 +13,17 @@ import (
 var lobbiesTempl *template.Template
 
 func ViewOpenLobbies(w http.ResponseWriter, r *http.Request) {
-	var lobbies []*lobby.Lobby
-	db.DB.Model(&lobby.Lobby{}).Preload("ServerInfo").Where("state = ?", lobby.InProgress).Find(&lobbies)
 	err := lobbiesTempl.Execute(w, map[string]interface{}{
-		"Lobbies":     lobbies,
+		"Lobbies":     getLobbiesInProgress(),
 		"FrontendURL": config.Constants.LoginRedirectPath,
 	})
 	if err != nil {
 		logrus.Error(err)
 	}
 }
+
+func getLobbiesInProgress() []*lobby.Lobby {
+	var lobbies []*lobby.Lobby
+	db.DB.Model(&lobby.Lobby{}).Preload("ServerInfo").Where("state = ?", lobby.InProgress).Find(&lobbies)
+	return lobbies
+}
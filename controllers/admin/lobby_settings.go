@@ -0,0 +1,157 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/TF2Stadium/Helen/config"
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/models/lobby_settings"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/xsrftoken"
+)
+
+var lobbySettingsTempl *template.Template
+
+func ViewLobbySettings(w http.ResponseWriter, r *http.Request) {
+	doc, err := lobbySettings.GetDocument()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, _ := json.MarshalIndent(doc, "", "\t")
+
+	err = lobbySettingsTempl.Execute(w, map[string]interface{}{
+		"Settings":  string(data),
+		"XSRFToken": xsrftoken.Generate(config.Constants.CookieStoreSecret, "admin", "POST"),
+	})
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// SaveLobbySettings replaces the lobby settings with the ones edited on the
+// settings page
+func SaveLobbySettings(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	if !xsrftoken.Valid(r.Form.Get("xsrf-token"), config.Constants.CookieStoreSecret, "admin", "POST") {
+		http.Error(w, "invalid xsrf token", http.StatusBadRequest)
+		return
+	}
+
+	var newDoc lobbySettings.Document
+	if err := json.Unmarshal([]byte(r.Form.Get("settings")), &newDoc); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, _ := chelpers.GetToken(r)
+	err := lobbySettings.Update(chelpers.GetPlayer(token).ID, func(doc *lobbySettings.Document) error {
+		*doc = newDoc
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/lobbysettings", http.StatusSeeOther)
+}
+
+func APIGetLobbySettings(w http.ResponseWriter, r *http.Request) {
+	doc, err := lobbySettings.GetDocument()
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, doc)
+}
+
+// APIPutLobbySetting adds a format, map, league or whitelist, or replaces the
+// one with the same name (or ID, for whitelists)
+func APIPutLobbySetting(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		Kind  string          `json:"kind"` // format, map, league or whitelist
+		Value json.RawMessage `json:"value"`
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	token, _ := chelpers.GetToken(r)
+	err := lobbySettings.Update(chelpers.GetPlayer(token).ID, func(doc *lobbySettings.Document) error {
+		var err error
+
+		switch args.Kind {
+		case "format":
+			var f lobbySettings.FormatData
+			if err = json.Unmarshal(args.Value, &f); err == nil {
+				doc.PutFormat(f)
+			}
+		case "map":
+			var m lobbySettings.MapData
+			if err = json.Unmarshal(args.Value, &m); err == nil {
+				doc.PutMap(m)
+			}
+		case "league":
+			var l lobbySettings.LeagueData
+			if err = json.Unmarshal(args.Value, &l); err == nil {
+				doc.PutLeague(l)
+			}
+		case "whitelist":
+			var wl lobbySettings.WhitelistData
+			if err = json.Unmarshal(args.Value, &wl); err == nil {
+				doc.PutWhitelist(wl)
+			}
+		default:
+			err = errors.New("unknown kind")
+		}
+		return err
+	})
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, args)
+}
+
+func APIDeleteLobbySetting(w http.ResponseWriter, r *http.Request) {
+	var args struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"` // for formats, maps and leagues
+		ID   int    `json:"id"`   // for whitelists
+	}
+	if !decodeBody(w, r, &args) {
+		return
+	}
+
+	token, _ := chelpers.GetToken(r)
+	err := lobbySettings.Update(chelpers.GetPlayer(token).ID, func(doc *lobbySettings.Document) error {
+		switch args.Kind {
+		case "format":
+			return doc.DeleteFormat(args.Name)
+		case "map":
+			return doc.DeleteMap(args.Name)
+		case "league":
+			return doc.DeleteLeague(args.Name)
+		case "whitelist":
+			return doc.DeleteWhitelist(args.ID)
+		}
+		return errors.New("unknown kind")
+	})
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chelpers.WriteJSON(w, http.StatusOK, args)
+}
@@ -9,12 +9,18 @@ import (
 	"net/http"
 
 	"github.com/TF2Stadium/Helen/config"
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
 	"github.com/TF2Stadium/Helen/helpers"
 	"github.com/TF2Stadium/Helen/helpers/authority"
 	"github.com/TF2Stadium/Helen/models/player"
 	"golang.org/x/net/xsrftoken"
 )
 
+var roleTypes = map[string]authority.AuthRole{
+	"admin": helpers.RoleAdmin,
+	"mod":   helpers.RoleMod,
+}
+
 func ChangeRole(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -31,10 +37,7 @@ func ChangeRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	role, ok := map[string]authority.AuthRole{
-		"admin": helpers.RoleAdmin,
-		"mod":   helpers.RoleMod,
-	}[values.Get("role")]
+	role, ok := roleTypes[values.Get("role")]
 	if !ok {
 		http.Error(w, "invalid role", http.StatusBadRequest)
 		return
@@ -49,12 +52,14 @@ func ChangeRole(w http.ResponseWriter, r *http.Request) {
 	if remove == "true" {
 		player.Role = 0
 		player.Save()
+		chelpers.ForceReauth(player.SteamID, 0)
 		fmt.Fprintf(w, "Player %s (%s) has been removed as %s", player.Name, player.SteamID, helpers.RoleNames[role])
 		return
 	}
 
 	player.Role = role
 	player.Save()
+	chelpers.ForceReauth(player.SteamID, 0)
 	fmt.Fprintf(w, "Player %s (%s) has been made a %s", player.Name, player.SteamID, helpers.RoleNames[role])
 	return
 }
@@ -75,5 +80,6 @@ func Remove(w http.ResponseWriter, r *http.Request) {
 
 	player.Role = authority.AuthRole(0)
 	player.Save()
+	chelpers.ForceReauth(player.SteamID, 0)
 	fmt.Fprintf(w, "%s (%s) is no longer an admin/mod", player.Name, player.SteamID)
 }
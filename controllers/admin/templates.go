@@ -9,5 +9,7 @@ func InitAdminTemplates() {
 	banlogsTempl = template.Must(template.ParseFiles("views/admin/templates/ban_logs.html"))
 	chatLogsTempl = template.Must(template.ParseFiles("views/admin/templates/chatlogs.html"))
 	lobbiesTempl = template.Must(template.ParseFiles("views/admin/templates/lobbies.html"))
+	jobsTempl = template.Must(template.ParseFiles("views/admin/templates/jobs.html"))
+	lobbySettingsTempl = template.Must(template.ParseFiles("views/admin/templates/lobby_settings.html"))
 	adminPageTempl = template.Must(template.ParseFiles("views/admin/index.html"))
 }
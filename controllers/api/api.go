@@ -0,0 +1,126 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+// Package api implements the public, read-only REST API under /api/v1/.
+// Endpoints don't need authentication, and respond with the same
+// {"success": ..., "data": ...} envelope as the socket API. Private lobbies
+// are only shown to players who send an API token (or JWT) as a bearer token,
+// and can see them. The response
+// schema is documented in API.md, and only changes incompatibly with a new
+// version prefix.
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/models/player"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+
+	// how long clients and proxies can cache responses for
+	lobbyMaxAge  = 5 * time.Second
+	playerMaxAge = time.Minute
+)
+
+// page is a page of an offset-paginated list
+type page struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// cursorPage is a page of a list paginated by ID. Next is the value to pass as
+// ?before= to get the next page, and is omitted on the last page.
+type cursorPage struct {
+	Items interface{} `json:"items"`
+	Limit int         `json:"limit"`
+	Next  uint        `json:"next,omitempty"`
+}
+
+// checkRequest sets headers common to all API responses, and writes an error
+// if the request isn't a GET or HEAD request.
+func checkRequest(w http.ResponseWriter, r *http.Request) bool {
+	// the API doesn't use cookies, so any site can read it
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		chelpers.WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+
+	return true
+}
+
+// requestPlayer returns the player whose token was sent in the Authorization
+// header, or nil. Cookies aren't used, since any site can read the API.
+func requestPlayer(r *http.Request) *player.Player {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return nil
+	}
+	token, err := chelpers.GetToken(r)
+	if err != nil || !token.Valid {
+		return nil
+	}
+	return chelpers.GetPlayer(token)
+}
+
+// writeJSON writes responses which depend on the player making the request
+// without letting proxies cache them
+func writeJSON(w http.ResponseWriter, r *http.Request, maxAge time.Duration, p *player.Player, data interface{}) {
+	if p != nil {
+		w.Header().Set("Cache-Control", "private, no-store")
+		chelpers.WriteJSON(w, http.StatusOK, data)
+		return
+	}
+	chelpers.WriteCachedJSON(w, r, maxAge, data)
+}
+
+// queryInt returns the integer query parameter with the given name, or def if
+// it isn't set. Negative values are rejected.
+func queryInt(r *http.Request, name string, def int) (int, bool) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, true
+	}
+
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// getLimit returns the ?limit= parameter, writing an error if it's invalid
+func getLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit, ok := queryInt(r, "limit", defaultLimit)
+	if !ok || limit == 0 || limit > maxLimit {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLimit))
+		return 0, false
+	}
+	return limit, true
+}
+
+// getPagination returns the ?limit= and ?offset= parameters, writing an error
+// if either is invalid
+func getPagination(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	limit, ok = getLimit(w, r)
+	if !ok {
+		return
+	}
+
+	offset, ok = queryInt(r, "offset", 0)
+	if !ok {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+	}
+	return
+}
@@ -0,0 +1,328 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/sirupsen/logrus"
+)
+
+// GET /api/v1/events streams the lobby list and the subListData messages sent
+// to websocket clients in the public room, as Server-Sent Events. Instead of
+// lobbyListDiff messages, SSE clients are sent the entire lobby list (as
+// lobbyListData), taken from the in-memory copy the diffs are computed from.
+//
+// Both events carry the complete list, so a client only ever needs the
+// newest one of each. On connecting, clients are sent the current lists,
+// and on reconnecting (with Last-Event-ID) only the lists that changed while
+// they were away.
+
+const (
+	publicRoom        = "0_public"
+	heartbeatInterval = 15 * time.Second
+	reconnectDelay    = 5 * time.Second
+	clientBufferSize  = 16
+)
+
+var streamedEvents = []string{"lobbyListData", "subListData"}
+
+type sseEvent struct {
+	id   uint64
+	name string
+	data interface{}
+	raw  []byte // data, marshalled once for unfiltered clients
+}
+
+type sseClient struct {
+	events chan *sseEvent
+	done   chan struct{} // closed when the client is dropped for falling behind
+}
+
+type eventHub struct {
+	mu      sync.Mutex
+	epoch   string // differs between restarts and instances, so stale Last-Event-IDs can be detected
+	seq     uint64
+	latest  map[string]*sseEvent
+	clients map[*sseClient]bool
+}
+
+var events = &eventHub{
+	epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+	latest:  make(map[string]*sseEvent),
+	clients: make(map[*sseClient]bool),
+}
+
+func init() {
+	broadcaster.ListenRoom(publicRoom, func(name string, data interface{}) {
+		switch name {
+		case "lobbyListDiff":
+			name, data = "lobbyListData", lobby.GetLobbyListSnapshot().Lobbies
+		case "subListData":
+			// sent by another instance, decode it so it can be filtered
+			if raw, ok := data.(json.RawMessage); ok {
+				var subs []lobby.SubstituteData
+				if err := json.Unmarshal(raw, &subs); err != nil {
+					logrus.Error(err)
+					return
+				}
+				data = subs
+			}
+		}
+		events.publish(name, data)
+	})
+}
+
+func (h *eventHub) eventID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", h.epoch, seq)
+}
+
+// parseEventID returns the sequence number from an event ID sent by the
+// client, or false if it wasn't issued by this instance.
+func (h *eventHub) parseEventID(id string) (uint64, bool) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 || parts[0] != h.epoch {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	return seq, err == nil
+}
+
+func isStreamed(name string) bool {
+	for _, e := range streamedEvents {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *eventHub) publish(name string, data interface{}) {
+	if !isStreamed(name) {
+		return
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e := &sseEvent{id: h.seq, name: name, data: data, raw: raw}
+	h.latest[name] = e
+
+	for c := range h.clients {
+		select {
+		case c.events <- e:
+		default:
+			// the client can't keep up, it'll catch up after reconnecting
+			close(c.done)
+			delete(h.clients, c)
+		}
+	}
+}
+
+// subscribe registers a new client, returning it along with the events it
+// has missed since lastID, or all current lists if lastID is empty or stale.
+func (h *eventHub) subscribe(lastID string) (*sseClient, []*sseEvent) {
+	c := &sseClient{
+		events: make(chan *sseEvent, clientBufferSize),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.clients[c] = true
+	since, ok := h.parseEventID(lastID)
+	if !ok || since > h.seq {
+		since = 0
+	}
+
+	var missed []*sseEvent
+	var load []string // lists which haven't been broadcast since startup
+	for _, name := range streamedEvents {
+		e, ok := h.latest[name]
+		switch {
+		case !ok && since == 0:
+			load = append(load, name)
+		case ok && (since == 0 || e.id > since):
+			missed = append(missed, e)
+		}
+	}
+	seq := h.seq
+	h.mu.Unlock()
+
+	for _, name := range load {
+		missed = append(missed, &sseEvent{id: seq, name: name, data: currentList(name)})
+	}
+
+	sort.Sort(byID(missed))
+	return c, missed
+}
+
+func (h *eventHub) unsubscribe(c *sseClient) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+}
+
+func currentList(name string) interface{} {
+	switch name {
+	case "lobbyListData":
+		return lobby.GetLobbyListSnapshot().Lobbies
+	case "subListData":
+		return lobby.DecorateSubstituteList()
+	}
+	return nil
+}
+
+type byID []*sseEvent
+
+func (e byID) Len() int           { return len(e) }
+func (e byID) Less(i, j int) bool { return e[i].id < e[j].id }
+func (e byID) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// eventFilter restricts the lobbies and substitutes sent to a client to the
+// given regions and formats. Empty sets match everything.
+type eventFilter struct {
+	regions map[string]bool
+	formats map[string]bool
+}
+
+func parseSet(str string) map[string]bool {
+	if str == "" {
+		return nil
+	}
+
+	set := make(map[string]bool)
+	for _, s := range strings.Split(str, ",") {
+		set[strings.ToLower(strings.TrimSpace(s))] = true
+	}
+	return set
+}
+
+func (f eventFilter) empty() bool {
+	return f.regions == nil && f.formats == nil
+}
+
+func (f eventFilter) match(region, format string) bool {
+	return (f.regions == nil || f.regions[strings.ToLower(region)]) &&
+		(f.formats == nil || f.formats[strings.ToLower(format)])
+}
+
+func (f eventFilter) apply(data interface{}) interface{} {
+	switch data := data.(type) {
+	case []lobby.LobbyData:
+		filtered := []lobby.LobbyData{}
+		for _, l := range data {
+			if f.match(l.Region.Code, l.Type) {
+				filtered = append(filtered, l)
+			}
+		}
+		return filtered
+	case []lobby.SubstituteData:
+		filtered := []lobby.SubstituteData{}
+		for _, s := range data {
+			if f.match(s.Region.Code, s.Format) {
+				filtered = append(filtered, s)
+			}
+		}
+		return filtered
+	}
+	return data
+}
+
+func writeEvent(w http.ResponseWriter, f eventFilter, id string, e *sseEvent) error {
+	data := e.raw
+	if data == nil || !f.empty() {
+		var err error
+		data, err = json.Marshal(f.apply(e.data))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, e.name, data)
+	return err
+}
+
+// GetEvents handles GET /api/v1/events. Lobbies and substitutes can be
+// filtered with ?region= and ?format=, both of which take comma separated
+// lists (like "eu,na" and "6s,highlander").
+func GetEvents(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		chelpers.WriteJSONError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	filter := eventFilter{
+		regions: parseSet(r.URL.Query().Get("region")),
+		formats: parseSet(r.URL.Query().Get("format")),
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		// for EventSource polyfills which can't set headers
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+
+	client, missed := events.subscribe(lastID)
+	defer events.unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay/time.Millisecond)
+	// events replayed on connecting keep their original sequence numbers
+	for _, e := range missed {
+		if err := writeEvent(w, filter, events.eventID(e.id), e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e := <-client.events:
+			if err := writeEvent(w, filter, events.eventID(e.id), e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-client.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
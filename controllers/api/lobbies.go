@@ -0,0 +1,91 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/models/lobby"
+)
+
+// GetLobbies handles GET /api/v1/lobbies, listing lobbies waiting for players,
+// newest first.
+func GetLobbies(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r) {
+		return
+	}
+	limit, offset, ok := getPagination(w, r)
+	if !ok {
+		return
+	}
+
+	lobbies, total := lobby.GetWaitingLobbiesPage(limit, offset)
+	chelpers.WriteCachedJSON(w, r, lobbyMaxAge, page{
+		Items:  lobby.DecorateLobbyListData(lobbies, false),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// GetLobby handles GET /api/v1/lobbies/{id}
+func GetLobby(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/v1/lobbies/"), 10, 32)
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, lobby.ErrLobbyNotFound.Error())
+		return
+	}
+
+	lob, err := lobby.GetLobbyByID(uint(id))
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, lobby.ErrLobbyNotFound.Error())
+		return
+	}
+	// private lobbies don't exist for players who can't see them
+	p := requestPlayer(r)
+	if !lob.CanSee(p) {
+		chelpers.WriteJSONError(w, http.StatusNotFound, lobby.ErrLobbyNotFound.Error())
+		return
+	}
+
+	writeJSON(w, r, lobbyMaxAge, p, lobby.DecorateLobbyData(lob, true))
+}
+
+// GetSubstitutes handles GET /api/v1/substitutes, listing slots in lobbies in
+// progress which need a substitute.
+func GetSubstitutes(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r) {
+		return
+	}
+	limit, offset, ok := getPagination(w, r)
+	if !ok {
+		return
+	}
+
+	subs := lobby.DecorateSubstituteList()
+	total := len(subs)
+	if offset < total {
+		subs = subs[offset:]
+	} else {
+		subs = subs[:0]
+	}
+	if len(subs) > limit {
+		subs = subs[:limit]
+	}
+
+	chelpers.WriteCachedJSON(w, r, lobbyMaxAge, page{
+		Items:  subs,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/player"
+)
+
+// GetPlayer handles both GET /api/v1/players/{steamid} and
+// GET /api/v1/players/{steamid}/lobbies
+func GetPlayer(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/players/"), "/")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "lobbies") {
+		chelpers.WriteJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	p, err := player.GetPlayerBySteamID(parts[0])
+	if err != nil {
+		chelpers.WriteJSONError(w, http.StatusNotFound, player.ErrPlayerNotFound.Error())
+		return
+	}
+
+	if len(parts) == 2 {
+		getPlayerLobbies(w, r, p)
+		return
+	}
+
+	p.SetPlayerProfile()
+	chelpers.WriteCachedJSON(w, r, playerMaxAge, p)
+}
+
+// getPlayerLobbies lists the lobbies the player has played in, newest first.
+// Pages are requested with ?before={id}, using the next field of the previous
+// page.
+func getPlayerLobbies(w http.ResponseWriter, r *http.Request, p *player.Player) {
+	limit, ok := getLimit(w, r)
+	if !ok {
+		return
+	}
+	before, ok := queryInt(r, "before", 0)
+	if !ok {
+		chelpers.WriteJSONError(w, http.StatusBadRequest, "before must be a lobby ID")
+		return
+	}
+
+	lobbies := lobby.GetPlayerRecentLobbies(p.ID, limit, 0, uint(before))
+	resp := cursorPage{Limit: limit}
+	// pages can have less than limit lobbies once the private ones are
+	// filtered out, so the next page starts after the last lobby fetched
+	if len(lobbies) == limit {
+		resp.Next = lobbies[len(lobbies)-1].ID
+	}
+	requester := requestPlayer(r)
+	resp.Items = lobby.DecorateLobbyListData(lobby.FilterVisible(lobbies, requester), true)
+
+	writeJSON(w, r, playerMaxAge, requester, resp)
+}
@@ -5,13 +5,35 @@
 package broadcaster
 
 import (
+	"sync"
+
 	"github.com/TF2Stadium/Helen/controllers/socket/sessions"
 	"github.com/TF2Stadium/Helen/helpers"
 	"github.com/TF2Stadium/Helen/routes/socket"
 	"github.com/TF2Stadium/wsevent"
 )
 
+var (
+	listenersMu sync.RWMutex
+	listeners   = make(map[string][]func(event string, content interface{}))
+)
+
+// ListenRoom registers f to be called with every message sent to the given
+// room, so that they can also be sent to clients not using the websocket.
+// f is called synchronously, and shouldn't block. Messages sent by other
+// instances are passed to f as a json.RawMessage.
+func ListenRoom(room string, f func(event string, content interface{})) {
+	listenersMu.Lock()
+	listeners[room] = append(listeners[room], f)
+	listenersMu.Unlock()
+}
+
 func SendMessage(steamid string, event string, content interface{}) {
+	sendMessage(steamid, event, content)
+	publish(clusterMessage{Kind: kindPlayer, SteamID: steamid, Event: event}, content)
+}
+
+func sendMessage(steamid string, event string, content interface{}) {
 	sockets, ok := sessions.GetSockets(steamid)
 	if !ok {
 		return
@@ -22,18 +44,35 @@ func SendMessage(steamid string, event string, content interface{}) {
 			so.EmitJSON(helpers.NewRequest(event, content))
 		}(socket)
 	}
-
-	return
 }
 
 func SendMessageToRoom(r string, event string, content interface{}) {
+	SendLocalMessageToRoom(r, event, content)
+	publish(clusterMessage{Kind: kindRoom, Room: r, Event: event}, content)
+}
+
+// SendLocalMessageToRoom sends the message only to the room's clients
+// connected to this instance, for messages which every instance sends
+// itself, like lobbyListDiff.
+func SendLocalMessageToRoom(r string, event string, content interface{}) {
 	v := helpers.NewRequest(event, content)
 
 	socket.AuthServer.BroadcastJSON(r, v)
 	socket.UnauthServer.BroadcastJSON(r, v)
+
+	listenersMu.RLock()
+	for _, f := range listeners[r] {
+		f(event, content)
+	}
+	listenersMu.RUnlock()
 }
 
 func SendMessageSkipIDs(skipID, steamid, event string, content interface{}) {
+	sendMessageSkipIDs(skipID, steamid, event, content)
+	publish(clusterMessage{Kind: kindPlayer, SkipID: skipID, SteamID: steamid, Event: event}, content)
+}
+
+func sendMessageSkipIDs(skipID, steamid, event string, content interface{}) {
 	sockets, ok := sessions.GetSockets(steamid)
 	if !ok {
 		return
@@ -45,3 +84,31 @@ func SendMessageSkipIDs(skipID, steamid, event string, content interface{}) {
 		}
 	}
 }
+
+// JoinRoom makes all of the player's sockets, on every instance, join the
+// room.
+func JoinRoom(steamid, room string) {
+	joinRoom(steamid, room)
+	publish(clusterMessage{Kind: kindJoin, SteamID: steamid, Room: room}, nil)
+}
+
+func joinRoom(steamid, room string) {
+	sockets, _ := sessions.GetSockets(steamid)
+	for _, so := range sockets {
+		socket.AuthServer.Join(so, room)
+	}
+}
+
+// LeaveRoom removes all of the player's sockets, on every instance, from
+// the room.
+func LeaveRoom(steamid, room string) {
+	leaveRoom(steamid, room)
+	publish(clusterMessage{Kind: kindLeave, SteamID: steamid, Room: room}, nil)
+}
+
+func leaveRoom(steamid, room string) {
+	sockets, _ := sessions.GetSockets(steamid)
+	for _, so := range sockets {
+		socket.AuthServer.Leave(so, room)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package broadcaster
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// When running multiple instances (HELEN_CLUSTER), every message sent to a
+// player or a room is also published to a fanout exchange, which every
+// instance has its own queue bound to. Instances deliver the messages they
+// receive from the others to their own clients, so a player's tabs can be
+// connected to different instances.
+//
+// Other packages can use Notify to tell the other instances about changes to
+// state they keep in memory, like the cached lobby data.
+
+const (
+	kindPlayer = "player"
+	kindRoom   = "room"
+	kindJoin   = "join"
+	kindLeave  = "leave"
+	kindNotify = "notify"
+)
+
+type clusterMessage struct {
+	Instance string          `json:"instance"`
+	Kind     string          `json:"kind"`
+	SteamID  string          `json:"steamid,omitempty"`
+	SkipID   string          `json:"skipId,omitempty"`
+	Room     string          `json:"room,omitempty"`
+	Event    string          `json:"event,omitempty"`
+	Content  json.RawMessage `json:"content,omitempty"`
+}
+
+var (
+	clusterMu      sync.Mutex // amqp channels can't be published on concurrently
+	clusterChannel *amqp.Channel
+
+	notifyMu       sync.RWMutex
+	notifyHandlers = make(map[string]func(json.RawMessage))
+)
+
+// StartCluster declares the broadcast exchange, and starts publishing
+// messages to and receiving them from the other instances.
+func StartCluster(conn *amqp.Connection) {
+	exchange := config.Constants.BroadcastExchange
+
+	ch, err := conn.Channel()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	err = ch.ExchangeDeclare(exchange, "fanout", true, false, false, false, nil)
+	if err != nil {
+		logrus.Fatal("Cannot declare broadcast exchange ", err)
+	}
+
+	// the queue is deleted when this instance disconnects
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		logrus.Fatal("Cannot declare broadcast queue ", err)
+	}
+
+	err = ch.QueueBind(q.Name, "", exchange, false, nil)
+	if err != nil {
+		logrus.Fatal("Cannot bind broadcast queue ", err)
+	}
+
+	msgs, err := ch.Consume(q.Name, config.Constants.InstanceID, true, true, false, false, nil)
+	if err != nil {
+		logrus.Fatal("Cannot consume broadcast messages ", err)
+	}
+
+	clusterMu.Lock()
+	clusterChannel = ch
+	clusterMu.Unlock()
+
+	go func() {
+		for msg := range msgs {
+			receive(msg.Body)
+		}
+		logrus.Error("Broadcast queue closed, no longer receiving messages from other instances")
+	}()
+
+	logrus.Info("Sharing messages with other instances over ", exchange)
+}
+
+// Notify calls the function registered with HandleNotification for kind on
+// every other instance, with data marshalled to JSON.
+func Notify(kind string, data interface{}) {
+	publish(clusterMessage{Kind: kindNotify, Event: kind}, data)
+}
+
+// HandleNotification registers f to be called when another instance calls
+// Notify with the given kind. f is called in the goroutine receiving
+// messages, so it shouldn't block for long.
+func HandleNotification(kind string, f func(data json.RawMessage)) {
+	notifyMu.Lock()
+	notifyHandlers[kind] = f
+	notifyMu.Unlock()
+}
+
+func publish(msg clusterMessage, content interface{}) {
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+
+	if clusterChannel == nil {
+		return
+	}
+
+	if content != nil {
+		bytes, err := json.Marshal(content)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		msg.Content = bytes
+	}
+	msg.Instance = config.Constants.InstanceID
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	err = clusterChannel.Publish(config.Constants.BroadcastExchange, "", false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Timestamp:   time.Now(),
+		Body:        body,
+	})
+	if err != nil {
+		logrus.Error("Couldn't publish broadcast message: ", err)
+	}
+}
+
+func receive(body []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		logrus.Error(err)
+		return
+	}
+	if msg.Instance == config.Constants.InstanceID {
+		return
+	}
+
+	switch msg.Kind {
+	case kindPlayer:
+		if msg.SkipID != "" {
+			sendMessageSkipIDs(msg.SkipID, msg.SteamID, msg.Event, msg.Content)
+		} else {
+			sendMessage(msg.SteamID, msg.Event, msg.Content)
+		}
+	case kindRoom:
+		// listeners get the message as a json.RawMessage
+		SendLocalMessageToRoom(msg.Room, msg.Event, msg.Content)
+	case kindJoin:
+		joinRoom(msg.SteamID, msg.Room)
+	case kindLeave:
+		leaveRoom(msg.SteamID, msg.Room)
+	case kindNotify:
+		notifyMu.RLock()
+		f, ok := notifyHandlers[msg.Event]
+		notifyMu.RUnlock()
+		if ok {
+			f(msg.Content)
+		}
+	default:
+		logrus.Warning("Unknown broadcast message kind ", msg.Kind)
+	}
+}
@@ -1,7 +1,9 @@
 package controllerhelpers
 
 import (
-	db "github.com/TF2Stadium/Helen/database"
+	"errors"
+	"time"
+
 	"github.com/TF2Stadium/Helen/helpers/authority"
 	"github.com/TF2Stadium/Helen/models/player"
 )
@@ -12,19 +14,53 @@ type TF2StadiumClaims struct {
 	MumblePassword string             `json:"mumble_password"`
 	Role           authority.AuthRole `json:"role"`
 	IssuedAt       int64              `json:"iat"`
+	ExpiresAt      int64              `json:"exp"`
 	Issuer         string             `json:"iss"`
-}
+	SessionID      uint               `json:"sid"` // ID of the login session the token was issued for
 
-func playerExists(id uint, steamID string) bool {
-	var count int
-	db.DB.Model(&player.Player{}).Where("id = ? AND steam_id = ?", id, steamID).Count(&count)
-	return count != 0
+	// set for requests authenticated with an API token, these are
+	// never part of a signed JWT.
+	APITokenID uint                `json:"-"`
+	Scopes     []player.TokenScope `json:"-"`
 }
 
+var (
+	ErrTokenExpired = errors.New("Token has expired")
+	ErrNoSession    = errors.New("Token wasn't issued for a login session")
+)
+
+// Valid checks that the token hasn't expired, and that its login session
+// hasn't been revoked. The role in the token is informational only, use the
+// player's current role for authorization.
 func (c TF2StadiumClaims) Valid() error {
-	if !playerExists(c.PlayerID, c.SteamID) {
-		return player.ErrPlayerNotFound
+	if c.ExpiresAt == 0 || time.Now().Unix() > c.ExpiresAt {
+		return ErrTokenExpired
+	}
+
+	// tokens issued before login sessions were introduced have no
+	// session ID, players need to log in again.
+	if c.SessionID == 0 {
+		return ErrNoSession
+	}
+
+	if !player.IsSessionActive(c.SessionID, c.PlayerID, c.SteamID) {
+		return player.ErrSessionNotFound
 	}
 
 	return nil
 }
+
+// HasScope returns true if the claims allow the given API token scope.
+// Claims from a login session (the auth-jwt cookie) have every scope.
+func (c *TF2StadiumClaims) HasScope(scope player.TokenScope) bool {
+	if c.APITokenID == 0 {
+		return true
+	}
+
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -5,17 +5,23 @@
 package controllerhelpers
 
 import (
+	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/TF2Stadium/Helen/config"
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
 	"github.com/TF2Stadium/Helen/helpers/authority"
+	"github.com/TF2Stadium/Helen/models/job"
 	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/TF2Stadium/wsevent"
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/xsrftoken"
 )
 
 var (
@@ -23,41 +29,70 @@ var (
 	whitelistSteamID map[string]bool
 )
 
-func WhitelistListener() {
-	ticker := time.NewTicker(time.Minute * 1)
-	for {
-		client := http.Client{Timeout: 5 * time.Second}
-		resp, err := client.Get(config.Constants.SteamIDWhitelist)
-
-		if err != nil {
-			logrus.Error(err.Error())
-			continue
-		}
-
-		var groupXML struct {
-			//XMLName xml.Name `xml:"memberList"`
-			//GroupID uint64   `xml:"groupID64"`
-			Members []string `xml:"members>steamID64"`
+func init() {
+	broadcaster.HandleNotification("whitelist", func(data json.RawMessage) {
+		var members []string
+		if err := json.Unmarshal(data, &members); err != nil {
+			logrus.Error(err)
+			return
 		}
+		setWhitelist(members)
+	})
+}
 
-		dec := xml.NewDecoder(resp.Body)
-		err = dec.Decode(&groupXML)
+// ScheduleWhitelist loads the whitelist, and schedules a job which reloads it
+// every minute. The job runs on one instance, and sends the list to the others.
+func ScheduleWhitelist() {
+	go func() {
+		members, err := fetchWhitelist()
 		if err != nil {
 			logrus.Error(err)
-			continue
+			return
 		}
+		setWhitelist(members)
+	}()
 
-		whitelistLock.Lock()
-		whitelistSteamID = make(map[string]bool)
+	job.Every("steamidWhitelist", time.Minute, updateWhitelist)
+}
 
-		for _, steamID := range groupXML.Members {
-			//_, ok := whitelistSteamID[steamID]
-			//logrus.Info("Whitelisting SteamID %s", steamID)
-			whitelistSteamID[steamID] = true
-		}
-		whitelistLock.Unlock()
-		<-ticker.C
+func updateWhitelist() error {
+	members, err := fetchWhitelist()
+	if err != nil {
+		return err
+	}
+
+	setWhitelist(members)
+	broadcaster.Notify("whitelist", members)
+	return nil
+}
+
+func fetchWhitelist() ([]string, error) {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(config.Constants.SteamIDWhitelist)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var groupXML struct {
+		// XMLName xml.Name `xml:"memberList"`
+		// GroupID uint64   `xml:"groupID64"`
+		Members []string `xml:"members>steamID64"`
 	}
+
+	dec := xml.NewDecoder(resp.Body)
+	err = dec.Decode(&groupXML)
+	return groupXML.Members, err
+}
+
+func setWhitelist(members []string) {
+	whitelistLock.Lock()
+	whitelistSteamID = make(map[string]bool)
+
+	for _, steamID := range members {
+		whitelistSteamID[steamID] = true
+	}
+	whitelistLock.Unlock()
 }
 
 func IsSteamIDWhitelisted(steamid string) bool {
@@ -78,16 +113,37 @@ func CheckPrivilege(so *wsevent.Client, action authority.AuthAction) error {
 	return nil
 }
 
+// CheckScope returns an error if the client is authenticated with an API token
+// that doesn't have the given scope.
+func CheckScope(so *wsevent.Client, scope player.TokenScope) error {
+	if !so.Token.Claims.(*TF2StadiumClaims).HasScope(scope) {
+		return fmt.Errorf("This action needs an API token with the %s scope", scope)
+	}
+	return nil
+}
+
+// CheckSession returns an error if the client is authenticated with an API
+// token, for actions that can only be performed by players themselves.
+func CheckSession(so *wsevent.Client) error {
+	if so.Token.Claims.(*TF2StadiumClaims).APITokenID != 0 {
+		return errors.New("This action can't be performed with an API token")
+	}
+	return nil
+}
+
+// FilterHTTPRequest only calls f if the requesting player's current role allows
+// action. The role is read from the database rather than the token, so that
+// role changes take effect immediately.
 func FilterHTTPRequest(action authority.AuthAction, f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		token, err := GetToken(r)
+		token, err := Authenticate(w, r)
 		if err != nil {
 			http.Error(w, "You're not logged in, or your JWT cookie is invalid.", http.StatusBadRequest)
 			return
 		}
 
-		if !(token.Claims.(*TF2StadiumClaims).Role.Can(action)) {
+		if !canPerform(token, action) {
 			http.Error(w, "Not authorized", 403)
 			return
 		}
@@ -95,3 +151,47 @@ func FilterHTTPRequest(action authority.AuthAction, f func(http.ResponseWriter,
 		f(w, r)
 	}
 }
+
+func canPerform(token *jwt.Token, action authority.AuthAction) bool {
+	claims := token.Claims.(*TF2StadiumClaims)
+	if !claims.HasScope(player.ScopeModerate) {
+		return false
+	}
+
+	player, err := player.GetPlayerByID(claims.PlayerID)
+	return err == nil && player.Role.Can(action)
+}
+
+// FilterAPIRequest is like FilterHTTPRequest, but for JSON API endpoints. It
+// only accepts requests with the given method, and writes errors as JSON.
+// Requests authenticated with the auth-jwt cookie (rather than a bearer token)
+// need a valid X-XSRF-Token header for any method other than GET.
+func FilterAPIRequest(method string, action authority.AuthAction, f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		token, err := Authenticate(w, r)
+		if err != nil {
+			WriteJSONError(w, http.StatusUnauthorized, "You're not logged in, or your token is invalid.")
+			return
+		}
+
+		if _, ok := bearerToken(r); !ok && method != "GET" {
+			if !xsrftoken.Valid(r.Header.Get("X-XSRF-Token"), config.Constants.CookieStoreSecret, "admin", "POST") {
+				WriteJSONError(w, http.StatusForbidden, "invalid xsrf token")
+				return
+			}
+		}
+
+		if !canPerform(token, action) {
+			WriteJSONError(w, http.StatusForbidden, "Not authorized")
+			return
+		}
+
+		f(w, r)
+	}
+}
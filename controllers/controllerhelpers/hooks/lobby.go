@@ -23,11 +23,9 @@ import (
 func AfterLobbyJoin(so *wsevent.Client, lob *lobby.Lobby, player *player.Player) {
 	room := fmt.Sprintf("%s_private", GetLobbyRoom(lob.ID))
 	//make all sockets join the private room, given the one the player joined the lobby on
-	//might close, so lobbyStart and lobbyReadyUp can be sent to other tabs
-	sockets, _ := sessions.GetSockets(player.SteamID)
-	for _, so := range sockets {
-		socket.AuthServer.Join(so, room)
-	}
+	//might close, so lobbyStart and lobbyReadyUp can be sent to other tabs (which might be
+	// connected to other instances)
+	broadcaster.JoinRoom(player.SteamID, room)
 	if lob.State == lobby.InProgress { // player is a substitute
 		lob.AfterPlayerNotInGameFunc(player, 5*time.Minute, func() {
 			// if player doesn't join game server in 5 minutes,
@@ -50,11 +48,8 @@ func AfterLobbyLeave(lob *lobby.Lobby, player *player.Player, kicked bool, notRe
 
 	broadcaster.SendMessage(player.SteamID, "lobbyLeft", event)
 
-	sockets, _ := sessions.GetSockets(player.SteamID)
 	//player might have connected from multiple tabs, remove all of them from the room
-	for _, so := range sockets {
-		socket.AuthServer.Leave(so, fmt.Sprintf("%s_private", GetLobbyRoom(lob.ID)))
-	}
+	broadcaster.LeaveRoom(player.SteamID, fmt.Sprintf("%s_private", GetLobbyRoom(lob.ID)))
 }
 
 func AfterLobbySpec(server *wsevent.Server, so *wsevent.Client, player *player.Player, lob *lobby.Lobby) {
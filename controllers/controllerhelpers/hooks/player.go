@@ -14,6 +14,7 @@ import (
 	db "github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/helpers"
 	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/party"
 	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/TF2Stadium/Helen/routes/socket"
 	"github.com/TF2Stadium/wsevent"
@@ -22,7 +23,7 @@ import (
 func AfterConnect(server *wsevent.Server, so *wsevent.Client) {
 	server.Join(so, "0_public") //room for global chat
 
-	so.EmitJSON(helpers.NewRequest("lobbyListData", lobby.DecorateLobbyListData(lobby.GetWaitingLobbies(), false)))
+	so.EmitJSON(helpers.NewRequest("lobbyListSnapshot", lobby.GetLobbyListSnapshot()))
 	chelpers.BroadcastScrollback(so, 0)
 	so.EmitJSON(helpers.NewRequest("subListData", lobby.DecorateSubstituteList()))
 }
@@ -30,7 +31,8 @@ func AfterConnect(server *wsevent.Server, so *wsevent.Client) {
 var emptyMap = make(map[string]string)
 
 func AfterConnectLoggedIn(so *wsevent.Client, player *player.Player) {
-	sessions.AddSocket(player.SteamID, so)
+	region, _ := helpers.GetRegion(chelpers.GetIPAddr(so.Request))
+	sessions.AddSocket(player.SteamID, region, so)
 
 	if time.Since(player.ProfileUpdatedAt) >= 30*time.Minute {
 		err := player.UpdatePlayerInfo()
@@ -62,6 +64,11 @@ func AfterConnectLoggedIn(so *wsevent.Client, player *player.Player) {
 		}
 	}
 
+	if pty, err := party.GetPlayerParty(player.ID); err == nil {
+		socket.AuthServer.Join(so, party.Room(pty.ID))
+		so.EmitJSON(helpers.NewRequest("partyData", party.DecorateParty(pty)))
+	}
+
 	if player.Settings != nil {
 		so.EmitJSON(helpers.NewRequest("playerSettings", player.Settings))
 	} else {
@@ -10,6 +10,7 @@ import (
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
 	"github.com/TF2Stadium/Helen/controllers/socket/sessions"
 	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/dgrijalva/jwt-go"
 )
 
@@ -28,17 +29,39 @@ func OnDisconnect(socketID string, token *jwt.Token) {
 			lob.RemoveSpectator(player, true)
 		}
 
-		id, _ = player.GetLobbyID(true)
-		//if player is in a waiting lobby, and hasn't connected for > 30 seconds,
-		//remove him from it. Here, connected = player isn't connected from any tab/window
-		if id != 0 && sessions.ConnectedSockets(player.SteamID) == 0 {
-			sessions.AfterDisconnectedFunc(player.SteamID, time.Second*30, func() {
-				lob, _ := lobby.GetLobbyByID(id)
-				if lob.State == lobby.Waiting {
-					lob.RemovePlayer(player)
-				}
-			})
+		removeIfDisconnected(player)
+	}
+
+}
+
+// OnStaleSocket is called when a socket connected to another instance is removed, because
+// that instance stopped responding
+func OnStaleSocket(steamid string, spectating uint) {
+	player, err := player.GetPlayerBySteamID(steamid)
+	if err != nil {
+		return
+	}
+
+	if spectating != 0 {
+		lob, err := lobby.GetLobbyByID(spectating)
+		if err == nil {
+			lob.RemoveSpectator(player, true)
 		}
 	}
 
+	removeIfDisconnected(player)
+}
+
+func removeIfDisconnected(player *player.Player) {
+	id, _ := player.GetLobbyID(true)
+	// if player is in a waiting lobby, and hasn't connected for > 30 seconds,
+	// remove him from it. Here, connected = player isn't connected from any tab/window
+	if id != 0 && sessions.ConnectedSockets(player.SteamID) == 0 {
+		sessions.AfterDisconnectedFunc(player.SteamID, time.Second*30, func() {
+			lob, _ := lobby.GetLobbyByID(id)
+			if lob.State == lobby.Waiting {
+				lob.RemovePlayer(player)
+			}
+		})
+	}
 }
@@ -0,0 +1,80 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package controllerhelpers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type jsonResponse struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// WriteJSON writes a successful JSON response with the given status code,
+// using the same envelope as socket responses.
+func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
+	writeJSON(w, status, jsonResponse{Success: true, Data: data})
+}
+
+// WriteJSONError writes a failed JSON response with the given status code
+func WriteJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, jsonResponse{Success: false, Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp jsonResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// WriteCachedJSON is like WriteJSON, but lets clients and proxies cache the
+// response for maxAge. The response gets an ETag computed from its body, and
+// requests with a matching If-None-Match header get a 304 with no body.
+func WriteCachedJSON(w http.ResponseWriter, r *http.Request, maxAge time.Duration, data interface{}) {
+	body, err := json.Marshal(jsonResponse{Success: true, Data: data})
+	if err != nil {
+		logrus.Error(err)
+		WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
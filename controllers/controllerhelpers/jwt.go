@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -30,15 +31,26 @@ func init() {
 	}
 }
 
-func NewToken(player *player.Player) string {
+const (
+	accessCookieName  = "auth-jwt"
+	refreshCookieName = "auth-refresh"
+)
+
+// NewToken returns a signed access token for the player, tied to the login
+// session with the given ID. Access tokens expire after
+// config.Constants.AccessTokenLifetime, and are refreshed from the session.
+func NewToken(player *player.Player, sessionID uint) string {
+	now := time.Now()
 	token := jwt.New(jwt.SigningMethodHS512)
 	token.Claims = TF2StadiumClaims{
 		PlayerID:       player.ID,
 		SteamID:        player.SteamID,
 		MumblePassword: player.MumbleAuthkey,
 		Role:           player.Role,
-		IssuedAt:       time.Now().Unix(),
+		IssuedAt:       now.Unix(),
+		ExpiresAt:      now.Add(config.Constants.AccessTokenLifetime).Unix(),
 		Issuer:         config.Constants.PublicAddress,
+		SessionID:      sessionID,
 	}
 
 	str, err := token.SignedString([]byte(signingKey))
@@ -49,6 +61,121 @@ func NewToken(player *player.Player) string {
 	return str
 }
 
+func setAuthCookie(w http.ResponseWriter, name, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.Constants.CookieDomain,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   config.Constants.SecureCookies,
+	})
+}
+
+// SetAccessCookie sets the auth-jwt cookie to a new access token for the
+// player and session. The cookie outlives the token itself, so that expired
+// tokens can be told apart from missing ones.
+func SetAccessCookie(w http.ResponseWriter, player *player.Player, sessionID uint) {
+	setAuthCookie(w, accessCookieName, NewToken(player, sessionID), time.Now().Add(config.Constants.SessionLifetime))
+}
+
+// NewLoginSession starts a new login session for the player, and sets the
+// access and refresh token cookies.
+func NewLoginSession(w http.ResponseWriter, r *http.Request, player *player.Player) error {
+	session, refreshToken, err := player.NewSession(r.UserAgent(), GetIPAddr(r))
+	if err != nil {
+		return err
+	}
+
+	setAuthCookie(w, refreshCookieName, refreshToken, session.ExpiresAt)
+	SetAccessCookie(w, player, session.ID)
+	return nil
+}
+
+// EndLoginSession revokes the session whose refresh token was sent with the
+// request, and clears both auth cookies.
+func EndLoginSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(refreshCookieName); err == nil {
+		if session, err := player.GetSessionByRefreshToken(cookie.Value); err == nil {
+			session.Revoke()
+		}
+	}
+
+	for _, name := range []string{accessCookieName, refreshCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:    name,
+			Path:    "/",
+			Domain:  config.Constants.CookieDomain,
+			MaxAge:  -1,
+			Expires: time.Unix(0, 0),
+		})
+	}
+}
+
+// Authenticate is like GetToken, but if the access token is missing or has
+// expired, it issues a new one from the login session and sets the auth-jwt
+// cookie. Use this for requests coming from a browser.
+func Authenticate(w http.ResponseWriter, r *http.Request) (*jwt.Token, error) {
+	token, err := GetToken(r)
+	if err == nil {
+		return token, nil
+	}
+	if _, ok := bearerToken(r); ok {
+		return nil, err
+	}
+
+	token, refreshErr := RefreshAccessToken(w, r)
+	if refreshErr != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// RefreshAccessToken sets the auth-jwt cookie to a new access token for the
+// login session in the auth-refresh cookie. Refresh tokens can only be used
+// once, so the session's refresh token is rotated and the auth-refresh cookie
+// set to the new one.
+func RefreshAccessToken(w http.ResponseWriter, r *http.Request) (*jwt.Token, error) {
+	cookie, err := r.Cookie(refreshCookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := player.GetSessionByRefreshToken(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := player.GetPlayerByID(session.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := session.Rotate(GetIPAddr(r))
+	if err != nil {
+		return nil, err
+	}
+	setAuthCookie(w, refreshCookieName, refreshToken, session.ExpiresAt)
+
+	tokenString := NewToken(p, session.ID)
+	token, err := jwt.ParseWithClaims(tokenString, &TF2StadiumClaims{}, verifyToken)
+	if err != nil {
+		return nil, err
+	}
+
+	setAuthCookie(w, accessCookieName, tokenString, time.Now().Add(config.Constants.SessionLifetime))
+	return token, nil
+}
+
+// GetSocketToken returns the token for websocket upgrade requests. Cookies
+// can't be set while upgrading, so the rotated refresh token couldn't be sent
+// back, and expired access tokens aren't refreshed here: clients should POST
+// to /refresh before (re)connecting.
+func GetSocketToken(r *http.Request) (*jwt.Token, error) {
+	return GetToken(r)
+}
+
 func verifyToken(token *jwt.Token) (interface{}, error) {
 	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
@@ -57,8 +184,18 @@ func verifyToken(token *jwt.Token) (interface{}, error) {
 	return signingKey, nil
 }
 
+// GetToken returns the token sent with the request, either as a bearer token in
+// the Authorization header (used by programmatic clients), or in the auth-jwt
+// cookie. Bearer tokens can either be JWTs or API tokens.
 func GetToken(r *http.Request) (*jwt.Token, error) {
-	cookie, err := r.Cookie("auth-jwt")
+	if tokenString, ok := bearerToken(r); ok {
+		if strings.HasPrefix(tokenString, player.APITokenPrefix) {
+			return apiTokenToJWT(tokenString)
+		}
+		return jwt.ParseWithClaims(tokenString, &TF2StadiumClaims{}, verifyToken)
+	}
+
+	cookie, err := r.Cookie(accessCookieName)
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +204,41 @@ func GetToken(r *http.Request) (*jwt.Token, error) {
 	return token, err
 }
 
+// apiTokenToJWT returns an (unsigned) token with claims for the owner of the
+// given API token, so that API tokens can be used wherever JWTs are.
+func apiTokenToJWT(secret string) (*jwt.Token, error) {
+	apiToken, err := player.GetAPIToken(secret)
+	if err != nil {
+		return nil, err
+	}
+	apiToken.Touch()
+
+	return &jwt.Token{
+		Claims: &TF2StadiumClaims{
+			PlayerID:       apiToken.Player.ID,
+			SteamID:        apiToken.Player.SteamID,
+			MumblePassword: apiToken.Player.MumbleAuthkey,
+			Role:           apiToken.Player.Role,
+			IssuedAt:       apiToken.CreatedAt.Unix(),
+			Issuer:         config.Constants.PublicAddress,
+
+			APITokenID: apiToken.ID,
+			Scopes:     apiToken.ScopeList(),
+		},
+		Valid: true,
+	}, nil
+}
+
+// bearerToken returns the token in the request's Authorization header, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")), true
+}
+
 func GetPlayer(token *jwt.Token) *player.Player {
 	player, _ := player.GetPlayerByID(token.Claims.(*TF2StadiumClaims).PlayerID)
 	return player
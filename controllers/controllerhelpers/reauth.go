@@ -0,0 +1,51 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package controllerhelpers
+
+import (
+	"encoding/json"
+
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	"github.com/TF2Stadium/Helen/controllers/socket/sessions"
+	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+type reauthNotification struct {
+	SteamID   string `json:"steamid"`
+	SessionID uint   `json:"sessionId"`
+}
+
+func init() {
+	broadcaster.HandleNotification("reauth", func(data json.RawMessage) {
+		var n reauthNotification
+		if err := json.Unmarshal(data, &n); err != nil {
+			logrus.Error(err)
+			return
+		}
+		forceReauth(n.SteamID, n.SessionID)
+	})
+}
+
+// ForceReauth closes the player's open sockets after sending them a
+// "reauthenticate" event, so that clients reconnect with a fresh token. If
+// sessionID isn't 0, only sockets authenticated through that login session are
+// closed. Call this after changing a player's role or bans, or revoking sessions.
+func ForceReauth(steamid string, sessionID uint) {
+	forceReauth(steamid, sessionID)
+	broadcaster.Notify("reauth", reauthNotification{steamid, sessionID})
+}
+
+func forceReauth(steamid string, sessionID uint) {
+	sockets, _ := sessions.GetSockets(steamid)
+	for _, so := range sockets {
+		if sessionID != 0 && so.Token.Claims.(*TF2StadiumClaims).SessionID != sessionID {
+			continue
+		}
+
+		so.EmitJSON(helpers.NewRequest("reauthenticate", struct{}{}))
+		so.Close()
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+// Package health serves /healthz and /readyz, for load balancers and
+// orchestration to probe. They're only served if HELEN_HEALTH_CHECKS is set.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/models/event"
+	"github.com/TF2Stadium/Helen/models/lobby_settings"
+)
+
+// Check is the status of a dependency
+type Check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Status is the /readyz response
+type Status struct {
+	Ready    bool             `json:"ready"`
+	Draining bool             `json:"draining"`
+	Checks   map[string]Check `json:"checks"`
+}
+
+var draining int32
+
+// Drain makes /readyz fail, so load balancers stop sending new connections
+// to the instance while it shuts down
+func Drain() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+func check(ok bool, err string) Check {
+	if ok {
+		return Check{OK: true}
+	}
+	return Check{Error: err}
+}
+
+func status() Status {
+	s := Status{
+		Draining: atomic.LoadInt32(&draining) == 1,
+		Checks:   make(map[string]Check),
+	}
+
+	if err := db.DB.DB().Ping(); err != nil {
+		s.Checks["database"] = Check{Error: err.Error()}
+	} else {
+		s.Checks["database"] = Check{OK: true}
+	}
+	s.Checks["amqp"] = check(helpers.AMQPOpen(), "channel closed")
+	s.Checks["events"] = check(event.Listening(), "not consuming events")
+	s.Checks["lobbySettings"] = check(lobbySettings.Loaded(), "not loaded")
+
+	s.Ready = !s.Draining
+	for _, c := range s.Checks {
+		s.Ready = s.Ready && c.OK
+	}
+	return s
+}
+
+// Healthz returns 200 as long as the process is serving requests
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"ok":true}`))
+}
+
+// Readyz returns 200 if the instance can serve players, and 503 with the
+// failing dependencies otherwise
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	s := status()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !s.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(s)
+}
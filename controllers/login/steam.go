@@ -73,32 +73,18 @@ func SteamMockLoginHandler(w http.ResponseWriter, r *http.Request) {
 		logrus.Error(err)
 	}
 
-	key := controllerhelpers.NewToken(p)
-	cookie := &http.Cookie{
-		Name:    "auth-jwt",
-		Value:   key,
-		Path:    "/",
-		Domain:  config.Constants.CookieDomain,
-		Expires: time.Now().Add(30 * 24 * time.Hour),
-		//Secure: true,
+	err = controllerhelpers.NewLoginSession(w, r, p)
+	if err != nil {
+		logrus.Error(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	http.SetCookie(w, cookie)
-
 	http.Redirect(w, r, config.Constants.LoginRedirectPath, 303)
 }
 
 func SteamLogoutHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("auth-jwt")
-	if err != nil { //user wasn't even logged in ಠ_ಠ
-		return
-	}
-
-	cookie.Domain = config.Constants.CookieDomain
-	cookie.MaxAge = -1
-	cookie.Expires = time.Time{}
-	http.SetCookie(w, cookie)
-
+	controllerhelpers.EndLoginSession(w, r)
 	http.Redirect(w, r, config.Constants.LoginRedirectPath, 303)
 }
 
@@ -148,27 +134,20 @@ func SteamLoginCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		database.DB.Create(p)
 	}
 
-	go func() {
-		if time.Since(p.ProfileUpdatedAt) >= 1*time.Hour {
-			err := p.UpdatePlayerInfo()
-			if err != nil {
-				logrus.Error(err)
-			}
+	if time.Since(p.ProfileUpdatedAt) >= 1*time.Hour {
+		err := p.QueuePlayerInfoUpdate()
+		if err != nil {
+			logrus.Error(err)
 		}
-	}()
+	}
 
-	key := controllerhelpers.NewToken(p)
-	cookie := &http.Cookie{
-		Name:     "auth-jwt",
-		Value:    key,
-		Path:     "/",
-		Domain:   config.Constants.CookieDomain,
-		Expires:  time.Now().Add(30 * 24 * time.Hour),
-		HttpOnly: true,
-		Secure:   config.Constants.SecureCookies,
+	err = controllerhelpers.NewLoginSession(w, r, p)
+	if err != nil {
+		logrus.Error(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	http.SetCookie(w, cookie)
 	if refererURL != "" {
 		http.Redirect(w, r, refererURL, 303)
 		return
@@ -176,3 +155,23 @@ func SteamLoginCallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(w, r, config.Constants.LoginRedirectPath, 303)
 }
+
+// RefreshHandler issues a new access token (in the auth-jwt cookie) for the
+// login session in the auth-refresh cookie, and rotates the refresh token.
+// Clients should call this before the access token expires, when a request
+// fails with an expired token, and before reconnecting to the websocket.
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		controllerhelpers.WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	_, err := controllerhelpers.RefreshAccessToken(w, r)
+	if err != nil {
+		controllerhelpers.WriteJSONError(w, http.StatusUnauthorized, "Your session has expired or has been revoked, please log in again.")
+		return
+	}
+
+	controllerhelpers.WriteJSON(w, http.StatusOK, struct{}{})
+}
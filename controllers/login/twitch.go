@@ -25,7 +25,7 @@ type userInfo struct {
 }
 
 func TwitchLoginHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := controllerhelpers.GetToken(r)
+	token, err := controllerhelpers.Authenticate(w, r)
 	if err == http.ErrNoCookie {
 		http.Error(w, "You are not logged in.", http.StatusUnauthorized)
 		return
@@ -58,7 +58,7 @@ func TwitchLoginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func TwitchAuthHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := controllerhelpers.GetToken(r)
+	token, err := controllerhelpers.Authenticate(w, r)
 	if err == http.ErrNoCookie {
 		http.Error(w, "You are not logged in.", http.StatusUnauthorized)
 		return
@@ -156,7 +156,7 @@ func getUserInfo(token string) (*userInfo, error) {
 }
 
 func TwitchLogoutHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := controllerhelpers.GetToken(r)
+	token, err := controllerhelpers.Authenticate(w, r)
 	if err == http.ErrNoCookie {
 		http.Error(w, "You are not logged in.", http.StatusUnauthorized)
 		return
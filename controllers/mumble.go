@@ -2,32 +2,30 @@ package controllers
 
 import (
 	"net/http"
-	"time"
 
 	"github.com/TF2Stadium/Helen/config"
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
 )
 
 func ResetMumblePassword(w http.ResponseWriter, r *http.Request) {
-	token, err := chelpers.GetToken(r)
+	token, err := chelpers.Authenticate(w, r)
 	if err != nil {
 		http.Error(w, "You aren't logged in.", http.StatusForbidden)
 		return
 	}
 
+	claims := token.Claims.(*chelpers.TF2StadiumClaims)
+	if claims.SessionID == 0 {
+		http.Error(w, "Can't reset the mumble password with an API token.", http.StatusForbidden)
+		return
+	}
+
 	player := chelpers.GetPlayer(token)
 	player.MumbleAuthkey = player.GenAuthKey()
 	player.Save()
 
-	newToken := chelpers.NewToken(player)
-	cookie := &http.Cookie{
-		Name:    "auth-jwt",
-		Value:   newToken,
-		Path:    "/",
-		Domain:  config.Constants.CookieDomain,
-		Expires: time.Now().Add(30 * 24 * time.Hour),
-	}
-	http.SetCookie(w, cookie)
+	// the mumble password is part of the access token
+	chelpers.SetAccessCookie(w, player, claims.SessionID)
 
 	referer, ok := r.Header["Referer"]
 	if ok {
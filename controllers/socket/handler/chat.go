@@ -13,6 +13,7 @@ import (
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
 	db "github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/models/chat"
 	"github.com/TF2Stadium/Helen/models/lobby"
 	"github.com/TF2Stadium/Helen/models/player"
@@ -29,6 +30,11 @@ func (Chat) ChatSend(so *wsevent.Client, args struct {
 	Message *string `json:"message"`
 	Room    *int    `json:"room"`
 }) interface{} {
+	defer metrics.ObserveRequest("chatSend", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
 	p := chelpers.GetPlayer(so.Token)
 	if banned, until := p.IsBannedWithTime(player.BanChat); banned {
 		ban, _ := p.GetActiveBan(player.BanChat)
@@ -76,6 +82,10 @@ func (Chat) ChatDelete(so *wsevent.Client, args struct {
 	ID   *int  `json:"id"`
 	Room *uint `json:"room"`
 }) interface{} {
+	defer metrics.ObserveRequest("chatDelete", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeModerate); err != nil {
+		return err
+	}
 
 	if err := chelpers.CheckPrivilege(so, helpers.ActionDeleteChat); err != nil {
 		return err
@@ -6,9 +6,11 @@ package handler
 
 import (
 	"errors"
+	"time"
 
 	"github.com/TF2Stadium/Helen/controllers/broadcaster"
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/models/lobby_settings"
 	"github.com/TF2Stadium/wsevent"
 	"github.com/bitly/go-simplejson"
@@ -23,6 +25,7 @@ func (Global) Name(s string) string {
 func (Global) GetConstant(so *wsevent.Client, args struct {
 	Constant string `json:"constant"`
 }) interface{} {
+	defer metrics.ObserveRequest("getConstant", time.Now())
 
 	output := simplejson.New()
 	switch args.Constant {
@@ -48,6 +51,11 @@ func (Global) SendToOtherClients(so *wsevent.Client, args struct {
 	Event string `json:"event"`
 	Data  string `json:"data"`
 }) interface{} {
+	defer metrics.ObserveRequest("sendToOtherClients", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
 	steamID := so.Token.Claims.(*chelpers.TF2StadiumClaims).SteamID
 	broadcaster.SendMessageSkipIDs(so.ID, steamID, args.Event, args.Data)
 	return emptySuccess
@@ -21,10 +21,13 @@ import (
 	"github.com/TF2Stadium/Helen/controllers/controllerhelpers/hooks"
 	db "github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/models/chat"
 	"github.com/TF2Stadium/Helen/models/gameserver"
 	"github.com/TF2Stadium/Helen/models/lobby"
 	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/TF2Stadium/Helen/models/lobby_settings"
+	"github.com/TF2Stadium/Helen/models/party"
 	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/TF2Stadium/Helen/models/rpc"
 	"github.com/TF2Stadium/Helen/routes/socket"
@@ -42,15 +45,6 @@ var (
 	reDiscordInvite = regexp.MustCompile(`https:\/\/discord.gg\/[a-zA-Z0-9]+`)
 	reSteamGroup    = regexp.MustCompile(`steamcommunity\.com\/groups\/(.+)`)
 	reServer        = regexp.MustCompile(`\w+\:\d+`)
-	playermap       = map[string]format.Format{
-		"debug":      format.Debug,
-		"6s":         format.Sixes,
-		"highlander": format.Highlander,
-		"ultiduo":    format.Ultiduo,
-		"bball":      format.Bball,
-		"4v4":        format.Fours,
-		"prolander":  format.Prolander,
-	}
 )
 
 type Restriction struct {
@@ -62,6 +56,15 @@ type Requirement struct {
 	Lobbies    int         `json:"lobbies"`
 	Restricted Restriction `json:"restricted"`
 }
+type Requirements struct {
+	Classes map[string]Requirement `json:"classes,omitempty"`
+	General Requirement            `json:"general,omitempty"`
+}
+
+type DiscordChannels struct {
+	RedChannel *string `json:"redChannel,omitempty"`
+	BluChannel *string `json:"bluChannel,omitempty"`
+}
 
 type servemeServer struct {
 	StartsAt string `json:"startsAt"`
@@ -85,16 +88,22 @@ func newRequirement(team, class string, requirement Requirement, lob *lobby.Lobb
 	return nil
 }
 
-func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
+type lobbyCreateArgs struct {
 	Map         *string        `json:"map"`
-	Type        *string        `json:"type" valid:"debug,6s,highlander,4v4,ultiduo,bball,prolander"`
-	League      *string        `json:"league" valid:"ugc,etf2l,esea,asiafortress,ozfortress,bballtf,rgl"`
+	Type        *string        `json:"type"`
+	League      *string        `json:"league"`
 	ServerType  *string        `json:"serverType" valid:"server,storedServer,serveme"`
 	Serveme     *servemeServer `json:"serveme" empty:"-"`
 	Server      *string        `json:"server" empty:"-"`
 	RconPwd     *string        `json:"rconpwd" empty:"-"`
 	WhitelistID *string        `json:"whitelistID"`
 	Mumble      *bool          `json:"mumbleRequired"`
+	// other maps players can vote for instead of Map, no vote if empty
+	MapVote []string `json:"mapVote"`
+	// maps played after Map in a best-of series, not a series if empty
+	Series []string `json:"series"`
+	// (Unix) time the lobby opens at, 0 to open it right away
+	ScheduledFor int64 `json:"scheduledFor"`
 
 	Password            *string `json:"password" empty:"-"`
 	SteamGroupWhitelist *string `json:"steamGroupWhitelist" empty:"-"`
@@ -104,17 +113,23 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 	TwitchWhitelistSubscribers bool `json:"twitchWhitelistSubs"`
 	TwitchWhitelistFollowers   bool `json:"twitchWhitelistFollows"`
 	RegionLock                 bool `json:"regionLock"`
+	// invite only, and hidden from the lobby list
+	Private bool `json:"private"`
 
-	Requirements *struct {
-		Classes map[string]Requirement `json:"classes,omitempty"`
-		General Requirement            `json:"general,omitempty"`
-	} `json:"requirements" empty:"-"`
+	Requirements *Requirements    `json:"requirements" empty:"-"`
+	Discord      *DiscordChannels `json:"discord" empty:"-"`
+}
+
+func (Lobby) LobbyCreate(so *wsevent.Client, args lobbyCreateArgs) interface{} {
+	defer metrics.ObserveRequest("lobbyCreate", time.Now())
+	return createLobby(so, args)
+}
+
+func createLobby(so *wsevent.Client, args lobbyCreateArgs) interface{} {
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
 
-	Discord *struct {
-		RedChannel *string `json:"redChannel,omitempty"`
-		BluChannel *string `json:"bluChannel,omitempty"`
-	} `json:"discord" empty:"-"`
-}) interface{} {
 	p := chelpers.GetPlayer(so.Token)
 	if banned, until := p.IsBannedWithTime(player.BanCreate); banned {
 		ban, _ := p.GetActiveBan(player.BanCreate)
@@ -127,6 +142,55 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 		}
 	}
 
+	lobbyType, ok := format.FromType(*args.Type)
+	if !ok {
+		return errors.New("Invalid lobby type.")
+	}
+	customMap := p.Role.Can(helpers.ActionCustomMap)
+	if err := lobbySettings.ValidateLobby(lobbyType.Name, *args.Map, *args.League, *args.WhitelistID, customMap); err != nil {
+		return err
+	}
+
+	var mapVote []string
+	if len(args.MapVote) != 0 {
+		seen := map[string]bool{*args.Map: true}
+		mapVote = []string{*args.Map}
+		for _, mapName := range args.MapVote {
+			if seen[mapName] {
+				continue
+			}
+			// only maps from the format's map pool can be voted for
+			if err := lobbySettings.ValidateLobby(lobbyType.Name, mapName, *args.League, *args.WhitelistID, false); err != nil {
+				return err
+			}
+			seen[mapName] = true
+			mapVote = append(mapVote, mapName)
+		}
+	}
+
+	var series []string
+	if len(args.Series) != 0 {
+		if mapVote != nil {
+			return errors.New("Series can't have a map vote.")
+		}
+
+		series = []string{*args.Map}
+		for _, mapName := range args.Series {
+			if err := lobbySettings.ValidateLobby(lobbyType.Name, mapName, *args.League, *args.WhitelistID, customMap); err != nil {
+				return err
+			}
+			series = append(series, mapName)
+		}
+	}
+
+	var scheduledFor time.Time
+	if args.ScheduledFor != 0 {
+		scheduledFor = time.Unix(args.ScheduledFor, 0)
+		if err := lobby.CheckSchedule(scheduledFor); err != nil {
+			return err
+		}
+	}
+
 	var steamGroup string
 	var context *servemetf.Context
 	var reservation servemetf.Reservation
@@ -152,6 +216,9 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 		if end, err = time.Parse(servemetf.TimeFormat, (*args.Serveme).EndsAt); err != nil {
 			return err
 		}
+		if !scheduledFor.IsZero() && (start.After(scheduledFor) || !end.After(scheduledFor)) {
+			return errors.New("The serveme reservation has to be running when the lobby opens.")
+		}
 
 		randBytes := make([]byte, 6)
 		rand.Read(randBytes)
@@ -189,7 +256,12 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 		if err != nil {
 			return err
 		}
-		server, err := gameserver.GetStoredServer(uint(id))
+		// scheduled lobbies take the server when they open
+		getServer := gameserver.GetStoredServer
+		if !scheduledFor.IsZero() {
+			getServer = gameserver.FindStoredServer
+		}
+		server, err := getServer(uint(id))
 		if err != nil {
 			return err
 		}
@@ -204,11 +276,7 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 		}
 	}
 
-	var count int
-
-	lobbyType := playermap[*args.Type]
-	db.DB.Model(&gameserver.ServerRecord{}).Where("host = ?", *args.Server).Count(&count)
-	if count != 0 {
+	if lobby.HostInUse(*args.Server) {
 		return errors.New("A lobby is already using this server.")
 	}
 
@@ -216,14 +284,26 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 	rand.Read(randBytes)
 	serverPwd := base64.URLEncoding.EncodeToString(randBytes)
 
-	//TODO what if playermap[lobbytype] is nil?
 	info := gameserver.ServerRecord{
 		Host:           *args.Server,
 		RconPassword:   *args.RconPwd,
 		ServerPassword: serverPwd,
 	}
 
-	lob := lobby.NewLobby(*args.Map, lobbyType, *args.League, info, *args.WhitelistID, *args.Mumble, steamGroup)
+	lob := lobby.NewLobby(*args.Map, lobbyType.ID, *args.League, info, *args.WhitelistID, *args.Mumble, steamGroup)
+	if mapVote != nil {
+		if err := lob.SetMapVote(mapVote); err != nil {
+			return err
+		}
+	}
+	if series != nil {
+		if err := lob.SetSeries(series); err != nil {
+			return err
+		}
+	}
+	if !scheduledFor.IsZero() {
+		lob.Schedule(scheduledFor)
+	}
 
 	if args.TwitchWhitelistSubscribers || args.TwitchWhitelistFollowers {
 		if p.TwitchName == "" {
@@ -249,6 +329,7 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 	}
 
 	lob.RegionLock = args.RegionLock
+	lob.Private = args.Private
 	lob.CreatedBySteamID = p.SteamID
 	lob.RegionCode, lob.RegionName = helpers.GetRegion(*args.Server)
 	if (lob.RegionCode == "" || lob.RegionName == "") && config.Constants.GeoIP {
@@ -257,7 +338,7 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 			for err != nil {
 				err = context.Delete(reservation.ID, p.SteamID)
 			}
-		} else if *args.ServerType == "storedServer" {
+		} else if *args.ServerType == "storedServer" && scheduledFor.IsZero() {
 			gameserver.PutStoredServer(*args.Server)
 		}
 
@@ -270,7 +351,7 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 			for err != nil {
 				err = context.Delete(reservation.ID, p.SteamID)
 			}
-		} else if *args.ServerType == "storedServer" {
+		} else if *args.ServerType == "storedServer" && scheduledFor.IsZero() {
 			gameserver.PutStoredServer(*args.Server)
 		}
 
@@ -284,36 +365,32 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 	lob.Save()
 	lob.CreateLock()
 
-	if *args.ServerType == "serveme" {
-		now := time.Now()
-
-		for {
-			status, err := context.Status(reservation.ID, p.SteamID)
-			if err != nil {
-				logrus.Error(err)
-			}
-			if status == "ready" {
-				break
-			}
-
-			time.Sleep(10 * time.Second)
-			if time.Since(now) >= 3*time.Minute {
+	if lob.IsScheduled() {
+		// the server is set up when the lobby opens
+		lob.SetState(lobby.Scheduled)
+		if err := enqueueOpenLobby(lob, context); err != nil {
+			lob.Delete()
+			return err
+		}
+		lob.EnqueueReminders()
+	} else {
+		if *args.ServerType == "serveme" {
+			if err := waitForServeme(context, reservation.ID, p.SteamID); err != nil {
 				lob.Delete()
-				return errors.New("Couldn't get Serveme reservation, try another server.")
+				return err
 			}
+			lob.ServemeCheck(context)
 		}
 
-		lob.ServemeCheck(context)
-	}
+		err := lob.SetupServer()
+		if err != nil { //lobby setup failed, delete lobby and corresponding server record
+			lob.Delete()
+			return err
+		}
 
-	err := lob.SetupServer()
-	if err != nil { //lobby setup failed, delete lobby and corresponding server record
-		lob.Delete()
-		return err
+		lob.SetState(lobby.Waiting)
 	}
 
-	lob.SetState(lobby.Waiting)
-
 	if args.Requirements != nil {
 		for class, requirement := range (*args.Requirements).Classes {
 			if requirement.Restricted.Blu {
@@ -324,7 +401,7 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 			}
 		}
 		if args.Requirements.General.Hours != 0 || args.Requirements.General.Lobbies != 0 {
-			for i := 0; i < 2*format.NumberOfClassesMap[lob.Type]; i++ {
+			for i := 0; i < 2*format.NumberOfClasses(lob.Type); i++ {
 				req := &lobby.Requirement{
 					LobbyID: lob.ID,
 					Hours:   args.Requirements.General.Hours,
@@ -338,7 +415,7 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 	}
 
 	if *args.Password != "" {
-		for i := 0; i < 2*format.NumberOfClassesMap[lob.Type]; i++ {
+		for i := 0; i < 2*format.NumberOfClasses(lob.Type); i++ {
 			req := &lobby.Requirement{
 				LobbyID:  lob.ID,
 				Slot:     i,
@@ -348,9 +425,14 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 		}
 	}
 
-	chat.NewBotMessage(fmt.Sprintf("Lobby created by %s", p.Alias()), int(lob.ID)).Send()
+	if lob.IsScheduled() {
+		chat.NewBotMessage(fmt.Sprintf("Lobby scheduled by %s, opens at %s", p.Alias(),
+			lob.ScheduledTime().UTC().Format(time.RFC822)), int(lob.ID)).Send()
+	} else {
+		chat.NewBotMessage(fmt.Sprintf("Lobby created by %s", p.Alias()), int(lob.ID)).Send()
+	}
 
-	lobby.BroadcastLobbyList()
+	lobby.BroadcastLobbyListChange(lob)
 	return newResponse(
 		struct {
 			ID uint `json:"id"`
@@ -360,6 +442,10 @@ func (Lobby) LobbyCreate(so *wsevent.Client, args struct {
 func (Lobby) LobbyServerReset(so *wsevent.Client, args struct {
 	ID *uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyServerReset", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
 
 	player := chelpers.GetPlayer(so.Token)
 	lob, tperr := lobby.GetLobbyByID(*args.ID)
@@ -389,14 +475,16 @@ func (Lobby) ServerVerify(so *wsevent.Client, args struct {
 	Server  *string `json:"server"`
 	Rconpwd *string `json:"rconpwd"`
 }) interface{} {
+	defer metrics.ObserveRequest("serverVerify", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
 
 	if !validAddress.MatchString(*args.Server) {
 		return errors.New("Invalid Server Address")
 	}
 
-	var count int
-	db.DB.Model(&gameserver.ServerRecord{}).Where("host = ?", *args.Server).Count(&count)
-	if count != 0 {
+	if lobby.HostInUse(*args.Server) {
 		return errors.New("A lobby is already using this server.")
 	}
 
@@ -418,6 +506,10 @@ func (Lobby) ServerVerify(so *wsevent.Client, args struct {
 func (Lobby) LobbyClose(so *wsevent.Client, args struct {
 	Id *uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyClose", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
 
 	player := chelpers.GetPlayer(so.Token)
 	lob, tperr := lobby.GetLobbyByIDServer(uint(*args.Id))
@@ -434,7 +526,8 @@ func (Lobby) LobbyClose(so *wsevent.Client, args struct {
 		return errors.New("Lobby already closed.")
 	}
 
-	lob.Close(true, false)
+	// scheduled lobbies' servers haven't been set up yet
+	lob.Close(lob.State != lobby.Scheduled, false)
 
 	notify := fmt.Sprintf("Lobby closed by %s", player.Alias())
 	chat.SendNotification(notify, int(lob.ID))
@@ -446,14 +539,12 @@ func (Lobby) LobbyClose(so *wsevent.Client, args struct {
 // notifs). Bad because it gets restart on Helen restart... but easy
 // for now
 var lobbyJoinLastNotif = make(map[uint]time.Time)
-var notifThreshold = map[format.Format]int{
-	format.Highlander: 13,
-	format.Prolander:  8,
-	format.Sixes:      8,
-	format.Fours:      5,
-	format.Ultiduo:    2,
-	format.Bball:      2,
-	format.Debug:      1,
+
+func notifThreshold(f format.Format) int {
+	if info, ok := format.Get(f); ok && info.NotifyThreshold > 0 {
+		return info.NotifyThreshold
+	}
+	return 2 * format.NumberOfClasses(f)
 }
 
 func (Lobby) LobbyJoin(so *wsevent.Client, args struct {
@@ -461,7 +552,13 @@ func (Lobby) LobbyJoin(so *wsevent.Client, args struct {
 	Class    *string `json:"class"`
 	Team     *string `json:"team" valid:"red,blu"`
 	Password *string `json:"password" empty:"-"`
+	// invite link token, for private lobbies
+	Invite string `json:"invite"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyJoin", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
 
 	p := chelpers.GetPlayer(so.Token)
 	if banned, until := p.IsBannedWithTime(player.BanJoin); banned {
@@ -509,65 +606,48 @@ func (Lobby) LobbyJoin(so *wsevent.Client, args struct {
 		return tperr
 	}
 
-	if prevId, _ := p.GetLobbyID(false); prevId != 0 && !sameLobby {
-		lob, _ := lobby.GetLobbyByID(prevId)
-		hooks.AfterLobbyLeave(lob, p, false, false)
+	if args.Invite != "" {
+		if err := lob.UseInvite(p, args.Invite); err != nil {
+			return err
+		}
 	}
 
+	prevId, _ := p.GetLobbyID(false)
 	tperr = lob.AddPlayer(p, slot, *args.Password)
 
 	if tperr != nil {
 		return tperr
 	}
 
+	if prevId != 0 && !sameLobby {
+		prevLobby, _ := lobby.GetLobbyByID(prevId)
+		hooks.AfterLobbyLeave(prevLobby, p, false, false)
+	}
+
 	if !sameLobby {
 		hooks.AfterLobbyJoin(so, lob, p)
+
+		// hold slots on the leader's team for the rest of their party
+		if lob.State == lobby.Waiting || lob.State == lobby.Scheduled {
+			if pty, err := party.GetPlayerParty(p.ID); err == nil && pty.LeaderID == p.ID {
+				if _, err := pty.HoldSlots(lob, slot); err != nil {
+					logrus.Error(err)
+				}
+			}
+		}
 	}
 
 	playersCnt := lob.GetPlayerNumber()
 	lastNotif, timerExists := lobbyJoinLastNotif[lob.ID]
-	if playersCnt >= notifThreshold[lob.Type] && !lob.IsEnoughPlayers(playersCnt) && (!timerExists || time.Since(lastNotif).Minutes() > 5) {
+	if lob.State != lobby.Scheduled && playersCnt >= notifThreshold(lob.Type) && !lob.IsEnoughPlayers(playersCnt) && (!timerExists || time.Since(lastNotif).Minutes() > 5) {
 		lob.DiscordNotif(fmt.Sprintf("Almost ready [%d/%d]", playersCnt, lob.RequiredPlayers()))
 		lobbyJoinLastNotif[lob.ID] = time.Now()
 	}
 
 	//check if lobby isn't already in progress (which happens when the player is subbing)
-	lob.Lock()
-	if lob.IsEnoughPlayers(playersCnt) && lob.State != lobby.InProgress && lob.State != lobby.ReadyingUp {
-		lob.State = lobby.ReadyingUp
-		lob.ReadyUpTimestamp = time.Now().Unix() + 30
-		lob.Save()
-
-		helpers.GlobalWait.Add(1)
-		time.AfterFunc(time.Second*30, func() {
-			state := lob.CurrentState()
-			//if all player's haven't readied up,
-			//remove unreadied players and unready the
-			//rest.
-			//don't do this when:
-			//  lobby.State == Waiting (someone already unreadied up, so all players have been unreadied)
-			// lobby.State == InProgress (all players have readied up, so the lobby has started)
-			// lobby.State == Ended (the lobby has been closed)
-			if state != lobby.Waiting && state != lobby.InProgress && state != lobby.Ended {
-				lob.SetState(lobby.Waiting)
-				removeUnreadyPlayers(lob)
-				lob.UnreadyAllPlayers()
-				//get updated lobby object
-				lob, _ = lobby.GetLobbyByID(lob.ID)
-				lobby.BroadcastLobby(lob)
-			}
-			helpers.GlobalWait.Done()
-		})
-
-		room := fmt.Sprintf("%s_private",
-			hooks.GetLobbyRoom(lob.ID))
-		broadcaster.SendMessageToRoom(room, "lobbyReadyUp",
-			struct {
-				Timeout int `json:"timeout"`
-			}{30})
-		lobby.BroadcastLobbyList()
+	if lob.IsEnoughPlayers(playersCnt) && lob.State == lobby.Waiting {
+		startReadyUp(lob)
 	}
-	lob.Unlock()
 
 	if lob.State == lobby.InProgress { //this happens when the player is a substitute
 		db.DB.Preload("ServerInfo").First(lob, lob.ID)
@@ -577,6 +657,64 @@ func (Lobby) LobbyJoin(so *wsevent.Client, args struct {
 	return emptySuccess
 }
 
+// startReadyUp starts readying up the lobby, which has enough players. Players
+// who haven't readied up after 30 seconds are removed. Nothing happens if the
+// lobby isn't waiting anymore, or another caller has started readying it up.
+func startReadyUp(lob *lobby.Lobby) {
+	changed, err := lob.ChangeState(lobby.Waiting, lobby.ReadyingUp)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if !changed {
+		return
+	}
+
+	lob.ReadyUpTimestamp = time.Now().Unix() + 30
+	db.DB.Model(&lobby.Lobby{}).Where("id = ?", lob.ID).UpdateColumn("ready_up_timestamp", lob.ReadyUpTimestamp)
+	lob.OnChange(true)
+	if err := lob.ApplyMapVote(); err != nil {
+		logrus.Error(err)
+	}
+	opened := lob.CreatedAt
+	if lob.IsScheduled() {
+		opened = lob.ScheduledTime()
+	}
+	metrics.LobbyFillSeconds.WithLabelValues(format.FriendlyName(lob.Type)).
+		Observe(time.Since(opened).Seconds())
+
+	helpers.GlobalWait.Add(1)
+	time.AfterFunc(time.Second*30, func() {
+		// if all player's haven't readied up,
+		// remove unreadied players and unready the
+		// rest.
+		// don't do this when:
+		//  lobby.State == Waiting (someone already unreadied up, so all players have been unreadied)
+		// lobby.State == InProgress (all players have readied up, so the lobby has started)
+		// lobby.State == Ended (the lobby has been closed)
+		changed, err := lob.ChangeState(lobby.ReadyingUp, lobby.Waiting)
+		if err != nil {
+			logrus.Error(err)
+		}
+		if changed {
+			removeUnreadyPlayers(lob)
+			lob.UnreadyAllPlayers()
+			// get updated lobby object
+			lob, _ = lobby.GetLobbyByID(lob.ID)
+			lobby.BroadcastLobby(lob)
+			lobby.BroadcastLobbyListChange(lob)
+		}
+		helpers.GlobalWait.Done()
+	})
+
+	room := fmt.Sprintf("%s_private",
+		hooks.GetLobbyRoom(lob.ID))
+	broadcaster.SendMessageToRoom(room, "lobbyReadyUp",
+		struct {
+			Timeout int `json:"timeout"`
+		}{30})
+	lobby.BroadcastLobbyListChange(lob)
+}
+
 //get list of unready players, remove them from lobby (and add them as spectators)
 //plus, call the after lobby leave hook for each player removed
 func removeUnreadyPlayers(lobby *lobby.Lobby) {
@@ -591,6 +729,10 @@ func removeUnreadyPlayers(lobby *lobby.Lobby) {
 func (Lobby) LobbySpectatorJoin(so *wsevent.Client, args struct {
 	Id *uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbySpectatorJoin", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeReadLobbies); err != nil {
+		return err
+	}
 
 	lob, err := lobby.GetLobbyByID(*args.Id)
 
@@ -599,6 +741,9 @@ func (Lobby) LobbySpectatorJoin(so *wsevent.Client, args struct {
 	}
 
 	player := chelpers.GetPlayer(so.Token)
+	if !lob.CanSee(player) {
+		return lobby.ErrNotInvited
+	}
 	var specSameLobby bool
 
 	arr, tperr := player.GetSpectatingIds()
@@ -681,6 +826,10 @@ func (Lobby) LobbyKick(so *wsevent.Client, args struct {
 	Id      *uint   `json:"id"`
 	Steamid *string `json:"steamid"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyKick", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
 
 	steamId := *args.Steamid
 	selfSteamId := so.Token.Claims.(*chelpers.TF2StadiumClaims).SteamID
@@ -709,6 +858,10 @@ func (Lobby) LobbyBan(so *wsevent.Client, args struct {
 	Id      *uint   `json:"id"`
 	Steamid *string `json:"steamid"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyBan", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
 
 	steamId := *args.Steamid
 	selfSteamId := so.Token.Claims.(*chelpers.TF2StadiumClaims).SteamID
@@ -738,6 +891,10 @@ func (Lobby) LobbyBan(so *wsevent.Client, args struct {
 func (Lobby) LobbyLeave(so *wsevent.Client, args struct {
 	Id *uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyLeave", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
 
 	steamId := so.Token.Claims.(*chelpers.TF2StadiumClaims).SteamID
 
@@ -754,6 +911,10 @@ func (Lobby) LobbyLeave(so *wsevent.Client, args struct {
 func (Lobby) LobbySpectatorLeave(so *wsevent.Client, args struct {
 	Id *uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbySpectatorLeave", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeReadLobbies); err != nil {
+		return err
+	}
 
 	player := chelpers.GetPlayer(so.Token)
 	lob, tperr := lobby.GetLobbyByID(*args.Id)
@@ -775,15 +936,34 @@ func (Lobby) LobbySpectatorLeave(so *wsevent.Client, args struct {
 }
 
 func (Lobby) RequestLobbyListData(so *wsevent.Client, _ struct{}) interface{} {
-	so.EmitJSON(helpers.NewRequest("lobbyListData", lobby.DecorateLobbyListData(lobby.GetWaitingLobbies(), false)))
+	defer metrics.ObserveRequest("requestLobbyListData", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeReadLobbies); err != nil {
+		return err
+	}
+
+	so.EmitJSON(helpers.NewRequest("lobbyListData", lobby.GetLobbyListSnapshot().Lobbies))
 
 	return emptySuccess
 }
 
+func (Lobby) RequestLobbyListSnapshot(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("requestLobbyListSnapshot", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeReadLobbies); err != nil {
+		return err
+	}
+
+	return newResponse(lobby.GetLobbyListSnapshot())
+}
+
 func (Lobby) LobbyChangeOwner(so *wsevent.Client, args struct {
 	ID      *uint   `json:"id"`
 	SteamID *string `json:"steamid"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyChangeOwner", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
 	lob, err := lobby.GetLobbyByID(*args.ID)
 	if err != nil {
 		return err
@@ -804,7 +984,7 @@ func (Lobby) LobbyChangeOwner(so *wsevent.Client, args struct {
 	lob.CreatedBySteamID = player2.SteamID
 	lob.Save()
 	lobby.BroadcastLobby(lob)
-	lobby.BroadcastLobbyList()
+	lobby.BroadcastLobbyListChange(lob)
 	chat.NewBotMessage(fmt.Sprintf("Lobby leader changed to %s", player2.Alias()), int(*args.ID)).Send()
 
 	return emptySuccess
@@ -818,6 +998,10 @@ func (Lobby) LobbySetRequirement(so *wsevent.Client, args struct {
 	Value    *json.Number `json:"value"`
 	Password *string      `json:"password" empty:"-"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbySetRequirement", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
 
 	lob, err := lobby.GetLobbyByID(*args.ID)
 	if err != nil {
@@ -829,7 +1013,7 @@ func (Lobby) LobbySetRequirement(so *wsevent.Client, args struct {
 		return errors.New("Only lobby owners can change requirements.")
 	}
 
-	if !(*args.Slot >= 0 && *args.Slot < 2*format.NumberOfClassesMap[lob.Type]) {
+	if !(*args.Slot >= 0 && *args.Slot < 2*format.NumberOfClasses(lob.Type)) {
 		return errors.New("Invalid slot.")
 	}
 
@@ -874,7 +1058,7 @@ func (Lobby) LobbySetRequirement(so *wsevent.Client, args struct {
 		req.Save()
 	}
 	lobby.BroadcastLobby(lob)
-	lobby.BroadcastLobbyList()
+	lobby.BroadcastLobbyListChange(lob)
 
 	return emptySuccess
 }
@@ -884,6 +1068,11 @@ func (Lobby) LobbySetTeamName(so *wsevent.Client, args struct {
 	Team    string `json:"team"`
 	NewName string `json:"name"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbySetTeamName", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 
 	lob, err := lobby.GetLobbyByID(args.Id)
@@ -915,6 +1104,11 @@ func (Lobby) LobbySetTeamName(so *wsevent.Client, args struct {
 func (Lobby) LobbyRemoveTwitchRestriction(so *wsevent.Client, args struct {
 	ID uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyRemoveTwitchRestriction", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 
 	lob, err := lobby.GetLobbyByID(args.ID)
@@ -930,7 +1124,7 @@ func (Lobby) LobbyRemoveTwitchRestriction(so *wsevent.Client, args struct {
 	lob.Save()
 
 	lobby.BroadcastLobby(lob)
-	lobby.BroadcastLobbyList()
+	lobby.BroadcastLobbyListChange(lob)
 
 	return emptySuccess
 }
@@ -938,6 +1132,11 @@ func (Lobby) LobbyRemoveTwitchRestriction(so *wsevent.Client, args struct {
 func (Lobby) LobbyRemoveSteamRestriction(so *wsevent.Client, args struct {
 	ID uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyRemoveSteamRestriction", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 
 	lob, err := lobby.GetLobbyByID(args.ID)
@@ -953,7 +1152,7 @@ func (Lobby) LobbyRemoveSteamRestriction(so *wsevent.Client, args struct {
 	lob.Save()
 
 	lobby.BroadcastLobby(lob)
-	lobby.BroadcastLobbyList()
+	lobby.BroadcastLobbyListChange(lob)
 
 	return emptySuccess
 }
@@ -961,6 +1160,11 @@ func (Lobby) LobbyRemoveSteamRestriction(so *wsevent.Client, args struct {
 func (Lobby) LobbyRemoveRegionLock(so *wsevent.Client, args struct {
 	ID uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyRemoveRegionLock", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 
 	lob, err := lobby.GetLobbyByID(args.ID)
@@ -976,7 +1180,7 @@ func (Lobby) LobbyRemoveRegionLock(so *wsevent.Client, args struct {
 	lob.Save()
 
 	lobby.BroadcastLobby(lob)
-	lobby.BroadcastLobbyList()
+	lobby.BroadcastLobbyListChange(lob)
 
 	return emptySuccess
 
@@ -985,6 +1189,11 @@ func (Lobby) LobbyRemoveRegionLock(so *wsevent.Client, args struct {
 func (Lobby) LobbyShuffle(so *wsevent.Client, args struct {
 	Id uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbyShuffle", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 
 	lob, err := lobby.GetLobbyByID(args.Id)
@@ -1005,3 +1214,209 @@ func (Lobby) LobbyShuffle(so *wsevent.Client, args struct {
 	chat.NewBotMessage(fmt.Sprintf("Lobby shuffled by %s", player.Alias()), int(args.Id)).Send()
 	return emptySuccess
 }
+
+func (Lobby) LobbyMapVote(so *wsevent.Client, args struct {
+	Id  uint   `json:"id"`
+	Map string `json:"map"`
+}) interface{} {
+	defer metrics.ObserveRequest("lobbyMapVote", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	player := chelpers.GetPlayer(so.Token)
+
+	lob, err := lobby.GetLobbyByID(args.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := lob.VoteMap(player, args.Map); err != nil {
+		return err
+	}
+	return emptySuccess
+}
+
+// waitForServeme waits for up to 3 minutes for the serveme reservation to be
+// ready
+func waitForServeme(context *servemetf.Context, id int, steamID string) error {
+	now := time.Now()
+
+	for {
+		status, err := context.Status(id, steamID)
+		if err != nil {
+			logrus.Error(err)
+		}
+		if status == "ready" {
+			return nil
+		}
+
+		time.Sleep(10 * time.Second)
+		if time.Since(now) >= 3*time.Minute {
+			return errors.New("Couldn't get Serveme reservation, try another server.")
+		}
+	}
+}
+
+func (Lobby) LobbyRematch(so *wsevent.Client, args struct {
+	Id uint `json:"id"`
+}) interface{} {
+	defer metrics.ObserveRequest("lobbyRematch", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	if banned, until := p.IsBannedWithTime(player.BanCreate); banned {
+		ban, _ := p.GetActiveBan(player.BanCreate)
+		return fmt.Errorf("You've been banned from creating lobbies till %s (%s)", until.Format(time.RFC822), ban.Reason)
+	}
+
+	lob, err := lobby.GetLobbyByID(args.Id)
+	if err != nil {
+		return err
+	}
+
+	if p.SteamID != lob.CreatedBySteamID && (p.Role != helpers.RoleAdmin && p.Role != helpers.RoleMod) {
+		return errors.New("You aren't authorized to rematch this lobby.")
+	}
+	if p.HasCreatedLobby() {
+		if p.Role != helpers.RoleAdmin && p.Role != helpers.RoleMod {
+			return errors.New("You have already created a lobby.")
+		}
+	}
+	if lobby.MapRegionFormatExists(lob.MapName, lob.RegionCode, lob.Type) {
+		return errors.New("Your region already has a lobby with this map and format.")
+	}
+
+	rematch, err := lob.NewRematch()
+	if err != nil {
+		return err
+	}
+	if rematch.ServemeID != 0 {
+		context := helpers.GetServemeContext(rematch.ServerInfo.Host)
+		if err := waitForServeme(context, rematch.ServemeID, rematch.CreatedBySteamID); err != nil {
+			rematch.Delete()
+			return err
+		}
+		rematch.ServemeCheck(context)
+	}
+
+	if err := rematch.SetupServer(); err != nil {
+		rematch.Delete()
+		return err
+	}
+	rematch.OpenRematch()
+
+	chat.NewBotMessage(fmt.Sprintf("Rematch of lobby #%d created by %s", lob.ID, p.Alias()), int(rematch.ID)).Send()
+	chat.SendNotification(fmt.Sprintf("Rematch: %s/lobby/%d", config.Constants.LoginRedirectPath, rematch.ID), int(lob.ID))
+
+	// players from the previous lobby have a while to take their slots again
+	for _, id := range lob.GetRematchPlayers() {
+		prev, err := player.GetPlayerByID(id)
+		if err != nil {
+			continue
+		}
+		broadcaster.SendMessage(prev.SteamID, "lobbyRematch", struct {
+			ID       uint  `json:"id"`
+			Previous uint  `json:"previous"`
+			Timeout  int64 `json:"timeout"`
+		}{rematch.ID, lob.ID, int64(lobby.RematchPriority / time.Second)})
+	}
+
+	lobby.BroadcastLobbyListChange(rematch)
+	return newResponse(
+		struct {
+			ID uint `json:"id"`
+		}{rematch.ID})
+}
+
+// LobbyInviteLink creates an invite link for the private lobby. Links expire
+// after expiresIn seconds and can be used by maxUses players, if those aren't 0.
+func (Lobby) LobbyInviteLink(so *wsevent.Client, args struct {
+	Id        *uint `json:"id"`
+	ExpiresIn int64 `json:"expiresIn"`
+	MaxUses   int   `json:"maxUses"`
+}) interface{} {
+	defer metrics.ObserveRequest("lobbyInviteLink", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	lob, err := lobby.GetLobbyByID(*args.Id)
+	if err != nil {
+		return err
+	}
+
+	if p.SteamID != lob.CreatedBySteamID && (p.Role != helpers.RoleAdmin && p.Role != helpers.RoleMod) {
+		return errors.New("You aren't authorized to invite players to this lobby.")
+	}
+	if !lob.Private {
+		return errors.New("Only private lobbies need invites.")
+	}
+	if lob.State == lobby.Ended {
+		return errors.New("Lobby has ended")
+	}
+	if args.ExpiresIn < 0 || args.MaxUses < 0 {
+		return errors.New("Invalid expiry or number of uses.")
+	}
+
+	invite, err := lob.NewInviteLink(time.Duration(args.ExpiresIn)*time.Second, args.MaxUses)
+	if err != nil {
+		return err
+	}
+
+	token := invite.Token()
+	return newResponse(struct {
+		Token     string     `json:"token"`
+		URL       string     `json:"url"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+		MaxUses   int        `json:"maxUses"`
+	}{token, fmt.Sprintf("%s/lobby/%d?invite=%s", config.Constants.LoginRedirectPath, lob.ID, token), invite.ExpiresAt, invite.MaxUses})
+}
+
+// LobbyInvitePlayers invites players to the private lobby, and sends them a
+// lobbyInvite message
+func (Lobby) LobbyInvitePlayers(so *wsevent.Client, args struct {
+	Id       *uint     `json:"id"`
+	SteamIDs *[]string `json:"steamids"`
+}) interface{} {
+	defer metrics.ObserveRequest("lobbyInvitePlayers", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	lob, err := lobby.GetLobbyByID(*args.Id)
+	if err != nil {
+		return err
+	}
+
+	if p.SteamID != lob.CreatedBySteamID && (p.Role != helpers.RoleAdmin && p.Role != helpers.RoleMod) {
+		return errors.New("You aren't authorized to invite players to this lobby.")
+	}
+	if !lob.Private {
+		return errors.New("Only private lobbies need invites.")
+	}
+	if lob.State == lobby.Ended {
+		return errors.New("Lobby has ended")
+	}
+
+	var players []*player.Player
+	for _, steamID := range *args.SteamIDs {
+		invited, err := player.GetPlayerBySteamID(steamID)
+		if err != nil {
+			return fmt.Errorf("Couldn't find player %s", steamID)
+		}
+		players = append(players, invited)
+	}
+
+	for _, invited := range players {
+		if err := lob.InvitePlayer(invited, p); err != nil {
+			return err
+		}
+	}
+
+	return emptySuccess
+}
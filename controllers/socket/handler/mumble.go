@@ -1,7 +1,10 @@
 package handler
 
 import (
+	"time"
+
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/wsevent"
 )
 
@@ -13,6 +16,11 @@ func (Mumble) Name(s string) string {
 }
 
 func (Mumble) ResetMumblePassword(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("resetMumblePassword", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 	player.MumbleAuthkey = player.GenAuthKey()
 	player.Save()
@@ -21,6 +29,11 @@ func (Mumble) ResetMumblePassword(so *wsevent.Client, _ struct{}) interface{} {
 }
 
 func (Mumble) GetMumblePassword(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("getMumblePassword", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 
 	return newResponse(struct {
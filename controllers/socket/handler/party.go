@@ -0,0 +1,158 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
+	"github.com/TF2Stadium/Helen/models/party"
+	"github.com/TF2Stadium/Helen/models/player"
+	"github.com/TF2Stadium/wsevent"
+)
+
+type Party struct{}
+
+func (Party) Name(s string) string {
+	return string((s[0])+32) + s[1:]
+}
+
+func (Party) PartyCreate(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("partyCreate", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	pty, err := party.NewParty(chelpers.GetPlayer(so.Token))
+	if err != nil {
+		return err
+	}
+
+	return newResponse(party.DecorateParty(pty))
+}
+
+func (Party) PartyInvite(so *wsevent.Client, args struct {
+	SteamID *string `json:"steamid"`
+}) interface{} {
+	defer metrics.ObserveRequest("partyInvite", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	pty, err := party.GetPlayerParty(p.ID)
+	if err != nil {
+		return err
+	}
+	if pty.LeaderID != p.ID {
+		return party.ErrNotLeader
+	}
+
+	invited, err := player.GetPlayerBySteamID(*args.SteamID)
+	if err != nil {
+		return fmt.Errorf("Couldn't find player %s", *args.SteamID)
+	}
+	if err := pty.Invite(invited, p); err != nil {
+		return err
+	}
+
+	return emptySuccess
+}
+
+func (Party) PartyJoin(so *wsevent.Client, args struct {
+	ID *uint `json:"id"`
+}) interface{} {
+	defer metrics.ObserveRequest("partyJoin", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	pty, err := party.GetParty(*args.ID)
+	if err != nil {
+		return err
+	}
+	if err := pty.Join(chelpers.GetPlayer(so.Token)); err != nil {
+		return err
+	}
+
+	return newResponse(party.DecorateParty(pty))
+}
+
+func (Party) PartyLeave(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("partyLeave", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	pty, err := party.GetPlayerParty(p.ID)
+	if err != nil {
+		return err
+	}
+	if err := pty.Leave(p); err != nil {
+		return err
+	}
+
+	return emptySuccess
+}
+
+// PartySetClasses sets the classes the player prefers to play, which slots
+// are held for when the party leader joins a lobby
+func (Party) PartySetClasses(so *wsevent.Client, args struct {
+	Classes *[]string `json:"classes"`
+}) interface{} {
+	defer metrics.ObserveRequest("partySetClasses", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	pty, err := party.GetPlayerParty(p.ID)
+	if err != nil {
+		return err
+	}
+	if err := pty.SetClasses(p, *args.Classes); err != nil {
+		return err
+	}
+
+	return emptySuccess
+}
+
+func (Party) PartyChat(so *wsevent.Client, args struct {
+	Message *string `json:"message"`
+}) interface{} {
+	defer metrics.ObserveRequest("partyChat", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	if banned, until := p.IsBannedWithTime(player.BanChat); banned {
+		ban, _ := p.GetActiveBan(player.BanChat)
+		return fmt.Errorf("You've been banned from chatting till %s (%s)", until.Format(time.RFC822), ban.Reason)
+	}
+
+	switch {
+	case len(*args.Message) == 0:
+		return errors.New("Cannot send an empty message")
+
+	case (*args.Message)[0] == '\n':
+		return errors.New("Cannot send messages prefixed with newline")
+
+	case len(*args.Message) > 150:
+		return errors.New("Message too long")
+	}
+
+	pty, err := party.GetPlayerParty(p.ID)
+	if err != nil {
+		return err
+	}
+	pty.SendChat(p, *args.Message)
+
+	return emptySuccess
+}
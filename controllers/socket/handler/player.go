@@ -9,8 +9,8 @@ import (
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
 	"github.com/TF2Stadium/Helen/controllers/controllerhelpers/hooks"
 	"github.com/TF2Stadium/Helen/controllers/socket/sessions"
-	db "github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/models/lobby"
 	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/TF2Stadium/Helen/models/rpc"
@@ -24,6 +24,11 @@ func (Player) Name(s string) string {
 }
 
 func (Player) PlayerReady(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("playerReady", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 	lobbyid, tperr := player.GetLobbyID(false)
 	if tperr != nil {
@@ -49,13 +54,18 @@ func (Player) PlayerReady(so *wsevent.Client, _ struct{}) interface{} {
 		lob.Start()
 
 		hooks.BroadcastLobbyStart(lob)
-		lobby.BroadcastLobbyList()
+		lobby.BroadcastLobbyListChange(lob)
 	}
 
 	return emptySuccess
 }
 
 func (Player) PlayerNotReady(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("playerNotReady", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 	lobbyid, tperr := player.GetLobbyID(false)
 	if tperr != nil {
@@ -92,6 +102,10 @@ func (Player) PlayerNotReady(so *wsevent.Client, _ struct{}) interface{} {
 func (Player) PlayerSettingsGet(so *wsevent.Client, args struct {
 	Key *string `json:"key"`
 }) interface{} {
+	defer metrics.ObserveRequest("playerSettingsGet", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
 
 	player := chelpers.GetPlayer(so.Token)
 	if *args.Key == "*" {
@@ -108,6 +122,10 @@ func (Player) PlayerSettingsSet(so *wsevent.Client, args struct {
 	Key   *string `json:"key"`
 	Value *string `json:"value"`
 }) interface{} {
+	defer metrics.ObserveRequest("playerSettingsSet", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
 
 	player := chelpers.GetPlayer(so.Token)
 
@@ -138,6 +156,10 @@ func (Player) PlayerSettingsSet(so *wsevent.Client, args struct {
 func (Player) PlayerProfile(so *wsevent.Client, args struct {
 	Steamid *string `json:"steamid"`
 }) interface{} {
+	defer metrics.ObserveRequest("playerProfile", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeReadLobbies); err != nil {
+		return err
+	}
 
 	steamid := *args.Steamid
 	if steamid == "" {
@@ -161,6 +183,11 @@ var (
 )
 
 func (Player) PlayerEnableTwitchBot(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("playerEnableTwitchBot", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 	if player.TwitchName == "" {
 		return errors.New("Please connect your Twitch Account first.")
@@ -189,6 +216,11 @@ func (Player) PlayerEnableTwitchBot(so *wsevent.Client, _ struct{}) interface{}
 }
 
 func (Player) PlayerDisableTwitchBot(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("playerDisableTwitchBot", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
 	player := chelpers.GetPlayer(so.Token)
 	if player.TwitchName == "" {
 		return errors.New("Please connect your Twitch Account first.")
@@ -220,6 +252,11 @@ func (Player) PlayerRecentLobbies(so *wsevent.Client, args struct {
 	Lobbies *int    `json:"lobbies"`
 	LobbyID int     `json:"lobbyId"` // start from this lobbyID, 0 when not specified in json
 }) interface{} {
+	defer metrics.ObserveRequest("playerRecentLobbies", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeReadLobbies); err != nil {
+		return err
+	}
+
 	var p *player.Player
 
 	if *args.SteamID != "" {
@@ -233,13 +270,121 @@ func (Player) PlayerRecentLobbies(so *wsevent.Client, args struct {
 		p = chelpers.GetPlayer(so.Token)
 	}
 
-	var lobbies []*lobby.Lobby
-
-	db.DB.Model(&lobby.Lobby{}).Joins("INNER JOIN lobby_slots ON lobbies.ID = lobby_slots.lobby_id").
-		Where("lobbies.match_ended = TRUE and lobby_slots.player_id = ? AND lobby_slots.needs_sub = FALSE AND lobbies.ID >= ?", p.ID, args.LobbyID).
-		Order("lobbies.id desc").
-		Limit(*args.Lobbies).
-		Find(&lobbies)
+	var minID uint
+	if args.LobbyID > 0 {
+		minID = uint(args.LobbyID)
+	}
 
+	lobbies := lobby.GetPlayerRecentLobbies(p.ID, *args.Lobbies, minID, 0)
+	lobbies = lobby.FilterVisible(lobbies, chelpers.GetPlayer(so.Token))
 	return newResponse(lobby.DecorateLobbyListData(lobbies, true))
 }
+
+func (Player) PlayerAPITokenList(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("playerAPITokenList", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	tokens, err := chelpers.GetPlayer(so.Token).GetAPITokens()
+	if err != nil {
+		return err
+	}
+
+	return newResponse(tokens)
+}
+
+func (Player) PlayerAPITokenCreate(so *wsevent.Client, args struct {
+	Name        *string   `json:"name"`
+	Scopes      *[]string `json:"scopes"`
+	Application *bool     `json:"application" empty:"-"`
+}) interface{} {
+	defer metrics.ObserveRequest("playerAPITokenCreate", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	if len(*args.Name) == 0 || len(*args.Name) > 64 {
+		return errors.New("Token name must be between 1 and 64 characters long.")
+	}
+
+	application := args.Application != nil && *args.Application
+	if application {
+		if err := chelpers.CheckPrivilege(so, helpers.ActionIssueAppTokens); err != nil {
+			return err
+		}
+	}
+
+	var scopes []player.TokenScope
+	for _, scope := range *args.Scopes {
+		// developers can view admin pages too, so check for a moderator permission
+		if player.TokenScope(scope) == player.ScopeModerate {
+			if err := chelpers.CheckPrivilege(so, helpers.ActionDeleteChat); err != nil {
+				return err
+			}
+		}
+		scopes = append(scopes, player.TokenScope(scope))
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	token, secret, err := p.NewAPIToken(*args.Name, application, scopes)
+	if err != nil {
+		return err
+	}
+
+	// the token itself is only ever shown once
+	return newResponse(struct {
+		Token  *player.APIToken `json:"token"`
+		Secret string           `json:"secret"`
+	}{token, secret})
+}
+
+func (Player) PlayerAPITokenRevoke(so *wsevent.Client, args struct {
+	ID *uint `json:"id"`
+}) interface{} {
+	defer metrics.ObserveRequest("playerAPITokenRevoke", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	err := chelpers.GetPlayer(so.Token).RevokeAPIToken(*args.ID)
+	if err != nil {
+		return err
+	}
+
+	return emptySuccess
+}
+
+func (Player) PlayerSessionList(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("playerSessionList", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	sessions, err := chelpers.GetPlayer(so.Token).GetSessions()
+	if err != nil {
+		return err
+	}
+
+	return newResponse(struct {
+		Sessions []*player.Session `json:"sessions"`
+		Current  uint              `json:"current"`
+	}{sessions, so.Token.Claims.(*chelpers.TF2StadiumClaims).SessionID})
+}
+
+func (Player) PlayerSessionRevoke(so *wsevent.Client, args struct {
+	ID *uint `json:"id"`
+}) interface{} {
+	defer metrics.ObserveRequest("playerSessionRevoke", time.Now())
+	if err := chelpers.CheckSession(so); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	if err := p.RevokeSession(*args.ID); err != nil {
+		return err
+	}
+
+	chelpers.ForceReauth(p.SteamID, *args.ID)
+	return emptySuccess
+}
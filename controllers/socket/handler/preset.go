@@ -0,0 +1,173 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/TF2Stadium/Helen/models/lobby_settings"
+	"github.com/TF2Stadium/Helen/models/player"
+	"github.com/TF2Stadium/wsevent"
+)
+
+// lobbyPreset has the lobbyCreate options players can save in presets. The
+// server and password are given every time a lobby is created.
+type lobbyPreset struct {
+	Map         string `json:"map"`
+	Type        string `json:"type"`
+	League      string `json:"league"`
+	WhitelistID string `json:"whitelistID"`
+	Mumble      bool   `json:"mumbleRequired"`
+
+	SteamGroupWhitelist        string `json:"steamGroupWhitelist,omitempty"`
+	TwitchWhitelistSubscribers bool   `json:"twitchWhitelistSubs,omitempty"`
+	TwitchWhitelistFollowers   bool   `json:"twitchWhitelistFollows,omitempty"`
+	RegionLock                 bool   `json:"regionLock,omitempty"`
+	Private                    bool   `json:"private,omitempty"`
+
+	Requirements *Requirements    `json:"requirements,omitempty"`
+	Discord      *DiscordChannels `json:"discord,omitempty"`
+}
+
+// validate checks the preset against the lobby settings, like lobbyCreate
+func (preset *lobbyPreset) validate(p *player.Player) error {
+	lobbyType, ok := format.FromType(preset.Type)
+	if !ok {
+		return errors.New("Invalid lobby type.")
+	}
+	customMap := p.Role.Can(helpers.ActionCustomMap)
+	if err := lobbySettings.ValidateLobby(lobbyType.Name, preset.Map, preset.League, preset.WhitelistID, customMap); err != nil {
+		return err
+	}
+
+	if preset.SteamGroupWhitelist != "" && !reSteamGroup.MatchString(preset.SteamGroupWhitelist) {
+		return errors.New("Invalid Steam group URL")
+	}
+	if preset.Discord != nil {
+		red, blu := preset.Discord.RedChannel, preset.Discord.BluChannel
+		if red == nil || blu == nil || !reDiscordInvite.MatchString(*red) || !reDiscordInvite.MatchString(*blu) {
+			return errors.New("Invalid Discord invite URL")
+		}
+	}
+
+	return nil
+}
+
+// createArgs fills args with the preset's options
+func (preset *lobbyPreset) createArgs(args lobbyCreateArgs) lobbyCreateArgs {
+	args.Map = &preset.Map
+	args.Type = &preset.Type
+	args.League = &preset.League
+	args.WhitelistID = &preset.WhitelistID
+	args.Mumble = &preset.Mumble
+	args.SteamGroupWhitelist = &preset.SteamGroupWhitelist
+	args.TwitchWhitelistSubscribers = preset.TwitchWhitelistSubscribers
+	args.TwitchWhitelistFollowers = preset.TwitchWhitelistFollowers
+	args.RegionLock = preset.RegionLock
+	args.Private = preset.Private
+	args.Requirements = preset.Requirements
+	args.Discord = preset.Discord
+	return args
+}
+
+func (Player) PlayerLobbyPresetList(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("playerLobbyPresetList", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
+	presets, err := chelpers.GetPlayer(so.Token).GetLobbyPresets()
+	if err != nil {
+		return err
+	}
+
+	return newResponse(presets)
+}
+
+func (Player) PlayerLobbyPresetSave(so *wsevent.Client, args struct {
+	Name    *string      `json:"name"`
+	Options *lobbyPreset `json:"options"`
+}) interface{} {
+	defer metrics.ObserveRequest("playerLobbyPresetSave", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	if err := args.Options.validate(p); err != nil {
+		return err
+	}
+
+	options, _ := json.Marshal(args.Options)
+	preset, err := p.SaveLobbyPreset(*args.Name, options)
+	if err != nil {
+		return err
+	}
+
+	return newResponse(preset)
+}
+
+func (Player) PlayerLobbyPresetDelete(so *wsevent.Client, args struct {
+	ID *uint `json:"id"`
+}) interface{} {
+	defer metrics.ObserveRequest("playerLobbyPresetDelete", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
+	err := chelpers.GetPlayer(so.Token).DeleteLobbyPreset(*args.ID)
+	if err != nil {
+		return err
+	}
+
+	return emptySuccess
+}
+
+// LobbyCreatePreset creates a lobby with the options saved in one of the
+// player's presets, on the given server
+func (Lobby) LobbyCreatePreset(so *wsevent.Client, args struct {
+	ID           *uint          `json:"id"`
+	ServerType   *string        `json:"serverType" valid:"server,storedServer,serveme"`
+	Serveme      *servemeServer `json:"serveme" empty:"-"`
+	Server       *string        `json:"server" empty:"-"`
+	RconPwd      *string        `json:"rconpwd" empty:"-"`
+	Password     *string        `json:"password" empty:"-"`
+	ScheduledFor int64          `json:"scheduledFor"`
+}) interface{} {
+	defer metrics.ObserveRequest("lobbyCreatePreset", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
+	p := chelpers.GetPlayer(so.Token)
+	saved, err := p.GetLobbyPreset(*args.ID)
+	if err != nil {
+		return err
+	}
+
+	preset := &lobbyPreset{}
+	if err := json.Unmarshal([]byte(saved.Options), preset); err != nil {
+		return err
+	}
+	// the lobby settings might have changed since the preset was saved
+	if err := preset.validate(p); err != nil {
+		return err
+	}
+
+	return createLobby(so, preset.createArgs(lobbyCreateArgs{
+		ServerType:   args.ServerType,
+		Serveme:      args.Serveme,
+		Server:       args.Server,
+		RconPwd:      args.RconPwd,
+		Password:     args.Password,
+		ScheduledFor: args.ScheduledFor,
+	}))
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/models/chat"
+	"github.com/TF2Stadium/Helen/models/gameserver"
+	"github.com/TF2Stadium/Helen/models/job"
+	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/servemetf"
+	"github.com/sirupsen/logrus"
+)
+
+const jobOpenLobby = "openScheduledLobby"
+
+// how long after a scheduled lobby should have opened its serveme reservation
+// can take to be ready
+const servemeReadyTimeout = 3 * time.Minute
+
+// how long a scheduled lobby waits to open if its server is in use, or its
+// region already has a lobby with the same map and format
+const openConflictTimeout = 10 * time.Minute
+
+type openLobbyJob struct {
+	LobbyID uint   `json:"lobbyID"`
+	Serveme string `json:"serveme,omitempty"` // the serveme context's host, for serveme lobbies
+}
+
+func init() {
+	job.Register(jobOpenLobby, openLobby)
+}
+
+func enqueueOpenLobby(lob *lobby.Lobby, context *servemetf.Context) error {
+	args := openLobbyJob{LobbyID: lob.ID}
+	if context != nil {
+		args.Serveme = context.Host
+	}
+
+	return job.Enqueue(jobOpenLobby, args, job.Options{
+		Key:   fmt.Sprint(lob.ID),
+		Delay: lob.ScheduledTime().Sub(time.Now()),
+	})
+}
+
+// openLobby sets up the server of a scheduled lobby, and opens it. If enough
+// players signed up, the lobby starts readying up.
+func openLobby(payload json.RawMessage) error {
+	var args openLobbyJob
+	if err := json.Unmarshal(payload, &args); err != nil {
+		return err
+	}
+
+	lob, err := lobby.GetLobbyByIDServer(args.LobbyID)
+	if err != nil || lob.CurrentState() != lobby.Scheduled {
+		// closed before it opened
+		return nil
+	}
+
+	// both were checked when the lobby was created, but could have changed.
+	// Stored servers are only taken now, others could use them until then.
+	var conflict string
+	if lob.ServemeID == 0 && lob.ServerInUse() {
+		conflict = "Another lobby is using the server"
+	} else if lobby.MapRegionFormatExists(lob.MapName, lob.RegionCode, lob.Type) {
+		conflict = "The region already has a lobby with this map and format"
+	} else if lob.ServemeID == 0 && gameserver.TakeStoredServer(lob.ServerInfo.Host) != nil {
+		conflict = "Another lobby is using the server"
+	}
+	if conflict != "" {
+		if time.Since(lob.ScheduledTime()) < openConflictTimeout {
+			return job.After(30 * time.Second)
+		}
+
+		chat.SendNotification(fmt.Sprintf("Lobby Closed (%s)", conflict), int(lob.ID))
+		lob.Close(false, false)
+		return nil
+	}
+
+	var context *servemetf.Context
+	if lob.ServemeID != 0 {
+		context = helpers.GetServemeContextHost(args.Serveme)
+		status, err := context.Status(lob.ServemeID, lob.CreatedBySteamID)
+		if err != nil {
+			logrus.Error(err)
+		}
+		if status != "ready" {
+			if time.Since(lob.ScheduledTime()) < servemeReadyTimeout {
+				return job.After(10 * time.Second)
+			}
+
+			chat.SendNotification("Lobby Closed (Couldn't get Serveme reservation)", int(lob.ID))
+			lob.Close(false, false)
+			return nil
+		}
+		lob.ServemeCheck(context)
+	}
+
+	// the lobby is still scheduled, so closing it doesn't put back the
+	// stored server it has just taken
+	putServer := func() {
+		if lob.ServemeID == 0 {
+			gameserver.PutStoredServer(lob.ServerInfo.Host)
+		}
+	}
+
+	if err := lob.SetupServer(); err != nil {
+		logrus.Error(err)
+		chat.SendNotification("Lobby Closed (Couldn't set up the server)", int(lob.ID))
+		lob.Close(false, false)
+		putServer()
+		return nil
+	}
+
+	changed, err := lob.ChangeState(lobby.Scheduled, lobby.Waiting)
+	if err != nil {
+		putServer()
+		return err
+	}
+	if !changed {
+		// closed while the server was set up
+		putServer()
+		return nil
+	}
+	chat.SendNotification("Lobby opened", int(lob.ID))
+	lobby.BroadcastLobby(lob)
+	lobby.BroadcastLobbyListChange(lob)
+	if lob.IsEnoughPlayers(lob.GetPlayerNumber()) {
+		startReadyUp(lob)
+	}
+
+	return nil
+}
@@ -3,11 +3,14 @@ package handler
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
 	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/models/gameserver"
+	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/TF2Stadium/servemetf"
 	"github.com/TF2Stadium/wsevent"
 )
@@ -19,6 +22,11 @@ func (Serveme) Name(s string) string {
 }
 
 func (Serveme) GetServemeServers(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("getServemeServers", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
 	context := helpers.GetServemeContextIP(chelpers.GetIPAddr(so.Request))
 
 	starts, ends, err := context.GetReservationTime(so.Token.Claims.(*chelpers.TF2StadiumClaims).SteamID)
@@ -53,6 +61,11 @@ func (Serveme) GetServemeServers(so *wsevent.Client, _ struct{}) interface{} {
 }
 
 func (Serveme) GetStoredServers(so *wsevent.Client, _ struct{}) interface{} {
+	defer metrics.ObserveRequest("getStoredServers", time.Now())
+	if err := chelpers.CheckScope(so, player.ScopeCreateLobbies); err != nil {
+		return err
+	}
+
 	servers := gameserver.GetAvailableServers()
 	return newResponse(servers)
 }
@@ -2,10 +2,12 @@ package handler
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/TF2Stadium/Helen/controllers/controllerhelpers/hooks"
 	"github.com/TF2Stadium/Helen/controllers/socket/sessions"
 	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/models/lobby"
 	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/TF2Stadium/Helen/routes/socket"
@@ -21,12 +23,17 @@ func (Unauth) Name(s string) string {
 func (Unauth) LobbySpectatorJoin(so *wsevent.Client, args struct {
 	ID *uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbySpectatorJoin", time.Now())
 
 	lob, err := lobby.GetLobbyByID(*args.ID)
 
 	if err != nil {
 		return err
 	}
+	// players have to log in to spectate private lobbies they're invited to
+	if !lob.CanSee(nil) {
+		return lobby.ErrLobbyNotFound
+	}
 
 	hooks.AfterLobbySpec(socket.UnauthServer, so, nil, lob)
 
@@ -38,6 +45,7 @@ func (Unauth) LobbySpectatorJoin(so *wsevent.Client, args struct {
 func (Unauth) LobbySpectatorLeave(so *wsevent.Client, args struct {
 	ID *uint `json:"id"`
 }) interface{} {
+	defer metrics.ObserveRequest("lobbySpectatorLeave", time.Now())
 
 	id, ok := sessions.GetSpectating(so.ID)
 	if ok {
@@ -51,6 +59,7 @@ func (Unauth) LobbySpectatorLeave(so *wsevent.Client, args struct {
 func (Unauth) PlayerProfile(so *wsevent.Client, args struct {
 	Steamid *string `json:"steamid"`
 }) interface{} {
+	defer metrics.ObserveRequest("playerProfile", time.Now())
 
 	player, err := player.GetPlayerBySteamID(*args.Steamid)
 	if err != nil {
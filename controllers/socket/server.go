@@ -3,11 +3,21 @@ package socket
 import (
 	"github.com/TF2Stadium/Helen/controllers/controllerhelpers/hooks"
 	"github.com/TF2Stadium/Helen/controllers/socket/handler"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/internal/pprof"
 	"github.com/TF2Stadium/Helen/routes/socket"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+func socketsGauge(auth string, server func() int) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "helen_sockets",
+		Help:        "Number of websockets connected to this instance, by whether they're logged in.",
+		ConstLabels: prometheus.Labels{"auth": auth},
+	}, func() float64 { return float64(server()) })
+}
+
 func RegisterHandlers() {
 	socket.AuthServer.OnDisconnect = hooks.OnDisconnect
 	socket.UnauthServer.OnDisconnect = func(string, *jwt.Token) { pprof.Clients.Add(-1) }
@@ -16,8 +26,14 @@ func RegisterHandlers() {
 	socket.AuthServer.Register(handler.Lobby{})  //Lobby Handlers
 	socket.AuthServer.Register(handler.Player{}) //Player Handlers
 	socket.AuthServer.Register(handler.Chat{})   //Chat Handlers
+	socket.AuthServer.Register(handler.Party{})  //Party Handlers
 	socket.AuthServer.Register(handler.Serveme{})
 	socket.AuthServer.Register(handler.Mumble{})
 
 	socket.UnauthServer.Register(handler.Unauth{})
+
+	metrics.MustRegister(
+		socketsGauge("true", socket.AuthServer.Clients),
+		socketsGauge("false", socket.UnauthServer.Clients),
+	)
 }
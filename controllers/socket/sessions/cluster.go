@@ -0,0 +1,153 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package sessions
+
+import (
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/sirupsen/logrus"
+)
+
+// When running multiple instances, a player's sockets can be connected to
+// different instances, so every socket is also stored in the database, along
+// with the lobby it's spectating. Instances periodically mark their sockets
+// as seen, and sockets which haven't been seen for a while (because their
+// instance crashed) are removed.
+
+const (
+	heartbeatInterval = 10 * time.Second
+	socketTimeout     = 30 * time.Second
+)
+
+// ConnectedSocket is a websocket connected to any instance
+type ConnectedSocket struct {
+	SocketID   string `gorm:"primary_key"`
+	SteamID    string `sql:"not null;index"`
+	InstanceID string `sql:"not null;index"`
+	Spectating uint   // lobby the socket is spectating, 0 if none
+	Region     string // region of the socket's IP address
+	SeenAt     time.Time
+}
+
+func trackSocket(steamid, socketID, region string) {
+	if !config.Constants.Cluster {
+		return
+	}
+
+	err := db.DB.Create(&ConnectedSocket{
+		SocketID:   socketID,
+		SteamID:    steamid,
+		InstanceID: config.Constants.InstanceID,
+		Region:     region,
+		SeenAt:     time.Now(),
+	}).Error
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+func untrackSocket(socketID string) {
+	if !config.Constants.Cluster {
+		return
+	}
+
+	db.DB.Where("socket_id = ?", socketID).Delete(&ConnectedSocket{})
+}
+
+func trackSpectating(socketID string, lobbyID uint) {
+	if !config.Constants.Cluster {
+		return
+	}
+
+	db.DB.Model(&ConnectedSocket{}).Where("socket_id = ?", socketID).
+		UpdateColumn("spectating", lobbyID)
+}
+
+// countSockets returns the number of sockets connected from steamid to all
+// instances
+func countSockets(steamid string) int {
+	var count int
+	db.DB.Model(&ConnectedSocket{}).
+		Where("steam_id = ? AND seen_at > ?", steamid, time.Now().Add(-socketTimeout)).
+		Count(&count)
+	return count
+}
+
+// onlinePlayers returns the region of every player connected to any instance
+func onlinePlayers() map[string]string {
+	players := make(map[string]string)
+
+	rows, err := db.DB.Model(&ConnectedSocket{}).Select("steam_id, COALESCE(min(region), '')").
+		Where("seen_at > ?", time.Now().Add(-socketTimeout)).Group("steam_id").Rows()
+	if err != nil {
+		logrus.Error(err)
+		return players
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var steamid, region string
+		if err := rows.Scan(&steamid, &region); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		players[steamid] = region
+	}
+	return players
+}
+
+// StartHeartbeat removes sockets left over from a previous run of this
+// instance, and starts marking this instance's sockets as seen. onStale is
+// called for every socket removed because its instance stopped responding,
+// with the lobby it was spectating.
+func StartHeartbeat(onStale func(steamid string, spectating uint)) {
+	db.DB.Where("instance_id = ?", config.Constants.InstanceID).Delete(&ConnectedSocket{})
+
+	go func() {
+		for range time.Tick(heartbeatInterval) {
+			err := db.DB.Model(&ConnectedSocket{}).
+				Where("instance_id = ?", config.Constants.InstanceID).
+				UpdateColumn("seen_at", time.Now()).Error
+			if err != nil {
+				logrus.Error(err)
+				continue
+			}
+
+			removeStaleSockets(onStale)
+		}
+	}()
+}
+
+func removeStaleSockets(onStale func(steamid string, spectating uint)) {
+	// every instance does this, RETURNING makes sure only one of them
+	// handles each socket
+	rows, err := db.DB.Raw("DELETE FROM connected_sockets WHERE seen_at < ? RETURNING steam_id, spectating",
+		time.Now().Add(-socketTimeout)).Rows()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	type staleSocket struct {
+		steamid    string
+		spectating uint
+	}
+	var stale []staleSocket
+	for rows.Next() {
+		var s staleSocket
+		if err := rows.Scan(&s.steamid, &s.spectating); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		stale = append(stale, s)
+	}
+	rows.Close()
+
+	for _, s := range stale {
+		onStale(s.steamid, s.spectating)
+	}
+}
@@ -3,6 +3,8 @@ package sessions
 //SetSpectator indicates that the socket with the given socketID is now
 //spectating lobbyID
 func SetSpectator(socketID string, lobbyID uint) {
+	trackSpectating(socketID, lobbyID)
+
 	socketsMu.Lock()
 	defer socketsMu.Unlock()
 	socketSpectating[socketID] = lobbyID
@@ -19,6 +21,8 @@ func GetSpectating(socketID string) (lobbyID uint, ok bool) {
 
 //RemoveSpectator indicates that socketID is no longer spectating the lobby it was earlier.
 func RemoveSpectator(socketID string) {
+	trackSpectating(socketID, 0)
+
 	socketsMu.Lock()
 	defer socketsMu.Unlock()
 	delete(socketSpectating, socketID)
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/TF2Stadium/Helen/config"
 	"github.com/TF2Stadium/wsevent"
 )
 
@@ -14,16 +15,21 @@ var (
 	socketsMu        = new(sync.RWMutex)
 	steamIDSockets   = make(map[string][]*wsevent.Client) //steamid -> client array, since players can have multiple tabs open
 	socketSpectating = make(map[string]uint)              //socketid -> id of lobby the socket is spectating
+	socketRegion     = make(map[string]string)            //socketid -> region of the socket's IP
 	connectedMu      = new(sync.Mutex)
 	connectedTimer   = make(map[string](*time.Timer))
 )
 
-//AddSocket adds so to the list of sockets connected from steamid
-func AddSocket(steamid string, so *wsevent.Client) {
+// AddSocket adds so to the list of sockets connected from steamid. region is
+// the region of the socket's IP address, empty if unknown.
+func AddSocket(steamid, region string, so *wsevent.Client) {
+	trackSocket(steamid, so.ID, region)
+
 	socketsMu.Lock()
 	defer socketsMu.Unlock()
 
 	steamIDSockets[steamid] = append(steamIDSockets[steamid], so)
+	socketRegion[so.ID] = region
 	if len(steamIDSockets[steamid]) == 1 {
 		connectedMu.Lock()
 		timer, ok := connectedTimer[steamid]
@@ -37,6 +43,8 @@ func AddSocket(steamid string, so *wsevent.Client) {
 
 //RemoveSocket removes so from the list of sockets connected from steamid
 func RemoveSocket(sessionID, steamID string) {
+	untrackSocket(sessionID)
+
 	socketsMu.Lock()
 	defer socketsMu.Unlock()
 
@@ -51,30 +59,38 @@ func RemoveSocket(sessionID, steamID string) {
 	}
 
 	steamIDSockets[steamID] = clients
+	delete(socketRegion, sessionID)
 
 	if len(clients) == 0 {
 		delete(steamIDSockets, steamID)
 	}
 }
 
-//GetSockets returns a list of sockets connected from steamid. The second return value is
-//false if they player has no sockets connected
+//GetSockets returns a list of sockets connected from steamid to this instance. The second return value is
+//false if they player has no sockets connected. The returned slice is a copy, so
+// it's safe to use while sockets connect or disconnect.
 func GetSockets(steamid string) (sockets []*wsevent.Client, success bool) {
 	socketsMu.RLock()
 	defer socketsMu.RUnlock()
 
-	sockets, success = steamIDSockets[steamid]
+	clients, success := steamIDSockets[steamid]
+	sockets = append(sockets, clients...)
 	return
 }
 
-//IsConnected returns whether the given steamid is connected to the website
+// IsConnected returns whether the given steamid is connected to the website,
+// on any instance
 func IsConnected(steamid string) bool {
-	_, ok := GetSockets(steamid)
-	return ok
+	return ConnectedSockets(steamid) != 0
 }
 
-//ConnectedSockets returns the number of socket connections from steamid
+// ConnectedSockets returns the number of socket connections from steamid,
+// to all instances
 func ConnectedSockets(steamid string) int {
+	if config.Constants.Cluster {
+		return countSockets(steamid)
+	}
+
 	socketsMu.RLock()
 	l := len(steamIDSockets[steamid])
 	socketsMu.RUnlock()
@@ -82,6 +98,26 @@ func ConnectedSockets(steamid string) int {
 	return l
 }
 
+// OnlinePlayers returns the region of every player connected to any
+// instance, by steamid. For players with sockets from different regions, one
+// of them is picked.
+func OnlinePlayers() map[string]string {
+	if config.Constants.Cluster {
+		return onlinePlayers()
+	}
+
+	socketsMu.RLock()
+	defer socketsMu.RUnlock()
+
+	players := make(map[string]string, len(steamIDSockets))
+	for steamid, sockets := range steamIDSockets {
+		if len(sockets) != 0 {
+			players[steamid] = socketRegion[sockets[0].ID]
+		}
+	}
+	return players
+}
+
 //AfterDisconnectedFunc waits the duration to elapse, and if the player with the given
 //steamid is still disconnected, calls f in it's own goroutine.
 func AfterDisconnectedFunc(steamid string, d time.Duration, f func()) {
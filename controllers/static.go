@@ -28,7 +28,7 @@ func MainHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var p *player.Player
-	token, err := controllerhelpers.GetToken(r)
+	token, err := controllerhelpers.Authenticate(w, r)
 
 	if err == nil {
 		p = controllerhelpers.GetPlayer(token)
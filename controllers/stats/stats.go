@@ -1,35 +1,224 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+// Package stats serves /stats, the number of players online, and in lobbies,
+// per region and format.
 package stats
 
 import (
-	"fmt"
+	"encoding/json"
 	"net/http"
-	"sync/atomic"
+	"sync"
+	"time"
 
+	"github.com/TF2Stadium/Helen/controllers/socket/sessions"
+	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
 	"github.com/TF2Stadium/Helen/routes/socket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	cacheFor        = 10 * time.Second
+	historyInterval = 5 * time.Minute
+	historyLength   = 288 // a day
+	unknownRegion   = "unknown"
+)
+
+// Counts are the number of players in each state. Players in a lobby are
+// counted as online only if they're connected.
+type Counts struct {
+	Online  int `json:"online"`
+	InQueue int `json:"inQueue"` // in a lobby which is filling up
+	InLobby int `json:"inLobby"` // in a lobby which is readying up
+	InGame  int `json:"inGame"`  // in a lobby in progress
+}
+
+func (c *Counts) add(state lobby.State) {
+	switch state {
+	case lobby.Initializing, lobby.Waiting:
+		c.InQueue++
+	case lobby.ReadyingUp:
+		c.InLobby++
+	case lobby.InProgress:
+		c.InGame++
+	}
+}
+
+// Region are the counts for a region, and for every format played in it
+type Region struct {
+	Counts
+	Formats map[string]*Counts `json:"formats"`
+}
+
+func (r *Region) format(name string) *Counts {
+	c, ok := r.Formats[name]
+	if !ok {
+		c = &Counts{}
+		r.Formats[name] = c
+	}
+	return c
+}
+
+// Point is an entry in the history
+type Point struct {
+	Time    time.Time         `json:"time"`
+	Total   Counts            `json:"total"`
+	Regions map[string]Counts `json:"regions"`
+}
+
+// Snapshot is what /stats returns. Players are counted in the region of the
+// lobby they're in, or of their IP address if they aren't in one.
+type Snapshot struct {
+	Time    time.Time          `json:"time"`
+	Total   Counts             `json:"total"`
+	Regions map[string]*Region `json:"regions"`
+	History []Point            `json:"history"` // oldest first
+
+	// online players in some regions, and connected sockets, kept for
+	// older clients
+	NA      int `json:"na"`
+	EU      int `json:"eu"`
+	AU      int `json:"au"`
+	AS      int `json:"as"`
+	Clients int `json:"clients"`
+}
+
+var (
+	mu      sync.Mutex
+	cached  *Snapshot
+	history []Point
 )
 
-var Stats struct {
-	NAPlayers, EUPlayers, AUPlayers, ASPlayers *int64
+func (s *Snapshot) region(code string) *Region {
+	if code == "" {
+		code = unknownRegion
+	}
+
+	r, ok := s.Regions[code]
+	if !ok {
+		r = &Region{Formats: make(map[string]*Counts)}
+		s.Regions[code] = r
+	}
+	return r
+}
+
+func (s *Snapshot) online(code string) int {
+	if r, ok := s.Regions[code]; ok {
+		return r.Online
+	}
+	return 0
+}
+
+func compute() (*Snapshot, error) {
+	slots, err := lobby.GetSlotPlayers()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{
+		Time:    time.Now(),
+		Regions: make(map[string]*Region),
+		Clients: socket.AuthServer.Clients() + socket.UnauthServer.Clients(),
+	}
+	online := sessions.OnlinePlayers()
+
+	for _, slot := range slots {
+		region := s.region(slot.Region)
+		formatCounts := region.format(format.FriendlyName(slot.Type))
+
+		s.Total.add(slot.State)
+		region.add(slot.State)
+		formatCounts.add(slot.State)
+
+		if _, ok := online[slot.SteamID]; ok {
+			s.Total.Online++
+			region.Online++
+			formatCounts.Online++
+			delete(online, slot.SteamID)
+		}
+	}
+
+	// players who aren't in a lobby
+	for _, code := range online {
+		s.Total.Online++
+		s.region(code).Online++
+	}
+
+	s.NA = s.online("na")
+	s.EU = s.online("eu")
+	s.AU = s.online("oc")
+	s.AS = s.online("as")
+	return s, nil
+}
+
+func (s *Snapshot) point() Point {
+	p := Point{Time: s.Time, Total: s.Total, Regions: make(map[string]Counts)}
+	for code, r := range s.Regions {
+		p.Regions[code] = r.Counts
+	}
+	return p
+}
+
+func current() (*Snapshot, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if cached != nil && time.Since(cached.Time) < cacheFor {
+		return cached, nil
+	}
+
+	s, err := compute()
+	if err != nil {
+		return nil, err
+	}
+	s.History = history
+	cached = s
+	return s, nil
 }
 
-func init() {
-	Stats.NAPlayers = new(int64)
-	Stats.EUPlayers = new(int64)
-	Stats.AUPlayers = new(int64)
-	Stats.ASPlayers = new(int64)
+func record() {
+	s, err := compute()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// history is never modified in place, snapshots can share it
+	h := make([]Point, 0, historyLength)
+	if len(history) == historyLength {
+		h = append(h, history[1:]...)
+	} else {
+		h = append(h, history...)
+	}
+	history = append(h, s.point())
+	s.History = history
+	cached = s
+}
+
+// StartHistory records the counts every 5 minutes, keeping the last day of
+// them. The history is kept in memory by every instance.
+func StartHistory() {
+	go func() {
+		record()
+		for range time.Tick(historyInterval) {
+			record()
+		}
+	}()
 }
 
 func StatsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, `{
-"na": %d,
-"eu": %d,
-"au": %d,
-"as": %d,
-"clients": %d
-}`,
-		atomic.LoadInt64(Stats.NAPlayers),
-		atomic.LoadInt64(Stats.EUPlayers),
-		atomic.LoadInt64(Stats.AUPlayers),
-		atomic.LoadInt64(Stats.ASPlayers),
-		socket.AuthServer.Clients()+socket.UnauthServer.Clients())
+	s, err := current()
+	if err != nil {
+		logrus.Error(err)
+		http.Error(w, "Couldn't get stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
 }
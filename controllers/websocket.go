@@ -19,7 +19,7 @@ import (
 var upgrader = websocket.Upgrader{CheckOrigin: func(_ *http.Request) bool { return true }}
 
 func SocketHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := chelpers.GetToken(r)
+	token, err := chelpers.GetSocketToken(r)
 	if err != nil && err != http.ErrNoCookie { //invalid jwt token
 		logrus.Errorf("Error reading JWT: %v", err)
 		token = nil
@@ -5,18 +5,15 @@
 package migrations
 
 import (
-	"github.com/sirupsen/logrus"
+	"fmt"
+
 	db "github.com/TF2Stadium/Helen/database"
 	"github.com/blang/semver"
 )
 
-//follows semantic versioning scheme
-var schemaVersion = semver.Version{
-	Major: 14,
-	Minor: 0,
-	Patch: 0,
-}
-
+// Constant is the table the schema version used to be stored in, before
+// schema_migrations. It's only read to find out which migrations a database
+// created before then has already had applied.
 type Constant struct {
 	SchemaVersion string
 }
@@ -28,38 +25,29 @@ func getCurrConstants() *Constant {
 	return constant
 }
 
-func writeConstants() {
-	db.DB.Exec("UPDATE constants SET schema_version = ?", schemaVersion.String())
-	logrus.Info("Current Schema Version: ", getCurrConstants().SchemaVersion)
-}
-
-func checkSchema() {
-	var count int
-	defer writeConstants()
-
-	db.DB.Model(&Constant{}).Where("schema_version = ?", schemaVersion.String()).Count(&count)
-
-	if count == 1 {
-		return
-	}
+// legacyApplied returns the versions of the migrations that were applied to
+// the database before it had a schema_migrations table. It fails if the stored
+// schema version can't be parsed, rather than guessing and running the legacy
+// routines (which aren't safe to run twice) again.
+func legacyApplied() ([]uint, error) {
+	var applied []uint
 
 	currStr := getCurrConstants().SchemaVersion
 	if currStr == "" {
-		db.DB.Save(&Constant{
-			schemaVersion.String(),
-		})
-		//Initial database migration
-		whitelist_id_string()
-		//Write current schema version
-		return
+		// a new database, which was created with the latest schema, and
+		// only needs the initial migration
+		for v := uint(2); v <= legacyVersion; v++ {
+			applied = append(applied, v)
+		}
+		return applied, nil
 	}
 
-	if v, _ := semver.Parse(currStr); v.Major < schemaVersion.Major {
-		logrus.Warning("Incompatible schema change detected (", currStr, ") attempting to migrate to (", schemaVersion.String(), ")")
-		for i := v.Major + 1; i <= schemaVersion.Major; i++ {
-			logrus.Debug("Calling migration routine for ", schemaVersion.String())
-			f := migrationRoutines[i]
-			f()
-		}
+	v, err := semver.Parse(currStr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the schema version in the constants table (%q): %v", currStr, err)
+	}
+	for i := uint(1); i <= uint(v.Major) && i <= legacyVersion; i++ {
+		applied = append(applied, i)
 	}
+	return applied, nil
 }
@@ -0,0 +1,106 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: helen migrate <command>
+
+commands:
+  status          list migrations, and whether they have been applied
+  up [n]          create missing tables and columns, and apply the next n
+                  pending migrations (default: all)
+  down [n]        roll back the last n applied migrations (default: 1)
+  sql up [n]      print the SQL "up [n]" would run, without running it
+  sql down [n]    print the SQL "down [n]" would run, without running it
+
+The SQL printed by "sql up" doesn't include the tables and columns created
+from the models.`
+
+// Command runs the `helen migrate` subcommand with the given arguments
+func Command(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "status":
+		return printStatus(w)
+	case "up":
+		n, err := getCount(args[1:], 0)
+		if err != nil {
+			return err
+		}
+		autoMigrate()
+		return Up(n)
+	case "down":
+		n, err := getCount(args[1:], 1)
+		if err != nil {
+			return err
+		}
+		return Down(n)
+	case "sql":
+		if len(args) < 2 || (args[1] != "up" && args[1] != "down") {
+			return errors.New(usage)
+		}
+		down, def := args[1] == "down", 0
+		if down {
+			def = 1
+		}
+		n, err := getCount(args[2:], def)
+		if err != nil {
+			return err
+		}
+
+		sql, err := SQL(down, n)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, sql)
+		return err
+	}
+
+	return errors.New(usage)
+}
+
+func getCount(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid number of migrations: %s", args[0])
+	}
+	return n, nil
+}
+
+func printStatus(w io.Writer) error {
+	list, err := Status()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+	for _, m := range list {
+		status := "pending"
+		switch {
+		case m.Baseline:
+			status = "baseline"
+		case m.Applied:
+			status = "applied " + m.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, m.Name, status)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,13 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package migrations
+
+// SetMigrations replaces the list of migrations, returning a function which
+// restores it
+func SetMigrations(list []Migration) func() {
+	old := migrations
+	migrations = list
+	return func() { migrations = old }
+}
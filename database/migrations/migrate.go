@@ -0,0 +1,331 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/sirupsen/logrus"
+)
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	baseline boolean NOT NULL DEFAULT FALSE,
+	applied_at timestamp with time zone NOT NULL DEFAULT now()
+)`
+
+// only one instance can migrate the database at a time
+const lockMigrations = "SELECT pg_advisory_xact_lock(2, 0)"
+
+// MigrationStatus is a migration, and whether it has been applied
+type MigrationStatus struct {
+	Version   uint
+	Name      string
+	Applied   bool
+	Baseline  bool // recorded as applied when schema_migrations was created
+	AppliedAt time.Time
+}
+
+type appliedMigration struct {
+	baseline  bool
+	appliedAt time.Time
+}
+
+func tableExists() bool {
+	var exists bool
+	db.DB.DB().QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	return exists
+}
+
+// getApplied returns the applied migrations. If schema_migrations doesn't
+// exist yet, it returns the ones which will be recorded as the baseline when
+// it's created.
+func getApplied() (map[uint]appliedMigration, error) {
+	applied := make(map[uint]appliedMigration)
+
+	if !tableExists() {
+		baseline, err := legacyApplied()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range baseline {
+			applied[v] = appliedMigration{baseline: true}
+		}
+		return applied, nil
+	}
+
+	rows, err := db.DB.DB().Query("SELECT version, baseline, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version uint
+		var m appliedMigration
+		if err := rows.Scan(&version, &m.baseline, &m.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = m
+	}
+
+	return applied, rows.Err()
+}
+
+// createMigrationsTable creates schema_migrations, recording the migrations
+// applied by the legacy schema version check as the baseline
+func createMigrationsTable() error {
+	if tableExists() {
+		return nil
+	}
+
+	baseline, err := legacyApplied()
+	if err != nil {
+		return err
+	}
+
+	tx := db.DB.Begin()
+	if err := tx.Exec(createTable).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, v := range baseline {
+		err := tx.Exec("INSERT INTO schema_migrations (version, name, baseline) VALUES (?, ?, TRUE)",
+			v, getMigration(v).Name).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if len(baseline) != 0 {
+		logrus.Info("Recorded migrations ", baseline[0], "-", baseline[len(baseline)-1], " as the baseline")
+	}
+	return tx.Commit().Error
+}
+
+func getMigration(version uint) Migration {
+	for _, m := range migrations {
+		if m.Version == version {
+			return m
+		}
+	}
+	return Migration{Version: version, Name: "unknown"}
+}
+
+// pending returns the migrations which haven't been applied, oldest first
+func pending(applied map[uint]appliedMigration) []Migration {
+	var list []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// rollbacks returns the applied migrations, newest first
+func rollbacks(applied map[uint]appliedMigration) []Migration {
+	var list []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[migrations[i].Version]; ok {
+			list = append(list, migrations[i])
+		}
+	}
+	return list
+}
+
+func limit(list []Migration, n int) []Migration {
+	if n > 0 && n < len(list) {
+		return list[:n]
+	}
+	return list
+}
+
+// withLock runs f while holding the migrations lock
+func withLock(f func() error) error {
+	lock := db.DB.Begin()
+	if err := lock.Exec(lockMigrations).Error; err != nil {
+		lock.Rollback()
+		return err
+	}
+	defer lock.Commit()
+
+	return f()
+}
+
+// Status returns every migration, and whether it has been applied
+func Status() ([]MigrationStatus, error) {
+	applied, err := getApplied()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []MigrationStatus
+	for _, m := range migrations {
+		a, ok := applied[m.Version]
+		list = append(list, MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			Baseline:  a.baseline,
+			AppliedAt: a.appliedAt,
+		})
+	}
+	return list, nil
+}
+
+// Pending returns the number of migrations which haven't been applied
+func Pending() (int, error) {
+	applied, err := getApplied()
+	if err != nil {
+		return 0, err
+	}
+	return len(pending(applied)), nil
+}
+
+// Up applies the next n pending migrations, or all of them if n is 0
+func Up(n int) error {
+	return withLock(func() error {
+		if err := createMigrationsTable(); err != nil {
+			return err
+		}
+
+		applied, err := getApplied()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range limit(pending(applied), n) {
+			logrus.Info("Applying migration ", m.Version, " (", m.Name, ")")
+			if err := apply(m); err != nil {
+				return fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the last n applied migrations. It stops at the first one
+// that can't be rolled back.
+func Down(n int) error {
+	return withLock(func() error {
+		if err := createMigrationsTable(); err != nil {
+			return err
+		}
+
+		applied, err := getApplied()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range limit(rollbacks(applied), n) {
+			if !m.reversible() {
+				return fmt.Errorf("migration %d (%s) can't be rolled back", m.Version, m.Name)
+			}
+
+			logrus.Info("Rolling back migration ", m.Version, " (", m.Name, ")")
+			if err := rollback(m); err != nil {
+				return fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func apply(m Migration) error {
+	if m.UpFunc != nil {
+		if err := m.UpFunc(); err != nil {
+			return err
+		}
+		return db.DB.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name).Error
+	}
+
+	tx := db.DB.Begin()
+	for _, stmt := range m.Up {
+		if err := tx.Exec(stmt).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func rollback(m Migration) error {
+	tx := db.DB.Begin()
+	for _, stmt := range m.Down {
+		if err := tx.Exec(stmt).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// SQL returns the statements Up(n) (or Down(n), if down is true) would run,
+// without running them
+func SQL(down bool, n int) (string, error) {
+	applied, err := getApplied()
+	if err != nil {
+		return "", err
+	}
+
+	var buf []string
+	if !tableExists() {
+		buf = append(buf, createTable+";")
+		var versions []int
+		for v := range applied {
+			versions = append(versions, int(v))
+		}
+		sort.Ints(versions)
+		for _, v := range versions {
+			buf = append(buf, fmt.Sprintf("INSERT INTO schema_migrations (version, name, baseline) VALUES (%d, '%s', TRUE);",
+				v, getMigration(uint(v)).Name))
+		}
+	}
+
+	if down {
+		for _, m := range limit(rollbacks(applied), n) {
+			if !m.reversible() {
+				buf = append(buf, fmt.Sprintf("-- %d: %s can't be rolled back", m.Version, m.Name))
+				break
+			}
+			buf = append(buf, migrationSQL(m, m.Down, fmt.Sprintf("DELETE FROM schema_migrations WHERE version = %d;", m.Version)))
+		}
+	} else {
+		for _, m := range limit(pending(applied), n) {
+			record := fmt.Sprintf("INSERT INTO schema_migrations (version, name) VALUES (%d, '%s');", m.Version, m.Name)
+			if m.UpFunc != nil {
+				buf = append(buf, fmt.Sprintf("-- %d: %s\n-- (data migration written in Go, can't be printed)\n%s", m.Version, m.Name, record))
+				continue
+			}
+			buf = append(buf, migrationSQL(m, m.Up, record))
+		}
+	}
+
+	return strings.Join(buf, "\n\n") + "\n", nil
+}
+
+func migrationSQL(m Migration, stmts []string, record string) string {
+	lines := []string{fmt.Sprintf("-- %d: %s", m.Version, m.Name), "BEGIN;"}
+	for _, stmt := range stmts {
+		lines = append(lines, stmt+";")
+	}
+	lines = append(lines, record, "COMMIT;")
+	return strings.Join(lines, "\n")
+}
@@ -5,19 +5,28 @@
 package migrations
 
 import (
-	"sync"
-
 	"github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/models"
 	"github.com/TF2Stadium/Helen/models/chat"
 	"github.com/TF2Stadium/Helen/models/gameserver"
 	"github.com/TF2Stadium/Helen/models/lobby"
 	"github.com/TF2Stadium/Helen/models/player"
+	"github.com/sirupsen/logrus"
 )
 
-var once = new(sync.Once)
-
+// Do creates tables and adds columns for the older models, and then applies
+// all pending migrations. AutoMigrate can't drop or change anything, so every
+// other change to the schema, and new tables, should be a numbered migration
+// (see versions.go).
 func Do() {
+	autoMigrate()
+
+	if err := Up(0); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func autoMigrate() {
 	database.DB.Exec("CREATE EXTENSION IF NOT EXISTS hstore")
 	database.DB.AutoMigrate(&player.Player{})
 	database.DB.AutoMigrate(&lobby.Lobby{})
@@ -31,13 +40,4 @@ func Do() {
 	database.DB.AutoMigrate(&Constant{})
 	database.DB.AutoMigrate(&gameserver.StoredServer{})
 	database.DB.AutoMigrate(&player.Report{})
-
-	database.DB.Model(&lobby.LobbySlot{}).
-		AddUniqueIndex("idx_lobby_slot_lobby_id_slot", "lobby_id", "slot")
-	database.DB.Model(&lobby.LobbySlot{}).
-		AddUniqueIndex("idx_lobby_id_player_id", "lobby_id", "player_id")
-	database.DB.Model(&lobby.LobbySlot{}).
-		AddUniqueIndex("idx_requirement_lobby_id_slot", "lobby_id", "slot")
-
-	once.Do(checkSchema)
 }
@@ -0,0 +1,135 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package migrations_test
+
+import (
+	"errors"
+	"testing"
+
+	db "github.com/TF2Stadium/Helen/database"
+	. "github.com/TF2Stadium/Helen/database/migrations"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	testhelpers.CleanupDB()
+}
+
+var testMigrations = []Migration{
+	{
+		Version: 1000,
+		Name:    "create test table",
+		Up:      []string{"CREATE TABLE migrations_test (id integer)"},
+		Down:    []string{"DROP TABLE migrations_test"},
+	},
+	{
+		Version: 1001,
+		Name:    "add test column",
+		Up:      []string{"ALTER TABLE migrations_test ADD COLUMN name text"},
+		Down:    []string{"ALTER TABLE migrations_test DROP COLUMN name"},
+	},
+}
+
+func tableExists(name string) bool {
+	var exists bool
+	db.DB.DB().QueryRow("SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists)
+	return exists
+}
+
+func getStatus(t *testing.T, version uint) MigrationStatus {
+	list, err := Status()
+	require.NoError(t, err)
+	for _, m := range list {
+		if m.Version == version {
+			return m
+		}
+	}
+	t.Fatalf("migration %d not found", version)
+	return MigrationStatus{}
+}
+
+func TestUpDown(t *testing.T) {
+	defer SetMigrations(testMigrations)()
+
+	require.NoError(t, Up(1))
+	assert.True(t, tableExists("migrations_test"))
+	assert.True(t, getStatus(t, 1000).Applied)
+	assert.False(t, getStatus(t, 1001).Applied)
+
+	n, err := Pending()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	require.NoError(t, Up(0))
+	assert.True(t, getStatus(t, 1001).Applied)
+
+	require.NoError(t, Down(2))
+	assert.False(t, tableExists("migrations_test"))
+	assert.False(t, getStatus(t, 1000).Applied)
+	assert.False(t, getStatus(t, 1001).Applied)
+}
+
+func TestSQLDoesntExecute(t *testing.T) {
+	defer SetMigrations(testMigrations)()
+
+	sql, err := SQL(false, 0)
+	require.NoError(t, err)
+	assert.Contains(t, sql, "CREATE TABLE migrations_test (id integer);")
+	assert.Contains(t, sql, "ALTER TABLE migrations_test ADD COLUMN name text;")
+	assert.False(t, tableExists("migrations_test"))
+
+	sql, err = SQL(false, 1)
+	require.NoError(t, err)
+	assert.NotContains(t, sql, "ADD COLUMN")
+}
+
+func TestFailedMigration(t *testing.T) {
+	defer SetMigrations([]Migration{{
+		Version: 1002,
+		Name:    "broken",
+		Up: []string{
+			"CREATE TABLE migrations_broken (id integer)",
+			"ALTER TABLE migrations_nonexistent ADD COLUMN name text",
+		},
+	}})()
+
+	assert.Error(t, Up(0))
+	// the whole migration is rolled back
+	assert.False(t, tableExists("migrations_broken"))
+	assert.False(t, getStatus(t, 1002).Applied)
+}
+
+func TestIrreversibleMigration(t *testing.T) {
+	defer SetMigrations([]Migration{{
+		Version: 1003,
+		Name:    "irreversible",
+		Up:      []string{"SELECT 1"},
+	}})()
+
+	require.NoError(t, Up(0))
+	assert.Error(t, Down(1))
+	assert.True(t, getStatus(t, 1003).Applied)
+
+	db.DB.Exec("DELETE FROM schema_migrations WHERE version = 1003")
+}
+
+func TestFailedDataMigration(t *testing.T) {
+	defer SetMigrations([]Migration{{
+		Version: 1004,
+		Name:    "broken data migration",
+		UpFunc:  func() error { return errors.New("broken") },
+	}})()
+
+	assert.Error(t, Up(0))
+	assert.False(t, getStatus(t, 1004).Applied)
+}
+
+func TestCreatedTables(t *testing.T) {
+	for _, table := range []string{"jobs", "api_tokens", "sessions", "lobby_settings", "series_games", "party_members", "slot_holds"} {
+		assert.True(t, tableExists(table), table)
+	}
+}
@@ -10,60 +10,63 @@ import (
 	"strings"
 	"time"
 
-	"github.com/sirupsen/logrus"
 	db "github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/helpers"
 	"github.com/TF2Stadium/Helen/models/lobby"
 	"github.com/TF2Stadium/Helen/models/lobby/format"
 	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/sirupsen/logrus"
 )
 
-// major ver -> migration routine
-var migrationRoutines = map[uint64]func(){
-	2:  lobbyTypeChange,
-	3:  dropSubtituteTable,
-	4:  increaseChatMessageLength,
-	5:  updateAllPlayerInfo,
-	6:  truncateHTTPSessions,
-	7:  setMumbleInfo,
-	8:  setPlayerExternalLinks,
-	9:  setPlayerSettings,
-	10: dropTableSessions,
-	11: dropColumnUpdatedAt,
-	12: moveReportsServers,
-	13: dropUnusedColumns,
-	14: downloadSTVDemos,
+// exec runs the statements one after the other, stopping at the first error
+func exec(stmts ...string) error {
+	for _, stmt := range stmts {
+		if err := db.DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func whitelist_id_string() {
+func whitelist_id_string() error {
 	var count int
 
-	db.DB.Model(&lobby.Lobby{}).Count(&count)
+	if err := db.DB.Model(&lobby.Lobby{}).Count(&count).Error; err != nil {
+		return err
+	}
 	if count == 0 {
-		db.DB.Exec("ALTER TABLE lobbies DROP COLUMN whitelist")
-		db.DB.Exec("ALTER TABLE lobbies ADD whitelist varchar(255)")
+		return exec("ALTER TABLE lobbies DROP COLUMN whitelist", "ALTER TABLE lobbies ADD whitelist varchar(255)")
 	}
 
 	var whitelistIDs []int
 	var lobbyIDs []uint
 
-	db.DB.Model(&lobby.Lobby{}).Order("whitelist").Pluck("whitelist", &whitelistIDs)
+	if err := db.DB.Model(&lobby.Lobby{}).Order("whitelist").Pluck("whitelist", &whitelistIDs).Error; err != nil {
+		return err
+	}
 	if len(whitelistIDs) == 0 {
-		return
+		return nil
 	}
 
-	db.DB.Model(&lobby.Lobby{}).Order("id").Pluck("id", &lobbyIDs)
+	if err := db.DB.Model(&lobby.Lobby{}).Order("id").Pluck("id", &lobbyIDs).Error; err != nil {
+		return err
+	}
 
-	db.DB.Exec("ALTER TABLE lobbies DROP whitelist")
-	db.DB.Exec("ALTER TABLE lobbies ADD whitelist varchar(255)")
+	if err := exec("ALTER TABLE lobbies DROP whitelist", "ALTER TABLE lobbies ADD whitelist varchar(255)"); err != nil {
+		return err
+	}
 
 	for i, lobbyID := range lobbyIDs {
-		db.DB.Model(&lobby.Lobby{}).Where("id = ?", lobbyID).Update("whitelist", strconv.Itoa(whitelistIDs[i]))
+		err := db.DB.Model(&lobby.Lobby{}).Where("id = ?", lobbyID).Update("whitelist", strconv.Itoa(whitelistIDs[i])).Error
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func lobbyTypeChange() {
+func lobbyTypeChange() error {
 	newLobbyType := map[int]format.Format{
 		6: format.Sixes,
 		9: format.Highlander,
@@ -74,91 +77,100 @@ func lobbyTypeChange() {
 	}
 
 	var lobbyIDs []uint
-	db.DB.Model(&lobby.Lobby{}).Order("id").Pluck("id", &lobbyIDs)
+	if err := db.DB.Model(&lobby.Lobby{}).Order("id").Pluck("id", &lobbyIDs).Error; err != nil {
+		return err
+	}
 
 	for _, lobbyID := range lobbyIDs {
 		var old int
-		db.DB.DB().QueryRow("SELECT type FROM lobbies WHERE id = $1", lobbyID).Scan(&old)
-		db.DB.Model(&lobby.Lobby{}).Where("id = ?", lobbyID).Update("type", newLobbyType[old])
+		if err := db.DB.DB().QueryRow("SELECT type FROM lobbies WHERE id = $1", lobbyID).Scan(&old); err != nil {
+			return err
+		}
+		if err := db.DB.Model(&lobby.Lobby{}).Where("id = ?", lobbyID).Update("type", newLobbyType[old]).Error; err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func dropSubtituteTable() {
-	db.DB.Exec("DROP TABLE substitutes")
-}
-
-func increaseChatMessageLength() {
-	db.DB.Exec("ALTER TABLE chat_messages ALTER COLUMN message TYPE character varying(150)")
-}
-
-func updateAllPlayerInfo() {
+func updateAllPlayerInfo() error {
 	var players []*player.Player
-	db.DB.Model(&player.Player{}).Find(&players)
+	if err := db.DB.Model(&player.Player{}).Find(&players).Error; err != nil {
+		return err
+	}
 
 	for _, player := range players {
-		player.UpdatePlayerInfo()
-		player.Save()
+		if err := player.UpdatePlayerInfo(); err != nil {
+			return err
+		}
+		if err := player.Save(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func truncateHTTPSessions() {
-	db.DB.Exec("TRUNCATE TABLE http_sessions")
-}
-
-func setMumbleInfo() {
+func setMumbleInfo() error {
 	var players []*player.Player
 
-	db.DB.Model(&player.Player{}).Find(&players)
+	if err := db.DB.Model(&player.Player{}).Find(&players).Error; err != nil {
+		return err
+	}
 	for _, player := range players {
 		player.MumbleUsername = strconv.Itoa(rand.Int())
 		player.MumbleAuthkey = player.GenAuthKey()
-		player.Save()
+		if err := player.Save(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func setPlayerExternalLinks() {
+func setPlayerExternalLinks() error {
 	var players []*player.Player
-	db.DB.Model(&player.Player{}).Find(&players)
+	if err := db.DB.Model(&player.Player{}).Find(&players).Error; err != nil {
+		return err
+	}
 
 	for _, player := range players {
 		player.ExternalLinks = make(postgres.Hstore)
 		player.SetExternalLinks()
-		player.Save()
+		if err := player.Save(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // move player_settings values to player.Settings hstore
-func setPlayerSettings() {
+func setPlayerSettings() error {
 	rows, err := db.DB.DB().Query("SELECT player_id, key, value FROM player_settings")
 	if err != nil {
-		logrus.Fatal(err)
+		return err
 	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var playerID uint
 		var key, value string
 
-		rows.Scan(&playerID, &key, &value)
-		p, _ := player.GetPlayerByID(playerID)
+		if err := rows.Scan(&playerID, &key, &value); err != nil {
+			return err
+		}
+		p, err := player.GetPlayerByID(playerID)
+		if err != nil {
+			return err
+		}
 		p.SetSetting(key, value)
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	db.DB.Exec("DROP TABLE player_settings")
-}
-
-func dropTableSessions() {
-	db.DB.Exec("DROP TABLE http_sessions")
-}
-
-func dropColumnUpdatedAt() {
-	db.DB.Exec("ALTER TABLE players DROP COLUMN updated_at")
+	return exec("DROP TABLE player_settings")
 }
 
-func moveReportsServers() {
-	type oldReport struct {
-		PlayerID uint
-		LobbyID  uint
-	}
-
+func moveReportsServers() error {
 	reportTypes := []struct {
 		table string
 		rtype player.ReportType
@@ -169,54 +181,79 @@ func moveReportsServers() {
 	}
 
 	for _, rtype := range reportTypes {
-		rows, _ := db.DB.DB().Query("SELECT * FROM " + rtype.table)
-
 		logrus.Info("Creating entries for ", rtype.table)
-		for rows.Next() {
-			var report oldReport
-
-			rows.Scan(&report.PlayerID, &report.LobbyID)
-			p, _ := player.GetPlayerByID(report.PlayerID)
-			newReport := &player.Report{
-				PlayerID: p.ID,
-				LobbyID:  report.LobbyID,
-				Type:     rtype.rtype,
-			}
-			db.DB.Create(newReport)
+		if err := moveReports(rtype.table, rtype.rtype); err != nil {
+			return err
 		}
 	}
 
-	db.DB.Exec("DROP TABLE ragequits_player_lobbies")
-	db.DB.Exec("DROP TABLE reports_player_lobbies")
-	db.DB.Exec("DROP TABLE substitutes_player_lobbies")
+	return exec(
+		"DROP TABLE ragequits_player_lobbies",
+		"DROP TABLE reports_player_lobbies",
+		"DROP TABLE substitutes_player_lobbies",
+	)
 }
 
-func dropUnusedColumns() {
-	db.DB.Model(&lobby.Lobby{}).DropColumn("slot_password")
-	db.DB.Model(&player.Player{}).DropColumn("debug")
+func moveReports(table string, rtype player.ReportType) error {
+	rows, err := db.DB.DB().Query("SELECT * FROM " + table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var playerID, lobbyID uint
+
+		if err := rows.Scan(&playerID, &lobbyID); err != nil {
+			return err
+		}
+		p, err := player.GetPlayerByID(playerID)
+		if err != nil {
+			return err
+		}
+		newReport := &player.Report{
+			PlayerID: p.ID,
+			LobbyID:  lobbyID,
+			Type:     rtype,
+		}
+		if err := db.DB.Create(newReport).Error; err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
-func downloadSTVDemos() {
+func downloadSTVDemos() error {
 	var lobbies []*lobby.Lobby
 
 	since := time.Now().Add(time.Hour * 24 * 30 * -1)
-	db.DB.Model(&lobby.Lobby{}).
+	err := db.DB.Model(&lobby.Lobby{}).
 		Where("match_ended = TRUE AND serveme_id <> 0 AND created_at > ?", since).
-		Find(&lobbies)
+		Find(&lobbies).Error
+	if err != nil {
+		return err
+	}
 	logrus.Debug("Downloading Demos for ", len(lobbies), " lobbies")
 
+	// demos are downloaded in the background, failed downloads are only
+	// logged, since the demos are kept by serveme.tf for a while anyway
 	for _, lob := range lobbies {
 		go func(lobby *lobby.Lobby) {
+			var err error
 			switch strings.ToLower(lobby.RegionCode) {
 			case "na", "sa":
-				lobby.DownloadDemo(helpers.ServemeNA)
+				err = lobby.DownloadDemo(helpers.ServemeNA)
 			case "eu", "as":
-				lobby.DownloadDemo(helpers.ServemeEU)
+				err = lobby.DownloadDemo(helpers.ServemeEU)
 			case "oc":
-				lobby.DownloadDemo(helpers.ServemeAU)
+				err = lobby.DownloadDemo(helpers.ServemeAU)
 			default:
-				lobby.DownloadDemo(helpers.ServemeEU)
+				err = lobby.DownloadDemo(helpers.ServemeEU)
+			}
+			if err != nil {
+				logrus.Error("Couldn't download demo of lobby ", lobby.ID, ": ", err)
 			}
 		}(lob)
 	}
+	return nil
 }
@@ -0,0 +1,381 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package migrations
+
+import (
+	"github.com/TF2Stadium/Helen/models/lobby_settings"
+)
+
+// Migration is a numbered change to the database schema or data. Migrations
+// are applied in order of their versions, and every applied migration is
+// recorded in the schema_migrations table.
+//
+// Schema changes should be written as SQL statements in Up (and Down, to undo
+// them), which are run in a single transaction and can be printed with
+// `helen migrate sql`. UpFunc is for data migrations which can't be written
+// in SQL, it's run outside of a transaction, and can't be printed.
+// Migrations without Down can't be rolled back.
+type Migration struct {
+	Version uint
+	Name    string
+
+	Up     []string
+	Down   []string
+	UpFunc func() error
+}
+
+func (m Migration) reversible() bool {
+	return m.Down != nil
+}
+
+// legacyVersion is the last schema version of the migration routines which
+// used to be dispatched on the major version stored in the constants table.
+// Migrations up to it are the ported routines.
+const legacyVersion = 14
+
+// New migrations go at the end, with the next version number. Applied
+// migrations must never be changed, add a new one instead.
+var migrations = []Migration{
+	{Version: 1, Name: "whitelist id string", UpFunc: whitelist_id_string},
+	{Version: 2, Name: "lobby type change", UpFunc: lobbyTypeChange},
+	{Version: 3, Name: "drop substitute table", Up: []string{
+		"DROP TABLE IF EXISTS substitutes",
+	}},
+	{Version: 4, Name: "increase chat message length", Up: []string{
+		"ALTER TABLE chat_messages ALTER COLUMN message TYPE character varying(150)",
+	}},
+	{Version: 5, Name: "update all player info", UpFunc: updateAllPlayerInfo},
+	{Version: 6, Name: "truncate http sessions", Up: []string{
+		"TRUNCATE TABLE http_sessions",
+	}},
+	{Version: 7, Name: "set mumble info", UpFunc: setMumbleInfo},
+	{Version: 8, Name: "set player external links", UpFunc: setPlayerExternalLinks},
+	{Version: 9, Name: "set player settings", UpFunc: setPlayerSettings},
+	{Version: 10, Name: "drop table sessions", Up: []string{
+		"DROP TABLE IF EXISTS http_sessions",
+	}},
+	{Version: 11, Name: "drop players.updated_at", Up: []string{
+		"ALTER TABLE players DROP COLUMN IF EXISTS updated_at",
+	}},
+	{Version: 12, Name: "move reports", UpFunc: moveReportsServers},
+	{Version: 13, Name: "drop unused columns", Up: []string{
+		"ALTER TABLE lobbies DROP COLUMN IF EXISTS slot_password",
+		"ALTER TABLE players DROP COLUMN IF EXISTS debug",
+	}},
+	{Version: 14, Name: "download STV demos", UpFunc: downloadSTVDemos},
+
+	{
+		Version: 15,
+		Name:    "lobby slot indexes",
+		Up: []string{
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_lobby_slot_lobby_id_slot ON lobby_slots (lobby_id, slot)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_lobby_id_player_id ON lobby_slots (lobby_id, player_id)",
+			// duplicate of idx_lobby_slot_lobby_id_slot
+			"DROP INDEX IF EXISTS idx_requirement_lobby_id_slot",
+		},
+		Down: []string{
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_requirement_lobby_id_slot ON lobby_slots (lobby_id, slot)",
+			"DROP INDEX IF EXISTS idx_lobby_id_player_id",
+			"DROP INDEX IF EXISTS idx_lobby_slot_lobby_id_slot",
+		},
+	},
+
+	// Tables and columns added since schema_migrations are created here
+	// rather than with AutoMigrate, so that they can be rolled back and show
+	// up in `helen migrate sql`. Changes to them need a new migration too.
+	{
+		Version: 16,
+		Name:    "create api tokens",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS api_tokens (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	player_id integer,
+	name text,
+	application boolean,
+	scopes text,
+	prefix text,
+	hash text NOT NULL UNIQUE,
+	last_used_at timestamp with time zone,
+	revoked boolean DEFAULT false
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_api_tokens_deleted_at ON api_tokens (deleted_at)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS api_tokens",
+		},
+	},
+	{
+		Version: 17,
+		Name:    "create sessions",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS sessions (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	player_id integer,
+	refresh_hash text NOT NULL UNIQUE,
+	user_agent text,
+	ip_addr text,
+	last_used_at timestamp with time zone,
+	expires_at timestamp with time zone,
+	revoked boolean DEFAULT false
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_sessions_player_id ON sessions (player_id)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS sessions",
+		},
+	},
+	{
+		Version: 18,
+		Name:    "create connected sockets",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS connected_sockets (
+	socket_id text PRIMARY KEY,
+	steam_id text NOT NULL,
+	instance_id text NOT NULL,
+	spectating integer,
+	region text,
+	seen_at timestamp with time zone
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_connected_sockets_steam_id ON connected_sockets (steam_id)",
+			"CREATE INDEX IF NOT EXISTS idx_connected_sockets_instance_id ON connected_sockets (instance_id)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS connected_sockets",
+		},
+	},
+	{
+		Version: 19,
+		Name:    "create lobby archives",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS lobby_archives (
+	id integer PRIMARY KEY,
+	created_at timestamp with time zone,
+	archived_at timestamp with time zone,
+	type integer,
+	mode text,
+	map_name text,
+	league text,
+	region_code text,
+	match_ended boolean,
+	logstf_id integer,
+	export text
+)`,
+			`CREATE TABLE IF NOT EXISTS archived_slots (
+	id serial PRIMARY KEY,
+	lobby_id integer,
+	player_id integer,
+	slot integer,
+	needs_sub boolean
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_archived_slots_lobby_id ON archived_slots (lobby_id)",
+			"CREATE INDEX IF NOT EXISTS idx_archived_slots_player_id ON archived_slots (player_id)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS archived_slots",
+			"DROP TABLE IF EXISTS lobby_archives",
+		},
+	},
+	{
+		Version: 20,
+		Name:    "create jobs",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS jobs (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	kind text NOT NULL,
+	unique_key text NOT NULL DEFAULT '',
+	payload text,
+	state text NOT NULL,
+	run_at timestamp with time zone,
+	attempts integer,
+	max_attempts integer,
+	locked_by text,
+	locked_at timestamp with time zone,
+	last_error text
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_jobs_state ON jobs (state)",
+			"CREATE INDEX IF NOT EXISTS idx_jobs_run_at ON jobs (run_at)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_kind_unique_key ON jobs (kind, unique_key) WHERE unique_key <> '' AND state IN ('pending', 'running')",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS jobs",
+		},
+	},
+	{
+		Version: 21,
+		Name:    "create lobby settings",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS lobby_settings (
+	id serial PRIMARY KEY,
+	updated_at timestamp with time zone,
+	updated_by integer,
+	data text
+)`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS lobby_settings",
+		},
+	},
+	{Version: 22, Name: "merge default lobby settings", UpFunc: lobbySettings.MergeDefaults},
+	{
+		Version: 23,
+		Name:    "create map votes",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS map_votes (
+	id serial PRIMARY KEY,
+	lobby_id integer,
+	player_id integer,
+	map_name text
+)`,
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_map_vote_lobby_id_player_id ON map_votes (lobby_id, player_id)",
+			"ALTER TABLE lobbies ADD COLUMN IF NOT EXISTS map_vote_maps text NOT NULL DEFAULT ''",
+		},
+		Down: []string{
+			"ALTER TABLE lobbies DROP COLUMN IF EXISTS map_vote_maps",
+			"DROP TABLE IF EXISTS map_votes",
+		},
+	},
+	{
+		Version: 24,
+		Name:    "create series games",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS series_games (
+	id serial PRIMARY KEY,
+	lobby_id integer,
+	number integer,
+	map_name text,
+	logstf_id integer,
+	red_score integer,
+	blu_score integer,
+	winner text,
+	ended_at timestamp with time zone
+)`,
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_series_game_lobby_id_number ON series_games (lobby_id, number)",
+			"ALTER TABLE lobbies ADD COLUMN IF NOT EXISTS series_maps text NOT NULL DEFAULT ''",
+		},
+		Down: []string{
+			"ALTER TABLE lobbies DROP COLUMN IF EXISTS series_maps",
+			"DROP TABLE IF EXISTS series_games",
+		},
+	},
+	{
+		Version: 25,
+		Name:    "create rematch servers",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS rematch_servers (
+	lobby_id integer PRIMARY KEY,
+	created_at timestamp with time zone,
+	host text,
+	rcon_password text,
+	stored_server_id integer,
+	serveme boolean NOT NULL DEFAULT FALSE
+)`,
+			"ALTER TABLE lobbies ADD COLUMN IF NOT EXISTS rematch_of integer NOT NULL DEFAULT 0",
+			"ALTER TABLE lobbies ADD COLUMN IF NOT EXISTS rematch_opened_at bigint NOT NULL DEFAULT 0",
+			"CREATE UNIQUE INDEX IF NOT EXISTS uix_lobbies_rematch_of ON lobbies (rematch_of) WHERE rematch_of <> 0 AND deleted_at IS NULL",
+		},
+		Down: []string{
+			"DROP INDEX IF EXISTS uix_lobbies_rematch_of",
+			"ALTER TABLE lobbies DROP COLUMN IF EXISTS rematch_opened_at",
+			"ALTER TABLE lobbies DROP COLUMN IF EXISTS rematch_of",
+			"DROP TABLE IF EXISTS rematch_servers",
+		},
+	},
+	{
+		Version: 26,
+		Name:    "scheduled lobbies",
+		Up: []string{
+			"ALTER TABLE lobbies ADD COLUMN IF NOT EXISTS scheduled_for bigint NOT NULL DEFAULT 0",
+		},
+		Down: []string{
+			"ALTER TABLE lobbies DROP COLUMN IF EXISTS scheduled_for",
+		},
+	},
+	{
+		Version: 27,
+		Name:    "create lobby presets",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS lobby_presets (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	player_id integer,
+	name text,
+	options text
+)`,
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_lobby_preset_player_id_name ON lobby_presets (player_id, name)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS lobby_presets",
+		},
+	},
+	{
+		Version: 28,
+		Name:    "create lobby invites",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS lobby_invites (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	lobby_id integer,
+	player_id integer,
+	expires_at timestamp with time zone,
+	max_uses integer,
+	uses integer
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_lobby_invites_lobby_id ON lobby_invites (lobby_id)",
+			"ALTER TABLE lobbies ADD COLUMN IF NOT EXISTS private boolean NOT NULL DEFAULT FALSE",
+		},
+		Down: []string{
+			"ALTER TABLE lobbies DROP COLUMN IF EXISTS private",
+			"DROP TABLE IF EXISTS lobby_invites",
+		},
+	},
+	{
+		Version: 29,
+		Name:    "create parties",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS parties (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	leader_id integer
+)`,
+			`CREATE TABLE IF NOT EXISTS party_members (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	party_id integer,
+	player_id integer,
+	classes text
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_party_members_party_id ON party_members (party_id)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS uix_party_members_player_id ON party_members (player_id)",
+			`CREATE TABLE IF NOT EXISTS party_invites (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	party_id integer,
+	player_id integer
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_party_invites_party_id ON party_invites (party_id)",
+			`CREATE TABLE IF NOT EXISTS slot_holds (
+	id serial PRIMARY KEY,
+	lobby_id integer,
+	slot integer,
+	player_id integer,
+	expires_at timestamp with time zone
+)`,
+			"CREATE INDEX IF NOT EXISTS idx_slot_holds_lobby_id ON slot_holds (lobby_id)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS slot_holds",
+			"DROP TABLE IF EXISTS party_invites",
+			"DROP TABLE IF EXISTS party_members",
+			"DROP TABLE IF EXISTS parties",
+		},
+	},
+}
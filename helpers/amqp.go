@@ -1,6 +1,8 @@
 package helpers
 
 import (
+	"sync/atomic"
+
 	"github.com/sirupsen/logrus"
 	"github.com/TF2Stadium/Helen/config"
 	"github.com/streadway/amqp"
@@ -9,6 +11,8 @@ import (
 var AMQPChannel *amqp.Channel
 var AMQPConn *amqp.Connection
 
+var amqpOpen int32
+
 func ConnectAMQP() {
 	var err error
 
@@ -22,5 +26,18 @@ func ConnectAMQP() {
 		logrus.Fatal(err)
 	}
 
+	atomic.StoreInt32(&amqpOpen, 1)
+	closed := AMQPChannel.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		err := <-closed
+		atomic.StoreInt32(&amqpOpen, 0)
+		logrus.Error("RabbitMQ channel closed: ", err)
+	}()
+
 	logrus.Info("Connected to RabbitMQ on ", config.Constants.RabbitMQURL)
 }
+
+// AMQPOpen returns true if AMQPChannel hasn't been closed
+func AMQPOpen() bool {
+	return atomic.LoadInt32(&amqpOpen) == 1
+}
@@ -37,7 +37,13 @@ const (
 	ActionViewLogs
 	ActionViewPage //view admin pages
 	ActionDeleteChat
-	ModifyServers //add/remove servers
+	ModifyServers        //add/remove servers
+	ActionBanPlayers     // ban/unban players
+	ActionIssueAppTokens // issue application API tokens
+	ActionRevokeTokens   // revoke other players' API tokens
+	ActionManageJobs     // retry and delete failed background jobs
+	ActionModifySettings // change lobby settings
+	ActionCustomMap      // create lobbies on maps which aren't in the lobby settings
 )
 
 var ActionNames = map[authority.AuthAction]string{
@@ -45,7 +51,9 @@ var ActionNames = map[authority.AuthAction]string{
 	ActionBanJoin:   "ActionBanJoin",
 	ActionBanChat:   "ActionBanChat",
 
-	ActionChangeRole: "ActionChangeRole",
+	ActionChangeRole:   "ActionChangeRole",
+	ActionBanPlayers:   "ActionBanPlayers",
+	ActionRevokeTokens: "ActionRevokeTokens",
 }
 
 func init() {
@@ -59,7 +67,13 @@ func init() {
 	RoleMod.Allow(ActionViewPage)
 	RoleMod.Allow(ActionDeleteChat)
 	RoleMod.Allow(ModifyServers)
+	RoleMod.Allow(ActionBanPlayers)
+	RoleMod.Allow(ActionRevokeTokens)
+	RoleMod.Allow(ActionManageJobs)
 
 	RoleAdmin.Inherit(RoleMod)
 	RoleAdmin.Allow(ActionChangeRole)
+	RoleAdmin.Allow(ActionIssueAppTokens)
+	RoleAdmin.Allow(ActionModifySettings)
+	RoleAdmin.Allow(ActionCustomMap)
 }
@@ -50,3 +50,14 @@ func init() {
 	ServemeEU.APIKey = config.Constants.ServemeAPIKey
 	ServemeAU.APIKey = config.Constants.ServemeAPIKey
 }
+
+// GetServemeContextHost returns the context for the given serveme.tf host,
+// so that contexts can be stored by their host
+func GetServemeContextHost(host string) *servemetf.Context {
+	for _, context := range []*servemetf.Context{ServemeNA, ServemeEU, ServemeAU} {
+		if context.Host == host {
+			return context
+		}
+	}
+	return ServemeEU
+}
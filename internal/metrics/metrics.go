@@ -0,0 +1,119 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+// Package metrics has the Prometheus metrics exposed on /metrics. Metrics
+// computed when they're scraped (connected sockets, open lobbies) are
+// registered by the packages that can compute them.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "helen"
+
+// Registry has all of Helen's metrics, and the Go runtime and process ones
+var Registry = prometheus.NewRegistry()
+
+var (
+	// LobbyFillSeconds is observed when a lobby gets enough players to ready up
+	LobbyFillSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lobby_fill_seconds",
+		Help:      "Time from a lobby being created to it having enough players to ready up, by format.",
+		Buckets:   []float64{30, 60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 7200},
+	}, []string{"format"})
+
+	// LobbyStartSeconds is observed when a lobby starts
+	LobbyStartSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lobby_start_seconds",
+		Help:      "Time from a lobby being created to it starting, by format.",
+		Buckets:   []float64{60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 7200},
+	}, []string{"format"})
+
+	Substitutes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "substitutes_total",
+		Help:      "Number of players who needed a substitute, by format.",
+	}, []string{"format"})
+
+	Events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Number of events received from the event queue, by name.",
+	}, []string{"event"})
+
+	// EventLag is only observed for events published with a timestamp
+	EventLag = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_lag_seconds",
+		Help:      "Time from an event being published to the event queue to it being handled.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	})
+
+	RPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of RPC calls to Pauling, Fumble and the Twitch bot, by method.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"method"})
+
+	RPCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Number of RPC calls which returned an error, by method.",
+	}, []string{"method"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "websocket_request_duration_seconds",
+		Help:      "Time taken to handle websocket requests, by request name.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"request"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		LobbyFillSeconds,
+		LobbyStartSeconds,
+		Substitutes,
+		Events,
+		EventLag,
+		RPCDuration,
+		RPCErrors,
+		RequestDuration,
+	)
+}
+
+// MustRegister registers metrics computed by other packages
+func MustRegister(cs ...prometheus.Collector) {
+	Registry.MustRegister(cs...)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records the time taken by a websocket request handler, used
+// as `defer metrics.ObserveRequest("requestName", time.Now())`. wsevent calls
+// handlers directly, so they can't be wrapped.
+func ObserveRequest(name string, start time.Time) {
+	RequestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRPC records the latency of an RPC call, and whether it failed
+func ObserveRPC(method string, start time.Time, err error) {
+	RPCDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		RPCErrors.WithLabelValues(method).Inc()
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -68,7 +69,11 @@ func ConnectWS(client *http.Client) (*websocket.Conn, error) {
 		return nil, errors.New("Client cookiejar has no cookies D:")
 	}
 
-	header := http.Header{"Cookie": []string{client.Jar.Cookies(domain)[0].String()}}
+	var cookies []string
+	for _, cookie := range client.Jar.Cookies(domain) {
+		cookies = append(cookies, cookie.String())
+	}
+	header := http.Header{"Cookie": []string{strings.Join(cookies, "; ")}}
 
 	conn, _, err := websocket.DefaultDialer.Dial(ws.String(), header)
 	return conn, err
@@ -12,6 +12,7 @@ import (
 	"github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/database/migrations"
 	_ "github.com/TF2Stadium/Helen/helpers/authority"
+	"github.com/TF2Stadium/Helen/models/lobby_settings"
 )
 
 var cleaningMutex sync.Mutex
@@ -39,20 +40,41 @@ func CleanupDB() {
 
 		database.Init()
 		migrations.Do()
+
+		// formats are loaded from the lobby settings
+		if err := lobbySettings.LoadLobbySettingsFromFile(lobbySettings.DefaultSettings); err != nil {
+			panic(err)
+		}
 	})
 
 	tables := []string{
 		"admin_log_entries",
+		"api_tokens",
+		"archived_slots",
 		"banned_players_lobbies",
 		"chat_messages",
+		"connected_sockets",
+		"jobs",
 		"lobbies",
+		"lobby_archives",
+		"lobby_invites",
+		"lobby_presets",
+		"lobby_settings",
 		"lobby_slots",
+		"map_votes",
+		"parties",
+		"party_invites",
+		"party_members",
 		"player_bans",
 		"player_stats",
 		"players",
+		"rematch_servers",
 		"reports",
 		"requirements",
+		"series_games",
+		"sessions",
 		"server_records",
+		"slot_holds",
 		"spectators_players_lobbies",
 		"stored_servers",
 	}
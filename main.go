@@ -14,22 +14,31 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/TF2Stadium/Helen/config"
 	"github.com/TF2Stadium/Helen/controllers"
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/controllers/controllerhelpers/hooks"
+	"github.com/TF2Stadium/Helen/controllers/health"
 	"github.com/TF2Stadium/Helen/controllers/socket"
+	"github.com/TF2Stadium/Helen/controllers/socket/sessions"
+	"github.com/TF2Stadium/Helen/controllers/stats"
 	"github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/database/migrations"
 	"github.com/TF2Stadium/Helen/helpers"
 	_ "github.com/TF2Stadium/Helen/helpers/authority" // to register authority types
 	_ "github.com/TF2Stadium/Helen/internal/pprof"    // to setup expvars
 	"github.com/TF2Stadium/Helen/internal/version"
+	"github.com/TF2Stadium/Helen/models"
 	"github.com/TF2Stadium/Helen/models/chat"
 	"github.com/TF2Stadium/Helen/models/event"
+	"github.com/TF2Stadium/Helen/models/job"
 	"github.com/TF2Stadium/Helen/models/lobby"
 	"github.com/TF2Stadium/Helen/models/lobby_settings"
+	"github.com/TF2Stadium/Helen/models/retention"
 	"github.com/TF2Stadium/Helen/models/rpc"
 	"github.com/TF2Stadium/Helen/routes"
 	socketServer "github.com/TF2Stadium/Helen/routes/socket"
@@ -61,6 +70,22 @@ func main() {
 		config.PrintConfigDoc()
 		os.Exit(0)
 	}
+	if flag.Arg(0) == "migrate" {
+		database.Init()
+		if err := migrations.Command(flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "retention" {
+		database.Init()
+		if err := retention.Command(flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	if helpers.Raven != nil {
 		hook, err := logrus_sentry.NewWithClientSentryHook(helpers.Raven, []logrus.Level{
@@ -96,13 +121,21 @@ func main() {
 
 	database.Init()
 	database.DB.DB().SetMaxOpenConns(*dbMaxopen)
-	migrations.Do()
+	if config.Constants.AutoMigrate {
+		migrations.Do()
+	} else if n, err := migrations.Pending(); err != nil || n != 0 {
+		logrus.Fatal("Database has pending migrations, run `helen migrate up` (", n, " pending, ", err, ")")
+	}
 
 	helpers.ConnectAMQP()
+	if config.Constants.Cluster {
+		broadcaster.StartCluster(helpers.AMQPConn)
+		sessions.StartHeartbeat(hooks.OnStaleSocket)
+	}
 	event.StartListening()
 	helpers.InitGeoIPDB()
 
-	err = lobbySettings.LoadLobbySettingsFromFile("assets/lobbySettingsData.json")
+	err = lobbySettings.Load()
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -110,11 +143,16 @@ func main() {
 	lobby.CreateLocks()
 	rpc.ConnectRPC(helpers.AMQPConn)
 	lobby.RestoreServemeChecks()
-	//go models.TFTVStreamStatusUpdater()
+	retention.Schedule()
+	if config.Constants.TFTVStreamStatus {
+		job.Every("tftvStreamStatus", 5*time.Minute, models.UpdateTFTVStreamStatus)
+	}
 
 	if config.Constants.SteamIDWhitelist != "" {
-		go chelpers.WhitelistListener()
+		chelpers.ScheduleWhitelist()
 	}
+	job.Start(config.Constants.JobWorkers)
+	stats.StartHistory()
 
 	mux := http.NewServeMux()
 	routes.SetupHTTP(mux)
@@ -140,6 +178,11 @@ func main() {
 
 func shutdown() {
 	logrus.Info("Received SIGINT/SIGTERM")
+	if config.Constants.HealthChecks {
+		logrus.Info("failing readiness checks for ", config.Constants.HealthDrainDelay)
+		health.Drain()
+		time.Sleep(config.Constants.HealthDrainDelay)
+	}
 	chat.SendNotification(`Backend will be going down for a while for an update, click on "Reconnect" to reconnect to TF2Stadium`, 0)
 	logrus.Info("waiting for GlobalWait")
 	helpers.GlobalWait.Wait()
@@ -149,4 +192,6 @@ func shutdown() {
 	socketServer.AuthServer.Close()
 	logrus.Info("stopping event listener")
 	event.StopListening()
+	logrus.Info("waiting for running jobs to finish")
+	job.Stop()
 }
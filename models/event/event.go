@@ -3,12 +3,14 @@ package event
 import (
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/TF2Stadium/Helen/config"
 	"github.com/TF2Stadium/Helen/controllers/broadcaster"
 	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/models/chat"
 	lobbypackage "github.com/TF2Stadium/Helen/models/lobby"
 	playerpackage "github.com/TF2Stadium/Helen/models/player"
@@ -46,7 +48,19 @@ const (
 	ReservationOver string = "reservationOver"
 )
 
-var stop = make(chan struct{})
+var (
+	stop      = make(chan struct{})
+	listening int32
+
+	// event names which were received without a timestamp, only used by
+	// the listening goroutine
+	untimed = make(map[string]bool)
+)
+
+// Listening returns true if events are being consumed from the queue
+func Listening() bool {
+	return atomic.LoadInt32(&listening) == 1
+}
 
 func StartListening() {
 	q, err := helpers.AMQPChannel.QueueDeclare(config.Constants.RabbitMQQueue, false, false, false, false, nil)
@@ -59,16 +73,32 @@ func StartListening() {
 		logrus.Fatal("Cannot consume messages ", err)
 	}
 
+	atomic.StoreInt32(&listening, 1)
 	go func() {
+		defer atomic.StoreInt32(&listening, 0)
 		for {
 			select {
-			case msg := <-msgs:
+			case msg, ok := <-msgs:
+				if !ok {
+					logrus.Error("Stopped receiving events, the RabbitMQ channel has been closed")
+					return
+				}
 				var event Event
 
 				err := json.Unmarshal(msg.Body, &event)
 				if err != nil {
 					logrus.Fatal(err)
 				}
+				metrics.Events.WithLabelValues(event.Name).Inc()
+				// Pauling and Fumble set the AMQP timestamp property when
+				// publishing events, warn about the ones which don't so the
+				// lag histogram doesn't silently miss them
+				if !msg.Timestamp.IsZero() {
+					metrics.EventLag.Observe(time.Since(msg.Timestamp).Seconds())
+				} else if !untimed[event.Name] {
+					untimed[event.Name] = true
+					logrus.Warningf("Event %s was published without a timestamp, its lag won't be measured", event.Name)
+				}
 				switch event.Name {
 				case PlayerDisconnected:
 					playerDisc(event.SteamID, event.LobbyID)
@@ -175,11 +205,23 @@ func matchEnded(lobbyID uint, logsID int) {
 		logrus.Error(err)
 		return
 	}
-	lobby.Close(false, true)
 
 	logs := fmt.Sprintf("http://logs.tf/%d", logsID)
-	msg := fmt.Sprintf("Lobby Ended. Logs: %s", logs)
-	chat.SendNotification(msg, int(lobby.ID))
+	if lobby.IsSeries() {
+		// the lobby stays open until the series is over
+		chat.SendNotification(fmt.Sprintf("Game Ended. Logs: %s", logs), int(lobby.ID))
+		over, err := lobby.EndSeriesGame(logsID)
+		if err != nil {
+			logrus.Error(err)
+		}
+		if over {
+			lobby.Close(false, true)
+		}
+	} else {
+		lobby.Close(false, true)
+		msg := fmt.Sprintf("Lobby Ended. Logs: %s", logs)
+		chat.SendNotification(msg, int(lobby.ID))
+	}
 
 	room := fmt.Sprintf("%d_private", lobby.ID)
 	broadcaster.SendMessageToRoom(room, "lobbyLogs", struct {
@@ -64,6 +64,31 @@ func GetStoredServer(id uint) (*StoredServer, error) {
 	return server, err
 }
 
+// FindStoredServer returns the stored server with the given id, without
+// taking it. Scheduled lobbies only take their server when they open.
+func FindStoredServer(id uint) (*StoredServer, error) {
+	server := &StoredServer{}
+	err := db.DB.Model(&StoredServer{}).Where("id = ?", id).First(server).Error
+	return server, err
+}
+
+// TakeStoredServer marks the stored server with the given address as used.
+// It returns ErrServerUsed if it already is, and nothing happens if the
+// address isn't a stored server's.
+func TakeStoredServer(address string) error {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	server := &StoredServer{}
+	if db.DB.Where("address = ?", address).First(server).RecordNotFound() {
+		return nil
+	}
+	if db.DB.Model(&StoredServer{}).Where("id = ? AND used = FALSE", server.ID).UpdateColumn("used", true).RowsAffected == 0 {
+		return ErrServerUsed
+	}
+	return nil
+}
+
 func PutStoredServer(address string) {
 	storeLock.Lock()
 	db.DB.Model(&StoredServer{}).Where("address = ?", address).UpdateColumn("used", false)
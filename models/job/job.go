@@ -0,0 +1,126 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+// Package job runs background work from a queue stored in the jobs table, so
+// that it survives restarts and can be run by any instance.
+//
+// Every kind of job has a handler, registered with Register (or Every, for
+// periodic jobs) before the workers are started. Jobs are claimed with
+// SELECT ... FOR UPDATE SKIP LOCKED, so every job is run by one worker at a
+// time. Failed jobs are retried with exponential backoff, until they've been
+// attempted MaxAttempts times.
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+)
+
+// State is the state of a job
+type State string
+
+const (
+	Pending State = "pending" // waiting to be run
+	Running State = "running"
+	Failed  State = "failed" // failed MaxAttempts times, needs to be retried manually
+)
+
+// DefaultMaxAttempts is the number of attempts jobs get if Options.MaxAttempts
+// isn't set
+const DefaultMaxAttempts = 5
+
+var ErrNotFound = errors.New("job not found")
+
+// Job is a unit of background work. Jobs are deleted once they've been run
+// successfully.
+type Job struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Kind      string `sql:"not null"`
+	UniqueKey string `sql:"not null;default:''"` // see Options.Key
+	Payload   string `sql:"type:text"`           // JSON encoded
+
+	State       State     `sql:"not null;index"`
+	RunAt       time.Time `sql:"index"` // when the job should be run next
+	Attempts    int
+	MaxAttempts int
+
+	LockedBy  string // instance running the job
+	LockedAt  *time.Time
+	LastError string `sql:"type:text"`
+}
+
+// Options are the options for enqueueing a job
+type Options struct {
+	// If Key is set, the job isn't enqueued if a pending or running job of
+	// the same kind with the same key exists already.
+	Key string
+	// Delay before the job is run
+	Delay time.Duration
+	// Defaults to DefaultMaxAttempts
+	MaxAttempts int
+}
+
+// Enqueue adds a job of the given kind to the queue. The payload is encoded
+// as JSON, and passed to the kind's handler.
+func Enqueue(kind string, payload interface{}, opts Options) error {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+
+	now := time.Now()
+	// idx_jobs_kind_unique_key only covers pending and running jobs, so
+	// conflicting with it means an equivalent job is queued already
+	return db.DB.Exec(`INSERT INTO jobs (created_at, updated_at, kind, unique_key, payload, state, run_at, attempts, max_attempts)
+VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?) ON CONFLICT DO NOTHING`,
+		now, now, kind, opts.Key, string(bytes), Pending, now.Add(opts.Delay), opts.MaxAttempts).Error
+}
+
+// GetJobs returns pending, running and failed jobs, failed jobs first
+func GetJobs() ([]*Job, error) {
+	var jobs []*Job
+	err := db.DB.Order("state = 'failed' DESC, run_at").Find(&jobs).Error
+	return jobs, err
+}
+
+// Retry sets a failed job to be run again, with its attempts reset
+func Retry(id uint) error {
+	res := db.DB.Model(&Job{}).Where("id = ? AND state = ?", id, Failed).
+		Updates(map[string]interface{}{
+			"state":    Pending,
+			"attempts": 0,
+			"run_at":   time.Now(),
+		})
+	if res.Error != nil {
+		// a job with the same key has been enqueued since
+		return fmt.Errorf("couldn't retry job #%d: %v", id, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete deletes a job which isn't running
+func Delete(id uint) error {
+	res := db.DB.Where("id = ? AND state <> ?", id, Running).Delete(&Job{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package job_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+	_ "github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	testhelpers.CleanupDB()
+}
+
+func getJob(t *testing.T, kind string) *Job {
+	var job Job
+	require.NoError(t, db.DB.Where("kind = ?", kind).First(&job).Error)
+	return &job
+}
+
+// makeDue sets the job to be run now
+func makeDue(id uint) {
+	db.DB.Model(&Job{}).Where("id = ?", id).UpdateColumn("run_at", time.Now())
+}
+
+func TestEnqueueUniqueKey(t *testing.T) {
+	require.NoError(t, Enqueue("test_unique", nil, Options{Key: "a", Delay: time.Hour}))
+	require.NoError(t, Enqueue("test_unique", nil, Options{Key: "a", Delay: time.Hour}))
+	require.NoError(t, Enqueue("test_unique", nil, Options{Key: "b", Delay: time.Hour}))
+	require.NoError(t, Enqueue("test_unique", nil, Options{Delay: time.Hour}))
+	require.NoError(t, Enqueue("test_unique", nil, Options{Delay: time.Hour}))
+
+	var count int
+	db.DB.Model(&Job{}).Where("kind = ?", "test_unique").Count(&count)
+	assert.Equal(t, 4, count)
+}
+
+func TestRunJob(t *testing.T) {
+	var got string
+	Register("test_run", func(payload json.RawMessage) error {
+		return json.Unmarshal(payload, &got)
+	})
+
+	require.NoError(t, Enqueue("test_run", "payload", Options{}))
+	ran, err := RunNext()
+	require.NoError(t, err)
+	assert.True(t, ran)
+	assert.Equal(t, "payload", got)
+
+	// deleted once it has been run
+	var count int
+	db.DB.Model(&Job{}).Where("kind = ?", "test_run").Count(&count)
+	assert.Zero(t, count)
+
+	ran, err = RunNext()
+	require.NoError(t, err)
+	assert.False(t, ran)
+}
+
+func TestDelayedJob(t *testing.T) {
+	Register("test_delayed", func(json.RawMessage) error { return nil })
+
+	require.NoError(t, Enqueue("test_delayed", nil, Options{Delay: time.Hour}))
+	ran, err := RunNext()
+	require.NoError(t, err)
+	assert.False(t, ran)
+
+	makeDue(getJob(t, "test_delayed").ID)
+	ran, err = RunNext()
+	require.NoError(t, err)
+	assert.True(t, ran)
+}
+
+func TestFailedJob(t *testing.T) {
+	Register("test_failed", func(json.RawMessage) error {
+		return errors.New("failed")
+	})
+
+	require.NoError(t, Enqueue("test_failed", nil, Options{MaxAttempts: 2}))
+
+	ran, err := RunNext()
+	require.NoError(t, err)
+	require.True(t, ran)
+
+	job := getJob(t, "test_failed")
+	assert.Equal(t, Pending, job.State)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, "failed", job.LastError)
+	assert.True(t, job.RunAt.After(time.Now()), "retried after a backoff")
+
+	makeDue(job.ID)
+	_, err = RunNext()
+	require.NoError(t, err)
+
+	job = getJob(t, "test_failed")
+	assert.Equal(t, Failed, job.State)
+	assert.Equal(t, 2, job.Attempts)
+
+	// failed jobs aren't run again until they're retried
+	ran, err = RunNext()
+	require.NoError(t, err)
+	assert.False(t, ran)
+
+	require.NoError(t, Retry(job.ID))
+	job = getJob(t, "test_failed")
+	assert.Equal(t, Pending, job.State)
+	assert.Zero(t, job.Attempts)
+
+	require.NoError(t, Delete(job.ID))
+	assert.Equal(t, ErrNotFound, Delete(job.ID))
+}
+
+func TestPanickingJob(t *testing.T) {
+	Register("test_panic", func(json.RawMessage) error {
+		panic("oops")
+	})
+
+	require.NoError(t, Enqueue("test_panic", nil, Options{MaxAttempts: 1}))
+	ran, err := RunNext()
+	require.NoError(t, err)
+	require.True(t, ran)
+
+	job := getJob(t, "test_panic")
+	assert.Equal(t, Failed, job.State)
+	assert.Equal(t, "panic: oops", job.LastError)
+}
+
+func TestRescheduledJob(t *testing.T) {
+	runs := 0
+	Register("test_after", func(json.RawMessage) error {
+		runs++
+		return After(time.Hour)
+	})
+
+	require.NoError(t, Enqueue("test_after", nil, Options{Key: "key", MaxAttempts: 1}))
+	for i := 0; i < 3; i++ {
+		ran, err := RunNext()
+		require.NoError(t, err)
+		require.True(t, ran)
+
+		job := getJob(t, "test_after")
+		assert.Equal(t, Pending, job.State)
+		assert.Zero(t, job.Attempts)
+		assert.True(t, job.RunAt.After(time.Now().Add(59*time.Minute)))
+		makeDue(job.ID)
+	}
+	assert.Equal(t, 3, runs)
+
+	// still pending, so the key is taken
+	require.NoError(t, Enqueue("test_after", nil, Options{Key: "key"}))
+	var count int
+	db.DB.Model(&Job{}).Where("kind = ?", "test_after").Count(&count)
+	assert.Equal(t, 1, count)
+	db.DB.Where("kind = ?", "test_after").Delete(&Job{})
+}
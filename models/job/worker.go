@@ -0,0 +1,228 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler runs a job, with the payload it was enqueued with. Returning an
+// error retries the job later.
+type Handler func(payload json.RawMessage) error
+
+const (
+	pollInterval = time.Second
+	// running jobs which haven't finished after this long are assumed to
+	// belong to an instance which died, and are run again
+	runTimeout = 10 * time.Minute
+	maxBackoff = time.Hour
+)
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]Handler)
+	periodic   = make(map[string]time.Duration)
+
+	stop    chan struct{}
+	workers sync.WaitGroup
+)
+
+// Register sets the handler for jobs of the given kind
+func Register(kind string, h Handler) {
+	handlersMu.Lock()
+	handlers[kind] = h
+	handlersMu.Unlock()
+}
+
+// Every registers f to be run every interval, on one instance at a time. The
+// first run is when the workers are started.
+func Every(kind string, interval time.Duration, f func() error) {
+	Register(kind, func(json.RawMessage) error {
+		return &reschedule{interval, f()}
+	})
+
+	handlersMu.Lock()
+	periodic[kind] = interval
+	handlersMu.Unlock()
+}
+
+type reschedule struct {
+	after time.Duration
+	err   error
+}
+
+func (r *reschedule) Error() string {
+	if r.err != nil {
+		return r.err.Error()
+	}
+	return fmt.Sprintf("rescheduled after %s", r.after)
+}
+
+// After can be returned by handlers to run the job again after d, with the
+// same payload. It doesn't count as a failed attempt.
+func After(d time.Duration) error {
+	return &reschedule{after: d}
+}
+
+func kinds() []string {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	var list []string
+	for kind := range handlers {
+		list = append(list, kind)
+	}
+	return list
+}
+
+func getHandler(kind string) Handler {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	return handlers[kind]
+}
+
+// Start schedules the periodic jobs, and starts n workers
+func Start(n int) {
+	handlersMu.RLock()
+	for kind := range periodic {
+		if err := Enqueue(kind, nil, Options{Key: kind}); err != nil {
+			logrus.Error("Couldn't schedule ", kind, ": ", err)
+		}
+	}
+	handlersMu.RUnlock()
+
+	stop = make(chan struct{})
+	for i := 0; i < n; i++ {
+		workers.Add(1)
+		go work()
+	}
+	logrus.Info("Started ", n, " job workers")
+}
+
+// Stop stops the workers, waiting for running jobs to finish
+func Stop() {
+	if stop == nil {
+		return
+	}
+	close(stop)
+	workers.Wait()
+}
+
+func work() {
+	defer workers.Done()
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		ran, err := RunNext()
+		if err != nil {
+			logrus.Error(err)
+		}
+		if !ran {
+			select {
+			case <-stop:
+				return
+			case <-time.After(pollInterval):
+			}
+		}
+	}
+}
+
+const claimJob = `UPDATE jobs SET state = ?, locked_by = ?, locked_at = ?, attempts = attempts + 1, updated_at = ?
+WHERE id = (
+	SELECT id FROM jobs
+	WHERE kind IN (?) AND ((state = ? AND run_at <= ?) OR (state = ? AND locked_at < ?))
+	ORDER BY run_at, id
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// RunNext claims and runs the next job that's due, if there is one. It
+// returns whether a job was run.
+func RunNext() (bool, error) {
+	list := kinds()
+	if len(list) == 0 {
+		return false, nil
+	}
+
+	var job Job
+	now := time.Now()
+	res := db.DB.Raw(claimJob, Running, config.Constants.InstanceID, now, now,
+		list, Pending, now, Running, now.Add(-runTimeout)).Scan(&job)
+	if res.RecordNotFound() {
+		return false, nil
+	}
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	err := run(&job)
+	return true, finish(&job, err)
+}
+
+func run(job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return getHandler(job.Kind)(json.RawMessage(job.Payload))
+}
+
+func backoff(attempts int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+func finish(job *Job, err error) error {
+	if err == nil {
+		return db.DB.Delete(job).Error
+	}
+
+	updates := map[string]interface{}{
+		"state":      Pending,
+		"locked_by":  "",
+		"locked_at":  nil,
+		"last_error": "",
+	}
+
+	if r, ok := err.(*reschedule); ok {
+		updates["attempts"] = 0
+		updates["run_at"] = time.Now().Add(r.after)
+		if r.err != nil {
+			logrus.Error(job.Kind, ": ", r.err)
+			updates["last_error"] = r.err.Error()
+		}
+	} else if job.Attempts >= job.MaxAttempts {
+		logrus.Error(fmt.Sprintf("%s job #%d failed %d times, giving up: %v", job.Kind, job.ID, job.Attempts, err))
+		updates["state"] = Failed
+		updates["last_error"] = err.Error()
+	} else {
+		logrus.Warning(fmt.Sprintf("%s job #%d failed: %v", job.Kind, job.ID, err))
+		updates["run_at"] = time.Now().Add(backoff(job.Attempts))
+		updates["last_error"] = err.Error()
+	}
+
+	return db.DB.Model(job).Updates(updates).Error
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	"github.com/sirupsen/logrus"
+)
+
+// Other instances tell this one when a lobby changes, so that its cached lobby
+// data and lobby list stay up to date.
+func init() {
+	broadcaster.HandleNotification("lobbyDataChanged", func(data json.RawMessage) {
+		var id uint
+		if err := json.Unmarshal(data, &id); err != nil {
+			logrus.Error(err)
+			return
+		}
+		invalidateLocalLobbyData(id)
+	})
+
+	broadcaster.HandleNotification("lobbyListChanged", func(data json.RawMessage) {
+		var id uint
+		if err := json.Unmarshal(data, &id); err != nil {
+			logrus.Error(err)
+			return
+		}
+		remoteListChanges.add(id)
+	})
+	go remoteListChanges.work()
+
+	broadcaster.HandleNotification("lobbyListResync", func(json.RawMessage) {
+		resyncLobbyList()
+	})
+}
+
+// remoteListChanges queues the lobbies other instances have changed, so that
+// the lobby list is rebuilt by a single worker instead of in the goroutine
+// receiving notifications. Repeated changes to a lobby are only applied once.
+var remoteListChanges = &listChanges{
+	ids:  make(map[uint]bool),
+	wake: make(chan struct{}, 1),
+}
+
+type listChanges struct {
+	mu   sync.Mutex
+	ids  map[uint]bool
+	wake chan struct{}
+}
+
+func (c *listChanges) add(id uint) {
+	c.mu.Lock()
+	c.ids[id] = true
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *listChanges) work() {
+	for range c.wake {
+		c.mu.Lock()
+		ids := c.ids
+		c.ids = make(map[uint]bool)
+		c.mu.Unlock()
+
+		for id := range ids {
+			applyRemoteListChange(id)
+		}
+	}
+}
+
+func applyRemoteListChange(id uint) {
+	lobby, err := GetLobbyByID(id)
+	if err != nil {
+		// deleted lobbies aren't in the list anymore
+		waitingList.updateMu.Lock()
+		waitingList.load()
+		waitingList.apply(nil, []uint{id})
+		waitingList.updateMu.Unlock()
+		return
+	}
+	updateLobbyList(lobby)
+}
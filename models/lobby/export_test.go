@@ -0,0 +1,22 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import "github.com/jinzhu/gorm"
+
+// DecorateLobbyDataUncached decorates the lobby without using the cache, for
+// benchmarks
+var DecorateLobbyDataUncached = decorateLobbyData
+
+// SetFetchScores replaces the function getting the scores of series games
+// from logs.tf
+func SetFetchScores(f func(logsID int) (red, blu int, err error)) {
+	fetchScores = f
+}
+
+// Transaction runs f in a transaction holding the lobby's advisory lock
+func (lobby *Lobby) Transaction(f func(tx *gorm.DB) error) error {
+	return lobby.transaction(f)
+}
@@ -1,7 +1,16 @@
 package format
 
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
 type Format int
 
+// IDs of the formats Helen had before they were loaded from the lobby
+// settings. Lobbies store their format's ID, and Pauling gets it when setting
+// up servers, so IDs can't be changed.
 const (
 	Sixes      Format = iota
 	Highlander        // lol
@@ -12,119 +21,149 @@ const (
 	Debug
 )
 
+// Info describes a format, loaded from the formats in the lobby settings
+type Info struct {
+	ID           Format
+	Name         string   // name in the lobby settings ("sixes")
+	Type         string   // name used in lobbyCreate ("6s")
+	FriendlyName string   // name shown in lobby data and notifications ("6s")
+	Classes      []string // slots of a team, in slot order
+
+	// player stats class counter each slot counts towards ("pocket":
+	// "soldier"), slots without one aren't counted
+	Stats map[string]string
+	// player stats played count lobbies of this format count towards, empty
+	// if none
+	PlayedStat string
+
+	MaxSubs         int // the lobby is closed once this many players needed a sub, 0 never closes it
+	NotifyThreshold int // players needed before the lobby is announced as almost ready
+
+	// gamemodes this format plays on some maps, instead of the gamemode of
+	// the map ("koth": "ultiduo")
+	Gamemodes map[string]string
+}
+
 var (
 	teamMap  = map[string]int{"red": 0, "blu": 1}
 	teamList = []string{"red", "blu"}
 
-	sixesClassMap = map[string]int{
-		"scout1":  0,
-		"scout2":  1,
-		"roamer":  2,
-		"pocket":  3,
-		"demoman": 4,
-		"medic":   5,
-	}
-	sixesClassList = []string{"scout1", "scout2", "roamer", "pocket", "demoman", "medic"}
-
-	hlClassMap = map[string]int{
-		"scout":    0,
-		"soldier":  1,
-		"pyro":     2,
-		"demoman":  3,
-		"heavy":    4,
-		"engineer": 5,
-		"medic":    6,
-		"sniper":   7,
-		"spy":      8,
+	mu      sync.RWMutex
+	formats = make(map[Format]*Info)
+	byType  = make(map[string]*Info)
+)
+
+func validate(info Info) error {
+	if info.Type == "" {
+		return fmt.Errorf("format %q has no type", info.Name)
 	}
-	hlClassList = []string{
-		"scout",
-		"soldier",
-		"pyro",
-		"demoman",
-		"heavy",
-		"engineer",
-		"medic",
-		"sniper",
-		"spy"}
-
-	debugClassMap = map[string]int{
-		"scout": 0,
+	if len(info.Classes) == 0 {
+		return fmt.Errorf("format %q has no classes", info.Name)
 	}
-	debugClassList = []string{"scout"}
 
-	bballClassMap = map[string]int{
-		"soldier1": 0,
-		"soldier2": 1,
+	seen := make(map[string]bool)
+	for _, class := range info.Classes {
+		if seen[class] {
+			return fmt.Errorf("format %q has class %q twice", info.Name, class)
+		}
+		seen[class] = true
 	}
-	bballClassList = []string{"soldier1", "soldier2"}
-
-	ultiduoClassMap = map[string]int{
-		"soldier": 0,
-		"medic":   1,
+	for class := range info.Stats {
+		if !seen[class] {
+			return fmt.Errorf("format %q has stats for non existing class %q", info.Name, class)
+		}
 	}
-	ultiduoClassList = []string{"soldier", "medic"}
+	return nil
+}
 
-	foursClassMap = map[string]int{
-		"scout":   0,
-		"soldier": 1,
-		"demoman": 2,
-		"medic":   3,
+// Validate checks that list can be used as the known formats
+func Validate(list []Info) error {
+	ids := make(map[Format]bool)
+	types := make(map[string]bool)
+
+	for _, info := range list {
+		if err := validate(info); err != nil {
+			return err
+		}
+		if ids[info.ID] {
+			return fmt.Errorf("format %q has the same id as another format", info.Name)
+		}
+		if types[info.Type] {
+			return fmt.Errorf("format %q has the same type as another format", info.Name)
+		}
+		ids[info.ID] = true
+		types[info.Type] = true
 	}
-	foursClassList = []string{"scout", "soldier", "demoman", "medic"}
-
-	prolanderClassMap = map[string]int{
-		"scout":   0,
-		"soldier": 1,
-		"demoman": 2,
-		"medic":   3,
-		"sniper":  4,
-		"flex1":   5,
-		"flex2":   6,
+	return nil
+}
+
+// Set replaces the known formats with list
+func Set(list []Info) error {
+	if err := Validate(list); err != nil {
+		return err
 	}
 
-	prolanderClasses = []string{"scout", "soldier", "demoman", "medic", "sniper", "flex1", "flex2"}
+	newFormats := make(map[Format]*Info)
+	newByType := make(map[string]*Info)
+	for i := range list {
+		info := list[i]
+		if info.FriendlyName == "" {
+			info.FriendlyName = info.Type
+		}
 
-	typeClassMap = map[Format]map[string]int{
-		Highlander: hlClassMap,
-		Sixes:      sixesClassMap,
-		Fours:      foursClassMap,
-		Ultiduo:    ultiduoClassMap,
-		Bball:      bballClassMap,
-		Debug:      debugClassMap,
-		Prolander:  prolanderClassMap,
+		newFormats[info.ID] = &info
+		newByType[info.Type] = &info
 	}
 
-	typeClassList = map[Format][]string{
-		Highlander: hlClassList,
-		Sixes:      sixesClassList,
-		Fours:      foursClassList,
-		Ultiduo:    ultiduoClassList,
-		Bball:      bballClassList,
-		Debug:      debugClassList,
-		Prolander:  prolanderClasses,
-	}
+	mu.Lock()
+	formats, byType = newFormats, newByType
+	mu.Unlock()
+	return nil
+}
+
+// Get returns the format with the given ID
+func Get(f Format) (*Info, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
 
-	NumberOfClassesMap = map[Format]int{
-		Highlander: 9,
-		Sixes:      6,
-		Fours:      4,
-		Ultiduo:    2,
-		Bball:      2,
-		Debug:      1,
-		Prolander:  7,
+	info, ok := formats[f]
+	return info, ok
+}
+
+// FromType returns the format with the given lobbyCreate type
+func FromType(name string) (*Info, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	info, ok := byType[name]
+	return info, ok
+}
+
+// List returns all formats, by ID
+func List() []*Info {
+	mu.RLock()
+	list := make([]*Info, 0, len(formats))
+	for _, info := range formats {
+		list = append(list, info)
 	}
+	mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// NumberOfClasses returns the number of slots in a team, 0 for unknown formats
+func NumberOfClasses(f Format) int {
+	return len(GetClasses(f))
+}
 
-	FriendlyNamesMap = map[Format]string{
-		Highlander: "Highlander",
-		Sixes:      "6s",
-		Fours:      "4v4",
-		Ultiduo:    "Ultiduo",
-		Bball:      "Bball",
-		Debug:      "Debug",
-		Prolander:  "Prolander",
+// FriendlyName returns the name of the format shown to players
+func FriendlyName(f Format) string {
+	if info, ok := Get(f); ok {
+		return info.FriendlyName
 	}
-)
+	return "Unknown"
+}
 
 //GetSlot returns the slot number for given team, class strings and the
 //lobby format
@@ -134,19 +173,21 @@ func GetSlot(lobbytype Format, teamStr string, classStr string) (int, error) {
 		return -1, ErrorInvalidTeam(teamStr)
 	}
 
-	class, ok := typeClassMap[lobbytype][classStr]
-	if !ok {
-		return -1, ErrorInvalidClass(classStr)
+	classList := GetClasses(lobbytype)
+	for class, name := range classList {
+		if name == classStr {
+			return team*len(classList) + class, nil
+		}
 	}
 
-	return team*NumberOfClassesMap[lobbytype] + class, nil
+	return -1, ErrorInvalidClass(classStr)
 }
 
 //GetSlotTeamClass returns the team and class strings for a given slot number
 func GetSlotTeamClass(lobbytype Format, slot int) (team, class string, err error) {
-	classList := typeClassList[lobbytype]
+	classList := GetClasses(lobbytype)
 
-	teamI, classI, err := getSlotNums(lobbytype, slot)
+	teamI, classI, err := getSlotNums(classList, slot)
 	if err == nil {
 		team, class, err = teamList[teamI], classList[classI], nil
 	}
@@ -155,10 +196,10 @@ func GetSlotTeamClass(lobbytype Format, slot int) (team, class string, err error
 
 //given a slot number, returns the numbers for the
 //slot's class and team for the given format
-func getSlotNums(lobbytype Format, slot int) (int, int, error) {
-	classList := typeClassList[lobbytype]
-
-	if slot < len(classList) {
+func getSlotNums(classList []string, slot int) (int, int, error) {
+	if slot < 0 {
+		return 0, 0, ErrorInvalidSlot(slot)
+	} else if slot < len(classList) {
 		return 0, slot, nil
 	} else if slot < 2*len(classList) {
 		return 1, slot - len(classList), nil
@@ -168,5 +209,8 @@ func getSlotNums(lobbytype Format, slot int) (int, int, error) {
 }
 
 func GetClasses(format Format) []string {
-	return typeClassList[format]
+	if info, ok := Get(format); ok {
+		return info.Classes
+	}
+	return nil
 }
@@ -9,6 +9,7 @@ import (
 
 	_ "github.com/TF2Stadium/Helen/helpers"
 	. "github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/TF2Stadium/Helen/models/lobby_settings"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,6 +24,12 @@ var slots = []struct {
 	{4, "demoman"},
 	{5, "medic"}}
 
+func init() {
+	if err := lobbySettings.LoadLobbySettingsFromFile("assets/lobbySettingsData.json"); err != nil {
+		panic(err)
+	}
+}
+
 func TestClassMaps(t *testing.T) {
 	res, err := GetSlot(Sixes, "red", "scout1")
 	assert.Equal(t, 0, res)
@@ -45,3 +52,41 @@ func TestClassMaps(t *testing.T) {
 		assert.Equal(t, team, "red")
 	}
 }
+
+func TestSetFormats(t *testing.T) {
+	formats := List()
+	defer Set(func() []Info {
+		var list []Info
+		for _, info := range formats {
+			list = append(list, *info)
+		}
+		return list
+	}())
+
+	err := Set([]Info{{ID: 10, Name: "ars", Type: "arena-respawn", Classes: []string{"scout", "soldier"}}})
+	assert.NoError(t, err)
+
+	info, ok := FromType("arena-respawn")
+	if assert.True(t, ok) {
+		assert.Equal(t, Format(10), info.ID)
+		assert.Equal(t, "arena-respawn", FriendlyName(info.ID))
+	}
+	_, ok = Get(Sixes)
+	assert.False(t, ok)
+
+	slot, err := GetSlot(10, "blu", "soldier")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, slot)
+
+	_, _, err = GetSlotTeamClass(10, 4)
+	assert.Error(t, err)
+
+	err = Set([]Info{
+		{ID: 10, Name: "a", Type: "a", Classes: []string{"scout"}},
+		{ID: 10, Name: "b", Type: "b", Classes: []string{"scout"}},
+	})
+	assert.Error(t, err)
+	// failing to set the formats keeps the old ones
+	_, ok = FromType("arena-respawn")
+	assert.True(t, ok)
+}
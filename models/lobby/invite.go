@@ -0,0 +1,176 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/TF2Stadium/Helen/models/player"
+)
+
+// Private lobbies aren't in the lobby list, and can only be joined by their
+// leader and invited players. Players are either invited directly, or join
+// with an invite link, whose token is signed so that it can't be guessed.
+// Once a player has used a link, they're invited directly, so they can leave
+// and join again without using it up.
+
+var (
+	ErrNotInvited    = errors.New("This lobby is invite only.")
+	ErrInvalidInvite = errors.New("This invite link isn't valid.")
+	ErrInviteExpired = errors.New("This invite link has expired.")
+	ErrInviteUsedUp  = errors.New("This invite link has been used too many times.")
+)
+
+// LobbyInvite is either a direct invite for a player, or an invite link
+type LobbyInvite struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	LobbyID   uint `gorm:"index"`
+
+	PlayerID  uint       // the invited player, 0 for invite links
+	ExpiresAt *time.Time // nil if the link doesn't expire
+	MaxUses   int        // 0 if the link can be used any number of times
+	Uses      int
+}
+
+func inviteSignature(inviteID uint) string {
+	mac := hmac.New(sha256.New, []byte(config.Constants.CookieStoreSecret))
+	fmt.Fprintf(mac, "lobbyInvite:%d", inviteID)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Token returns the token players join with using the invite link
+func (invite *LobbyInvite) Token() string {
+	return fmt.Sprintf("%d.%s", invite.ID, inviteSignature(invite.ID))
+}
+
+// NewInviteLink creates an invite link for the lobby, which expires after
+// expiresIn (if not zero), and can be used by maxUses players (if not zero)
+func (lobby *Lobby) NewInviteLink(expiresIn time.Duration, maxUses int) (*LobbyInvite, error) {
+	invite := &LobbyInvite{LobbyID: lobby.ID, MaxUses: maxUses}
+	if expiresIn != 0 {
+		expiresAt := time.Now().Add(expiresIn)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	err := db.DB.Create(invite).Error
+	return invite, err
+}
+
+// InvitePlayer invites the player to the lobby, and notifies them
+func (lobby *Lobby) InvitePlayer(p *player.Player, by *player.Player) error {
+	if !lobby.hasDirectInvite(p) {
+		err := db.DB.Create(&LobbyInvite{LobbyID: lobby.ID, PlayerID: p.ID}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	broadcaster.SendMessage(p.SteamID, "lobbyInvite", struct {
+		ID        uint   `json:"id"`
+		Map       string `json:"map"`
+		Type      string `json:"type"`
+		InvitedBy string `json:"invitedBy"`
+	}{lobby.ID, lobby.MapName, format.FriendlyName(lobby.Type), by.Alias()})
+	return nil
+}
+
+func (lobby *Lobby) hasDirectInvite(p *player.Player) bool {
+	var count int
+	db.DB.Model(&LobbyInvite{}).Where("lobby_id = ? AND player_id = ?", lobby.ID, p.ID).Count(&count)
+	return count != 0
+}
+
+// IsInvited returns true if the player can join the private lobby: they lead
+// it, have been invited, or played in the lobby it's a rematch of
+func (lobby *Lobby) IsInvited(p *player.Player) bool {
+	if p.SteamID == lobby.CreatedBySteamID || lobby.hasDirectInvite(p) {
+		return true
+	}
+
+	if lobby.RematchOf != 0 {
+		var count int
+		db.DB.Model(&LobbySlot{}).Where("lobby_id = ? AND player_id = ? AND needs_sub = FALSE", lobby.RematchOf, p.ID).Count(&count)
+		return count != 0
+	}
+
+	return false
+}
+
+// CanSee returns true if the player can see the lobby's data, and spectate it.
+// Private lobbies can only be seen by players who can join them, and the ones
+// who are in it. p is nil for players who aren't logged in.
+func (lobby *Lobby) CanSee(p *player.Player) bool {
+	if !lobby.Private {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	if lobby.IsInvited(p) {
+		return true
+	}
+
+	var count int
+	db.DB.Model(&LobbySlot{}).Where("lobby_id = ? AND player_id = ?", lobby.ID, p.ID).Count(&count)
+	return count != 0
+}
+
+// FilterVisible returns the lobbies the player can see (see CanSee)
+func FilterVisible(lobbies []*Lobby, p *player.Player) []*Lobby {
+	visible := make([]*Lobby, 0, len(lobbies))
+	for _, lobby := range lobbies {
+		if lobby.CanSee(p) {
+			visible = append(visible, lobby)
+		}
+	}
+	return visible
+}
+
+// UseInvite checks the invite link token, and invites the player to the lobby
+// with it. Players who are already invited don't use the link up.
+func (lobby *Lobby) UseInvite(p *player.Player, token string) error {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalidInvite
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || !hmac.Equal([]byte(parts[1]), []byte(inviteSignature(uint(id)))) {
+		return ErrInvalidInvite
+	}
+
+	invite := &LobbyInvite{}
+	err = db.DB.Where("id = ? AND lobby_id = ? AND player_id = 0", id, lobby.ID).First(invite).Error
+	if err != nil {
+		return ErrInvalidInvite
+	}
+	if lobby.IsInvited(p) {
+		return nil
+	}
+	if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+		return ErrInviteExpired
+	}
+
+	res := db.DB.Exec("UPDATE lobby_invites SET uses = uses + 1 WHERE id = ? AND (max_uses = 0 OR uses < max_uses)", invite.ID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInviteUsedUp
+	}
+
+	return db.DB.Create(&LobbyInvite{LobbyID: lobby.ID, PlayerID: p.ID}).Error
+}
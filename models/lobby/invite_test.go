@@ -0,0 +1,90 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivateLobby(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	lobby.Private = true
+	lobby.Save()
+
+	for _, waiting := range GetWaitingLobbies() {
+		assert.NotEqual(t, lobby.ID, waiting.ID, "private lobbies shouldn't be listed")
+	}
+
+	leader := testhelpers.CreatePlayer()
+	invited := testhelpers.CreatePlayer()
+	other := testhelpers.CreatePlayer()
+
+	assert.Equal(t, ErrNotInvited, lobby.AddPlayer(other, 0, ""))
+	assert.False(t, lobby.CanSee(other))
+	assert.False(t, lobby.CanSee(nil))
+
+	require.NoError(t, lobby.InvitePlayer(invited, leader))
+	assert.True(t, lobby.IsInvited(invited))
+	assert.True(t, lobby.CanSee(invited))
+	assert.NoError(t, lobby.AddPlayer(invited, 0, ""))
+
+	assert.Empty(t, FilterVisible([]*Lobby{lobby}, other))
+	assert.Len(t, FilterVisible([]*Lobby{lobby}, invited), 1)
+}
+
+func TestInviteLink(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	lobby.Private = true
+	lobby.Save()
+
+	invite, err := lobby.NewInviteLink(0, 1)
+	require.NoError(t, err)
+	token := invite.Token()
+
+	p1 := testhelpers.CreatePlayer()
+	p2 := testhelpers.CreatePlayer()
+
+	assert.Equal(t, ErrInvalidInvite, lobby.UseInvite(p1, token+"x"))
+	assert.Equal(t, ErrInvalidInvite, lobby.UseInvite(p1, "1.foo"))
+
+	require.NoError(t, lobby.UseInvite(p1, token))
+	assert.True(t, lobby.IsInvited(p1))
+	require.NoError(t, lobby.AddPlayer(p1, 0, ""))
+	// players who used the link can use it again
+	assert.NoError(t, lobby.UseInvite(p1, token))
+
+	assert.Equal(t, ErrInviteUsedUp, lobby.UseInvite(p2, token))
+	assert.False(t, lobby.IsInvited(p2))
+
+	// tokens only work for the lobby they were created for
+	other := testhelpers.CreateLobby()
+	defer other.Close(false, true)
+	assert.Equal(t, ErrInvalidInvite, other.UseInvite(p2, token))
+}
+
+func TestInviteLinkExpired(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	lobby.Private = true
+	lobby.Save()
+
+	invite, err := lobby.NewInviteLink(time.Millisecond, 0)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	p := testhelpers.CreatePlayer()
+	assert.Equal(t, ErrInviteExpired, lobby.UseInvite(p, invite.Token()))
+}
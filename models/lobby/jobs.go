@@ -0,0 +1,116 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/models/chat"
+	"github.com/TF2Stadium/Helen/models/job"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	jobServemeCheck = "servemeCheck"
+	jobDownloadDemo = "downloadDemo"
+	jobSeriesScores = "seriesScores"
+
+	jobPurgeRematchServers = "purgeRematchServers"
+	jobPurgeSlotHolds      = "purgeSlotHolds"
+)
+
+// payload for serveme jobs, the reservation is made with the context for
+// the lobby creator's region, which can't be found from the lobby
+type servemeJob struct {
+	LobbyID uint   `json:"lobbyID"`
+	Serveme string `json:"serveme"` // the context's host
+}
+
+type seriesJob struct {
+	LobbyID uint `json:"lobbyID"`
+	LogsID  int  `json:"logsID"`
+}
+
+func init() {
+	job.Register(jobServemeCheck, servemeCheck)
+	job.Register(jobDownloadDemo, downloadDemo)
+	job.Register(jobSeriesScores, seriesScores)
+	job.Every(jobPurgeRematchServers, time.Minute, purgeRematchServers)
+	job.Every(jobPurgeSlotHolds, time.Minute, purgeSlotHolds)
+}
+
+func servemeCheck(payload json.RawMessage) error {
+	var args servemeJob
+	if err := json.Unmarshal(payload, &args); err != nil {
+		return err
+	}
+
+	l, err := GetLobbyByIDServer(args.LobbyID)
+	if err != nil || l.ServemeID == 0 || l.CurrentState() == Ended {
+		return nil
+	}
+
+	context := helpers.GetServemeContextHost(args.Serveme)
+	ended, err := context.Ended(l.ServemeID, l.CreatedBySteamID)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if ended {
+		chat.SendNotification("Lobby Closed (Serveme reservation ended.)", int(l.ID))
+		l.Close(true, false)
+		return nil
+	}
+
+	return job.After(10 * time.Second)
+}
+
+func downloadDemo(payload json.RawMessage) error {
+	var args servemeJob
+	if err := json.Unmarshal(payload, &args); err != nil {
+		return err
+	}
+
+	l, err := GetLobbyByID(args.LobbyID)
+	if err != nil {
+		return nil
+	}
+
+	return l.DownloadDemo(helpers.GetServemeContextHost(args.Serveme))
+}
+
+// seriesScores records the result of a series game whose scores couldn't be
+// fetched when it ended. Failing to fetch them retries the job.
+func seriesScores(payload json.RawMessage) error {
+	var args seriesJob
+	if err := json.Unmarshal(payload, &args); err != nil {
+		return err
+	}
+
+	l, err := GetLobbyByIDServer(args.LobbyID)
+	if err != nil || l.CurrentState() == Ended {
+		return nil
+	}
+
+	red, blu, err := fetchScores(args.LogsID)
+	if err != nil {
+		return err
+	}
+	// the result is recorded, so errors changing the map aren't retried
+	over, err := l.recordSeriesGame(args.LogsID, red, blu)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if over {
+		l.Close(false, true)
+	}
+	return nil
+}
+
+func jobKey(lobbyID uint) string {
+	return fmt.Sprint(lobbyID)
+}
@@ -18,8 +18,10 @@ import (
 	"github.com/TF2Stadium/Helen/controllers/broadcaster"
 	db "github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/TF2Stadium/Helen/models/chat"
 	"github.com/TF2Stadium/Helen/models/gameserver"
+	"github.com/TF2Stadium/Helen/models/job"
 	"github.com/TF2Stadium/Helen/models/lobby/format"
 	"github.com/TF2Stadium/Helen/models/player"
 	"github.com/TF2Stadium/Helen/models/rpc"
@@ -37,6 +39,7 @@ const (
 	ReadyingUp   State = 2
 	InProgress   State = 3
 	Ended        State = 5
+	Scheduled    State = 6 // players can sign up until the lobby opens
 )
 
 var (
@@ -47,6 +50,8 @@ var (
 	ErrNotWhitelisted  = errors.New("You are not allowed in this lobby")
 	ErrInvalidPassword = errors.New("Incorrect slot password")
 	ErrNeedsSub        = errors.New("This slot needs a substitute")
+	ErrLobbyClosed     = errors.New("Cannot join a closed lobby.")
+	ErrConcurrentJoin  = errors.New("Your lobby changed while joining, please try again")
 
 	ErrReqHours       = errors.New("You do not have sufficient hours to join that slot")
 	ErrReqLobbies     = errors.New("You have not played sufficient lobbies to join that slot")
@@ -119,6 +124,7 @@ type Lobby struct {
 	Slots []LobbySlot `gorm:"ForeignKey:LobbyID"` // List of occupied slots
 
 	RegionLock        bool
+	Private           bool              // invite only, and hidden from the lobby list
 	PlayerWhitelist   string            // URL of steam group
 	TwitchChannel     string            // twitch channel, slots will be restricted
 	TwitchRestriction TwitchRestriction // restricted to either followers or subs
@@ -134,30 +140,38 @@ type Lobby struct {
 
 	Whitelist string //whitelist.tf ID
 
+	MapVoteMaps string // maps players vote for, comma separated. Empty if there's no map vote
+	SeriesMaps  string // maps of a best-of series in the order they're played, comma separated. Empty if the lobby isn't a series
+
 	Spectators    []player.Player `gorm:"many2many:spectators_players_lobbies"` // List of spectators
 	BannedPlayers []player.Player `gorm:"many2many:banned_players_lobbies"`     // List of Banned Players
 
 	CreatedBySteamID string // SteamID of the lobby leader/creator
+	RematchOf        uint   // ID of the lobby this lobby is a rematch of, if any
+	RematchOpenedAt  int64  // (Unix) Timestamp at which the rematch started waiting for players
 
+	ScheduledFor     int64 // (Unix) Timestamp at which a scheduled lobby opens, 0 if it opened when created
 	ReadyUpTimestamp int64 // (Unix) Timestamp at which the ready up timeout started
 	MatchEnded       bool  // if true, the lobby ended with the match ending in the game server
 	LogstfID         int   // logs.tf id (only when match ends)
 }
 
 func getGamemode(mapName string, lobbyType format.Format) string {
-	switch {
-	case strings.HasPrefix(mapName, "koth"):
-		if lobbyType == format.Ultiduo {
-			return "ultiduo"
+	mode := mapGamemode(mapName)
+	if info, ok := format.Get(lobbyType); ok {
+		if formatMode, ok := info.Gamemodes[mode]; ok {
+			return formatMode
 		}
+	}
+	return mode
+}
 
+func mapGamemode(mapName string) string {
+	switch {
+	case strings.HasPrefix(mapName, "koth"):
 		return "koth"
 
 	case strings.HasPrefix(mapName, "ctf"):
-		if lobbyType == format.Bball {
-			return "bball"
-		}
-
 		return "ctf"
 
 	case strings.HasPrefix(mapName, "cp"):
@@ -212,7 +226,8 @@ func (lobby *Lobby) Delete() {
 	var count int
 
 	db.DB.Model(&gameserver.ServerRecord{}).Where("host = ?", lobby.ServerInfo.Host).Count(&count)
-	if count != 0 {
+	// scheduled lobbies haven't taken their server yet
+	if count != 0 && lobby.State != Scheduled {
 		gameserver.PutStoredServer(lobby.ServerInfo.Host)
 	}
 
@@ -226,13 +241,44 @@ func (lobby *Lobby) Delete() {
 
 	db.DB.Delete(lobby)
 	db.DB.Delete(&lobby.ServerInfo)
+	db.DB.Where("lobby_id = ?", lobby.ID).Delete(&MapVote{})
+	db.DB.Where("lobby_id = ?", lobby.ID).Delete(&SeriesGame{})
+	db.DB.Where("lobby_id = ?", lobby.ID).Delete(&LobbyInvite{})
+	db.DB.Where("lobby_id = ?", lobby.ID).Delete(&SlotHold{})
+	invalidateLobbyData(lobby.ID)
 
 	lobby.deleteLock()
 }
 
-//GetWaitingLobbies returns a list of lobby objects that haven't been filled yet
+// listedStates are the states of lobbies in the lobby list
+var listedStates = []State{Waiting, Scheduled}
+
+// GetWaitingLobbies returns a list of lobby objects that haven't been filled yet,
+// including scheduled lobbies players can sign up for. Private lobbies aren't listed.
 func GetWaitingLobbies() (lobbies []*Lobby) {
-	db.DB.Where("state = ?", Waiting).Order("id desc").Find(&lobbies)
+	db.DB.Where("state IN (?) AND private = FALSE", listedStates).Order("id desc").Find(&lobbies)
+	return
+}
+
+// GetWaitingLobbiesPage is like GetWaitingLobbies, but returns at most limit lobbies,
+// skipping the first offset ones. total is the number of waiting lobbies.
+func GetWaitingLobbiesPage(limit, offset int) (lobbies []*Lobby, total int) {
+	db.DB.Model(&Lobby{}).Where("state IN (?) AND private = FALSE", listedStates).Count(&total)
+	db.DB.Where("state IN (?) AND private = FALSE", listedStates).Order("id desc").Limit(limit).Offset(offset).Find(&lobbies)
+	return
+}
+
+// GetPlayerRecentLobbies returns up to limit ended lobbies the player played in (and
+// wasn't substituted out of), newest first. Only lobbies with IDs >= minID
+// and, if beforeID isn't zero, < beforeID are returned.
+func GetPlayerRecentLobbies(playerID uint, limit int, minID, beforeID uint) (lobbies []*Lobby) {
+	query := db.DB.Model(&Lobby{}).Joins("INNER JOIN lobby_slots ON lobbies.ID = lobby_slots.lobby_id").
+		Where("lobbies.match_ended = TRUE and lobby_slots.player_id = ? AND lobby_slots.needs_sub = FALSE AND lobbies.ID >= ?", playerID, minID)
+	if beforeID != 0 {
+		query = query.Where("lobbies.ID < ?", beforeID)
+	}
+
+	query.Order("lobbies.id desc").Limit(limit).Find(&lobbies)
 	return
 }
 
@@ -248,29 +294,42 @@ func (l *Lobby) CurrentState() State {
 func (l *Lobby) SetState(s State) {
 	db.DB.Model(&Lobby{}).Where("id = ?", l.ID).UpdateColumn("state", s)
 	l.State = s
+	invalidateLobbyData(l.ID)
+}
+
+// ChangeState changes the lobby's state to s if it's currently from, in a
+// transaction holding the lobby's advisory lock. Only one caller, on any
+// instance, changes the state, and gets true.
+func (l *Lobby) ChangeState(from, s State) (bool, error) {
+	changed := false
+	err := l.transaction(func(tx *gorm.DB) error {
+		res := tx.Exec("UPDATE lobbies SET state = ? WHERE id = ? AND state = ?", s, l.ID, from)
+		if res.Error != nil {
+			return res.Error
+		}
+		changed = res.RowsAffected == 1
+		return nil
+	})
+	if err != nil || !changed {
+		return false, err
+	}
+
+	l.State = s
+	invalidateLobbyData(l.ID)
+	return true, nil
 }
 
-//ServemeCheck checks the status of the serveme reservation for the lobby
-//(if any) every 10 seconds in a goroutine, and closes the lobby if it has ended
+// ServemeCheck enqueues a job which checks the status of the serveme
+// reservation for the lobby every 10 seconds, and closes the lobby if it has ended
 func (l *Lobby) ServemeCheck(context *servemetf.Context) {
-	go func() {
-		for {
-			ended, err := context.Ended(l.ServemeID, l.CreatedBySteamID)
-			if err != nil {
-				logrus.Error(err)
-			}
-			if ended {
-				if l.CurrentState() != Ended {
-					chat.SendNotification("Lobby Closed (Serveme reservation ended.)", int(l.ID))
-					l.Close(true, false)
-				}
-				return
-			}
-			time.Sleep(10 * time.Second)
-		}
-	}()
+	err := job.Enqueue(jobServemeCheck, servemeJob{l.ID, context.Host}, job.Options{Key: jobKey(l.ID)})
+	if err != nil {
+		logrus.Error(err)
+	}
 }
 
+// RestoreServemeChecks enqueues checks for lobbies which don't have one yet,
+// for lobbies created before checks were jobs
 func RestoreServemeChecks() {
 	var ids []uint
 	db.DB.Model(&Lobby{}).Where("state <> ? AND serveme_id <> 0", Ended).Pluck("id", &ids)
@@ -362,6 +421,7 @@ func (lobby *Lobby) SlotNeedsSubstitute(slot int) (needsSub bool) {
 //FillSubstitute marks the substitute reocrd for the given slot as true, and Broadcasts the updated sub list
 func (lobby *Lobby) FillSubstitute(slot int) error {
 	err := db.DB.Model(&LobbySlot{}).Where("lobby_id = ? AND slot = ?", lobby.ID, slot).UpdateColumn("needs_sub", false).Error
+	invalidateLobbyData(lobby.ID)
 	BroadcastSubList()
 	return err
 }
@@ -382,6 +442,8 @@ func (lobby *Lobby) IsSlotOccupied(slot int) bool {
 
 //AddPlayer adds the given player to lobby, If the player occupies a slot in the lobby already, switch slots.
 //If the player is in another lobby, removes them from that lobby before adding them.
+//Moving the player out of their previous slot and into the new one happens in a single transaction,
+// so a failed join leaves the player where they were.
 func (lobby *Lobby) AddPlayer(p *player.Player, slot int, password string) error {
 	/* Possible errors while joining
 	 * Slot has been filled
@@ -394,17 +456,10 @@ func (lobby *Lobby) AddPlayer(p *player.Player, slot int, password string) error
 		return ErrLobbyBan
 	}
 
-	if slot >= 2*format.NumberOfClassesMap[lobby.Type] || slot < 0 {
+	if slot >= 2*format.NumberOfClasses(lobby.Type) || slot < 0 {
 		return ErrBadSlot
 	}
 
-	isSubstitution := lobby.SlotNeedsSubstitute(slot)
-
-	//Check whether the slot is occupied
-	if !isSubstitution && lobby.IsSlotOccupied(slot) {
-		return ErrFilled
-	}
-
 	if lobby.HasSlotRequirement(slot) {
 		//check if player fits the requirements for the slot
 		if ok, err := lobby.FitsRequirements(p, slot); !ok {
@@ -417,73 +472,43 @@ func (lobby *Lobby) AddPlayer(p *player.Player, slot int, password string) error
 		}
 	}
 
-	var slotChange bool
-	//Check if the player is currently in another lobby
-	if currLobbyID, err := p.GetLobbyID(false); err == nil {
-		if currLobbyID != lobby.ID {
-			//if the player is in a different lobby, remove them from that lobby
-			//plus substitute them
-			curLobby, _ := GetLobbyByID(currLobbyID)
-
-			if curLobby.State == InProgress {
-				curLobby.Substitute(p)
-			} else {
-				curLobby.Lock()
-				db.DB.Where("player_id = ? AND lobby_id = ?", p.ID, curLobby.ID).Delete(&LobbySlot{})
-				curLobby.Unlock()
-
-			}
-
-		} else { //player is in the same lobby, they're changing their slots
-			//assign the player to a new slot
-			if isSubstitution {
-				//the slot needs a substitute (which happens when the lobby is in progress),
-				//so players already in the lobby cannot fill it.
-				return ErrNeedsSub
-			}
-			lobby.Lock()
-			db.DB.Where("player_id = ? AND lobby_id = ?", p.ID, lobby.ID).Delete(&LobbySlot{})
-			lobby.Unlock()
-
-			slotChange = true
+	// the whitelist and twitch checks make HTTP requests, so they can't be done
+	// while holding row locks. They're only needed for players joining the lobby.
+	currLobbyID, _ := p.GetLobbyID(false)
+	inLobby := currLobbyID == lobby.ID
+	if !inLobby {
+		if err := lobby.checkJoinRestrictions(p); err != nil {
+			return err
 		}
 	}
 
-	if !slotChange {
-		//check if the player is in the steam group whitelist
-		url := fmt.Sprintf(`http://steamcommunity.com/groups/%s/memberslistxml/?xml=1`,
-			lobby.PlayerWhitelist)
+	var join *slotJoin
+	err := lobby.transaction(func(tx *gorm.DB) (err error) {
+		join, err = lobby.joinSlot(tx, p, slot, inLobby)
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-		if lobby.PlayerWhitelist != "" && !helpers.IsWhitelisted(p.SteamID, url) {
-			return ErrNotWhitelisted
+	if join.prevLobby != nil {
+		if join.prevSubstituted {
+			join.prevLobby.afterSubstitute(p)
+		} else {
+			join.prevLobby.OnChange(true)
 		}
-
-		//check if player has been subbed to the twitch channel (if any)
-		//allow channel owners
-		if lobby.TwitchChannel != "" && p.TwitchName != lobby.TwitchChannel {
-			//check if player has connected their twitch account
-			if p.TwitchAccessToken == "" {
-				return errors.New("You need to connect your Twitch Account first to join the lobby.")
-			}
-			if lobby.TwitchRestriction == TwitchSubscribers && !p.IsSubscribed(lobby.TwitchChannel) {
-				return fmt.Errorf("You aren't subscribed to %s", lobby.TwitchChannel)
-			}
-			if lobby.TwitchRestriction == TwitchFollowers && !p.IsFollowing(lobby.TwitchChannel) {
-				return fmt.Errorf("You aren't following %s", lobby.TwitchChannel)
-			}
+		if join.prevLobby.State == Scheduled {
+			// players can only be in one lobby, so joining another cancels
+			// their sign up
+			broadcaster.SendMessage(p.SteamID, "lobbySignupRemoved", struct {
+				ID     uint `json:"id"`
+				Joined uint `json:"joined"`
+			}{join.prevLobby.ID, lobby.ID})
 		}
 	}
 
-	// Check if player is a substitute (the slot needs a subtitute)
-	if isSubstitution {
-		//get previous slot, to kick them from game
-		prevPlayerID, _ := lobby.GetPlayerIDBySlot(slot)
-		prevPlayer, _ := player.GetPlayerByID(prevPlayerID)
-
-		lobby.Lock()
-		db.DB.Where("lobby_id = ? AND slot = ?", lobby.ID, slot).Delete(&LobbySlot{})
-		lobby.Unlock()
-
+	if join.substituted != 0 {
+		prevPlayer, _ := player.GetPlayerByID(join.substituted)
 		go func() {
 			//kicks previous slot occupant if they're in-game, resets their !rep count, removes them from the lobby
 			rpc.DisallowPlayer(lobby.ID, prevPlayer.SteamID, prevPlayer.ID)
@@ -492,22 +517,12 @@ func (lobby *Lobby) AddPlayer(p *player.Player, slot int, password string) error
 			class, team, _ := format.GetSlotTeamClass(lobby.Type, slot)
 			rpc.Say(lobby.ID, fmt.Sprintf("Substitute found for %s %s: %s (%s)", team, class, p.Name, p.SteamID))
 		}()
-		//allow player in mumble
 	}
 
 	//try to remove them from spectators
 	lobby.RemoveSpectator(p, true)
 
-	newSlotObj := &LobbySlot{
-		PlayerID: p.ID,
-		LobbyID:  lobby.ID,
-		Slot:     slot,
-	}
-
-	lobby.Lock()
-	db.DB.Create(newSlotObj)
-	lobby.Unlock()
-	if !slotChange {
+	if !join.slotChange {
 		if p.TwitchName != "" {
 			rpc.TwitchBotAnnouce(p.TwitchName, lobby.ID)
 		}
@@ -519,12 +534,156 @@ func (lobby *Lobby) AddPlayer(p *player.Player, slot int, password string) error
 	return nil
 }
 
+// checkJoinRestrictions checks the lobby's invites, steam group whitelist and twitch restriction
+func (lobby *Lobby) checkJoinRestrictions(p *player.Player) error {
+	if lobby.Private && !lobby.IsInvited(p) {
+		return ErrNotInvited
+	}
+
+	// check if the player is in the steam group whitelist
+	url := fmt.Sprintf(`http://steamcommunity.com/groups/%s/memberslistxml/?xml=1`,
+		lobby.PlayerWhitelist)
+
+	if lobby.PlayerWhitelist != "" && !helpers.IsWhitelisted(p.SteamID, url) {
+		return ErrNotWhitelisted
+	}
+
+	// check if player has been subbed to the twitch channel (if any)
+	// allow channel owners
+	if lobby.TwitchChannel != "" && p.TwitchName != lobby.TwitchChannel {
+		// check if player has connected their twitch account
+		if p.TwitchAccessToken == "" {
+			return errors.New("You need to connect your Twitch Account first to join the lobby.")
+		}
+		if lobby.TwitchRestriction == TwitchSubscribers && !p.IsSubscribed(lobby.TwitchChannel) {
+			return fmt.Errorf("You aren't subscribed to %s", lobby.TwitchChannel)
+		}
+		if lobby.TwitchRestriction == TwitchFollowers && !p.IsFollowing(lobby.TwitchChannel) {
+			return fmt.Errorf("You aren't following %s", lobby.TwitchChannel)
+		}
+	}
+
+	return nil
+}
+
+// slotJoin describes what joinSlot changed, so that the right messages can be
+// sent after the transaction has been committed
+type slotJoin struct {
+	prevLobby       *Lobby //lobby the player was moved out of, if any
+	prevSubstituted bool   //true if the player needs a substitute in prevLobby
+	slotChange      bool   //true if the player moved to another slot in the same lobby
+	substituted     uint   //ID of the player whose slot was taken, if it needed a substitute
+}
+
+// joinSlot moves the player into the slot, in the transaction tx, which holds
+// the lobby's advisory lock. The player and the lobby rows are locked (in that
+// order, so that concurrent joins can't deadlock), so joins to the same lobby,
+// and joins by the same player, happen one after the other.
+func (lobby *Lobby) joinSlot(tx *gorm.DB, p *player.Player, slot int, wasInLobby bool) (*slotJoin, error) {
+	join := &slotJoin{}
+	forUpdate := tx.Set("gorm:query_option", "FOR UPDATE")
+
+	if err := forUpdate.First(&player.Player{}, p.ID).Error; err != nil {
+		return nil, err
+	}
+
+	locked := &Lobby{}
+	if err := forUpdate.First(locked, lobby.ID).Error; err != nil {
+		return nil, ErrLobbyNotFound
+	}
+	if locked.State == Ended {
+		return nil, ErrLobbyClosed
+	}
+
+	target := &LobbySlot{}
+	err := forUpdate.Where("lobby_id = ? AND slot = ?", lobby.ID, slot).First(target).Error
+	isSubstitution := err == nil && target.NeedsSub
+	// Check whether the slot is occupied
+	if err == nil && !isSubstitution {
+		return nil, ErrFilled
+	}
+	if err := locked.checkRematchPriority(tx, p.ID, slot); err != nil {
+		return nil, err
+	}
+	if err := locked.checkSlotHold(tx, p.ID, slot); err != nil {
+		return nil, err
+	}
+
+	// only the slot row is locked, locking the other lobby's row could
+	// deadlock with a player joining the other way round
+	current := &LobbySlot{}
+	err = tx.Set("gorm:query_option", "FOR UPDATE OF lobby_slots").
+		Select("lobby_slots.*").
+		Joins("INNER JOIN lobbies ON lobbies.id = lobby_slots.lobby_id").
+		Where("lobby_slots.player_id = ? AND lobbies.state <> ? AND lobby_slots.needs_sub = FALSE", p.ID, Ended).
+		First(current).Error
+	inLobby := err == nil
+
+	switch {
+	case inLobby && current.LobbyID == lobby.ID:
+		if !wasInLobby {
+			// the whitelist checks were skipped, since the player was in this
+			// lobby before the transaction
+			return nil, ErrConcurrentJoin
+		}
+		if isSubstitution {
+			// the slot needs a substitute (which happens when the lobby is in progress),
+			// so players already in the lobby cannot fill it.
+			return nil, ErrNeedsSub
+		}
+		if err := tx.Delete(current).Error; err != nil {
+			return nil, err
+		}
+		join.slotChange = true
+
+	case inLobby:
+		// if the player is in a different lobby, remove them from that lobby
+		// plus substitute them
+		prevLobby, err := GetLobbyByID(current.LobbyID)
+		if err != nil {
+			return nil, err
+		}
+		join.prevLobby = prevLobby
+
+		if prevLobby.State == InProgress {
+			err = tx.Model(current).UpdateColumn("needs_sub", true).Error
+			join.prevSubstituted = true
+		} else {
+			err = tx.Delete(current).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	case wasInLobby:
+		return nil, ErrConcurrentJoin
+	}
+
+	// Check if player is a substitute (the slot needs a subtitute)
+	if isSubstitution {
+		if err := tx.Delete(target).Error; err != nil {
+			return nil, err
+		}
+		join.substituted = target.PlayerID
+	}
+
+	newSlotObj := &LobbySlot{
+		PlayerID: p.ID,
+		LobbyID:  lobby.ID,
+		Slot:     slot,
+	}
+	if err := tx.Create(newSlotObj).Error; err != nil {
+		return nil, err
+	}
+
+	return join, nil
+}
+
 //RemovePlayer removes a given player from the lobby
 func (lobby *Lobby) RemovePlayer(player *player.Player) error {
-	lobby.Lock()
-	err := db.DB.Where("player_id = ? AND lobby_id = ?", player.ID, lobby.ID).Delete(&LobbySlot{}).Error
-	lobby.Unlock()
-
+	err := lobby.updateSlots(func(tx *gorm.DB) error {
+		return tx.Where("player_id = ? AND lobby_id = ?", player.ID, lobby.ID).Delete(&LobbySlot{}).Error
+	})
 	if err != nil {
 		return err
 	}
@@ -572,18 +731,21 @@ func (lobby *Lobby) GetUnreadyPlayers() (players []*player.Player) {
 func (lobby *Lobby) RemoveUnreadyPlayers(spec bool) error {
 	playerids := []uint{}
 
-	if spec {
-		//get list of player ids which are not ready
-		err := db.DB.Model(&LobbySlot{}).Where("lobby_id = ? AND ready = ?", lobby.ID, false).Pluck("player_id", &playerids).Error
-		if err != nil {
-			return err
+	err := lobby.updateSlots(func(tx *gorm.DB) error {
+		if spec {
+			//get list of player ids which are not ready
+			err := tx.Model(&LobbySlot{}).Where("lobby_id = ? AND ready = ?", lobby.ID, false).Pluck("player_id", &playerids).Error
+			if err != nil {
+				return err
+			}
 		}
-	}
 
-	//remove players which aren't ready
-	lobby.Lock()
-	err := db.DB.Where("lobby_id = ? AND ready = ?", lobby.ID, false).Delete(&LobbySlot{}).Error
-	lobby.Unlock()
+		//remove players which aren't ready
+		return tx.Where("lobby_id = ? AND ready = ?", lobby.ID, false).Delete(&LobbySlot{}).Error
+	})
+	if err != nil {
+		return err
+	}
 
 	if spec {
 		for _, id := range playerids {
@@ -592,7 +754,7 @@ func (lobby *Lobby) RemoveUnreadyPlayers(spec bool) error {
 		}
 	}
 	lobby.OnChange(true)
-	return err
+	return nil
 }
 
 var (
@@ -640,9 +802,9 @@ func (lobby *Lobby) IsPlayerReady(player *player.Player) (bool, error) {
 
 //UnreadyAllPlayers unreadies all players in the lobby
 func (lobby *Lobby) UnreadyAllPlayers() error {
-	lobby.Lock()
-	err := db.DB.Model(&LobbySlot{}).Where("lobby_id = ?", lobby.ID).UpdateColumn("ready", false).Error
-	lobby.Unlock()
+	err := lobby.updateSlots(func(tx *gorm.DB) error {
+		return tx.Model(&LobbySlot{}).Where("lobby_id = ?", lobby.ID).UpdateColumn("ready", false).Error
+	})
 
 	lobby.OnChange(false)
 	return err
@@ -659,7 +821,7 @@ func (lobby *Lobby) IsEveryoneReady() bool {
 	readyPlayers := 0
 	db.DB.Model(&LobbySlot{}).Where("lobby_id = ? AND ready = ?", lobby.ID, true).Count(&readyPlayers)
 
-	return readyPlayers == 2*format.NumberOfClassesMap[lobby.Type]
+	return readyPlayers == 2*format.NumberOfClasses(lobby.Type)
 }
 
 //AddSpectator adds a given player as a lobby spectator
@@ -681,6 +843,9 @@ func (lobby *Lobby) RemoveSpectator(player *player.Player, broadcast bool) error
 	}
 	if broadcast {
 		lobby.OnChange(false)
+	} else {
+		// the cached data includes spectators
+		invalidateLobbyData(lobby.ID)
 	}
 	return nil
 }
@@ -701,7 +866,7 @@ func (lobby *Lobby) IsFull() bool {
 }
 
 func (lobby *Lobby) RequiredPlayers() int {
-	return 2 * format.NumberOfClassesMap[lobby.Type]
+	return 2 * format.NumberOfClasses(lobby.Type)
 }
 
 func (lobby *Lobby) IsEnoughPlayers(n int) bool {
@@ -725,12 +890,6 @@ func (lobby *Lobby) GetAllSlots() []LobbySlot {
 
 //GetAllSlots returns a list of all occupied slots in the lobby
 func (lobby *Lobby) ShuffleAllSlots() error {
-	if lobby.GetPlayerNumber() == lobby.RequiredPlayers() {
-		return errors.New("Cannot shuffle a full lobby")
-	}
-
-	lobby.Lock()
-	lobby.GetAllSlots()
 	classes := format.GetClasses(lobby.Type)
 	swapClass := make(map[string]bool)
 
@@ -738,29 +897,42 @@ func (lobby *Lobby) ShuffleAllSlots() error {
 		swapClass[className] = rand.Intn(2) == 1
 	}
 
-	err := db.DB.Delete(&LobbySlot{}, "lobby_id = ?", lobby.ID).Error
-	if err != nil {
-		return err
-	}
-
-	numClasses := len(classes)
-	for i := range lobby.Slots {
-		slot := &lobby.Slots[i]
-		if swapClass[classes[slot.Slot%numClasses]] {
-			slot.Slot = (slot.Slot + numClasses) % (2 * numClasses)
+	err := lobby.updateSlots(func(tx *gorm.DB) error {
+		var slots []LobbySlot
+		if err := tx.Where("lobby_id = ?", lobby.ID).Find(&slots).Error; err != nil {
+			return err
+		}
+		if len(slots) == lobby.RequiredPlayers() {
+			return errors.New("Cannot shuffle a full lobby")
 		}
-		if err = db.DB.Create(&slot).Error; err != nil {
+
+		err := tx.Delete(&LobbySlot{}, "lobby_id = ?", lobby.ID).Error
+		if err != nil {
 			return err
 		}
+
+		numClasses := len(classes)
+		for i := range slots {
+			slot := &slots[i]
+			if swapClass[classes[slot.Slot%numClasses]] {
+				slot.Slot = (slot.Slot + numClasses) % (2 * numClasses)
+			}
+			if err = tx.Create(slot).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	lobby.Unlock()
 
 	lobby.OnChange(true)
 	return nil
 }
 
 func (lobby *Lobby) DiscordNotif(msg string) {
-	if helpers.Discord != nil {
+	if helpers.Discord != nil && !lobby.Private {
 		mumble := ""
 		if lobby.Mumble {
 			mumble = helpers.DiscordEmoji("mumble")
@@ -781,7 +953,7 @@ func (lobby *Lobby) DiscordNotif(msg string) {
 			byLine = fmt.Sprintf(" by %s", player.Alias())
 		}
 
-		formatName := format.FriendlyNamesMap[lobby.Type]
+		formatName := format.FriendlyName(lobby.Type)
 
 		msg := fmt.Sprintf("%s: %s%s %s on %s%s: %s/lobby/%d", msg, region, mumble, formatName, lobby.MapName, byLine, config.Constants.LoginRedirectPath, lobby.ID)
 		specificChannel := strings.ToLower(fmt.Sprintf("%s-%s", formatName, lobby.RegionCode))
@@ -818,19 +990,27 @@ func (lobby *Lobby) Close(doRPC, matchEnded bool) {
 
 	db.DB.Preload("ServerInfo").First(lobby, lobby.ID)
 	db.DB.Model(&gameserver.ServerRecord{}).Where("host = ?", lobby.ServerInfo.Host).Count(&count)
-	if count != 0 {
+	// scheduled lobbies haven't taken their server yet
+	if count != 0 && lobby.State != Scheduled {
 		gameserver.PutStoredServer(lobby.ServerInfo.Host)
 	}
 
 	lobby.SetState(Ended)
 	db.DB.First(lobby).UpdateColumn("match_ended", matchEnded)
+	invalidateLobbyData(lobby.ID)
 	//db.DB.Exec("DELETE FROM spectators_players_lobbies WHERE lobby_id = ?", lobby.ID)
 	if doRPC {
 		rpc.End(lobby.ID)
 	}
-	if matchEnded {
+	// series games are counted when each of them ends
+	if matchEnded && !lobby.IsSeries() {
 		lobby.UpdateStats()
 	}
+	if matchEnded {
+		if err := lobby.saveRematchServer(); err != nil {
+			logrus.Error(err)
+		}
+	}
 	if lobby.ServemeID != 0 {
 		context := helpers.GetServemeContext(lobby.ServerInfo.Host)
 		err := context.Delete(lobby.ServemeID, lobby.CreatedBySteamID)
@@ -838,9 +1018,13 @@ func (lobby *Lobby) Close(doRPC, matchEnded bool) {
 			logrus.Error(err)
 		}
 		if matchEnded {
-			time.AfterFunc(10*time.Second, func() {
-				lobby.DownloadDemo(context)
+			err := job.Enqueue(jobDownloadDemo, servemeJob{lobby.ID, context.Host}, job.Options{
+				Key:   jobKey(lobby.ID),
+				Delay: 10 * time.Second,
 			})
+			if err != nil {
+				logrus.Error(err)
+			}
 		}
 	}
 
@@ -853,21 +1037,23 @@ func (lobby *Lobby) Close(doRPC, matchEnded bool) {
 	db.DB.Model(&gameserver.ServerRecord{}).Where("id = ?", lobby.ServerInfoID).Delete(&gameserver.ServerRecord{})
 	BroadcastSubList()
 	BroadcastLobby(lobby)
-	BroadcastLobbyList() // has to be done manually for now
+	BroadcastLobbyListChange(lobby) // has to be done manually for now
 	rpc.FumbleLobbyEnded(lobby.ID)
 	lobby.deleteLock()
 }
 
-func (lobby *Lobby) DownloadDemo(context *servemetf.Context) {
+func (lobby *Lobby) DownloadDemo(context *servemetf.Context) error {
 	file := fmt.Sprintf("%s/%d.dem", config.Constants.DemosFolder,
 		lobby.ID)
 	err := context.DownloadDemo(lobby.ServemeID, lobby.CreatedBySteamID, file)
 	if err != nil {
 		logrus.Error(err)
-	} else {
-		url := fmt.Sprintf("%s/demos/%d.dem", config.Constants.PublicAddress, lobby.ID)
-		chat.SendNotification("STV Demo for this lobby is available at "+url, int(lobby.ID))
+		return err
 	}
+
+	url := fmt.Sprintf("%s/demos/%d.dem", config.Constants.PublicAddress, lobby.ID)
+	chat.SendNotification("STV Demo for this lobby is available at "+url, int(lobby.ID))
+	return nil
 }
 
 //UpdateStats updates the PlayerStats records for all players in the lobby
@@ -980,7 +1166,10 @@ func (lobby *Lobby) SetNotInMumble(player *player.Player) error {
 //Start sets lobby.State to LobbyStateInProgress, calls SubNotInGamePlayers after 5 minutes
 func (lobby *Lobby) Start() {
 	rows := db.DB.Model(&Lobby{}).Where("id = ? AND state <> ?", lobby.ID, InProgress).Update("state", InProgress).RowsAffected
+	invalidateLobbyData(lobby.ID)
 	if rows != 0 { // if == 0, then game is already in progress
+		metrics.LobbyStartSeconds.WithLabelValues(format.FriendlyName(lobby.Type)).
+			Observe(time.Since(lobby.CreatedAt).Seconds())
 		go rpc.ReExecConfig(lobby.ID, false)
 
 		// var playerids []uint
@@ -995,19 +1184,25 @@ func (lobby *Lobby) Start() {
 	}
 }
 
-//OnChange broadcasts the given lobby to other players. If base is true, broadcasts the lobby list too.
+// OnChange broadcasts the given lobby to other players. If base is true, broadcasts the change to the lobby list too.
 func (lobby *Lobby) OnChange(base bool) {
+	invalidateLobbyData(lobby.ID)
+
 	switch lobby.State {
-	case Waiting, InProgress, ReadyingUp:
+	case Waiting, InProgress, ReadyingUp, Scheduled:
 		BroadcastLobby(lobby)
 		if base {
-			BroadcastLobbyList()
+			BroadcastLobbyListChange(lobby)
 		}
 	}
 }
 
-//BroadcastLobby broadcasts the lobby to the lobby's public room (id_public)
+// BroadcastLobby broadcasts the lobby to the lobby's public room (id_public).
+// Callers that have changed the lobby without calling OnChange rely on this
+// invalidating the lobby's cached data.
 func BroadcastLobby(lobby *Lobby) {
+	invalidateLobbyData(lobby.ID)
+
 	room := strconv.FormatUint(uint64(lobby.ID), 10)
 
 	broadcaster.SendMessageToRoom(fmt.Sprintf("%s_public", room), "lobbyData", DecorateLobbyData(lobby, true))
@@ -1018,33 +1213,34 @@ func BroadcastLobbyToUser(lobby *Lobby, steamid string) {
 	broadcaster.SendMessage(steamid, "lobbyData", DecorateLobbyData(lobby, true))
 }
 
-//BroadcastLobbyList broadcasts the lobby list to all users
-func BroadcastLobbyList() {
-	broadcaster.SendMessageToRoom(
-		"0_public",
-		"lobbyListData", DecorateLobbyListData(GetWaitingLobbies(), false))
-}
+//Substitute sets the needs_sub column of the given slot to true, and broadcasts the new
+//substitute list. Players who already need a substitute aren't counted again.
+func (lobby *Lobby) Substitute(player *player.Player) error {
+	substituted := false
+	err := lobby.updateSlots(func(tx *gorm.DB) error {
+		res := tx.Model(&LobbySlot{}).Where("lobby_id = ? AND player_id = ? AND needs_sub = FALSE", lobby.ID, player.ID).UpdateColumn("needs_sub", true)
+		substituted = res.RowsAffected != 0
+		return res.Error
+	})
+	if err != nil {
+		return err
+	}
 
-var maxSubs = map[format.Format]int{
-	format.Highlander: 5,
-	format.Sixes:      4,
-	format.Bball:      2,
-	format.Ultiduo:    2,
-	format.Debug:      2,
-	format.Fours:      2,
-	format.Prolander:  4,
+	if substituted {
+		lobby.afterSubstitute(player)
+	}
+	return nil
 }
 
-//Substitute sets the needs_sub column of the given slot to true, and broadcasts the new
-//substitute list
-func (lobby *Lobby) Substitute(player *player.Player) {
-	lobby.Lock()
-	db.DB.Model(&LobbySlot{}).Where("lobby_id = ? AND player_id = ?", lobby.ID, player.ID).UpdateColumn("needs_sub", true)
-	lobby.Unlock()
+// afterSubstitute closes the lobby if it has too many substitutes, and
+// broadcasts the updated substitute list
+func (lobby *Lobby) afterSubstitute(player *player.Player) {
+	invalidateLobbyData(lobby.ID)
+	metrics.Substitutes.WithLabelValues(format.FriendlyName(lobby.Type)).Inc()
 
 	var count int
 	db.DB.Model(&LobbySlot{}).Where("lobby_id = ? AND needs_sub = TRUE", lobby.ID).Count(&count)
-	if count == maxSubs[lobby.Type] {
+	if info, ok := format.Get(lobby.Type); ok && count == info.MaxSubs {
 		chat.SendNotification("Lobby closed (Too many subs).", int(lobby.ID))
 		lobby.Close(true, false)
 	}
@@ -7,6 +7,8 @@ package lobby
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/TF2Stadium/Helen/config"
 	"github.com/TF2Stadium/Helen/controllers/broadcaster"
@@ -63,6 +65,13 @@ type LobbyData struct {
 
 	Classes []ClassDetails `json:"classes"`
 
+	MapVote []MapVoteOption `json:"mapVote,omitempty"`
+	Series  *SeriesData     `json:"series,omitempty"`
+
+	Private      bool  `json:"private,omitempty"`
+	RematchOf    uint  `json:"rematchOf,omitempty"`
+	ScheduledFor int64 `json:"scheduledFor,omitempty"` // (Unix) time a scheduled lobby opens
+
 	Leader      player.Player `json:"leader"`
 	CreatedAt   int64         `json:"createdAt"`
 	State       int           `json:"state"`
@@ -121,51 +130,226 @@ type LobbyEvent struct {
 	NotReady bool `json:"notReady,omitempty"` // true if player removed for not being ready
 }
 
-func decorateSlotDetails(lobby *Lobby, slot int, playerInfo bool) SlotDetails {
-	playerId, err := lobby.GetPlayerIDBySlot(slot)
-	needsSub := lobby.SlotNeedsSubstitute(slot)
+// lobbyDetails holds everything needed to decorate a lobby, loaded by
+// loadLobbyDetails in a fixed number of queries
+type lobbyDetails struct {
+	slots        map[int]*LobbySlot
+	requirements map[int]*Requirement
+	players      map[uint]*player.Player
+	leader       *player.Player
+	spectators   []*player.Player
+	mapVotes     map[string]int
+	seriesGames  []SeriesGame
+}
 
-	slotDetails := SlotDetails{Slot: slot, Filled: err == nil && !needsSub}
+// loadLobbyDetails loads the lobby's slots and requirements, and if playerInfo
+// is true, the players in those slots, the leader and spectators. This takes
+// 2 queries without player info, and 5 with it, plus one for lobbies with a
+// map vote, and one for series.
+func loadLobbyDetails(lobby *Lobby, playerInfo bool) *lobbyDetails {
+	details := &lobbyDetails{
+		slots:        make(map[int]*LobbySlot),
+		requirements: make(map[int]*Requirement),
+		players:      make(map[uint]*player.Player),
+	}
 
-	if err == nil && playerInfo && !needsSub {
-		p, _ := player.GetPlayerByID(playerId)
-		p.SetPlayerSummary()
+	var slots []*LobbySlot
+	db.DB.Where("lobby_id = ?", lobby.ID).Find(&slots)
+	for _, slot := range slots {
+		details.slots[slot.Slot] = slot
+	}
 
-		slotDetails.Player = p
+	var reqs []*Requirement
+	db.DB.Where("lobby_id = ?", lobby.ID).Find(&reqs)
+	for _, req := range reqs {
+		details.requirements[req.Slot] = req
+	}
 
-		ready, _ := lobby.IsPlayerReady(p)
-		slotDetails.Ready = &ready
+	if lobby.HasMapVote() {
+		details.mapVotes = countMapVotes(lobby.ID)
+	}
+	if lobby.IsSeries() {
+		details.seriesGames = getSeriesGames(lobby.ID)
+	}
+
+	if !playerInfo {
+		return details
+	}
 
-		ingame := lobby.IsPlayerInGame(p)
-		slotDetails.InGame = &ingame
+	var specIDs []uint
+	db.DB.Table("spectators_players_lobbies").Where("lobby_id = ?", lobby.ID).Pluck("player_id", &specIDs)
 
-		inmumble := lobby.IsPlayerInMumble(p)
-		slotDetails.InMumble = &inmumble
+	ids := append([]uint{}, specIDs...)
+	for _, slot := range slots {
+		if !slot.NeedsSub {
+			ids = append(ids, slot.PlayerID)
+		}
+	}
+	var steamIDs []string
+	if lobby.CreatedBySteamID != "" { // == "" during tests
+		steamIDs = append(steamIDs, lobby.CreatedBySteamID)
 	}
 
-	if lobby.HasSlotRequirement(slot) {
-		req, _ := lobby.GetSlotRequirement(slot)
-		if req != nil {
-			slotDetails.Requirements = req
-			slotDetails.Password = req.Password != ""
+	for _, p := range player.GetPlayerSummaries(ids, steamIDs) {
+		details.players[p.ID] = p
+		if p.SteamID == lobby.CreatedBySteamID {
+			details.leader = p
+		}
+	}
+	for _, id := range specIDs {
+		if p, ok := details.players[id]; ok {
+			details.spectators = append(details.spectators, p)
 		}
 	}
 
+	return details
+}
+
+func decorateSlotDetails(details *lobbyDetails, slot int, playerInfo bool) SlotDetails {
+	slotObj, occupied := details.slots[slot]
+	needsSub := occupied && slotObj.NeedsSub
+
+	slotDetails := SlotDetails{Slot: slot, Filled: occupied && !needsSub}
+
+	if occupied && playerInfo && !needsSub {
+		if p, ok := details.players[slotObj.PlayerID]; ok {
+			slotDetails.Player = p
+
+			ready, ingame, inmumble := slotObj.Ready, slotObj.InGame, slotObj.InMumble
+			slotDetails.Ready = &ready
+			slotDetails.InGame = &ingame
+			slotDetails.InMumble = &inmumble
+		}
+	}
+
+	if req, ok := details.requirements[slot]; ok {
+		// same default as GetSlotRequirement
+		req := *req
+		if req.Hours == 0 {
+			req.Hours = 150
+		}
+
+		slotDetails.Requirements = &req
+		slotDetails.Password = req.Password != ""
+	}
+
 	return slotDetails
 }
 
 var stateString = map[State]string{
 	Waiting:    "Waiting For Players",
+	Scheduled:  "Scheduled",
 	InProgress: "Lobby in Progress",
 	Ended:      "Lobby Ended",
 }
 
+// Decorated lobbies are cached until the lobby changes (see invalidateLobbyData),
+// so that a lobby isn't decorated again for every client asking for it and for
+// the lobby list. Entries also expire after a while, since players' names and
+// streaming statuses can change without the lobby changing.
+const lobbyDataCacheTTL = time.Minute
+
+type lobbyDataKey struct {
+	id         uint
+	playerInfo bool
+}
+
+type cachedLobbyData struct {
+	data    LobbyData
+	expires time.Time
+}
+
+type lobbyDataGeneration struct {
+	n       uint64
+	changed time.Time
+}
+
+var (
+	lobbyDataMu    sync.RWMutex
+	lobbyDataCache = make(map[lobbyDataKey]cachedLobbyData)
+	// incremented every time a lobby changes, so that data decorated
+	// before the change isn't cached after it
+	lobbyDataGen = make(map[uint]lobbyDataGeneration)
+)
+
+func init() {
+	go func() {
+		for range time.Tick(lobbyDataCacheTTL) {
+			evictLobbyData(time.Now())
+		}
+	}()
+}
+
+// evictLobbyData removes expired data from the cache, and the generations of
+// lobbies which haven't changed for a while, such as ended lobbies. Data
+// can't take that long to decorate, so no decoration started before the
+// generation was removed is still running.
+func evictLobbyData(now time.Time) {
+	lobbyDataMu.Lock()
+	defer lobbyDataMu.Unlock()
+
+	for key, cached := range lobbyDataCache {
+		if now.After(cached.expires) {
+			delete(lobbyDataCache, key)
+		}
+	}
+	for id, gen := range lobbyDataGen {
+		if now.Sub(gen.changed) > lobbyDataCacheTTL {
+			delete(lobbyDataGen, id)
+		}
+	}
+}
+
+// invalidateLobbyData removes the lobby's cached decorated data, on every
+// instance. It should be called whenever a lobby, its slots, requirements or
+// spectators change.
+func invalidateLobbyData(id uint) {
+	invalidateLocalLobbyData(id)
+	broadcaster.Notify("lobbyDataChanged", id)
+}
+
+func invalidateLocalLobbyData(id uint) {
+	lobbyDataMu.Lock()
+	lobbyDataGen[id] = lobbyDataGeneration{lobbyDataGen[id].n + 1, time.Now()}
+	delete(lobbyDataCache, lobbyDataKey{id, true})
+	delete(lobbyDataCache, lobbyDataKey{id, false})
+	lobbyDataMu.Unlock()
+}
+
+// DecorateLobbyData returns the lobby data sent to clients. If playerInfo is
+// true, slots include the players in them, and spectators are included.
+// The returned data may be shared with other callers, and must not be modified.
 func DecorateLobbyData(lobby *Lobby, playerInfo bool) LobbyData {
+	key := lobbyDataKey{lobby.ID, playerInfo}
+
+	lobbyDataMu.RLock()
+	cached, ok := lobbyDataCache[key]
+	gen := lobbyDataGen[lobby.ID]
+	lobbyDataMu.RUnlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.data
+	}
+
+	data := decorateLobbyData(lobby, playerInfo)
+
+	lobbyDataMu.Lock()
+	if lobbyDataGen[lobby.ID].n == gen.n {
+		lobbyDataCache[key] = cachedLobbyData{data, time.Now().Add(lobbyDataCacheTTL)}
+	}
+	lobbyDataMu.Unlock()
+
+	return data
+}
+
+func decorateLobbyData(lobby *Lobby, playerInfo bool) LobbyData {
+	details := loadLobbyDetails(lobby, playerInfo)
+
 	lobbyData := LobbyData{
 		ID:                lobby.ID,
 		Mode:              lobby.Mode,
-		Type:              format.FriendlyNamesMap[lobby.Type],
-		Players:           lobby.GetPlayerNumber(),
+		Type:              format.FriendlyName(lobby.Type),
+		Players:           len(details.slots),
 		Map:               lobby.MapName,
 		League:            lobby.League,
 		Mumble:            lobby.Mumble,
@@ -185,12 +369,12 @@ func DecorateLobbyData(lobby *Lobby, playerInfo bool) LobbyData {
 	classList := format.GetClasses(lobby.Type)
 
 	classes := make([]ClassDetails, len(classList))
-	lobbyData.MaxPlayers = format.NumberOfClassesMap[lobby.Type] * 2
+	lobbyData.MaxPlayers = format.NumberOfClasses(lobby.Type) * 2
 
 	for slot, className := range classList {
 		class := ClassDetails{
-			Red:   decorateSlotDetails(lobby, slot, playerInfo),
-			Blu:   decorateSlotDetails(lobby, slot+format.NumberOfClassesMap[lobby.Type], playerInfo),
+			Red:   decorateSlotDetails(details, slot, playerInfo),
+			Blu:   decorateSlotDetails(details, slot+format.NumberOfClasses(lobby.Type), playerInfo),
 			Class: className,
 		}
 
@@ -199,34 +383,33 @@ func DecorateLobbyData(lobby *Lobby, playerInfo bool) LobbyData {
 
 	lobbyData.Classes = classes
 	lobbyData.WhitelistID = lobby.Whitelist
+	lobbyData.Private = lobby.Private
+	lobbyData.RematchOf = lobby.RematchOf
+	lobbyData.ScheduledFor = lobby.ScheduledFor
+	if lobby.HasMapVote() {
+		lobbyData.MapVote = mapVoteOptions(lobby, details.mapVotes)
+	}
+	if lobby.IsSeries() {
+		lobbyData.Series = seriesData(lobby, details.seriesGames)
+	}
 
 	if !playerInfo {
 		return lobbyData
 	}
 
-	if lobby.CreatedBySteamID != "" { // == "" during tests
-		leader, _ := player.GetPlayerBySteamID(lobby.CreatedBySteamID)
-		leader.SetPlayerSummary()
-		lobbyData.Leader = *leader
+	if details.leader != nil {
+		lobbyData.Leader = *details.leader
 	}
 
 	lobbyData.CreatedAt = lobby.CreatedAt.Unix()
 	lobbyData.State = int(lobby.State)
 
-	var specIDs []uint
-	db.DB.Table("spectators_players_lobbies").Where("lobby_id = ?", lobby.ID).Pluck("player_id", &specIDs)
-
-	spectators := make([]SpecDetails, len(specIDs))
-
-	for i, spectatorID := range specIDs {
-		specPlayer, _ := player.GetPlayerByID(spectatorID)
-
-		specJs := SpecDetails{
+	spectators := make([]SpecDetails, len(details.spectators))
+	for i, specPlayer := range details.spectators {
+		spectators[i] = SpecDetails{
 			Name:    specPlayer.Alias(),
 			SteamID: specPlayer.SteamID,
 		}
-
-		spectators[i] = specJs
 	}
 
 	lobbyData.Spectators = spectators
@@ -286,10 +469,14 @@ func DecorateLobbyClosed(lobby *Lobby) LobbyEvent {
 
 func DecorateSubstitute(slot *LobbySlot) SubstituteData {
 	lobby, _ := GetLobbyByID(slot.LobbyID)
+	req, _ := lobby.GetSlotRequirement(slot.Slot)
+	return decorateSubstitute(lobby, slot, req)
+}
 
+func decorateSubstitute(lobby *Lobby, slot *LobbySlot, req *Requirement) SubstituteData {
 	substitute := SubstituteData{
 		LobbyID:       lobby.ID,
-		Format:        format.FriendlyNamesMap[lobby.Type],
+		Format:        format.FriendlyName(lobby.Type),
 		MapName:       lobby.MapName,
 		Mumble:        lobby.Mumble,
 		TwitchChannel: lobby.TwitchChannel,
@@ -297,7 +484,6 @@ func DecorateSubstitute(slot *LobbySlot) SubstituteData {
 		RegionLock:    lobby.RegionLock,
 	}
 
-	req, _ := lobby.GetSlotRequirement(slot.Slot)
 	if req != nil {
 		substitute.Password = req.Password != ""
 	}
@@ -309,14 +495,46 @@ func DecorateSubstitute(slot *LobbySlot) SubstituteData {
 	return substitute
 }
 
+// DecorateSubstituteList returns all slots in lobbies in progress that need a
+// substitute. The slots, their lobbies and requirements are loaded in 3 queries.
 func DecorateSubstituteList() []SubstituteData {
 	slots := []*LobbySlot{}
 	subList := []SubstituteData{}
 
-	db.DB.Model(&LobbySlot{}).Joins("INNER JOIN lobbies ON lobbies.id = lobby_slots.lobby_id").Where("lobby_slots.needs_sub = ? AND lobbies.state = ?", true, InProgress).Find(&slots)
+	db.DB.Model(&LobbySlot{}).Joins("INNER JOIN lobbies ON lobbies.id = lobby_slots.lobby_id").Where("lobby_slots.needs_sub = ? AND lobbies.state = ? AND lobbies.private = FALSE", true, InProgress).Find(&slots)
+	if len(slots) == 0 {
+		return subList
+	}
+
+	var lobbyIDs []uint
+	for _, slot := range slots {
+		lobbyIDs = append(lobbyIDs, slot.LobbyID)
+	}
+
+	var lobbies []*Lobby
+	db.DB.Where("id IN (?)", lobbyIDs).Find(&lobbies)
+	lobbyMap := make(map[uint]*Lobby)
+	for _, lobby := range lobbies {
+		lobbyMap[lobby.ID] = lobby
+	}
+
+	var reqs []*Requirement
+	db.DB.Where("lobby_id IN (?)", lobbyIDs).Find(&reqs)
+	type reqKey struct {
+		lobbyID uint
+		slot    int
+	}
+	reqMap := make(map[reqKey]*Requirement)
+	for _, req := range reqs {
+		reqMap[reqKey{req.LobbyID, req.Slot}] = req
+	}
 
 	for _, slot := range slots {
-		subList = append(subList, DecorateSubstitute(slot))
+		lobby, ok := lobbyMap[slot.LobbyID]
+		if !ok {
+			continue
+		}
+		subList = append(subList, decorateSubstitute(lobby, slot, reqMap[reqKey{slot.LobbyID, slot.Slot}]))
 	}
 
 	return subList
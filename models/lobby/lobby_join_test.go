@@ -0,0 +1,173 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby_test
+
+import (
+	"sync"
+	"testing"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/lobby"
+	. "github.com/TF2Stadium/Helen/models/player"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkSlotInvariants asserts that no slot in the lobby has more than one
+// player, and that no player is in more than one slot of an open lobby
+func checkSlotInvariants(t *testing.T, lobby *Lobby) []LobbySlot {
+	var slots []LobbySlot
+	require.NoError(t, db.DB.Where("lobby_id = ?", lobby.ID).Find(&slots).Error)
+
+	seenSlots := make(map[int]bool)
+	for _, slot := range slots {
+		assert.False(t, seenSlots[slot.Slot], "slot %d has more than one player", slot.Slot)
+		seenSlots[slot.Slot] = true
+
+		var count int
+		db.DB.Table("lobby_slots").
+			Joins("INNER JOIN lobbies ON lobbies.id = lobby_slots.lobby_id").
+			Where("lobby_slots.player_id = ? AND lobbies.state <> ? AND lobby_slots.needs_sub = FALSE", slot.PlayerID, Ended).
+			Count(&count)
+		assert.Equal(t, 1, count, "player %d is in %d slots", slot.PlayerID, count)
+	}
+
+	return slots
+}
+
+func createPlayers(n int) []*Player {
+	players := make([]*Player, n)
+	for i := range players {
+		players[i] = testhelpers.CreatePlayer()
+	}
+	return players
+}
+
+func TestConcurrentJoinsSameSlot(t *testing.T) {
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	players := createPlayers(20)
+
+	errs := make([]error, len(players))
+	var wg sync.WaitGroup
+	for i, p := range players {
+		wg.Add(1)
+		go func(i int, p *Player) {
+			defer wg.Done()
+			errs[i] = lobby.AddPlayer(p, 0, "")
+		}(i, p)
+	}
+	wg.Wait()
+
+	joined := 0
+	for _, err := range errs {
+		if err == nil {
+			joined++
+		} else {
+			assert.Equal(t, ErrFilled, err)
+		}
+	}
+	assert.Equal(t, 1, joined)
+
+	slots := checkSlotInvariants(t, lobby)
+	assert.Len(t, slots, 1)
+}
+
+func TestConcurrentJoinsAllSlots(t *testing.T) {
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	players := createPlayers(36)
+
+	var mu sync.Mutex
+	joined := 0
+
+	// every player tries every slot until one works
+	var wg sync.WaitGroup
+	for i, p := range players {
+		wg.Add(1)
+		go func(i int, p *Player) {
+			defer wg.Done()
+			for j := 0; j < 12; j++ {
+				if lobby.AddPlayer(p, (i+j)%12, "") == nil {
+					mu.Lock()
+					joined++
+					mu.Unlock()
+					return
+				}
+			}
+		}(i, p)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 12, joined)
+	slots := checkSlotInvariants(t, lobby)
+	assert.Len(t, slots, 12)
+}
+
+func TestConcurrentSlotSwitches(t *testing.T) {
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	players := createPlayers(10)
+
+	for i, p := range players {
+		require.NoError(t, lobby.AddPlayer(p, i, ""))
+	}
+
+	// players keep switching to other slots, most of which are filled.
+	// Failed switches must leave them in the slot they were in.
+	var wg sync.WaitGroup
+	for i, p := range players {
+		wg.Add(1)
+		go func(i int, p *Player) {
+			defer wg.Done()
+			for j := 1; j <= 24; j++ {
+				lobby.AddPlayer(p, (i+j)%12, "")
+			}
+		}(i, p)
+	}
+	wg.Wait()
+
+	slots := checkSlotInvariants(t, lobby)
+	assert.Len(t, slots, len(players))
+	for _, p := range players {
+		id, err := p.GetLobbyID(false)
+		assert.NoError(t, err)
+		assert.Equal(t, lobby.ID, id)
+	}
+}
+
+func TestConcurrentJoinsDifferentLobbies(t *testing.T) {
+	lobbies := make([]*Lobby, 4)
+	for i := range lobbies {
+		lobbies[i] = testhelpers.CreateLobby()
+	}
+	defer func() {
+		for _, lobby := range lobbies {
+			lobby.Close(false, true)
+		}
+	}()
+	players := createPlayers(8)
+
+	// every player tries to join every lobby at the same time, and has to
+	// end up in exactly one of them
+	var wg sync.WaitGroup
+	for i, p := range players {
+		for _, lobby := range lobbies {
+			wg.Add(1)
+			go func(lobby *Lobby, p *Player, slot int) {
+				defer wg.Done()
+				lobby.AddPlayer(p, slot, "")
+			}(lobby, p, i)
+		}
+	}
+	wg.Wait()
+
+	total := 0
+	for _, lobby := range lobbies {
+		total += len(checkSlotInvariants(t, lobby))
+	}
+	assert.Equal(t, len(players), total)
+}
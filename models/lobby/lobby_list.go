@@ -0,0 +1,249 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+)
+
+// Instead of broadcasting the entire lobby list every time a lobby changes,
+// an in-memory copy of the (decorated) list is kept, and only the differences
+// are broadcast to the 0_public room as lobbyListDiff messages.
+//
+// Every diff has a sequence number one higher than the previous one. Clients
+// are sent a lobbyListSnapshot message on connecting, and apply the diffs
+// after it. If a client misses a diff, it can request a new snapshot with
+// requestLobbyListSnapshot.
+
+// LobbyListDiff is the lobbyListDiff message
+type LobbyListDiff struct {
+	Seq     uint64        `json:"seq"`
+	Added   []LobbyData   `json:"added,omitempty"`
+	Updated []LobbyUpdate `json:"updated,omitempty"`
+	Removed []uint        `json:"removed,omitempty"`
+}
+
+// LobbyUpdate describes the changes made to a lobby in the list. If anything
+// other than the lobby's slots changed, Lobby contains the entire lobby,
+// which replaces the old one.
+type LobbyUpdate struct {
+	ID      uint          `json:"id"`
+	Players int           `json:"players"`
+	Slots   []SlotDetails `json:"slots,omitempty"`
+	Lobby   *LobbyData    `json:"lobby,omitempty"`
+}
+
+// LobbyListSnapshot is the entire lobby list, as of the diff with the
+// sequence number Seq
+type LobbyListSnapshot struct {
+	Seq     uint64      `json:"seq"`
+	Lobbies []LobbyData `json:"lobbies"`
+}
+
+type lobbyList struct {
+	// held while updating the list, so that diffs are computed and
+	// broadcast in order
+	updateMu sync.Mutex
+
+	mu      sync.RWMutex // protects everything below
+	loaded  bool
+	seq     uint64
+	lobbies map[uint]LobbyData
+}
+
+var waitingList = &lobbyList{lobbies: make(map[uint]LobbyData)}
+
+// load decorates all waiting lobbies, the first time the list is used.
+// updateMu has to be held.
+func (l *lobbyList) load() {
+	l.mu.RLock()
+	loaded := l.loaded
+	l.mu.RUnlock()
+	if loaded {
+		return
+	}
+
+	lobbies := make(map[uint]LobbyData)
+	for _, data := range DecorateLobbyListData(GetWaitingLobbies(), false) {
+		lobbies[data.ID] = data
+	}
+
+	l.mu.Lock()
+	l.lobbies = lobbies
+	l.loaded = true
+	l.mu.Unlock()
+}
+
+// diffSlots returns the slots in new which are different from the ones in
+// old, or false if the lobbies have different classes.
+func diffSlots(old, new LobbyData) ([]SlotDetails, bool) {
+	if len(old.Classes) != len(new.Classes) {
+		return nil, false
+	}
+
+	var slots []SlotDetails
+	for i := range new.Classes {
+		if old.Classes[i].Class != new.Classes[i].Class {
+			return nil, false
+		}
+		if !reflect.DeepEqual(old.Classes[i].Red, new.Classes[i].Red) {
+			slots = append(slots, new.Classes[i].Red)
+		}
+		if !reflect.DeepEqual(old.Classes[i].Blu, new.Classes[i].Blu) {
+			slots = append(slots, new.Classes[i].Blu)
+		}
+	}
+
+	return slots, true
+}
+
+func diffLobby(old, new LobbyData) LobbyUpdate {
+	update := LobbyUpdate{ID: new.ID, Players: new.Players}
+
+	slots, ok := diffSlots(old, new)
+	if !ok {
+		update.Lobby = &new
+		return update
+	}
+
+	full := new
+	old.Classes, new.Classes = nil, nil
+	old.Players, new.Players = 0, 0
+	if !reflect.DeepEqual(old, new) {
+		update.Lobby = &full
+		return update
+	}
+
+	update.Slots = slots
+	return update
+}
+
+// apply updates the list with the given decorated lobbies, removing the ones
+// with the given IDs, and broadcasts the difference. updateMu has to be held.
+func (l *lobbyList) apply(changed map[uint]LobbyData, removed []uint) {
+	diff := LobbyListDiff{}
+
+	l.mu.Lock()
+	for _, id := range removed {
+		if _, ok := l.lobbies[id]; ok {
+			delete(l.lobbies, id)
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	for id, data := range changed {
+		old, ok := l.lobbies[id]
+		l.lobbies[id] = data
+
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, data)
+		case !reflect.DeepEqual(old, data):
+			diff.Updated = append(diff.Updated, diffLobby(old, data))
+		}
+	}
+
+	if diff.Added == nil && diff.Updated == nil && diff.Removed == nil {
+		l.mu.Unlock()
+		return
+	}
+
+	l.seq++
+	diff.Seq = l.seq
+	l.mu.Unlock()
+
+	// every instance keeps its own list, with its own sequence numbers
+	broadcaster.SendLocalMessageToRoom("0_public", "lobbyListDiff", diff)
+}
+
+// BroadcastLobbyListChange updates the lobby in the lobby list, adding it if it's
+// now waiting for players, or removing it if it isn't, and broadcasts the
+// difference.
+func BroadcastLobbyListChange(lobby *Lobby) {
+	updateLobbyList(lobby)
+	broadcaster.Notify("lobbyListChanged", lobby.ID)
+}
+
+func updateLobbyList(lobby *Lobby) {
+	waitingList.updateMu.Lock()
+	defer waitingList.updateMu.Unlock()
+	waitingList.load()
+
+	if state := lobby.CurrentState(); (state != Waiting && state != Scheduled) || lobby.Private {
+		waitingList.apply(nil, []uint{lobby.ID})
+		return
+	}
+
+	waitingList.apply(map[uint]LobbyData{lobby.ID: DecorateLobbyData(lobby, false)}, nil)
+}
+
+// BroadcastLobbyList re-decorates all waiting lobbies, and broadcasts the
+// difference from the current lobby list
+func BroadcastLobbyList() {
+	resyncLobbyList()
+	broadcaster.Notify("lobbyListResync", nil)
+}
+
+func resyncLobbyList() {
+	waitingList.updateMu.Lock()
+	defer waitingList.updateMu.Unlock()
+	waitingList.load()
+
+	changed := make(map[uint]LobbyData)
+	for _, data := range DecorateLobbyListData(GetWaitingLobbies(), false) {
+		changed[data.ID] = data
+	}
+
+	var removed []uint
+	waitingList.mu.RLock()
+	for id := range waitingList.lobbies {
+		if _, ok := changed[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	waitingList.mu.RUnlock()
+
+	waitingList.apply(changed, removed)
+}
+
+// GetLobbyListSnapshot returns the current lobby list, newest lobbies first.
+// It doesn't block while a diff is being broadcast, so it can be called
+// (including from listeners of lobbyListDiff messages).
+func GetLobbyListSnapshot() LobbyListSnapshot {
+	waitingList.mu.RLock()
+	loaded := waitingList.loaded
+	waitingList.mu.RUnlock()
+
+	if !loaded {
+		waitingList.updateMu.Lock()
+		waitingList.load()
+		waitingList.updateMu.Unlock()
+	}
+
+	return waitingList.snapshot()
+}
+
+func (l *lobbyList) snapshot() LobbyListSnapshot {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	snapshot := LobbyListSnapshot{Seq: l.seq, Lobbies: make([]LobbyData, 0, len(l.lobbies))}
+	for _, data := range l.lobbies {
+		snapshot.Lobbies = append(snapshot.Lobbies, data)
+	}
+	sort.Sort(byNewest(snapshot.Lobbies))
+
+	return snapshot
+}
+
+type byNewest []LobbyData
+
+func (l byNewest) Len() int           { return len(l) }
+func (l byNewest) Less(i, j int) bool { return l[i].ID > l[j].ID }
+func (l byNewest) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,70 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby_test
+
+import (
+	"testing"
+
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func findInSnapshot(snapshot LobbyListSnapshot, id uint) *LobbyData {
+	for i := range snapshot.Lobbies {
+		if snapshot.Lobbies[i].ID == id {
+			return &snapshot.Lobbies[i]
+		}
+	}
+	return nil
+}
+
+func TestLobbyListChanges(t *testing.T) {
+	lobby := testhelpers.CreateLobby()
+	lobby.SetState(Waiting)
+
+	seq := GetLobbyListSnapshot().Seq
+	BroadcastLobbyListChange(lobby)
+
+	snapshot := GetLobbyListSnapshot()
+	data := findInSnapshot(snapshot, lobby.ID)
+	require.NotNil(t, data)
+	assert.Equal(t, 0, data.Players)
+	assert.True(t, snapshot.Seq > seq)
+
+	// broadcasting an unchanged lobby doesn't create a diff
+	seq = snapshot.Seq
+	BroadcastLobbyListChange(lobby)
+	assert.Equal(t, seq, GetLobbyListSnapshot().Seq)
+
+	player := testhelpers.CreatePlayer()
+	err := lobby.AddPlayer(player, 0, "")
+	require.NoError(t, err)
+
+	snapshot = GetLobbyListSnapshot()
+	data = findInSnapshot(snapshot, lobby.ID)
+	require.NotNil(t, data)
+	assert.Equal(t, 1, data.Players)
+	assert.True(t, snapshot.Seq > seq)
+
+	lobby.Close(false, false)
+	assert.Nil(t, findInSnapshot(GetLobbyListSnapshot(), lobby.ID))
+}
+
+func TestLobbyListResync(t *testing.T) {
+	lobby := testhelpers.CreateLobby()
+	lobby.SetState(Waiting)
+	defer lobby.Close(false, false)
+
+	// SetState doesn't update the lobby list, BroadcastLobbyList picks up
+	// every change
+	BroadcastLobbyList()
+	assert.NotNil(t, findInSnapshot(GetLobbyListSnapshot(), lobby.ID))
+
+	lobby.SetState(InProgress)
+	BroadcastLobbyList()
+	assert.Nil(t, findInSnapshot(GetLobbyListSnapshot(), lobby.ID))
+}
@@ -483,6 +483,20 @@ func TestSlotNeedsSubstitute(t *testing.T) {
 	assert.True(t, lobby.SlotNeedsSubstitute(1))
 }
 
+func TestSubstituteTwice(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	player := testhelpers.CreatePlayer()
+
+	require.NoError(t, lobby.AddPlayer(player, 1, ""))
+	require.NoError(t, lobby.Substitute(player))
+	require.NoError(t, lobby.Substitute(player))
+
+	db.DB.Preload("Stats").First(player, player.ID)
+	assert.Equal(t, 1, player.Stats.Substitutes)
+}
+
 func TestFillSubstitute(t *testing.T) {
 	t.Parallel()
 	lobby := testhelpers.CreateLobby()
@@ -580,3 +594,185 @@ func TestUpdateHours(t *testing.T) {
 	assert.Equal(t, logsID, lobby.LogstfID)
 	//TODO: check player.Stats for updated hours
 }
+
+func TestGetWaitingLobbiesPage(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		testhelpers.CreateLobby()
+	}
+
+	lobbies, total := GetWaitingLobbiesPage(2, 0)
+	require.Len(t, lobbies, 2)
+	assert.True(t, total >= 3)
+	assert.True(t, lobbies[0].ID > lobbies[1].ID)
+
+	next, total2 := GetWaitingLobbiesPage(2, 1)
+	require.NotEmpty(t, next)
+	assert.Equal(t, total, total2)
+	assert.Equal(t, lobbies[1].ID, next[0].ID)
+}
+
+func TestGetPlayerRecentLobbies(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+
+	var ids []uint
+	for i := 0; i < 3; i++ {
+		lobby := testhelpers.CreateLobby()
+		err := lobby.AddPlayer(player, 0, "")
+		require.NoError(t, err)
+		lobby.Close(false, true)
+		ids = append(ids, lobby.ID)
+	}
+
+	// lobbies that didn't end with the match aren't included
+	lobby := testhelpers.CreateLobby()
+	lobby.AddPlayer(player, 0, "")
+	lobby.Close(false, false)
+
+	lobbies := GetPlayerRecentLobbies(player.ID, 5, 0, 0)
+	require.Len(t, lobbies, 3)
+	assert.Equal(t, ids[2], lobbies[0].ID)
+	assert.Equal(t, ids[0], lobbies[2].ID)
+
+	lobbies = GetPlayerRecentLobbies(player.ID, 1, 0, ids[2])
+	require.Len(t, lobbies, 1)
+	assert.Equal(t, ids[1], lobbies[0].ID)
+
+	lobbies = GetPlayerRecentLobbies(player.ID, 5, ids[1], 0)
+	assert.Len(t, lobbies, 2)
+}
+
+func TestDecorateLobbyData(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	lobby.SetState(Waiting)
+	defer lobby.Close(false, false)
+
+	player := testhelpers.CreatePlayer()
+	spec := testhelpers.CreatePlayer()
+
+	data := DecorateLobbyData(lobby, true)
+	assert.Equal(t, 0, data.Players)
+	assert.False(t, data.Classes[0].Red.Filled)
+
+	// changes invalidate the cached data
+	require.NoError(t, lobby.AddPlayer(player, 0, ""))
+	require.NoError(t, lobby.AddSpectator(spec))
+	require.NoError(t, lobby.ReadyPlayer(player))
+
+	data = DecorateLobbyData(lobby, true)
+	assert.Equal(t, 1, data.Players)
+	require.True(t, data.Classes[0].Red.Filled)
+	assert.Equal(t, player.SteamID, data.Classes[0].Red.Player.SteamID)
+	assert.True(t, *data.Classes[0].Red.Ready)
+	require.Len(t, data.Spectators, 1)
+	assert.Equal(t, spec.SteamID, data.Spectators[0].SteamID)
+	assert.Equal(t, data, DecorateLobbyDataUncached(lobby, true))
+
+	// including spectators leaving without a broadcast
+	require.NoError(t, lobby.RemoveSpectator(spec, false))
+	data = DecorateLobbyData(lobby, true)
+	assert.Len(t, data.Spectators, 0)
+
+	lobby.Substitute(player)
+	data = DecorateLobbyData(lobby, false)
+	assert.False(t, data.Classes[0].Red.Filled)
+}
+
+// createFullLobby returns a waiting 6s lobby with every slot filled
+func createFullLobby(b *testing.B) *Lobby {
+	lobby := testhelpers.CreateLobby()
+	lobby.SetState(Waiting)
+
+	for i := 0; i < 12; i++ {
+		player := testhelpers.CreatePlayer()
+		if err := lobby.AddPlayer(player, i, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+	lobby.AddSpectator(testhelpers.CreatePlayer())
+
+	return lobby
+}
+
+func BenchmarkDecorateLobbyData(b *testing.B) {
+	lobby := createFullLobby(b)
+	defer lobby.Close(false, false)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		DecorateLobbyDataUncached(lobby, true)
+	}
+}
+
+func BenchmarkDecorateLobbyDataNoPlayerInfo(b *testing.B) {
+	lobby := createFullLobby(b)
+	defer lobby.Close(false, false)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		DecorateLobbyDataUncached(lobby, false)
+	}
+}
+
+func BenchmarkDecorateLobbyDataCached(b *testing.B) {
+	lobby := createFullLobby(b)
+	defer lobby.Close(false, false)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		DecorateLobbyData(lobby, true)
+	}
+}
+
+func BenchmarkDecorateSubstituteList(b *testing.B) {
+	for i := 0; i < 5; i++ {
+		lobby := createFullLobby(b)
+		lobby.SetState(InProgress)
+		defer lobby.Close(false, false)
+
+		player, _ := GetPlayerByID(lobby.GetAllSlots()[0].PlayerID)
+		lobby.Substitute(player)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		DecorateSubstituteList()
+	}
+}
+
+func TestGetSlotPlayers(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	lobby.RegionCode = "eu"
+	lobby.Save()
+	lobby.SetState(InProgress)
+
+	player := testhelpers.CreatePlayer()
+	sub := testhelpers.CreatePlayer()
+	require.NoError(t, lobby.AddPlayer(player, 0, ""))
+	require.NoError(t, lobby.AddPlayer(sub, 1, ""))
+	lobby.Substitute(sub)
+
+	find := func(steamid string) *SlotPlayer {
+		players, err := GetSlotPlayers()
+		require.NoError(t, err)
+		for i := range players {
+			if players[i].SteamID == steamid {
+				return &players[i]
+			}
+		}
+		return nil
+	}
+
+	p := find(player.SteamID)
+	require.NotNil(t, p)
+	assert.Equal(t, "eu", p.Region)
+	assert.Equal(t, format.Sixes, p.Type)
+	assert.Equal(t, InProgress, p.State)
+	// slots needing a substitute aren't counted
+	assert.Nil(t, find(sub.SteamID))
+
+	lobby.Close(false, true)
+	assert.Nil(t, find(player.SteamID))
+}
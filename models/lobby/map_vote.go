@@ -0,0 +1,190 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/models/chat"
+	"github.com/TF2Stadium/Helen/models/player"
+	"github.com/TF2Stadium/Helen/models/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxMapVoteMaps is the largest number of maps a map vote can have
+const MaxMapVoteMaps = 5
+
+var (
+	ErrNoMapVote     = errors.New("This lobby doesn't have a map vote.")
+	ErrMapVoteOver   = errors.New("The map vote is over.")
+	ErrNotInLobby    = errors.New("You aren't in this lobby.")
+	ErrNotInMapVote  = errors.New("That map isn't in the map vote.")
+	ErrMapVoteLength = fmt.Errorf("A map vote needs between 2 and %d maps.", MaxMapVoteMaps)
+)
+
+// MapVote is a player's vote for the map of a lobby with a map vote. Players
+// have one vote, and only the votes of players in the lobby's slots count.
+type MapVote struct {
+	ID       uint   `gorm:"primary_key"`
+	LobbyID  uint   `gorm:"unique_index:idx_map_vote_lobby_id_player_id"`
+	PlayerID uint   `gorm:"unique_index:idx_map_vote_lobby_id_player_id"`
+	MapName  string // map voted for
+}
+
+// MapVoteOption is a map in a lobby's map vote, with the number of votes
+// it got
+type MapVoteOption struct {
+	Map   string `json:"map"`
+	Votes int    `json:"votes"`
+}
+
+// SetMapVote makes players vote for the lobby's map, between the given maps.
+// The first map is the one the lobby is created with, and wins ties.
+func (lobby *Lobby) SetMapVote(maps []string) error {
+	if len(maps) < 2 || len(maps) > MaxMapVoteMaps {
+		return ErrMapVoteLength
+	}
+
+	lobby.MapName = maps[0]
+	lobby.Mode = getGamemode(maps[0], lobby.Type)
+	lobby.MapVoteMaps = strings.Join(maps, ",")
+	return nil
+}
+
+// HasMapVote returns true if the lobby's map is voted for
+func (lobby *Lobby) HasMapVote() bool {
+	return lobby.MapVoteMaps != ""
+}
+
+// MapVoteOptions returns the maps players can vote for
+func (lobby *Lobby) MapVoteOptions() []string {
+	if !lobby.HasMapVote() {
+		return nil
+	}
+	return strings.Split(lobby.MapVoteMaps, ",")
+}
+
+// VoteMap sets the player's vote for the lobby's map. Votes can only be
+// changed while the lobby is waiting for players.
+func (lobby *Lobby) VoteMap(p *player.Player, mapName string) error {
+	if !lobby.HasMapVote() {
+		return ErrNoMapVote
+	}
+	if lobby.CurrentState() != Waiting {
+		return ErrMapVoteOver
+	}
+	if !lobby.HasPlayer(p) {
+		return ErrNotInLobby
+	}
+
+	valid := false
+	for _, option := range lobby.MapVoteOptions() {
+		if option == mapName {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrNotInMapVote
+	}
+
+	err := db.DB.Exec(`INSERT INTO map_votes (lobby_id, player_id, map_name) VALUES (?, ?, ?)
+ON CONFLICT (lobby_id, player_id) DO UPDATE SET map_name = EXCLUDED.map_name`,
+		lobby.ID, p.ID, mapName).Error
+	if err != nil {
+		return err
+	}
+
+	lobby.OnChange(false)
+	return nil
+}
+
+// countMapVotes returns the number of votes for each map, counting only the
+// players in the lobby's slots
+func countMapVotes(lobbyID uint) map[string]int {
+	votes := make(map[string]int)
+
+	rows, err := db.DB.Table("map_votes").Select("map_votes.map_name, count(*)").
+		Joins("INNER JOIN lobby_slots ON lobby_slots.lobby_id = map_votes.lobby_id AND lobby_slots.player_id = map_votes.player_id").
+		Where("map_votes.lobby_id = ? AND lobby_slots.needs_sub = FALSE", lobbyID).
+		Group("map_votes.map_name").Rows()
+	if err != nil {
+		logrus.Error(err)
+		return votes
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mapName string
+		var count int
+		rows.Scan(&mapName, &count)
+		votes[mapName] = count
+	}
+	return votes
+}
+
+// GetMapVotes returns the maps in the lobby's map vote, with their votes
+func (lobby *Lobby) GetMapVotes() []MapVoteOption {
+	if !lobby.HasMapVote() {
+		return nil
+	}
+	return mapVoteOptions(lobby, countMapVotes(lobby.ID))
+}
+
+func mapVoteOptions(lobby *Lobby, votes map[string]int) []MapVoteOption {
+	maps := lobby.MapVoteOptions()
+	options := make([]MapVoteOption, len(maps))
+	for i, mapName := range maps {
+		options[i] = MapVoteOption{mapName, votes[mapName]}
+	}
+	return options
+}
+
+// MapVoteWinner returns the map with the most votes. Ties go to the map
+// listed first.
+func (lobby *Lobby) MapVoteWinner() string {
+	winner := MapVoteOption{Map: lobby.MapName}
+	for i, option := range lobby.GetMapVotes() {
+		if i == 0 || option.Votes > winner.Votes {
+			winner = option
+		}
+	}
+	return winner.Map
+}
+
+// ApplyMapVote changes the lobby's map to the one which won the map vote, and
+// has the server change to it. It's called once ready up begins.
+func (lobby *Lobby) ApplyMapVote() error {
+	if !lobby.HasMapVote() {
+		return nil
+	}
+
+	winner := lobby.MapVoteWinner()
+	if winner == lobby.MapName {
+		return nil
+	}
+
+	lobby.MapName = winner
+	lobby.Mode = getGamemode(winner, lobby.Type)
+	err := db.DB.Model(&Lobby{}).Where("id = ?", lobby.ID).UpdateColumns(map[string]interface{}{
+		"map_name": lobby.MapName,
+		"mode":     lobby.Mode,
+	}).Error
+	if err != nil {
+		return err
+	}
+	lobby.OnChange(true)
+
+	chat.NewBotMessage(fmt.Sprintf("%s won the map vote", winner), int(lobby.ID)).Send()
+	go func() {
+		if err := rpc.ReExecConfig(lobby.ID, true); err != nil {
+			logrus.Errorf("Couldn't change the map of lobby %d: %v", lobby.ID, err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby_test
+
+import (
+	"testing"
+
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapVote(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+
+	assert.Equal(t, ErrMapVoteLength, lobby.SetMapVote([]string{"cp_badlands"}))
+	require.NoError(t, lobby.SetMapVote([]string{"cp_badlands", "cp_process_final", "koth_product_rc8"}))
+	lobby.Save()
+	lobby.SetState(Waiting)
+
+	p1 := testhelpers.CreatePlayer()
+	p2 := testhelpers.CreatePlayer()
+	p3 := testhelpers.CreatePlayer()
+
+	assert.Equal(t, ErrNotInLobby, lobby.VoteMap(p1, "cp_process_final"))
+	require.NoError(t, lobby.AddPlayer(p1, 0, ""))
+	require.NoError(t, lobby.AddPlayer(p2, 1, ""))
+	require.NoError(t, lobby.AddPlayer(p3, 2, ""))
+
+	assert.Equal(t, ErrNotInMapVote, lobby.VoteMap(p1, "cp_granary"))
+	// no votes, the lobby's map wins
+	assert.Equal(t, "cp_badlands", lobby.MapVoteWinner())
+
+	require.NoError(t, lobby.VoteMap(p1, "koth_product_rc8"))
+	require.NoError(t, lobby.VoteMap(p2, "cp_process_final"))
+	require.NoError(t, lobby.VoteMap(p3, "cp_process_final"))
+	// players can change their vote
+	require.NoError(t, lobby.VoteMap(p3, "koth_product_rc8"))
+	// ties go to the map listed first
+	assert.Equal(t, "cp_process_final", lobby.MapVoteWinner())
+
+	require.NoError(t, lobby.VoteMap(p2, "koth_product_rc8"))
+	assert.Equal(t, []MapVoteOption{
+		{"cp_badlands", 0},
+		{"cp_process_final", 0},
+		{"koth_product_rc8", 3},
+	}, lobby.GetMapVotes())
+
+	// players who left don't count
+	require.NoError(t, lobby.RemovePlayer(p3))
+	assert.Equal(t, 2, lobby.GetMapVotes()[2].Votes)
+
+	data := DecorateLobbyData(lobby, false)
+	assert.Equal(t, lobby.GetMapVotes(), data.MapVote)
+
+	lobby.SetState(ReadyingUp)
+	assert.Equal(t, ErrMapVoteOver, lobby.VoteMap(p1, "cp_badlands"))
+
+	require.NoError(t, lobby.ApplyMapVote())
+	lobby, _ = GetLobbyByID(lobby.ID)
+	assert.Equal(t, "koth_product_rc8", lobby.MapName)
+	assert.Equal(t, "koth", lobby.Mode)
+}
+
+func TestNoMapVote(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	lobby.SetState(Waiting)
+
+	player := testhelpers.CreatePlayer()
+	require.NoError(t, lobby.AddPlayer(player, 0, ""))
+	assert.Equal(t, ErrNoMapVote, lobby.VoteMap(player, "cp_badlands"))
+	assert.NoError(t, lobby.ApplyMapVote())
+	assert.Nil(t, DecorateLobbyData(lobby, false).MapVote)
+}
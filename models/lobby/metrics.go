@@ -0,0 +1,59 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/internal/metrics"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var stateNames = map[State]string{
+	Initializing: "initializing",
+	Waiting:      "waiting",
+	ReadyingUp:   "readyingUp",
+	InProgress:   "inProgress",
+	Scheduled:    "scheduled",
+}
+
+var lobbiesDesc = prometheus.NewDesc("helen_lobbies", "Number of open lobbies, by state, format and region.",
+	[]string{"state", "format", "region"}, nil)
+
+// lobbyCollector counts open lobbies when metrics are scraped
+type lobbyCollector struct{}
+
+func init() {
+	metrics.MustRegister(lobbyCollector{})
+}
+
+func (lobbyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lobbiesDesc
+}
+
+func (lobbyCollector) Collect(ch chan<- prometheus.Metric) {
+	rows, err := db.DB.Model(&Lobby{}).Select("state, type, region_code, count(*)").
+		Where("state <> ?", Ended).Group("state, type, region_code").Rows()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var state State
+		var lobbyType format.Format
+		var region string
+		var count int
+
+		if err := rows.Scan(&state, &lobbyType, &region, &count); err != nil {
+			logrus.Error(err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(lobbiesDesc, prometheus.GaugeValue, float64(count),
+			stateNames[state], format.FriendlyName(lobbyType), region)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/models/gameserver"
+	"github.com/TF2Stadium/servemetf"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RematchTimeout is how long after its match ended a lobby can be rematched
+	RematchTimeout = 15 * time.Minute
+	// RematchPriority is how long slots in a rematch are kept for the players
+	// who had them in the previous lobby
+	RematchPriority = 2 * time.Minute
+)
+
+var (
+	ErrRematchNotEnded = errors.New("Only lobbies whose match ended can be rematched.")
+	ErrRematchExists   = errors.New("This lobby has already been rematched.")
+	ErrRematchTooLate  = errors.New("It's too late to rematch this lobby.")
+	ErrRematchServer   = errors.New("This lobby's server can't be reused, please create a new lobby.")
+	ErrServerInUse     = errors.New("A lobby is already using this server.")
+	ErrServemeReserved = errors.New("This lobby's serveme server has been reserved by someone else, please create a new lobby.")
+	ErrSlotReserved    = errors.New("This slot is reserved for the player who had it in the previous lobby.")
+)
+
+// RematchServer is the server of a lobby whose match ended, kept so that it
+// can be reused for a rematch. Lobbies' server records are deleted when they
+// close. It's deleted once the rematch is created, or after RematchTimeout.
+type RematchServer struct {
+	LobbyID   uint `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	Host           string
+	RconPassword   string
+	StoredServerID uint // if the server is a stored server, it's taken again for the rematch
+	// serveme reservations are deleted once the lobby closes, the server is
+	// reserved again for the rematch, with a new RCON password
+	Serveme bool
+}
+
+// saveRematchServer keeps the server of the lobby, which has ended with the
+// match ending
+func (lobby *Lobby) saveRematchServer() error {
+	if lobby.ServerInfo.Host == "" {
+		return nil
+	}
+
+	server := &RematchServer{
+		LobbyID: lobby.ID,
+		Host:    lobby.ServerInfo.Host,
+		Serveme: lobby.ServemeID != 0,
+	}
+	if !server.Serveme {
+		server.RconPassword = lobby.ServerInfo.RconPassword
+	}
+
+	var ids []uint
+	db.DB.Model(&gameserver.StoredServer{}).Where("address = ?", server.Host).Pluck("id", &ids)
+	if len(ids) != 0 {
+		server.StoredServerID = ids[0]
+	}
+
+	return db.DB.Create(server).Error
+}
+
+// NewRematch creates a lobby with the same settings, requirements and server
+// as the ended lobby. The players who were in the lobby's slots have
+// RematchPriority to take their slot again before others can join it.
+// The server still has to be set up.
+func (lobby *Lobby) NewRematch() (*Lobby, error) {
+	if lobby.State != Ended || !lobby.MatchEnded {
+		return nil, ErrRematchNotEnded
+	}
+
+	var count int
+	db.DB.Model(&Lobby{}).Where("rematch_of = ?", lobby.ID).Count(&count)
+	if count != 0 {
+		return nil, ErrRematchExists
+	}
+
+	server := &RematchServer{}
+	err := db.DB.Where("lobby_id = ?", lobby.ID).First(server).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrRematchServer
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Since(server.CreatedAt) > RematchTimeout {
+		return nil, ErrRematchTooLate
+	}
+
+	if HostInUse(server.Host) {
+		return nil, ErrServerInUse
+	}
+
+	// only one rematch can take the server, and its RCON password isn't
+	// kept any longer than needed
+	if db.DB.Where("lobby_id = ?", lobby.ID).Delete(&RematchServer{}).RowsAffected == 0 {
+		return nil, ErrRematchExists
+	}
+	// put back if the rematch can't be created, so that it can be tried again
+	restore := func() {
+		err := db.DB.Exec(`INSERT INTO rematch_servers (lobby_id, created_at, host, rcon_password, stored_server_id, serveme)
+VALUES (?, ?, ?, ?, ?, ?)`, server.LobbyID, server.CreatedAt, server.Host, server.RconPassword, server.StoredServerID, server.Serveme).Error
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+
+	if server.StoredServerID != 0 {
+		if _, err := gameserver.GetStoredServer(server.StoredServerID); err != nil {
+			restore()
+			return nil, ErrServerInUse
+		}
+	}
+
+	randBytes := make([]byte, 6)
+	rand.Read(randBytes)
+	info := gameserver.ServerRecord{
+		Host:           server.Host,
+		RconPassword:   server.RconPassword,
+		ServerPassword: base64.URLEncoding.EncodeToString(randBytes),
+	}
+
+	var reservationID int
+	if server.Serveme {
+		reservation, err := reserveServeme(lobby.CreatedBySteamID, server.Host)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		reservationID = reservation.ID
+		info.Host = reservation.Server.IPAndPort
+		info.RconPassword = reservation.RCON
+	}
+
+	rematch := NewLobby(lobby.MapName, lobby.Type, lobby.League, info, lobby.Whitelist, lobby.Mumble, lobby.PlayerWhitelist)
+	rematch.RematchOf = lobby.ID
+	rematch.CreatedBySteamID = lobby.CreatedBySteamID
+	rematch.RegionCode, rematch.RegionName = lobby.RegionCode, lobby.RegionName
+	rematch.RegionLock = lobby.RegionLock
+	rematch.Private = lobby.Private
+	rematch.TwitchChannel, rematch.TwitchRestriction = lobby.TwitchChannel, lobby.TwitchRestriction
+	rematch.Discord = lobby.Discord
+	rematch.DiscordRedChannel, rematch.DiscordBluChannel = lobby.DiscordRedChannel, lobby.DiscordBluChannel
+	rematch.RedTeamName, rematch.BluTeamName = lobby.RedTeamName, lobby.BluTeamName
+	if lobby.HasMapVote() {
+		rematch.SetMapVote(lobby.MapVoteOptions())
+	}
+	if lobby.IsSeries() {
+		rematch.SetSeries(lobby.GetSeriesMaps())
+	}
+	rematch.ServemeID = reservationID
+
+	if err := rematch.Save(); err != nil {
+		if server.StoredServerID != 0 {
+			gameserver.PutStoredServer(server.Host)
+		}
+		if reservationID != 0 {
+			context := helpers.GetServemeContext(info.Host)
+			if err := context.Delete(reservationID, lobby.CreatedBySteamID); err != nil {
+				logrus.Error(err)
+			}
+		}
+		restore()
+		return nil, err
+	}
+	rematch.CreateLock()
+
+	var reqs []*Requirement
+	db.DB.Where("lobby_id = ?", lobby.ID).Find(&reqs)
+	for _, req := range reqs {
+		req.ID = 0
+		req.LobbyID = rematch.ID
+		req.Save()
+	}
+
+	return rematch, nil
+}
+
+// reserveServeme reserves the serveme server with the given address again,
+// for as long as serveme allows, with a new RCON password
+func reserveServeme(steamID, host string) (servemetf.Reservation, error) {
+	context := helpers.GetServemeContext(host)
+	starts, ends, err := context.GetReservationTime(steamID)
+	if err != nil {
+		logrus.Error(err)
+		return servemetf.Reservation{}, ErrServemeReserved
+	}
+
+	servers, err := context.FindServers(starts, ends, steamID)
+	if err != nil {
+		logrus.Error(err)
+		return servemetf.Reservation{}, ErrServemeReserved
+	}
+	serverID := 0
+	for _, server := range servers.Servers {
+		if server.IPAndPort == host {
+			serverID = server.ID
+		}
+	}
+	if serverID == 0 {
+		return servemetf.Reservation{}, ErrServemeReserved
+	}
+
+	randBytes := make([]byte, 6)
+	rand.Read(randBytes)
+	rcon := base64.URLEncoding.EncodeToString(randBytes)
+
+	resp, err := context.Create(servemetf.Reservation{
+		StartsAt:    starts.Format(servemetf.TimeFormat),
+		EndsAt:      ends.Format(servemetf.TimeFormat),
+		ServerID:    serverID,
+		WhitelistID: 1,
+		RCON:        rcon,
+		Password:    "foobar",
+	}, steamID)
+	if err != nil || resp.Reservation.Errors != nil {
+		if err != nil {
+			logrus.Error(err)
+		} else {
+			logrus.Error(resp.Reservation.Errors)
+		}
+		return servemetf.Reservation{}, ErrServemeReserved
+	}
+
+	reservation := resp.Reservation
+	reservation.RCON = rcon
+	return reservation, nil
+}
+
+// purgeRematchServers deletes the servers of lobbies which can't be
+// rematched anymore
+func purgeRematchServers() error {
+	return db.DB.Where("created_at < ?", time.Now().Add(-RematchTimeout)).Delete(&RematchServer{}).Error
+}
+
+// GetRematchPlayers returns the IDs of the players who were in the lobby's
+// slots, who have priority for their slots in its rematch
+func (lobby *Lobby) GetRematchPlayers() []uint {
+	var ids []uint
+	db.DB.Model(&LobbySlot{}).Where("lobby_id = ? AND needs_sub = FALSE", lobby.ID).Pluck("player_id", &ids)
+	return ids
+}
+
+// OpenRematch lets players join the rematch, which has its server set up.
+// Slots are kept for the previous lobby's players for RematchPriority from
+// now, however long setting up the server took.
+func (lobby *Lobby) OpenRematch() {
+	lobby.RematchOpenedAt = time.Now().Unix()
+	db.DB.Model(&Lobby{}).Where("id = ?", lobby.ID).UpdateColumn("rematch_opened_at", lobby.RematchOpenedAt)
+	lobby.SetState(Waiting)
+}
+
+// checkRematchPriority returns ErrSlotReserved if the player can't take the
+// slot yet, because it's kept for the player who had it in the previous
+// lobby. Once that player is in the rematch, the slot isn't kept for them.
+func (lobby *Lobby) checkRematchPriority(tx *gorm.DB, playerID uint, slot int) error {
+	if lobby.RematchOf == 0 {
+		return nil
+	}
+	opened := lobby.CreatedAt
+	if lobby.RematchOpenedAt != 0 {
+		opened = time.Unix(lobby.RematchOpenedAt, 0)
+	}
+	if time.Since(opened) > RematchPriority {
+		return nil
+	}
+
+	var ids []uint
+	tx.Model(&LobbySlot{}).Where("lobby_id = ? AND slot = ? AND needs_sub = FALSE", lobby.RematchOf, slot).Pluck("player_id", &ids)
+	if len(ids) == 0 || ids[0] == playerID {
+		return nil
+	}
+
+	var count int
+	tx.Model(&LobbySlot{}).Where("lobby_id = ? AND player_id = ?", lobby.ID, ids[0]).Count(&count)
+	if count != 0 {
+		return nil
+	}
+	return ErrSlotReserved
+}
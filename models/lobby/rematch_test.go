@@ -0,0 +1,82 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby_test
+
+import (
+	"testing"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	"github.com/TF2Stadium/Helen/models/gameserver"
+	. "github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRematch(t *testing.T) {
+	t.Parallel()
+	server := gameserver.ServerRecord{Host: "rematch.tf2stadium.com:27015", RconPassword: "rcon"}
+	lobby := NewLobby("cp_badlands", format.Sixes, "etf2l", server, "0", false, "")
+	lobby.RedTeamName = "Rematchers"
+	lobby.Save()
+	lobby.CreateLock()
+	lobby.SetState(InProgress)
+	NewRequirement(lobby.ID, 3, 500, 10)
+
+	p1 := testhelpers.CreatePlayer()
+	p2 := testhelpers.CreatePlayer()
+	p3 := testhelpers.CreatePlayer()
+	require.NoError(t, lobby.AddPlayer(p1, 0, ""))
+	require.NoError(t, lobby.AddPlayer(p2, 1, ""))
+
+	_, err := lobby.NewRematch()
+	assert.Equal(t, ErrRematchNotEnded, err)
+
+	lobby.Close(false, true)
+	lobby, _ = GetLobbyByID(lobby.ID)
+
+	rematch, err := lobby.NewRematch()
+	require.NoError(t, err)
+	defer rematch.Close(false, false)
+
+	assert.Equal(t, lobby.ID, rematch.RematchOf)
+	assert.Equal(t, "cp_badlands", rematch.MapName)
+	assert.Equal(t, "Rematchers", rematch.RedTeamName)
+	assert.Equal(t, server.Host, rematch.ServerInfo.Host)
+	assert.True(t, rematch.HasSlotRequirement(3))
+	// the server's RCON password isn't kept once it's been reused
+	var count int
+	db.DB.Model(&RematchServer{}).Where("lobby_id = ?", lobby.ID).Count(&count)
+	assert.Zero(t, count)
+
+	_, err = lobby.NewRematch()
+	assert.Equal(t, ErrRematchExists, err)
+
+	// setting up the server can take a while, slots are kept from when the
+	// rematch opens
+	db.DB.Model(&Lobby{}).Where("id = ?", rematch.ID).UpdateColumn("created_at", time.Now().Add(-10*time.Minute))
+	rematch.OpenRematch()
+	// slots are kept for the players who had them
+	assert.Equal(t, ErrSlotReserved, rematch.AddPlayer(p3, 0, ""))
+	require.NoError(t, rematch.AddPlayer(p1, 0, ""))
+	assert.Equal(t, ErrSlotReserved, rematch.AddPlayer(p3, 1, ""))
+
+	// until they're in the rematch
+	require.NoError(t, rematch.AddPlayer(p2, 2, ""))
+	assert.NoError(t, rematch.AddPlayer(p3, 1, ""))
+}
+
+func TestRematchNoServer(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	lobby.Close(false, true)
+	lobby, _ = GetLobbyByID(lobby.ID)
+
+	// lobbies without a server address can't be rematched
+	_, err := lobby.NewRematch()
+	assert.Equal(t, ErrRematchServer, err)
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/models/chat"
+	"github.com/TF2Stadium/Helen/models/gameserver"
+	"github.com/TF2Stadium/Helen/models/job"
+	"github.com/TF2Stadium/Helen/models/player"
+	"github.com/sirupsen/logrus"
+)
+
+// Scheduled lobbies are created ahead of time. Players can sign up for their
+// slots until the lobby opens at the scheduled time, when its server is set
+// up and it starts waiting for players like other lobbies.
+
+// MaxScheduleAhead is how far in the future lobbies can be scheduled
+const MaxScheduleAhead = 7 * 24 * time.Hour
+
+// ScheduleReminders are how long before a scheduled lobby opens players who
+// signed up are reminded of it
+var ScheduleReminders = []time.Duration{time.Hour, 10 * time.Minute}
+
+var (
+	ErrScheduleInPast = errors.New("Lobbies can only be scheduled in the future.")
+	ErrScheduleTooFar = fmt.Errorf("Lobbies can't be scheduled more than %d days ahead.", int(MaxScheduleAhead.Hours()/24))
+)
+
+const jobScheduleReminder = "scheduledLobbyReminder"
+
+type reminderJob struct {
+	LobbyID uint          `json:"lobbyID"`
+	Before  time.Duration `json:"before"`
+}
+
+func init() {
+	job.Register(jobScheduleReminder, scheduleReminder)
+}
+
+// CheckSchedule returns an error if lobbies can't be scheduled to open at the
+// given time
+func CheckSchedule(at time.Time) error {
+	if !at.After(time.Now()) {
+		return ErrScheduleInPast
+	}
+	if at.Sub(time.Now()) > MaxScheduleAhead {
+		return ErrScheduleTooFar
+	}
+	return nil
+}
+
+// Schedule makes the lobby open at the given time (checked with
+// CheckSchedule), instead of when it's created. It has to be called before
+// the lobby is saved.
+func (lobby *Lobby) Schedule(at time.Time) {
+	lobby.ScheduledFor = at.Unix()
+}
+
+// IsScheduled returns true if the lobby was created to open at a later time
+func (lobby *Lobby) IsScheduled() bool {
+	return lobby.ScheduledFor != 0
+}
+
+// ScheduledTime returns the time the lobby opens
+func (lobby *Lobby) ScheduledTime() time.Time {
+	return time.Unix(lobby.ScheduledFor, 0)
+}
+
+// ServerInUse returns true if another lobby which hasn't ended is using the
+// lobby's server. Servers of scheduled lobbies aren't set up until they open,
+// so others can use them until then.
+func (lobby *Lobby) ServerInUse() bool {
+	var count int
+	db.DB.Table("lobbies").
+		Joins("INNER JOIN server_records ON server_records.id = lobbies.server_info_id").
+		Where("server_records.host = ? AND lobbies.id <> ? AND lobbies.state IN (?) AND lobbies.deleted_at IS NULL",
+			lobby.ServerInfo.Host, lobby.ID, []State{Waiting, ReadyingUp, InProgress}).
+		Count(&count)
+	return count != 0
+}
+
+// HostInUse returns true if a lobby, or a server being verified, uses the
+// server with the given address. Scheduled lobbies don't count, as their
+// servers aren't set up until they open.
+func HostInUse(host string) bool {
+	var count int
+	db.DB.Model(&gameserver.ServerRecord{}).
+		Where("host = ? AND id NOT IN (SELECT server_info_id FROM lobbies WHERE state = ? AND deleted_at IS NULL)", host, Scheduled).
+		Count(&count)
+	return count != 0
+}
+
+// EnqueueReminders enqueues the reminders sent to players who signed up for
+// the scheduled lobby
+func (lobby *Lobby) EnqueueReminders() {
+	for _, before := range ScheduleReminders {
+		delay := lobby.ScheduledTime().Add(-before).Sub(time.Now())
+		if delay < 0 {
+			continue
+		}
+
+		err := job.Enqueue(jobScheduleReminder, reminderJob{lobby.ID, before}, job.Options{
+			Key:   fmt.Sprintf("%d-%s", lobby.ID, before),
+			Delay: delay,
+		})
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+func scheduleReminder(payload json.RawMessage) error {
+	var args reminderJob
+	if err := json.Unmarshal(payload, &args); err != nil {
+		return err
+	}
+
+	lobby, err := GetLobbyByID(args.LobbyID)
+	if err != nil || lobby.State != Scheduled {
+		return nil
+	}
+
+	var players []*player.Player
+	db.DB.Model(&player.Player{}).Joins("INNER JOIN lobby_slots ON lobby_slots.player_id = players.id").
+		Where("lobby_slots.lobby_id = ?", lobby.ID).Find(&players)
+
+	minutes := int(args.Before.Minutes())
+	for _, p := range players {
+		broadcaster.SendMessage(p.SteamID, "lobbyReminder", struct {
+			ID      uint  `json:"id"`
+			OpensAt int64 `json:"opensAt"`
+			OpensIn int   `json:"opensIn"` // minutes
+		}{lobby.ID, lobby.ScheduledFor, minutes})
+	}
+
+	chat.SendNotification(fmt.Sprintf("This lobby opens in %d minutes", minutes), int(lobby.ID))
+	lobby.DiscordNotif(fmt.Sprintf("Opens in %d minutes [%d/%d signed up]", minutes, len(players), lobby.RequiredPlayers()))
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	"github.com/TF2Stadium/Helen/models/gameserver"
+	. "github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSchedule(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, ErrScheduleInPast, CheckSchedule(time.Now().Add(-time.Minute)))
+	assert.Equal(t, ErrScheduleTooFar, CheckSchedule(time.Now().Add(MaxScheduleAhead+time.Hour)))
+	assert.NoError(t, CheckSchedule(time.Now().Add(2*time.Hour)))
+}
+
+func TestScheduledLobby(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+	assert.False(t, lobby.IsScheduled())
+
+	at := time.Now().Add(2 * time.Hour)
+	lobby.Schedule(at)
+	lobby.Save()
+	lobby.SetState(Scheduled)
+
+	lobby, _ = GetLobbyByID(lobby.ID)
+	assert.True(t, lobby.IsScheduled())
+	assert.Equal(t, at.Unix(), lobby.ScheduledTime().Unix())
+
+	// players can sign up before the lobby opens
+	player := testhelpers.CreatePlayer()
+	require.NoError(t, lobby.AddPlayer(player, 0, ""))
+
+	found := false
+	for _, waiting := range GetWaitingLobbies() {
+		if waiting.ID == lobby.ID {
+			found = true
+		}
+	}
+	assert.True(t, found, "scheduled lobbies should be listed")
+
+	data := DecorateLobbyData(lobby, false)
+	assert.Equal(t, at.Unix(), data.ScheduledFor)
+}
+
+func TestScheduledLobbyServerInUse(t *testing.T) {
+	t.Parallel()
+	server := gameserver.ServerRecord{Host: "scheduled.tf2stadium.com:27015"}
+	scheduled := NewLobby("cp_badlands", format.Sixes, "etf2l", server, "0", false, "")
+	scheduled.Schedule(time.Now().Add(2 * time.Hour))
+	scheduled.Save()
+	scheduled.CreateLock()
+	scheduled.SetState(Scheduled)
+	defer scheduled.Close(false, false)
+	scheduled, _ = GetLobbyByIDServer(scheduled.ID)
+	assert.False(t, scheduled.ServerInUse())
+	// others can use the server until the lobby opens
+	assert.False(t, HostInUse(server.Host))
+
+	// a lobby was created on the server before the scheduled one opened
+	other := NewLobby("cp_process_final", format.Sixes, "etf2l", server, "0", false, "")
+	other.Save()
+	other.CreateLock()
+	other.SetState(Waiting)
+	assert.True(t, scheduled.ServerInUse())
+	assert.True(t, HostInUse(server.Host))
+
+	other.Close(false, false)
+	assert.False(t, scheduled.ServerInUse())
+}
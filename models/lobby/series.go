@@ -0,0 +1,249 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/models/chat"
+	"github.com/TF2Stadium/Helen/models/job"
+	"github.com/TF2Stadium/Helen/models/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// A series lobby plays a best-of-N series on several maps, in order, with the
+// same players and server. When a game ends, its result is recorded and the
+// server changes to the next map, until a team has won most of the games.
+
+// MaxSeriesMaps is the largest number of maps a series can have
+const MaxSeriesMaps = 5
+
+var ErrSeriesLength = fmt.Errorf("A series needs an odd number of maps, between 3 and %d.", MaxSeriesMaps)
+
+// SeriesGame is the result of a game in a series
+type SeriesGame struct {
+	ID       uint   `gorm:"primary_key" json:"-"`
+	LobbyID  uint   `gorm:"unique_index:idx_series_game_lobby_id_number" json:"-"`
+	Number   int    `gorm:"unique_index:idx_series_game_lobby_id_number" json:"number"` // 0 for the first map
+	MapName  string `json:"map"`
+	LogstfID int    `json:"logsId"`
+
+	RedScore int    `json:"redScore"`
+	BluScore int    `json:"bluScore"`
+	Winner   string `json:"winner"` // "red", "blu", or empty for a draw
+
+	EndedAt time.Time `json:"endedAt"`
+}
+
+// SeriesData is the state of a series, sent in lobby data
+type SeriesData struct {
+	Maps    []string     `json:"maps"`
+	Games   []SeriesGame `json:"games"`
+	RedWins int          `json:"redWins"`
+	BluWins int          `json:"bluWins"`
+	Winner  string       `json:"winner"` // set once the series is over, "draw" if no team won
+	Over    bool         `json:"over"`
+}
+
+// SetSeries makes the lobby play a series on the given maps, in order
+func (lobby *Lobby) SetSeries(maps []string) error {
+	if len(maps) < 3 || len(maps) > MaxSeriesMaps || len(maps)%2 == 0 {
+		return ErrSeriesLength
+	}
+
+	lobby.MapName = maps[0]
+	lobby.Mode = getGamemode(maps[0], lobby.Type)
+	lobby.SeriesMaps = strings.Join(maps, ",")
+	return nil
+}
+
+// IsSeries returns true if the lobby plays a series
+func (lobby *Lobby) IsSeries() bool {
+	return lobby.SeriesMaps != ""
+}
+
+// GetSeriesMaps returns the maps of the series, in the order they're played
+func (lobby *Lobby) GetSeriesMaps() []string {
+	if !lobby.IsSeries() {
+		return nil
+	}
+	return strings.Split(lobby.SeriesMaps, ",")
+}
+
+func getSeriesGames(lobbyID uint) []SeriesGame {
+	var games []SeriesGame
+	db.DB.Where("lobby_id = ?", lobbyID).Order("number").Find(&games)
+	return games
+}
+
+// GetSeries returns the maps and results of the series
+func (lobby *Lobby) GetSeries() *SeriesData {
+	if !lobby.IsSeries() {
+		return nil
+	}
+	return seriesData(lobby, getSeriesGames(lobby.ID))
+}
+
+func seriesData(lobby *Lobby, games []SeriesGame) *SeriesData {
+	series := &SeriesData{
+		Maps:  lobby.GetSeriesMaps(),
+		Games: games,
+	}
+	if series.Games == nil {
+		series.Games = []SeriesGame{}
+	}
+
+	for _, game := range games {
+		switch game.Winner {
+		case "red":
+			series.RedWins++
+		case "blu":
+			series.BluWins++
+		}
+	}
+
+	needed := len(series.Maps)/2 + 1
+	switch {
+	case series.RedWins >= needed:
+		series.Winner, series.Over = "red", true
+	case series.BluWins >= needed:
+		series.Winner, series.Over = "blu", true
+	case len(games) >= len(series.Maps):
+		series.Over = true
+		switch {
+		case series.RedWins > series.BluWins:
+			series.Winner = "red"
+		case series.BluWins > series.RedWins:
+			series.Winner = "blu"
+		default:
+			series.Winner = "draw"
+		}
+	}
+
+	return series
+}
+
+// logs.tf calls BLU "Blue"
+type logsTeams struct {
+	Success bool
+	Error   string
+	Teams   struct {
+		Red  struct{ Score int }
+		Blue struct{ Score int }
+	}
+}
+
+// getLogsScores returns the team scores of the game with the given logs.tf ID
+func getLogsScores(logsID int) (red, blu int, err error) {
+	resp, err := helpers.HTTPClient.Get(fmt.Sprintf("http://logs.tf/json/%d", logsID))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("logs.tf returned %s", resp.Status)
+	}
+
+	var logs logsTeams
+	if err := json.NewDecoder(resp.Body).Decode(&logs); err != nil {
+		return 0, 0, err
+	}
+	if !logs.Success {
+		return 0, 0, fmt.Errorf("logs.tf returned an error: %s", logs.Error)
+	}
+	return logs.Teams.Red.Score, logs.Teams.Blue.Score, nil
+}
+
+// fetchScores gets the scores of a game, replaced in tests
+var fetchScores = getLogsScores
+
+// EndSeriesGame records the result of the game that ended, and counts it in
+// the players' stats. If the series isn't over, the server changes to the
+// next map. Returns true once the series is over, the lobby should then be
+// closed.
+//
+// If the scores can't be fetched from logs.tf, the series is held on the
+// current map, and a job records the result once they can be.
+func (lobby *Lobby) EndSeriesGame(logsID int) (bool, error) {
+	if !lobby.IsSeries() {
+		return false, errors.New("Lobby isn't a series.")
+	}
+
+	red, blu, err := fetchScores(logsID)
+	if err != nil {
+		logrus.Errorf("Couldn't get the scores of logs.tf/%d: %v", logsID, err)
+		chat.SendNotification("Couldn't get the scores from logs.tf, the series will continue once they're available.", int(lobby.ID))
+		return false, job.Enqueue(jobSeriesScores, seriesJob{lobby.ID, logsID}, job.Options{
+			Key:   fmt.Sprintf("%d_%d", lobby.ID, logsID),
+			Delay: 30 * time.Second,
+		})
+	}
+
+	return lobby.recordSeriesGame(logsID, red, blu)
+}
+
+func (lobby *Lobby) recordSeriesGame(logsID, red, blu int) (bool, error) {
+	games := getSeriesGames(lobby.ID)
+	if seriesData(lobby, games).Over {
+		return true, nil
+	}
+
+	game := SeriesGame{
+		LobbyID:  lobby.ID,
+		Number:   len(games),
+		MapName:  lobby.MapName,
+		LogstfID: logsID,
+		RedScore: red,
+		BluScore: blu,
+		EndedAt:  time.Now(),
+	}
+	switch {
+	case game.RedScore > game.BluScore:
+		game.Winner = "red"
+	case game.BluScore > game.RedScore:
+		game.Winner = "blu"
+	}
+
+	if err := db.DB.Create(&game).Error; err != nil {
+		return false, err
+	}
+	lobby.UpdateStats()
+
+	series := seriesData(lobby, append(games, game))
+	msg := fmt.Sprintf("Game %d on %s ended %d-%d (RED-BLU).", game.Number+1, game.MapName, game.RedScore, game.BluScore)
+	if series.Over {
+		if series.Winner == "draw" {
+			msg += fmt.Sprintf(" The series is a draw (%d-%d).", series.RedWins, series.BluWins)
+		} else {
+			msg += fmt.Sprintf(" %s won the series %d-%d.", strings.ToUpper(series.Winner), series.RedWins, series.BluWins)
+		}
+		chat.SendNotification(msg, int(lobby.ID))
+		return true, nil
+	}
+
+	lobby.MapName = series.Maps[len(series.Games)]
+	lobby.Mode = getGamemode(lobby.MapName, lobby.Type)
+	err := db.DB.Model(&Lobby{}).Where("id = ?", lobby.ID).UpdateColumns(map[string]interface{}{
+		"map_name": lobby.MapName,
+		"mode":     lobby.Mode,
+	}).Error
+	if err != nil {
+		return false, err
+	}
+	lobby.OnChange(true)
+
+	chat.SendNotification(msg+fmt.Sprintf(" Next map: %s.", lobby.MapName), int(lobby.ID))
+	if err := rpc.ReExecConfig(lobby.ID, true); err != nil {
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	"github.com/TF2Stadium/Helen/models/job"
+	. "github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	scores := map[int][2]int{1: {3, 1}, 2: {0, 2}, 3: {1, 1}, 4: {5, 4}}
+	SetFetchScores(func(logsID int) (int, int, error) {
+		if logsID == 5 {
+			return 0, 0, errors.New("logs.tf is down")
+		}
+		return scores[logsID][0], scores[logsID][1], nil
+	})
+}
+
+func TestSeries(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+
+	assert.Equal(t, ErrSeriesLength, lobby.SetSeries([]string{"cp_badlands", "cp_process_final"}))
+	require.NoError(t, lobby.SetSeries([]string{"cp_badlands", "koth_product_rc8", "cp_process_final"}))
+	lobby.Save()
+	lobby.SetState(InProgress)
+
+	player := testhelpers.CreatePlayer()
+	require.NoError(t, lobby.AddPlayer(player, 0, ""))
+
+	over, err := lobby.EndSeriesGame(1)
+	require.NoError(t, err)
+	assert.False(t, over)
+	assert.Equal(t, "koth_product_rc8", lobby.MapName)
+	assert.Equal(t, "koth", lobby.Mode)
+
+	// draws don't count for either team
+	over, err = lobby.EndSeriesGame(3)
+	require.NoError(t, err)
+	assert.False(t, over)
+
+	over, err = lobby.EndSeriesGame(2)
+	require.NoError(t, err)
+	assert.True(t, over)
+
+	series := lobby.GetSeries()
+	require.Len(t, series.Games, 3)
+	game := series.Games[0]
+	assert.Equal(t, "cp_badlands", game.MapName)
+	assert.Equal(t, 1, game.LogstfID)
+	assert.Equal(t, 3, game.RedScore)
+	assert.Equal(t, 1, game.BluScore)
+	assert.Equal(t, "red", game.Winner)
+	assert.Equal(t, "", series.Games[1].Winner)
+	assert.Equal(t, "cp_process_final", series.Games[2].MapName)
+	assert.Equal(t, 1, series.RedWins)
+	assert.Equal(t, 1, series.BluWins)
+	assert.True(t, series.Over)
+	assert.Equal(t, "draw", series.Winner)
+
+	// every game is counted in the players' stats
+	db.DB.Preload("Stats").First(player, player.ID)
+	assert.Equal(t, 3, player.Stats.TotalLobbies())
+
+	data := DecorateLobbyData(lobby, false)
+	require.NotNil(t, data.Series)
+	assert.Len(t, data.Series.Games, 3)
+	assert.Equal(t, "draw", data.Series.Winner)
+}
+
+func TestSeriesDecided(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+
+	require.NoError(t, lobby.SetSeries([]string{"cp_badlands", "koth_product_rc8", "cp_process_final"}))
+	lobby.Save()
+	lobby.SetState(InProgress)
+
+	over, err := lobby.EndSeriesGame(1)
+	require.NoError(t, err)
+	assert.False(t, over)
+	// the third map isn't played once a team has won twice
+	over, err = lobby.EndSeriesGame(4)
+	require.NoError(t, err)
+	assert.True(t, over)
+
+	series := lobby.GetSeries()
+	assert.Len(t, series.Games, 2)
+	assert.Equal(t, "red", series.Winner)
+	assert.Equal(t, 2, series.RedWins)
+}
+
+func TestSeriesScoresUnavailable(t *testing.T) {
+	t.Parallel()
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+
+	require.NoError(t, lobby.SetSeries([]string{"cp_badlands", "koth_product_rc8", "cp_process_final"}))
+	lobby.Save()
+	lobby.SetState(InProgress)
+
+	// the game isn't counted as a draw, the series waits for the scores
+	over, err := lobby.EndSeriesGame(5)
+	require.NoError(t, err)
+	assert.False(t, over)
+	assert.Empty(t, lobby.GetSeries().Games)
+	assert.Equal(t, "cp_badlands", lobby.MapName)
+
+	var count int
+	db.DB.Model(&job.Job{}).Where("kind = ? AND unique_key = ?", "seriesScores", fmt.Sprintf("%d_5", lobby.ID)).Count(&count)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	"errors"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/jinzhu/gorm"
+)
+
+var ErrSlotHeld = errors.New("This slot is held for a party member of another player.")
+
+// SlotHold keeps a slot for a player for a short while, so that other players
+// can't take it. Party members get held slots when their leader joins a lobby.
+type SlotHold struct {
+	ID        uint `gorm:"primary_key"`
+	LobbyID   uint `gorm:"index"`
+	Slot      int
+	PlayerID  uint
+	ExpiresAt time.Time
+}
+
+// HoldSlots holds the slots for the players (by player ID) for d
+func (lobby *Lobby) HoldSlots(slots map[uint]int, d time.Duration) error {
+	expiresAt := time.Now().Add(d)
+
+	tx := db.DB.Begin()
+	for playerID, slot := range slots {
+		hold := &SlotHold{LobbyID: lobby.ID, Slot: slot, PlayerID: playerID, ExpiresAt: expiresAt}
+		if err := tx.Create(hold).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	lobby.OnChange(true)
+	return nil
+}
+
+// purgeSlotHolds deletes the holds which have expired
+func purgeSlotHolds() error {
+	return db.DB.Where("expires_at < ?", time.Now()).Delete(&SlotHold{}).Error
+}
+
+// GetHeldSlots returns the slots which are currently held, and the IDs of the
+// players they're held for
+func (lobby *Lobby) GetHeldSlots() map[int]uint {
+	var holds []SlotHold
+	db.DB.Where("lobby_id = ? AND expires_at > ?", lobby.ID, time.Now()).Find(&holds)
+
+	slots := make(map[int]uint)
+	for _, hold := range holds {
+		slots[hold.Slot] = hold.PlayerID
+	}
+	return slots
+}
+
+// checkSlotHold returns ErrSlotHeld if the slot is held for another player.
+// Once that player is in the lobby, the slot isn't held for them anymore.
+func (lobby *Lobby) checkSlotHold(tx *gorm.DB, playerID uint, slot int) error {
+	var ids []uint
+	tx.Model(&SlotHold{}).Where("lobby_id = ? AND slot = ? AND expires_at > ?", lobby.ID, slot, time.Now()).Pluck("player_id", &ids)
+	if len(ids) == 0 || ids[0] == playerID {
+		return nil
+	}
+
+	var count int
+	tx.Model(&LobbySlot{}).Where("lobby_id = ? AND player_id = ?", lobby.ID, ids[0]).Count(&count)
+	if count != 0 {
+		return nil
+	}
+	return ErrSlotHeld
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby
+
+import (
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+)
+
+// SlotPlayer is a player in a slot of an open lobby
+type SlotPlayer struct {
+	SteamID string
+	Region  string // region of the lobby
+	Type    format.Format
+	State   State
+}
+
+// GetSlotPlayers returns the players in the slots of every open lobby.
+// Slots which need a substitute aren't included.
+func GetSlotPlayers() ([]SlotPlayer, error) {
+	var players []SlotPlayer
+
+	rows, err := db.DB.Table("lobby_slots").
+		Select("players.steam_id, lobbies.region_code, lobbies.type, lobbies.state").
+		Joins("INNER JOIN lobbies ON lobbies.id = lobby_slots.lobby_id").
+		Joins("INNER JOIN players ON players.id = lobby_slots.player_id").
+		Where("lobbies.state <> ? AND lobbies.deleted_at IS NULL AND lobby_slots.needs_sub = FALSE", Ended).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p SlotPlayer
+		if err := rows.Scan(&p.SteamID, &p.Region, &p.Type, &p.State); err != nil {
+			return nil, err
+		}
+		players = append(players, p)
+	}
+	return players, rows.Err()
+}
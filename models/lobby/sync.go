@@ -1,11 +1,18 @@
 package lobby
 
 import (
+	"fmt"
 	"sync"
 
+	"github.com/TF2Stadium/Helen/config"
 	db "github.com/TF2Stadium/Helen/database"
+	"github.com/jinzhu/gorm"
 )
 
+// first key of the advisory locks taken on lobbies, so that they don't
+// collide with any other advisory locks
+const advisoryLockClass = 1
+
 var (
 	mu         = new(sync.RWMutex)
 	lobbyLocks = make(map[uint]*sync.Mutex)
@@ -13,11 +20,18 @@ var (
 
 //Lock aquires the lock for the given lobby.
 //Be careful while using Lock outside of models,
-//improper usage could result in deadlocks
+//improper usage could result in deadlocks.
+//The lock only excludes other goroutines on this instance, changes to the
+//lobby's slots are made in transactions taking the lobby's advisory lock
+//(see transaction), which other instances take too.
 func (lobby *Lobby) Lock() {
 	mu.RLock()
 	lock, ok := lobbyLocks[lobby.ID]
 	mu.RUnlock()
+	if !ok && config.Constants.Cluster {
+		// the lobby might have been created by another instance
+		lock, ok = lobby.getOrCreateLock(), true
+	}
 	if ok {
 		lock.Lock()
 	}
@@ -33,6 +47,66 @@ func (lobby *Lobby) Unlock() {
 	}
 }
 
+// lockTx takes the lobby's postgres advisory lock in the transaction tx, when
+// running multiple instances. The lock is released when tx is committed or
+// rolled back, so it doesn't keep a connection any longer than tx does.
+func (lobby *Lobby) lockTx(tx *gorm.DB) error {
+	if !config.Constants.Cluster {
+		return nil
+	}
+
+	err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", advisoryLockClass, int32(lobby.ID)).Error
+	if err != nil {
+		return fmt.Errorf("Couldn't lock lobby %d: %v", lobby.ID, err)
+	}
+	return nil
+}
+
+// transaction runs f in a transaction holding the lobby's advisory lock,
+// committing it if f doesn't return an error.
+func (lobby *Lobby) transaction(f func(tx *gorm.DB) error) error {
+	tx := db.DB.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	if err := lobby.lockTx(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// updateSlots runs f in a transaction holding the lobby's advisory lock and
+// its row lock, the same locks joinSlot takes, so that changes to the lobby's
+// slots can't interleave with players joining it, on any instance.
+func (lobby *Lobby) updateSlots(f func(tx *gorm.DB) error) error {
+	return lobby.transaction(func(tx *gorm.DB) error {
+		err := tx.Set("gorm:query_option", "FOR UPDATE").First(&Lobby{}, lobby.ID).Error
+		if err != nil {
+			return ErrLobbyNotFound
+		}
+		return f(tx)
+	})
+}
+
+func (lobby *Lobby) getOrCreateLock() *sync.Mutex {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lock, ok := lobbyLocks[lobby.ID]
+	if !ok {
+		lock = new(sync.Mutex)
+		lobbyLocks[lobby.ID] = lock
+	}
+	return lock
+}
+
 //CreateLock creates a lock for lobby
 func (lobby *Lobby) CreateLock() {
 	mu.Lock()
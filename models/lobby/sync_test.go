@@ -0,0 +1,65 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobby_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TF2Stadium/Helen/config"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tryAdvisoryLock reports whether the lobby's advisory lock can be taken from
+// another connection, like another instance would
+func tryAdvisoryLock(t *testing.T, id uint) bool {
+	tx, err := db.DB.DB().Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	var locked bool
+	err = tx.QueryRow("SELECT pg_try_advisory_xact_lock(1, $1)", id).Scan(&locked)
+	require.NoError(t, err)
+	return locked
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	config.Constants.Cluster = true
+	defer func() { config.Constants.Cluster = false }()
+
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+
+	// the lock is only held by the transaction
+	err := lobby.Transaction(func(*gorm.DB) error {
+		assert.False(t, tryAdvisoryLock(t, lobby.ID))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, tryAdvisoryLock(t, lobby.ID))
+
+	// and released when it's rolled back
+	errRollback := errors.New("rollback")
+	err = lobby.Transaction(func(*gorm.DB) error {
+		return errRollback
+	})
+	assert.Equal(t, errRollback, err)
+	assert.True(t, tryAdvisoryLock(t, lobby.ID))
+}
+
+func TestLockWithoutCluster(t *testing.T) {
+	lobby := testhelpers.CreateLobby()
+	defer lobby.Close(false, true)
+
+	err := lobby.Transaction(func(*gorm.DB) error {
+		assert.True(t, tryAdvisoryLock(t, lobby.ID))
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobbySettings
+
+import (
+	"fmt"
+)
+
+// Document is the lobby settings, as stored in the database and in
+// assets/lobbySettingsData.json
+type Document struct {
+	Formats    []FormatData    `json:"formats"`
+	Maps       []MapData       `json:"maps"`
+	Leagues    []LeagueData    `json:"leagues"`
+	Whitelists []WhitelistData `json:"whitelists"`
+}
+
+type FormatData struct {
+	Name       string `json:"name"`
+	PrettyName string `json:"prettyName"`
+	Important  bool   `json:"important,omitempty"`
+
+	ID              *int              `json:"id"`
+	Type            string            `json:"type"`
+	FriendlyName    string            `json:"friendlyName,omitempty"`
+	Classes         []string          `json:"classes"`
+	Stats           map[string]string `json:"stats,omitempty"`
+	PlayedStat      string            `json:"playedStat,omitempty"`
+	MaxSubs         int               `json:"maxSubs,omitempty"`
+	NotifyThreshold int               `json:"notifyThreshold,omitempty"`
+	Gamemodes       map[string]string `json:"gamemodes,omitempty"`
+}
+
+type MapData struct {
+	Name    string         `json:"name"`
+	Formats map[string]int `json:"formats"`
+}
+
+type LeagueData struct {
+	Name         string            `json:"name"`
+	PrettyName   string            `json:"prettyName"`
+	Descriptions map[string]string `json:"descriptions"`
+	Formats      map[string]bool   `json:"formats"`
+}
+
+type WhitelistData struct {
+	ID         int    `json:"id"`
+	PrettyName string `json:"prettyName"`
+	League     string `json:"league"`
+	Format     string `json:"format"`
+}
+
+// PutFormat adds f, or replaces the format with the same name
+func (d *Document) PutFormat(f FormatData) {
+	for i := range d.Formats {
+		if d.Formats[i].Name == f.Name {
+			d.Formats[i] = f
+			return
+		}
+	}
+	d.Formats = append(d.Formats, f)
+}
+
+// DeleteFormat deletes the format with the given name
+func (d *Document) DeleteFormat(name string) error {
+	for i := range d.Formats {
+		if d.Formats[i].Name == name {
+			d.Formats = append(d.Formats[:i], d.Formats[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Format %q doesn't exist", name)
+}
+
+// PutMap adds m, or replaces the map with the same name
+func (d *Document) PutMap(m MapData) {
+	for i := range d.Maps {
+		if d.Maps[i].Name == m.Name {
+			d.Maps[i] = m
+			return
+		}
+	}
+	d.Maps = append(d.Maps, m)
+}
+
+// DeleteMap deletes the map with the given name
+func (d *Document) DeleteMap(name string) error {
+	for i := range d.Maps {
+		if d.Maps[i].Name == name {
+			d.Maps = append(d.Maps[:i], d.Maps[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Map %q doesn't exist", name)
+}
+
+// PutLeague adds l, or replaces the league with the same name
+func (d *Document) PutLeague(l LeagueData) {
+	for i := range d.Leagues {
+		if d.Leagues[i].Name == l.Name {
+			d.Leagues[i] = l
+			return
+		}
+	}
+	d.Leagues = append(d.Leagues, l)
+}
+
+// DeleteLeague deletes the league with the given name
+func (d *Document) DeleteLeague(name string) error {
+	for i := range d.Leagues {
+		if d.Leagues[i].Name == name {
+			d.Leagues = append(d.Leagues[:i], d.Leagues[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("League %q doesn't exist", name)
+}
+
+// PutWhitelist adds w, or replaces the whitelist with the same ID
+func (d *Document) PutWhitelist(w WhitelistData) {
+	for i := range d.Whitelists {
+		if d.Whitelists[i].ID == w.ID {
+			d.Whitelists[i] = w
+			return
+		}
+	}
+	d.Whitelists = append(d.Whitelists, w)
+}
+
+// DeleteWhitelist deletes the whitelist with the given ID
+func (d *Document) DeleteWhitelist(id int) error {
+	for i := range d.Whitelists {
+		if d.Whitelists[i].ID == id {
+			d.Whitelists = append(d.Whitelists[:i], d.Whitelists[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Whitelist %d doesn't exist", id)
+}
+
+// Merge adds the formats, maps, leagues and whitelists in defaults which
+// aren't in d, and the formats maps and leagues are listed for in defaults but
+// not in d. Nothing in d is changed or removed.
+func (d *Document) Merge(defaults *Document) {
+	formats := make(map[string]bool)
+	for _, f := range d.Formats {
+		formats[f.Name] = true
+	}
+	for _, f := range defaults.Formats {
+		if !formats[f.Name] {
+			d.Formats = append(d.Formats, f)
+		}
+	}
+
+	maps := make(map[string]int)
+	for i, m := range d.Maps {
+		maps[m.Name] = i
+	}
+	for _, m := range defaults.Maps {
+		i, ok := maps[m.Name]
+		if !ok {
+			d.Maps = append(d.Maps, m)
+			continue
+		}
+		if d.Maps[i].Formats == nil {
+			d.Maps[i].Formats = make(map[string]int)
+		}
+		for format, n := range m.Formats {
+			if _, ok := d.Maps[i].Formats[format]; !ok {
+				d.Maps[i].Formats[format] = n
+			}
+		}
+	}
+
+	leagues := make(map[string]int)
+	for i, l := range d.Leagues {
+		leagues[l.Name] = i
+	}
+	for _, l := range defaults.Leagues {
+		i, ok := leagues[l.Name]
+		if !ok {
+			d.Leagues = append(d.Leagues, l)
+			continue
+		}
+		if d.Leagues[i].Formats == nil {
+			d.Leagues[i].Formats = make(map[string]bool)
+		}
+		for format, used := range l.Formats {
+			if _, ok := d.Leagues[i].Formats[format]; !ok {
+				d.Leagues[i].Formats[format] = used
+			}
+		}
+	}
+
+	whitelists := make(map[int]bool)
+	for _, w := range d.Whitelists {
+		whitelists[w.ID] = true
+	}
+	for _, w := range defaults.Whitelists {
+		if !whitelists[w.ID] {
+			d.Whitelists = append(d.Whitelists, w)
+		}
+	}
+}
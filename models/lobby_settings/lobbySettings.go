@@ -8,8 +8,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/TF2Stadium/Helen/assets"
+	lobbyformat "github.com/TF2Stadium/Helen/models/lobby/format"
 	"github.com/bitly/go-simplejson"
 )
 
@@ -18,6 +20,7 @@ type LobbyFormat struct {
 	Name       string
 	PrettyName string
 	Important  bool
+	Info       *lobbyformat.Info
 }
 
 type LobbyMapFormat struct {
@@ -78,19 +81,35 @@ type LobbyWhitelist struct {
 	Format     *LobbyFormat
 }
 
-var LobbyFormats []LobbyFormat
-var lobbyFormatFromName map[string]int
+// The settings are replaced as a whole when they're reloaded, and never
+// changed in place, so pointers returned by the getters stay valid.
+var (
+	mu sync.RWMutex
 
-var LobbyMaps []LobbyMap
-var lobbyMapFromName map[string]int
+	LobbyFormats        []LobbyFormat
+	lobbyFormatFromName map[string]int
 
-var LobbyLeagues []LobbyLeague
-var lobbyLeagueFromName map[string]int
+	LobbyMaps        []LobbyMap
+	lobbyMapFromName map[string]int
 
-var LobbyWhitelists []LobbyWhitelist
-var lobbyWhitelistFromID map[int]int
+	LobbyLeagues        []LobbyLeague
+	lobbyLeagueFromName map[string]int
+
+	LobbyWhitelists      []LobbyWhitelist
+	lobbyWhitelistFromID map[int]int
+)
+
+// Loaded returns true if the lobby settings have been loaded
+func Loaded() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(LobbyFormats) != 0
+}
 
 func GetLobbyFormat(formatName string) (*LobbyFormat, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	if format, ok := lobbyFormatFromName[formatName]; ok {
 		return &LobbyFormats[format], true
 	}
@@ -98,6 +117,9 @@ func GetLobbyFormat(formatName string) (*LobbyFormat, bool) {
 }
 
 func GetLobbyMap(mapName string) (*LobbyMap, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	if amap, ok := lobbyMapFromName[mapName]; ok {
 		return &LobbyMaps[amap], true
 	}
@@ -105,6 +127,9 @@ func GetLobbyMap(mapName string) (*LobbyMap, bool) {
 }
 
 func GetLobbyLeague(leagueName string) (*LobbyLeague, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	if league, ok := lobbyLeagueFromName[leagueName]; ok {
 		return &LobbyLeagues[league], true
 	}
@@ -112,6 +137,9 @@ func GetLobbyLeague(leagueName string) (*LobbyLeague, bool) {
 }
 
 func GetLobbyWhitelist(whitelistId int) (*LobbyWhitelist, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	if whitelist, ok := lobbyWhitelistFromID[whitelistId]; ok {
 		return &LobbyWhitelists[whitelist], true
 	}
@@ -123,75 +151,121 @@ func LoadLobbySettingsFromFile(fileName string) error {
 	return LoadLobbySettings(data)
 }
 
+// LoadLobbySettings replaces the lobby settings with the ones in data. If
+// data isn't valid, the current settings are kept.
 func LoadLobbySettings(data []byte) error {
-	var args struct {
-		Formats []struct {
-			Name       string `json:"name"`
-			PrettyName string `json:"prettyName"`
-			Important  bool   `json:"important"`
-		} `json:"formats"`
-		Maps []struct {
-			Name    string         `json:"name"`
-			Formats map[string]int `json:"formats"`
-		} `json:"maps"`
-		Leagues []struct {
-			Name         string            `json:"name"`
-			PrettyName   string            `json:"prettyName"`
-			Descriptions map[string]string `json:"descriptions"`
-			Formats      map[string]bool   `json:"formats"`
-		} `json:"leagues"`
-		Whitelists []struct {
-			ID         int    `json:"id"`
-			PrettyName string `json:"prettyName"`
-			League     string `json:"league"`
-			Format     string `json:"format"`
-		} `json:"whitelists"`
-	}
+	var doc Document
 
-	err := json.Unmarshal(data, &args)
+	err := json.Unmarshal(data, &doc)
 	if err != nil {
 		return err
 	}
 
+	return apply(&doc)
+}
+
+// settings is a complete set of lobby settings, built from a Document
+type settings struct {
+	formats        []LobbyFormat
+	formatFromName map[string]int
+
+	maps        []LobbyMap
+	mapFromName map[string]int
+
+	leagues        []LobbyLeague
+	leagueFromName map[string]int
+
+	whitelists      []LobbyWhitelist
+	whitelistFromID map[int]int
+
+	infos []lobbyformat.Info
+}
+
+func (s *settings) getFormat(name string) (*LobbyFormat, bool) {
+	if format, ok := s.formatFromName[name]; ok {
+		return &s.formats[format], true
+	}
+	return nil, false
+}
+
+// build checks doc, and builds the settings from it
+func build(doc *Document) (*settings, error) {
+	s := &settings{}
+
 	// formats
-	LobbyFormats = make([]LobbyFormat, len(args.Formats))
-	lobbyFormatFromName = make(map[string]int)
-	for i, format := range args.Formats {
-		LobbyFormats[i] = LobbyFormat{
+	s.infos = make([]lobbyformat.Info, len(doc.Formats))
+	s.formats = make([]LobbyFormat, len(doc.Formats))
+	s.formatFromName = make(map[string]int)
+	for i, format := range doc.Formats {
+		if format.ID == nil {
+			return nil, fmt.Errorf("Format %q has no id", format.Name)
+		}
+		if _, ok := s.formatFromName[format.Name]; ok {
+			return nil, fmt.Errorf("Format %q exists twice", format.Name)
+		}
+
+		s.infos[i] = lobbyformat.Info{
+			ID:              lobbyformat.Format(*format.ID),
+			Name:            format.Name,
+			Type:            format.Type,
+			FriendlyName:    format.FriendlyName,
+			Classes:         format.Classes,
+			Stats:           format.Stats,
+			PlayedStat:      format.PlayedStat,
+			MaxSubs:         format.MaxSubs,
+			NotifyThreshold: format.NotifyThreshold,
+			Gamemodes:       format.Gamemodes,
+		}
+		if s.infos[i].FriendlyName == "" {
+			s.infos[i].FriendlyName = format.Type
+		}
+		s.formats[i] = LobbyFormat{
 			Name:       format.Name,
 			PrettyName: format.PrettyName,
 			Important:  format.Important,
+			Info:       &s.infos[i],
 		}
-		lobbyFormatFromName[format.Name] = i
+		s.formatFromName[format.Name] = i
+	}
+	if err := lobbyformat.Validate(s.infos); err != nil {
+		return nil, err
 	}
 
 	// maps
-	LobbyMaps = make([]LobbyMap, len(args.Maps))
-	lobbyMapFromName = make(map[string]int)
-	for i, amap := range args.Maps {
+	s.maps = make([]LobbyMap, len(doc.Maps))
+	s.mapFromName = make(map[string]int)
+	for i, amap := range doc.Maps {
+		if _, ok := s.mapFromName[amap.Name]; ok {
+			return nil, fmt.Errorf("Map %q exists twice", amap.Name)
+		}
+
 		lobbyMap := LobbyMap{
 			Name:    amap.Name,
 			Formats: make([]*LobbyMapFormat, 0, len(amap.Formats)),
 		}
 		for name, importance := range amap.Formats {
-			if lobbyFormat, ok := GetLobbyFormat(name); ok {
+			if lobbyFormat, ok := s.getFormat(name); ok {
 				lobbyMap.Formats = append(lobbyMap.Formats, &LobbyMapFormat{
 					Format:     lobbyFormat,
 					Importance: importance,
 				})
 			} else {
-				return errors.New(fmt.Sprintf("Referenced a non existing format %q", name))
+				return nil, errors.New(fmt.Sprintf("Referenced a non existing format %q", name))
 			}
 		}
 
-		LobbyMaps[i] = lobbyMap
-		lobbyMapFromName[amap.Name] = i
+		s.maps[i] = lobbyMap
+		s.mapFromName[amap.Name] = i
 	}
 
 	// leagues
-	LobbyLeagues = make([]LobbyLeague, len(args.Leagues))
-	lobbyLeagueFromName = make(map[string]int)
-	for i, league := range args.Leagues {
+	s.leagues = make([]LobbyLeague, len(doc.Leagues))
+	s.leagueFromName = make(map[string]int)
+	for i, league := range doc.Leagues {
+		if _, ok := s.leagueFromName[league.Name]; ok {
+			return nil, fmt.Errorf("League %q exists twice", league.Name)
+		}
+
 		lobbyLeague := LobbyLeague{
 			Name:         league.Name,
 			PrettyName:   league.PrettyName,
@@ -206,48 +280,75 @@ func LoadLobbySettings(data []byte) error {
 			lobbyLeague.Descriptions = append(lobbyLeague.Descriptions, lobbyLeagueDescription)
 		}
 		for name, used := range league.Formats {
-			if lobbyFormat, ok := GetLobbyFormat(name); ok {
+			if lobbyFormat, ok := s.getFormat(name); ok {
 				lobbyLeagueFormat := &LobbyLeagueFormat{
 					Format: lobbyFormat,
 					Used:   used,
 				}
 				lobbyLeague.Formats = append(lobbyLeague.Formats, lobbyLeagueFormat)
 			} else {
-				return errors.New(fmt.Sprintf("Referenced a non existing format %q", name))
+				return nil, errors.New(fmt.Sprintf("Referenced a non existing format %q", name))
 			}
 		}
 
-		LobbyLeagues[i] = lobbyLeague
-		lobbyLeagueFromName[league.Name] = i
+		s.leagues[i] = lobbyLeague
+		s.leagueFromName[league.Name] = i
 	}
 
 	// whitelists
-	LobbyWhitelists = make([]LobbyWhitelist, len(args.Whitelists))
-	lobbyWhitelistFromID = make(map[int]int)
-	for i, whitelist := range args.Whitelists {
-		if lobbyLeague, ok := GetLobbyLeague(whitelist.League); ok {
-			if lobbyFormat, ok := GetLobbyFormat(whitelist.Format); ok {
-				lobbyWhitelist := LobbyWhitelist{
-					ID:         whitelist.ID,
-					PrettyName: whitelist.PrettyName,
-					League:     lobbyLeague,
-					Format:     lobbyFormat,
-				}
+	s.whitelists = make([]LobbyWhitelist, len(doc.Whitelists))
+	s.whitelistFromID = make(map[int]int)
+	for i, whitelist := range doc.Whitelists {
+		if _, ok := s.whitelistFromID[whitelist.ID]; ok {
+			return nil, fmt.Errorf("Whitelist %d exists twice", whitelist.ID)
+		}
 
-				LobbyWhitelists[i] = lobbyWhitelist
-				lobbyWhitelistFromID[whitelist.ID] = i
-			} else {
-				return errors.New(fmt.Sprintf("Referenced a non existing format %q", whitelist.Format))
-			}
-		} else {
-			return errors.New(fmt.Sprintf("Referenced a non existing league %q", whitelist.League))
+		league, ok := s.leagueFromName[whitelist.League]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Referenced a non existing league %q", whitelist.League))
+		}
+		lobbyFormat, ok := s.getFormat(whitelist.Format)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Referenced a non existing format %q", whitelist.Format))
 		}
+
+		s.whitelists[i] = LobbyWhitelist{
+			ID:         whitelist.ID,
+			PrettyName: whitelist.PrettyName,
+			League:     &s.leagues[league],
+			Format:     lobbyFormat,
+		}
+		s.whitelistFromID[whitelist.ID] = i
+	}
+
+	return s, nil
+}
+
+// apply replaces the current settings with the ones built from doc
+func apply(doc *Document) error {
+	s, err := build(doc)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := lobbyformat.Set(s.infos); err != nil {
+		return err
 	}
 
+	LobbyFormats, lobbyFormatFromName = s.formats, s.formatFromName
+	LobbyMaps, lobbyMapFromName = s.maps, s.mapFromName
+	LobbyLeagues, lobbyLeagueFromName = s.leagues, s.leagueFromName
+	LobbyWhitelists, lobbyWhitelistFromID = s.whitelists, s.whitelistFromID
 	return nil
 }
 
 func LobbySettingsToJSON() *simplejson.Json {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	j := simplejson.New()
 
 	// formats
@@ -260,6 +361,8 @@ func LobbySettingsToJSON() *simplejson.Json {
 			f.Set("value", format.Name)
 			f.Set("title", format.PrettyName)
 			f.Set("important", format.Important)
+			f.Set("type", format.Info.Type)
+			f.Set("classes", format.Info.Classes)
 
 			formatList[i] = f
 		}
@@ -3,6 +3,7 @@ package lobbySettings_test
 import (
 	"testing"
 
+	lobbyformat "github.com/TF2Stadium/Helen/models/lobby/format"
 	. "github.com/TF2Stadium/Helen/models/lobby_settings"
 	"github.com/stretchr/testify/assert"
 )
@@ -13,14 +14,26 @@ var testSettingsData = []byte(`
 		{
 			"name": "sixes",
 			"prettyName": "6v6",
-			"important": true
+			"important": true,
+			"id": 0,
+			"type": "6s",
+			"classes": ["scout1", "scout2", "roamer", "pocket", "demoman", "medic"]
 		},{
 			"name": "highlander",
 			"prettyName": "Highlander",
-			"important": true
+			"important": true,
+			"id": 1,
+			"type": "highlander",
+			"friendlyName": "Highlander",
+			"classes": ["scout", "soldier", "pyro", "demoman", "heavy", "engineer", "medic", "sniper", "spy"]
 		},{
 			"name": "fours",
-			"prettyName": "4v4"
+			"prettyName": "4v4",
+			"id": 2,
+			"type": "4v4",
+			"classes": ["scout", "soldier", "demoman", "medic"],
+			"stats": {"scout": "scout", "soldier": "soldier", "demoman": "demoman", "medic": "medic"},
+			"maxSubs": 2
 		}
 	],
 	"maps": [
@@ -82,6 +95,15 @@ func TestSettingsLoad(t *testing.T) {
 			if format, ok := GetLobbyFormat("fours"); assert.True(ok) {
 				assert.Equal("4v4", format.PrettyName)
 				assert.Equal(false, format.Important)
+				assert.Equal(lobbyformat.Fours, format.Info.ID)
+				assert.Equal("4v4", format.Info.FriendlyName)
+				assert.Equal(2, format.Info.MaxSubs)
+			}
+
+			if info, ok := lobbyformat.FromType("highlander"); assert.True(ok) {
+				assert.Equal(lobbyformat.Highlander, info.ID)
+				assert.Equal("Highlander", info.FriendlyName)
+				assert.Equal(9, lobbyformat.NumberOfClasses(info.ID))
 			}
 		}
 
@@ -116,3 +138,67 @@ func TestSettingsLoad(t *testing.T) {
 		assert.NoError(err)
 	}
 }
+
+func TestSettingsInvalidFormat(t *testing.T) {
+	formats := []string{
+		`{"name": "sixes", "type": "6s", "classes": ["scout"]}`,
+		`{"name": "sixes", "id": 0, "classes": ["scout"]}`,
+		`{"name": "sixes", "id": 0, "type": "6s", "classes": ["scout", "scout"]}`,
+		`{"name": "sixes", "id": 0, "type": "6s", "classes": ["scout"], "stats": {"medic": "medic"}}`,
+	}
+
+	for _, format := range formats {
+		err := LoadLobbySettings([]byte(`{"formats": [` + format + `]}`))
+		assert.Error(t, err, format)
+	}
+}
+
+func TestBundledSettings(t *testing.T) {
+	err := LoadLobbySettingsFromFile("assets/lobbySettingsData.json")
+	assert.NoError(t, err)
+
+	for _, name := range []string{"6s", "highlander", "4v4", "ultiduo", "bball", "prolander", "debug", "arena-respawn"} {
+		_, ok := lobbyformat.FromType(name)
+		assert.True(t, ok, name)
+	}
+}
+
+func TestSettingsLoadInvalidKeepsSettings(t *testing.T) {
+	assert.NoError(t, LoadLobbySettings(testSettingsData))
+
+	err := LoadLobbySettings([]byte(`{
+	"formats": [{"name": "sixes", "id": 0, "type": "6s", "classes": ["scout"]}],
+	"maps": [{"name": "cp_process_final", "formats": {"highlander": 1}}]
+}`))
+	assert.Error(t, err)
+
+	assert.Len(t, LobbyFormats, 3)
+	_, ok := GetLobbyMap("pl_upward")
+	assert.True(t, ok)
+	_, ok = lobbyformat.FromType("4v4")
+	assert.True(t, ok)
+}
+
+func TestValidateLobby(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(LoadLobbySettings(testSettingsData))
+
+	assert.NoError(ValidateLobby("highlander", "pl_upward", "etf2l", "3250", false))
+	assert.NoError(ValidateLobby("sixes", "cp_process_final", "etf2l", "", false))
+
+	assert.EqualError(ValidateLobby("ultiduo", "pl_upward", "etf2l", "", false), `Unknown format "ultiduo".`)
+	assert.EqualError(ValidateLobby("sixes", "cp_process_final", "ugc", "", false), `Unknown league "ugc".`)
+	assert.EqualError(ValidateLobby("fours", "cp_process_final", "etf2l", "", false), "ETF2L doesn't have 4v4 lobbies.")
+	assert.EqualError(ValidateLobby("sixes", "pl_upward", "etf2l", "", false), "pl_upward isn't played in 6v6.")
+	assert.EqualError(ValidateLobby("sixes", "cp_foo", "etf2l", "", false), "cp_foo isn't in the map list.")
+	assert.EqualError(ValidateLobby("sixes", "cp_process_final", "etf2l", "3250", false),
+		"ETF2L Highlander (Season 8) is for ETF2L Highlander lobbies.")
+	// custom whitelists
+	assert.NoError(ValidateLobby("sixes", "cp_process_final", "etf2l", "1234", false))
+	assert.NoError(ValidateLobby("sixes", "cp_process_final", "etf2l", "etf2l_6v6", false))
+
+	// custom maps are allowed, but still need a valid league and whitelist
+	assert.NoError(ValidateLobby("sixes", "pl_upward", "etf2l", "", true))
+	assert.NoError(ValidateLobby("sixes", "cp_foo", "etf2l", "", true))
+	assert.Error(ValidateLobby("fours", "cp_foo", "etf2l", "", true))
+}
@@ -0,0 +1,215 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobbySettings
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/TF2Stadium/Helen/assets"
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// The lobby settings are stored in the database, so they can be changed from
+// the admin panel without restarting Helen. The settings in
+// assets/lobbySettingsData.json are used to fill the database the first time
+// Helen is started, and additions to them are merged into the stored settings
+// by MergeDefaults, which is run by a migration.
+
+// DefaultSettings is the asset the settings are first loaded from
+const DefaultSettings = "assets/lobbySettingsData.json"
+
+// lobbyEnded is lobby.Ended, the lobby package imports this one
+const lobbyEnded = 5
+
+// Record is the row storing the lobby settings. There's only one, with
+// ID 1.
+type Record struct {
+	ID        uint `gorm:"primary_key"`
+	UpdatedAt time.Time
+	UpdatedBy uint   // player who last changed the settings, 0 if nobody did
+	Data      string `sql:"type:text"` // the settings Document, as JSON
+}
+
+func (Record) TableName() string { return "lobby_settings" }
+
+func init() {
+	// another instance changed the settings
+	broadcaster.HandleNotification("lobbySettings", func(json.RawMessage) {
+		if err := Load(); err != nil {
+			logrus.Error("Couldn't reload lobby settings: ", err)
+		}
+	})
+}
+
+// Load loads the settings stored in the database. If there aren't any, the
+// default settings are stored first.
+func Load() error {
+	var rec Record
+
+	err := db.DB.First(&rec, 1).Error
+	if err == gorm.ErrRecordNotFound {
+		err = db.DB.Exec(`INSERT INTO lobby_settings (id, updated_at, updated_by, data) VALUES (1, ?, 0, ?)
+ON CONFLICT DO NOTHING`, time.Now(), string(assets.MustAsset(DefaultSettings))).Error
+		if err != nil {
+			return err
+		}
+		err = db.DB.First(&rec, 1).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	return LoadLobbySettings([]byte(rec.Data))
+}
+
+// MergeDefaults adds what's new in the default settings (formats, maps,
+// leagues, whitelists, and the formats maps and leagues are listed for) to
+// the stored settings, keeping the changes made from the admin panel. It does
+// nothing if the settings haven't been stored yet, as Load stores the
+// defaults then.
+func MergeDefaults() error {
+	var defaults Document
+	if err := json.Unmarshal(assets.MustAsset(DefaultSettings), &defaults); err != nil {
+		return err
+	}
+
+	tx := db.DB.Begin()
+
+	var rec Record
+	err := tx.Set("gorm:query_option", "FOR UPDATE").First(&rec, 1).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	var doc Document
+	if err := json.Unmarshal([]byte(rec.Data), &doc); err != nil {
+		tx.Rollback()
+		return err
+	}
+	doc.Merge(&defaults)
+	if _, err := build(&doc); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	data, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	rec.Data = string(data)
+	if err := tx.Save(&rec).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// GetDocument returns the settings stored in the database
+func GetDocument() (*Document, error) {
+	var rec Record
+	if err := db.DB.First(&rec, 1).Error; err != nil {
+		return nil, err
+	}
+
+	doc := new(Document)
+	err := json.Unmarshal([]byte(rec.Data), doc)
+	return doc, err
+}
+
+// Update changes the stored settings with f, and reloads them on every
+// instance. The change is only saved if the resulting settings are valid.
+// Clients are sent the new lobbySettingsList.
+func Update(playerID uint, f func(doc *Document) error) error {
+	tx := db.DB.Begin()
+
+	var rec Record
+	err := tx.Set("gorm:query_option", "FOR UPDATE").First(&rec, 1).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var old, doc Document
+	if err := json.Unmarshal([]byte(rec.Data), &old); err != nil {
+		tx.Rollback()
+		return err
+	}
+	json.Unmarshal([]byte(rec.Data), &doc)
+	if err := f(&doc); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := build(&doc); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := checkFormatChanges(tx, &old, &doc); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	data, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	rec.Data = string(data)
+	rec.UpdatedBy = playerID
+	if err := tx.Save(&rec).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if err := apply(&doc); err != nil {
+		return err
+	}
+	broadcaster.Notify("lobbySettings", nil)
+	broadcaster.SendMessageToRoom("0_public", "lobbySettingsList", LobbySettingsToJSON())
+	return nil
+}
+
+// checkFormatChanges returns an error if doc changes the id of a format in
+// old, or deletes or changes the classes of a format used by lobbies which
+// haven't ended, since their slots depend on it.
+func checkFormatChanges(tx *gorm.DB, old, doc *Document) error {
+	formats := make(map[string]FormatData)
+	for _, f := range doc.Formats {
+		formats[f.Name] = f
+	}
+
+	for _, prev := range old.Formats {
+		f, ok := formats[prev.Name]
+		if ok && *f.ID != *prev.ID {
+			return fmt.Errorf("Format %q's id can't be changed", prev.Name)
+		}
+		if ok && reflect.DeepEqual(f.Classes, prev.Classes) {
+			continue
+		}
+
+		var count int
+		err := tx.Table("lobbies").Where("type = ? AND state <> ? AND deleted_at IS NULL", *prev.ID, lobbyEnded).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			return fmt.Errorf("Format %q is used by %d lobbies which haven't ended", prev.Name, count)
+		}
+	}
+	return nil
+}
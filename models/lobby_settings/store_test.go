@@ -0,0 +1,131 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobbySettings_test
+
+import (
+	"testing"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/lobby_settings"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoredSettings(t *testing.T) {
+	testhelpers.CleanupDB()
+
+	// the bundled settings are stored the first time
+	require.NoError(t, Load())
+	doc, err := GetDocument()
+	require.NoError(t, err)
+	assert.NotEmpty(t, doc.Maps)
+
+	err = Update(1, func(doc *Document) error {
+		doc.PutMap(MapData{Name: "cp_new_map", Formats: map[string]int{"sixes": 1}})
+		return nil
+	})
+	require.NoError(t, err)
+	_, ok := GetLobbyMap("cp_new_map")
+	assert.True(t, ok)
+
+	// maps referring to non existing formats are rejected
+	err = Update(1, func(doc *Document) error {
+		doc.PutMap(MapData{Name: "cp_bad_map", Formats: map[string]int{"7v7": 1}})
+		return nil
+	})
+	assert.Error(t, err)
+	_, ok = GetLobbyMap("cp_bad_map")
+	assert.False(t, ok)
+
+	// formats used by maps can't be deleted
+	err = Update(1, func(doc *Document) error {
+		return doc.DeleteFormat("sixes")
+	})
+	assert.Error(t, err)
+
+	err = Update(1, func(doc *Document) error {
+		return doc.DeleteMap("cp_new_map")
+	})
+	require.NoError(t, err)
+
+	// changes are stored
+	require.NoError(t, Load())
+	_, ok = GetLobbyMap("cp_new_map")
+	assert.False(t, ok)
+	_, ok = GetLobbyMap("cp_badlands")
+	assert.True(t, ok)
+}
+
+func TestMergeDefaults(t *testing.T) {
+	testhelpers.CleanupDB()
+	require.NoError(t, Load())
+
+	// settings stored before fours lobbies were added to cp_badlands, with
+	// a map added from the admin panel
+	err := Update(1, func(doc *Document) error {
+		doc.PutMap(MapData{Name: "cp_badlands", Formats: map[string]int{"sixes": 2}})
+		doc.PutMap(MapData{Name: "cp_new_map", Formats: map[string]int{"sixes": 1}})
+		return doc.DeleteMap("pl_upward")
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, MergeDefaults())
+	doc, err := GetDocument()
+	require.NoError(t, err)
+
+	formats := make(map[string]map[string]int)
+	for _, m := range doc.Maps {
+		formats[m.Name] = m.Formats
+	}
+	assert.Equal(t, map[string]int{"sixes": 2, "fours": 1, "debug": 1}, formats["cp_badlands"])
+	assert.Contains(t, formats, "cp_new_map")
+	assert.Contains(t, formats, "pl_upward")
+}
+
+func TestFormatsInUse(t *testing.T) {
+	testhelpers.CleanupDB()
+	require.NoError(t, Load())
+
+	doc, err := GetDocument()
+	require.NoError(t, err)
+	var sixes FormatData
+	for _, f := range doc.Formats {
+		if f.Name == "sixes" {
+			sixes = f
+		}
+	}
+	require.NotNil(t, sixes.ID)
+
+	// format ids can't be changed
+	err = Update(1, func(doc *Document) error {
+		f := sixes
+		id := *f.ID + 100
+		f.ID = &id
+		doc.PutFormat(f)
+		return nil
+	})
+	assert.Error(t, err)
+
+	require.NoError(t, db.DB.Exec("INSERT INTO lobbies (created_at, updated_at, state, type) VALUES (now(), now(), 1, ?)", *sixes.ID).Error)
+
+	// the classes of formats used by running lobbies can't be changed
+	err = Update(1, func(doc *Document) error {
+		f := sixes
+		f.Classes = f.Classes[1:]
+		doc.PutFormat(f)
+		return nil
+	})
+	assert.Error(t, err)
+
+	// other changes are fine
+	err = Update(1, func(doc *Document) error {
+		f := sixes
+		f.PrettyName = "6v6"
+		doc.PutFormat(f)
+		return nil
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package lobbySettings
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// HasFormat returns true if the map is listed as being played in the format
+func (m *LobbyMap) HasFormat(formatName string) bool {
+	for _, mapFormat := range m.Formats {
+		if mapFormat.Format.Name == formatName {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFormat returns true if the league has lobbies of the format
+func (l *LobbyLeague) HasFormat(formatName string) bool {
+	for _, leagueFormat := range l.Formats {
+		if leagueFormat.Format.Name == formatName {
+			return leagueFormat.Used
+		}
+	}
+	return false
+}
+
+// ValidateLobby checks that a lobby of the given format (by its name in the
+// settings) can be created with the map, league and whitelist. An empty
+// whitelistID means no whitelist, and custom whitelists are allowed. If customMap is true, maps which aren't in
+// the settings, or aren't played in the format, are allowed.
+func ValidateLobby(formatName, mapName, leagueName, whitelistID string, customMap bool) error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	i, ok := lobbyFormatFromName[formatName]
+	if !ok {
+		return fmt.Errorf("Unknown format %q.", formatName)
+	}
+	format := &LobbyFormats[i]
+
+	i, ok = lobbyLeagueFromName[leagueName]
+	if !ok {
+		return fmt.Errorf("Unknown league %q.", leagueName)
+	}
+	league := &LobbyLeagues[i]
+	if !league.HasFormat(formatName) {
+		return fmt.Errorf("%s doesn't have %s lobbies.", league.PrettyName, format.PrettyName)
+	}
+
+	if i, ok := lobbyMapFromName[mapName]; !ok {
+		if !customMap {
+			return fmt.Errorf("%s isn't in the map list.", mapName)
+		}
+	} else if !LobbyMaps[i].HasFormat(formatName) && !customMap {
+		return fmt.Errorf("%s isn't played in %s.", mapName, format.PrettyName)
+	}
+
+	// whitelists which aren't in the settings (whitelist.tf IDs) are
+	// allowed, only the ones which are have to match the league and format
+	id, err := strconv.Atoi(whitelistID)
+	if err != nil {
+		return nil
+	}
+	i, ok = lobbyWhitelistFromID[id]
+	if !ok {
+		return nil
+	}
+	whitelist := &LobbyWhitelists[i]
+	if whitelist.League.Name != leagueName || whitelist.Format.Name != formatName {
+		return fmt.Errorf("%s is for %s %s lobbies.", whitelist.PrettyName,
+			whitelist.League.PrettyName, whitelist.Format.PrettyName)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package party
+
+import (
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/TF2Stadium/Helen/models/player"
+)
+
+// HoldSlots holds slots on the team of the leader's slot in the lobby for
+// the other members, for HoldTime. Members get a slot for the first class
+// they prefer which is free, or any free slot if there isn't one. Members
+// who don't fit on the leader's team, are already in the lobby, or aren't
+// invited to a private lobby don't get a slot. Members with held slots are sent a
+// partySlotHeld message. The held slots are returned by player ID.
+func (party *Party) HoldSlots(lob *lobby.Lobby, leaderSlot int) (map[uint]int, error) {
+	info, ok := format.Get(lob.Type)
+	if !ok || len(info.Classes) == 0 {
+		return nil, nil
+	}
+	team := leaderSlot / len(info.Classes)
+
+	taken := make(map[int]bool)
+	for _, slot := range lob.GetAllSlots() {
+		taken[slot.Slot] = true
+	}
+	for slot := range lob.GetHeldSlots() {
+		taken[slot] = true
+	}
+
+	holds := make(map[uint]int)
+	var players []*player.Player
+	for _, member := range party.GetMembers() {
+		if member.PlayerID == party.LeaderID {
+			continue
+		}
+		p, err := player.GetPlayerByID(member.PlayerID)
+		if err != nil {
+			continue
+		}
+		if id, err := p.GetLobbyID(false); err == nil && id == lob.ID {
+			continue
+		}
+		if lob.Private && !lob.IsInvited(p) {
+			continue
+		}
+
+		slot, ok := pickSlot(info, team, member.GetClasses(), taken)
+		if !ok {
+			break // the leader's team is full
+		}
+		holds[p.ID] = slot
+		taken[slot] = true
+		players = append(players, p)
+	}
+
+	if len(holds) == 0 {
+		return holds, nil
+	}
+	if err := lob.HoldSlots(holds, HoldTime); err != nil {
+		return nil, err
+	}
+
+	for _, p := range players {
+		teamName, class, _ := format.GetSlotTeamClass(lob.Type, holds[p.ID])
+		broadcaster.SendMessage(p.SteamID, "partySlotHeld", struct {
+			LobbyID   uint   `json:"id"`
+			Team      string `json:"team"`
+			Class     string `json:"class"`
+			ExpiresIn int    `json:"expiresIn"` // seconds
+		}{lob.ID, teamName, class, int(HoldTime.Seconds())})
+	}
+
+	return holds, nil
+}
+
+// pickSlot returns a free slot on the team for the first class in prefs which
+// has one, or any free slot on the team. Slots match a class if their name or
+// the stats class they count towards is the class ("pocket" counts towards
+// "soldier").
+func pickSlot(info *format.Info, team int, prefs []string, taken map[int]bool) (int, bool) {
+	n := len(info.Classes)
+	for _, pref := range prefs {
+		for i, class := range info.Classes {
+			slot := team*n + i
+			if !taken[slot] && (class == pref || info.Stats[class] == pref) {
+				return slot, true
+			}
+		}
+	}
+
+	for i := range info.Classes {
+		if slot := team*n + i; !taken[slot] {
+			return slot, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,304 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+// Package party implements parties, groups of players who want to play
+// together. When a party's leader joins a lobby, slots on the leader's team
+// are held for the other members for a short while, so that they don't have
+// to race other players for them.
+package party
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/TF2Stadium/Helen/models/player"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// MaxSize is the number of players in a party, including the leader
+	MaxSize = 6
+	// HoldTime is how long slots are held for party members after their
+	// leader joins a lobby
+	HoldTime = time.Minute
+)
+
+var (
+	ErrPartyNotFound = errors.New("Party not found.")
+	ErrInParty       = errors.New("You're already in a party.")
+	ErrNotInParty    = errors.New("You aren't in a party.")
+	ErrPartyFull     = errors.New("This party is full.")
+	ErrNotInvited    = errors.New("You haven't been invited to this party.")
+	ErrNotLeader     = errors.New("Only the party leader can do this.")
+	ErrInvalidClass  = errors.New("Invalid class.")
+)
+
+// validClass returns true if the class is a slot of one of the formats
+// ("pocket"), or a stats class slots count towards ("soldier")
+func validClass(class string) bool {
+	for _, info := range format.List() {
+		for _, slot := range info.Classes {
+			if slot == class {
+				return true
+			}
+		}
+		for _, stats := range info.Stats {
+			if stats == class {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type Party struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	LeaderID  uint
+}
+
+// Member is a player in a party
+type Member struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	PartyID   uint   `gorm:"index"`
+	PlayerID  uint   `gorm:"unique_index"` // players can only be in one party
+	Classes   string // classes the player prefers to play, comma separated, most preferred first
+}
+
+func (Member) TableName() string { return "party_members" }
+
+// Invite lets a player join a party
+type Invite struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	PartyID   uint `gorm:"index"`
+	PlayerID  uint
+}
+
+func (Invite) TableName() string { return "party_invites" }
+
+// Room returns the wsevent room of the party, where party chat and changes
+// to the party are sent
+func Room(partyID uint) string {
+	return fmt.Sprintf("party_%d", partyID)
+}
+
+// NewParty creates a party led by the player
+func NewParty(leader *player.Player) (*Party, error) {
+	if _, err := GetPlayerParty(leader.ID); err == nil {
+		return nil, ErrInParty
+	}
+
+	party := &Party{LeaderID: leader.ID}
+	tx := db.DB.Begin()
+	if err := tx.Create(party).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Create(&Member{PartyID: party.ID, PlayerID: leader.ID}).Error; err != nil {
+		tx.Rollback()
+		return nil, ErrInParty
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	broadcaster.JoinRoom(leader.SteamID, Room(party.ID))
+	party.Broadcast()
+	return party, nil
+}
+
+// GetParty returns the party with the given ID
+func GetParty(id uint) (*Party, error) {
+	party := &Party{}
+	if err := db.DB.First(party, id).Error; err != nil {
+		return nil, ErrPartyNotFound
+	}
+	return party, nil
+}
+
+// GetPlayerParty returns the party the player is in
+func GetPlayerParty(playerID uint) (*Party, error) {
+	member := &Member{}
+	if err := db.DB.Where("player_id = ?", playerID).First(member).Error; err != nil {
+		return nil, ErrNotInParty
+	}
+	return GetParty(member.PartyID)
+}
+
+// GetMembers returns the party's members, in the order they joined
+func (party *Party) GetMembers() []*Member {
+	var members []*Member
+	db.DB.Where("party_id = ?", party.ID).Order("id").Find(&members)
+	return members
+}
+
+// GetClasses returns the classes the member prefers to play
+func (m *Member) GetClasses() []string {
+	if m.Classes == "" {
+		return nil
+	}
+	return strings.Split(m.Classes, ",")
+}
+
+// Invite invites the player to the party, and sends them a partyInvite message
+func (party *Party) Invite(p *player.Player, by *player.Player) error {
+	var count int
+	db.DB.Model(&Invite{}).Where("party_id = ? AND player_id = ?", party.ID, p.ID).Count(&count)
+	if count == 0 {
+		if err := db.DB.Create(&Invite{PartyID: party.ID, PlayerID: p.ID}).Error; err != nil {
+			return err
+		}
+	}
+
+	broadcaster.SendMessage(p.SteamID, "partyInvite", struct {
+		ID        uint   `json:"id"`
+		InvitedBy string `json:"invitedBy"`
+	}{party.ID, by.Alias()})
+	return nil
+}
+
+// lockParty locks the party's row in the transaction, so that members join
+// and leave the party one at a time
+func lockParty(tx *gorm.DB, id uint) error {
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&Party{}, id).Error; err != nil {
+		return ErrPartyNotFound
+	}
+	return nil
+}
+
+// Join adds the invited player to the party
+func (party *Party) Join(p *player.Player) error {
+	if _, err := GetPlayerParty(p.ID); err == nil {
+		return ErrInParty
+	}
+
+	tx := db.DB.Begin()
+	if err := lockParty(tx, party.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var count int
+	tx.Model(&Member{}).Where("party_id = ?", party.ID).Count(&count)
+	if count >= MaxSize {
+		tx.Rollback()
+		return ErrPartyFull
+	}
+
+	res := tx.Where("party_id = ? AND player_id = ?", party.ID, p.ID).Delete(&Invite{})
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return ErrNotInvited
+	}
+
+	// the unique index on player_id keeps players from joining two parties at once
+	if err := tx.Create(&Member{PartyID: party.ID, PlayerID: p.ID}).Error; err != nil {
+		tx.Rollback()
+		return ErrInParty
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	broadcaster.JoinRoom(p.SteamID, Room(party.ID))
+	party.Broadcast()
+	return nil
+}
+
+// Leave removes the player from the party. If the leader leaves, the member
+// who joined first after them becomes the leader, and the party is disbanded
+// once nobody is left.
+func (party *Party) Leave(p *player.Player) error {
+	tx := db.DB.Begin()
+	if err := lockParty(tx, party.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	res := tx.Where("party_id = ? AND player_id = ?", party.ID, p.ID).Delete(&Member{})
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return ErrNotInParty
+	}
+
+	var members []*Member
+	tx.Where("party_id = ?", party.ID).Order("id").Find(&members)
+	var err error
+	switch {
+	case len(members) == 0:
+		if err = tx.Where("party_id = ?", party.ID).Delete(&Invite{}).Error; err == nil {
+			err = tx.Delete(party).Error
+		}
+	case party.LeaderID == p.ID:
+		party.LeaderID = members[0].PlayerID
+		err = tx.Model(&Party{}).Where("id = ?", party.ID).UpdateColumn("leader_id", party.LeaderID).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	broadcaster.LeaveRoom(p.SteamID, Room(party.ID))
+	broadcaster.SendMessage(p.SteamID, "partyLeft", struct {
+		ID uint `json:"id"`
+	}{party.ID})
+
+	if len(members) != 0 {
+		party.Broadcast()
+	}
+	return nil
+}
+
+// SetClasses sets the classes the member prefers to play, most preferred first
+func (party *Party) SetClasses(p *player.Player, prefs []string) error {
+	for _, class := range prefs {
+		if !validClass(class) {
+			return ErrInvalidClass
+		}
+	}
+
+	res := db.DB.Model(&Member{}).Where("party_id = ? AND player_id = ?", party.ID, p.ID).UpdateColumn("classes", strings.Join(prefs, ","))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotInParty
+	}
+
+	party.Broadcast()
+	return nil
+}
+
+// SendChat sends a chat message to the party's room. Party chat isn't saved.
+func (party *Party) SendChat(p *player.Player, message string) {
+	broadcaster.SendMessageToRoom(Room(party.ID), "partyChatReceive", ChatMessage{
+		PartyID:   party.ID,
+		Timestamp: time.Now(),
+		Message:   message,
+		Player:    MemberData{Name: p.Alias(), SteamID: p.SteamID},
+	})
+}
+
+// Broadcast sends the party's data to its room
+func (party *Party) Broadcast() {
+	broadcaster.SendMessageToRoom(Room(party.ID), "partyData", DecorateParty(party))
+}
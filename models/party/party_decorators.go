@@ -0,0 +1,53 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package party
+
+import (
+	"time"
+
+	"github.com/TF2Stadium/Helen/models/player"
+)
+
+type PartyData struct {
+	ID      uint         `json:"id"`
+	Leader  string       `json:"leader"` // leader's SteamID
+	Members []MemberData `json:"members"`
+}
+
+type MemberData struct {
+	Name    string   `json:"name"`
+	SteamID string   `json:"steamid"`
+	Classes []string `json:"classes,omitempty"`
+}
+
+// ChatMessage is a message sent to party chat
+type ChatMessage struct {
+	PartyID   uint       `json:"id"`
+	Timestamp time.Time  `json:"timestamp"`
+	Message   string     `json:"message"`
+	Player    MemberData `json:"player"`
+}
+
+func DecorateParty(party *Party) PartyData {
+	data := PartyData{ID: party.ID, Members: []MemberData{}}
+
+	for _, member := range party.GetMembers() {
+		p, err := player.GetPlayerByID(member.PlayerID)
+		if err != nil {
+			continue
+		}
+		if p.ID == party.LeaderID {
+			data.Leader = p.SteamID
+		}
+
+		data.Members = append(data.Members, MemberData{
+			Name:    p.Alias(),
+			SteamID: p.SteamID,
+			Classes: member.GetClasses(),
+		})
+	}
+
+	return data
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package party_test
+
+import (
+	"testing"
+
+	_ "github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	"github.com/TF2Stadium/Helen/models/lobby"
+	. "github.com/TF2Stadium/Helen/models/party"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	testhelpers.CleanupDB()
+}
+
+func TestParty(t *testing.T) {
+	t.Parallel()
+	leader := testhelpers.CreatePlayer()
+	member := testhelpers.CreatePlayer()
+	other := testhelpers.CreatePlayer()
+
+	party, err := NewParty(leader)
+	require.NoError(t, err)
+	_, err = NewParty(leader)
+	assert.Equal(t, ErrInParty, err)
+
+	assert.Equal(t, ErrNotInvited, party.Join(other))
+	require.NoError(t, party.Invite(member, leader))
+	require.NoError(t, party.Join(member))
+
+	found, err := GetPlayerParty(member.ID)
+	require.NoError(t, err)
+	assert.Equal(t, party.ID, found.ID)
+
+	require.NoError(t, party.SetClasses(member, []string{"medic", "soldier"}))
+	assert.Equal(t, ErrInvalidClass, party.SetClasses(member, []string{"wizard"}))
+	// slots of formats can be preferred too
+	require.NoError(t, party.SetClasses(member, []string{"pocket", "flex1", "medic", "soldier"}))
+	require.NoError(t, party.SetClasses(member, []string{"medic", "soldier"}))
+
+	data := DecorateParty(party)
+	assert.Equal(t, leader.SteamID, data.Leader)
+	require.Len(t, data.Members, 2)
+	assert.Equal(t, []string{"medic", "soldier"}, data.Members[1].Classes)
+
+	// the next member leads the party once the leader leaves
+	require.NoError(t, party.Leave(leader))
+	party, err = GetParty(party.ID)
+	require.NoError(t, err)
+	assert.Equal(t, member.ID, party.LeaderID)
+
+	require.NoError(t, party.Leave(member))
+	_, err = GetParty(party.ID)
+	assert.Equal(t, ErrPartyNotFound, err)
+}
+
+func TestHoldSlots(t *testing.T) {
+	t.Parallel()
+	lob := testhelpers.CreateLobby()
+	defer lob.Close(false, true)
+
+	leader := testhelpers.CreatePlayer()
+	medic := testhelpers.CreatePlayer()
+	soldier := testhelpers.CreatePlayer()
+	other := testhelpers.CreatePlayer()
+
+	party, err := NewParty(leader)
+	require.NoError(t, err)
+	require.NoError(t, party.Invite(medic, leader))
+	require.NoError(t, party.Join(medic))
+	require.NoError(t, party.SetClasses(medic, []string{"medic"}))
+	require.NoError(t, party.Invite(soldier, leader))
+	require.NoError(t, party.Join(soldier))
+	require.NoError(t, party.SetClasses(soldier, []string{"soldier"}))
+
+	// leader joins blu scout1
+	require.NoError(t, lob.AddPlayer(leader, 6, ""))
+	holds, err := party.HoldSlots(lob, 6)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]int{medic.ID: 11, soldier.ID: 8}, holds)
+
+	assert.Equal(t, lobby.ErrSlotHeld, lob.AddPlayer(other, 11, ""))
+	require.NoError(t, lob.AddPlayer(medic, 11, ""))
+	assert.Equal(t, lobby.ErrSlotHeld, lob.AddPlayer(medic, 8, ""))
+
+	// once the member is in the lobby, their slot isn't held for them anymore
+	require.NoError(t, lob.AddPlayer(soldier, 9, ""))
+	assert.NoError(t, lob.AddPlayer(other, 8, ""))
+}
+
+func TestHoldSlotsTeamFull(t *testing.T) {
+	t.Parallel()
+	lob := testhelpers.CreateLobby()
+	defer lob.Close(false, true)
+
+	leader := testhelpers.CreatePlayer()
+	member := testhelpers.CreatePlayer()
+	party, err := NewParty(leader)
+	require.NoError(t, err)
+	require.NoError(t, party.Invite(member, leader))
+	require.NoError(t, party.Join(member))
+
+	// the rest of blu is taken
+	for slot := 7; slot < 12; slot++ {
+		require.NoError(t, lob.AddPlayer(testhelpers.CreatePlayer(), slot, ""))
+	}
+	require.NoError(t, lob.AddPlayer(leader, 6, ""))
+
+	// members only get slots on the leader's team
+	holds, err := party.HoldSlots(lob, 6)
+	require.NoError(t, err)
+	assert.Empty(t, holds)
+}
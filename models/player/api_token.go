@@ -0,0 +1,186 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package player
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/jinzhu/gorm"
+)
+
+// TokenScope limits what an API token can be used for
+type TokenScope string
+
+const (
+	ScopeReadLobbies   TokenScope = "lobbies:read"   // read lobby, player and chat data
+	ScopeCreateLobbies TokenScope = "lobbies:create" // create and manage lobbies led by the token owner
+	ScopeModerate      TokenScope = "moderate"       // use the admin API, delete chat messages
+)
+
+// APITokenPrefix is prepended to every API token, so they can be told apart
+// from JWTs in Authorization headers.
+const APITokenPrefix = "tf2s_"
+
+var (
+	ErrAPITokenNotFound = errors.New("API token not found")
+	ErrInvalidScope     = errors.New("Invalid API token scope")
+	ErrNoScopes         = errors.New("API tokens need at least one scope")
+)
+
+var validScopes = map[TokenScope]bool{
+	ScopeReadLobbies:   true,
+	ScopeCreateLobbies: true,
+	ScopeModerate:      true,
+}
+
+// APIToken is a long-lived token used by bots and third-party integrations
+// to authenticate as a player. Only the SHA256 hash of the token is stored.
+type APIToken struct {
+	gorm.Model
+	PlayerID uint
+	Player   Player `gorm:"ForeignKey:PlayerID"`
+
+	Name        string
+	Application bool   // application tokens are issued by admins for integrations, personal tokens by players
+	Scopes      string // space separated list of scopes
+	Prefix      string // first few characters of the token, to help players tell tokens apart
+	Hash        string `sql:"not null;unique"`
+	LastUsedAt  *time.Time
+	Revoked     bool `sql:"default:false"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIToken issues a new API token for the player, returning the token
+// record along with the token itself, which isn't stored anywhere.
+func (player *Player) NewAPIToken(name string, application bool, scopes []TokenScope) (*APIToken, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrNoScopes
+	}
+
+	var scopeStrs []string
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			return nil, "", ErrInvalidScope
+		}
+		scopeStrs = append(scopeStrs, string(scope))
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	secret := APITokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	token := &APIToken{
+		PlayerID:    player.ID,
+		Name:        name,
+		Application: application,
+		Scopes:      strings.Join(scopeStrs, " "),
+		Prefix:      secret[:len(APITokenPrefix)+6],
+		Hash:        hashToken(secret),
+	}
+
+	err := db.DB.Create(token).Error
+	return token, secret, err
+}
+
+// GetAPIToken returns the unrevoked token record for the given token
+func GetAPIToken(secret string) (*APIToken, error) {
+	token := &APIToken{}
+	err := db.DB.Preload("Player").Where("hash = ? AND revoked = FALSE", hashToken(secret)).First(token).Error
+	if err != nil {
+		return nil, ErrAPITokenNotFound
+	}
+
+	return token, nil
+}
+
+// GetAPITokens returns all unrevoked tokens issued to the player
+func (player *Player) GetAPITokens() ([]*APIToken, error) {
+	var tokens []*APIToken
+	err := db.DB.Where("player_id = ? AND revoked = FALSE", player.ID).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeAPIToken revokes one of the player's tokens
+func (player *Player) RevokeAPIToken(id uint) error {
+	res := db.DB.Model(&APIToken{}).Where("id = ? AND player_id = ? AND revoked = FALSE", id, player.ID).Update("revoked", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+// RevokeAPIToken revokes any token, used by admins
+func RevokeAPIToken(id uint) error {
+	res := db.DB.Model(&APIToken{}).Where("id = ? AND revoked = FALSE", id).Update("revoked", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+// ScopeList returns the token's scopes
+func (t *APIToken) ScopeList() []TokenScope {
+	var scopes []TokenScope
+	for _, scope := range strings.Fields(t.Scopes) {
+		scopes = append(scopes, TokenScope(scope))
+	}
+	return scopes
+}
+
+// HasScope returns true if the token was issued with the given scope
+func (t *APIToken) HasScope(scope TokenScope) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Touch records that the token has just been used. To avoid a write on every
+// request, the timestamp is only updated once a minute.
+func (t *APIToken) Touch() {
+	now := time.Now()
+	if t.LastUsedAt != nil && now.Sub(*t.LastUsedAt) < time.Minute {
+		return
+	}
+
+	t.LastUsedAt = &now
+	db.DB.Model(&APIToken{}).Where("id = ?", t.ID).UpdateColumn("last_used_at", now)
+}
+
+func (t *APIToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID          uint         `json:"id"`
+		Name        string       `json:"name"`
+		Application bool         `json:"application"`
+		Scopes      []TokenScope `json:"scopes"`
+		Prefix      string       `json:"prefix"`
+		CreatedAt   time.Time    `json:"createdAt"`
+		LastUsedAt  *time.Time   `json:"lastUsedAt"`
+	}{t.ID, t.Name, t.Application, t.ScopeList(), t.Prefix, t.CreatedAt, t.LastUsedAt})
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package player_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/player"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	testhelpers.CleanupDB()
+}
+
+func TestNewAPIToken(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+
+	token, secret, err := player.NewAPIToken("bot", false, []TokenScope{ScopeReadLobbies})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, APITokenPrefix))
+	assert.True(t, strings.HasPrefix(secret, token.Prefix))
+	assert.NotContains(t, token.Hash, secret)
+
+	token2, err := GetAPIToken(secret)
+	require.NoError(t, err)
+	assert.Equal(t, token.ID, token2.ID)
+	assert.Equal(t, player.ID, token2.Player.ID)
+	assert.True(t, token2.HasScope(ScopeReadLobbies))
+	assert.False(t, token2.HasScope(ScopeModerate))
+
+	_, err = GetAPIToken(secret + "x")
+	assert.Equal(t, ErrAPITokenNotFound, err)
+}
+
+func TestNewAPITokenInvalidScopes(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+
+	_, _, err := player.NewAPIToken("bot", false, nil)
+	assert.Equal(t, ErrNoScopes, err)
+
+	_, _, err = player.NewAPIToken("bot", false, []TokenScope{"lobbies:destroy"})
+	assert.Equal(t, ErrInvalidScope, err)
+}
+
+func TestRevokeAPIToken(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+	other := testhelpers.CreatePlayer()
+
+	token, secret, err := player.NewAPIToken("bot", false, []TokenScope{ScopeReadLobbies, ScopeCreateLobbies})
+	require.NoError(t, err)
+
+	tokens, err := player.GetAPITokens()
+	require.NoError(t, err)
+	assert.Len(t, tokens, 1)
+
+	// players can't revoke tokens they don't own
+	assert.Equal(t, ErrAPITokenNotFound, other.RevokeAPIToken(token.ID))
+
+	require.NoError(t, player.RevokeAPIToken(token.ID))
+	_, err = GetAPIToken(secret)
+	assert.Equal(t, ErrAPITokenNotFound, err)
+
+	tokens, err = player.GetAPITokens()
+	require.NoError(t, err)
+	assert.Len(t, tokens, 0)
+}
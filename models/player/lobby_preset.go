@@ -0,0 +1,106 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package player
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	db "github.com/TF2Stadium/Helen/database"
+)
+
+// MaxLobbyPresets is the number of presets a player can save
+const MaxLobbyPresets = 10
+
+var (
+	ErrPresetNotFound = errors.New("Lobby preset not found")
+	ErrPresetName     = errors.New("Preset name must be between 1 and 32 characters long.")
+	ErrTooManyPresets = errors.New("You can't save more than 10 lobby presets.")
+)
+
+// LobbyPreset is a named set of lobby options saved by a player, which they
+// can create lobbies from. The options are validated when a lobby is created
+// from the preset, since the lobby settings might have changed since it was
+// saved.
+type LobbyPreset struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	PlayerID uint   `gorm:"unique_index:idx_lobby_preset_player_id_name"`
+	Name     string `gorm:"unique_index:idx_lobby_preset_player_id_name"`
+	Options  string `sql:"type:text"` // JSON encoded lobbyCreate options
+}
+
+// SaveLobbyPreset saves the options as the player's preset with the given
+// name, replacing the options of the preset with the same name if there is one.
+func (player *Player) SaveLobbyPreset(name string, options []byte) (*LobbyPreset, error) {
+	if len(name) == 0 || len(name) > 32 {
+		return nil, ErrPresetName
+	}
+
+	preset := &LobbyPreset{}
+	err := db.DB.Where("player_id = ? AND name = ?", player.ID, name).First(preset).Error
+	if err == nil {
+		preset.Options = string(options)
+		err = db.DB.Save(preset).Error
+		return preset, err
+	}
+
+	var count int
+	db.DB.Model(&LobbyPreset{}).Where("player_id = ?", player.ID).Count(&count)
+	if count >= MaxLobbyPresets {
+		return nil, ErrTooManyPresets
+	}
+
+	preset = &LobbyPreset{
+		PlayerID: player.ID,
+		Name:     name,
+		Options:  string(options),
+	}
+	err = db.DB.Create(preset).Error
+	return preset, err
+}
+
+// GetLobbyPresets returns the player's presets, by name
+func (player *Player) GetLobbyPresets() ([]*LobbyPreset, error) {
+	var presets []*LobbyPreset
+	err := db.DB.Where("player_id = ?", player.ID).Order("name").Find(&presets).Error
+	return presets, err
+}
+
+// GetLobbyPreset returns one of the player's presets
+func (player *Player) GetLobbyPreset(id uint) (*LobbyPreset, error) {
+	preset := &LobbyPreset{}
+	err := db.DB.Where("id = ? AND player_id = ?", id, player.ID).First(preset).Error
+	if err != nil {
+		return nil, ErrPresetNotFound
+	}
+
+	return preset, nil
+}
+
+// DeleteLobbyPreset deletes one of the player's presets
+func (player *Player) DeleteLobbyPreset(id uint) error {
+	res := db.DB.Where("id = ? AND player_id = ?", id, player.ID).Delete(&LobbyPreset{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrPresetNotFound
+	}
+
+	return nil
+}
+
+func (p *LobbyPreset) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID        uint            `json:"id"`
+		Name      string          `json:"name"`
+		Options   json.RawMessage `json:"options"`
+		UpdatedAt time.Time       `json:"updatedAt"`
+	}{p.ID, p.Name, json.RawMessage(p.Options), p.UpdatedAt})
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package player_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/player"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveLobbyPreset(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+	other := testhelpers.CreatePlayer()
+
+	preset, err := player.SaveLobbyPreset("sixes", []byte(`{"map":"cp_badlands"}`))
+	require.NoError(t, err)
+
+	// saving with the same name replaces the options
+	preset2, err := player.SaveLobbyPreset("sixes", []byte(`{"map":"cp_process_final"}`))
+	require.NoError(t, err)
+	assert.Equal(t, preset.ID, preset2.ID)
+
+	presets, err := player.GetLobbyPresets()
+	require.NoError(t, err)
+	require.Len(t, presets, 1)
+	assert.Equal(t, `{"map":"cp_process_final"}`, presets[0].Options)
+
+	_, err = other.GetLobbyPreset(preset.ID)
+	assert.Equal(t, ErrPresetNotFound, err)
+	assert.Equal(t, ErrPresetNotFound, other.DeleteLobbyPreset(preset.ID))
+
+	require.NoError(t, player.DeleteLobbyPreset(preset.ID))
+	_, err = player.GetLobbyPreset(preset.ID)
+	assert.Equal(t, ErrPresetNotFound, err)
+}
+
+func TestSaveLobbyPresetLimits(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+
+	_, err := player.SaveLobbyPreset("", []byte(`{}`))
+	assert.Equal(t, ErrPresetName, err)
+	_, err = player.SaveLobbyPreset(strings.Repeat("a", 33), []byte(`{}`))
+	assert.Equal(t, ErrPresetName, err)
+
+	for i := 0; i < MaxLobbyPresets; i++ {
+		_, err := player.SaveLobbyPreset(fmt.Sprint("preset", i), []byte(`{}`))
+		require.NoError(t, err)
+	}
+	_, err = player.SaveLobbyPreset("one more", []byte(`{}`))
+	assert.Equal(t, ErrTooManyPresets, err)
+
+	// existing presets can still be changed
+	_, err = player.SaveLobbyPreset("preset0", []byte(`{"map":"cp_badlands"}`))
+	assert.NoError(t, err)
+}
@@ -23,6 +23,7 @@ import (
 	db "github.com/TF2Stadium/Helen/database"
 	"github.com/TF2Stadium/Helen/helpers"
 	"github.com/TF2Stadium/Helen/helpers/authority"
+	"github.com/TF2Stadium/Helen/models/job"
 	"github.com/TF2Stadium/Helen/models/lobby/format"
 	"github.com/TF2Stadium/PlayerStatsScraper"
 	"github.com/jinzhu/gorm/dialects/postgres"
@@ -359,6 +360,33 @@ func (player *Player) UpdatePlayerInfo() error {
 	return nil
 }
 
+const (
+	jobUpdatePlayerInfo      = "updatePlayerInfo"
+	jobUpdateStreamingStatus = "updateStreamingStatus"
+)
+
+func init() {
+	job.Register(jobUpdatePlayerInfo, func(payload json.RawMessage) error {
+		var steamid string
+		if err := json.Unmarshal(payload, &steamid); err != nil {
+			return err
+		}
+
+		player, err := GetPlayerBySteamID(steamid)
+		if err != nil {
+			return nil
+		}
+		return player.UpdatePlayerInfo()
+	})
+	job.Every(jobUpdateStreamingStatus, 3*time.Minute, UpdateStreamingStatus)
+}
+
+// QueuePlayerInfoUpdate enqueues a job which updates the player's details
+//(see UpdatePlayerInfo)
+func (player *Player) QueuePlayerInfoUpdate() error {
+	return job.Enqueue(jobUpdatePlayerInfo, player.SteamID, job.Options{Key: player.SteamID})
+}
+
 func (player *Player) SetSetting(key string, value string) {
 	if player.Settings == nil {
 		player.Settings = make(postgres.Hstore)
@@ -446,8 +474,12 @@ func (p *Player) IsFollowing(channel string) bool {
 	return resp.StatusCode != 404
 }
 
-var states = []int{0, 5}
+// lobbies in these states (initializing, ended and scheduled) don't count as
+// the player's lobby
+var states = []int{0, 5, 6}
 
+// HasCreatedLobby returns true if the player created a lobby which is open.
+// Scheduled lobbies aren't counted until they open.
 func (p *Player) HasCreatedLobby() bool {
 	var count int
 
@@ -456,17 +488,9 @@ func (p *Player) HasCreatedLobby() bool {
 	return count != 0
 }
 
-//if the player has connected their twitch account, and
-//is currently streaming Team Fortress 2, returns true
-func (p *Player) setStreamingStatus() {
-	if p.TwitchName == "" {
-		return
-	}
-
-	if time.Since(p.StreamStatusUpdatedAt) < 3*time.Minute {
-		return
-	}
-
+// streamingChannels returns which of the given twitch channels (at most 100)
+// are streaming Team Fortress 2, in lower case
+func streamingChannels(channels []string) (map[string]bool, error) {
 	u := &url.URL{
 		Scheme: "https",
 		Host:   "api.twitch.tv",
@@ -475,8 +499,9 @@ func (p *Player) setStreamingStatus() {
 
 	values := u.Query()
 	values.Set("game", "Team Fortress 2")
-	values.Set("channel", p.TwitchName)
+	values.Set("channel", strings.Join(channels, ","))
 	values.Set("stream_type", "live")
+	values.Set("limit", "100")
 	u.RawQuery = values.Encode()
 
 	req, _ := http.NewRequest("GET", u.String(), nil)
@@ -485,16 +510,65 @@ func (p *Player) setStreamingStatus() {
 
 	resp, err := helpers.HTTPClient.Do(req)
 	if err != nil {
-		logrus.Error(err)
-		return
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("twitch returned %s", resp.Status)
 	}
 
 	var reply struct {
-		Total int `json:"_total"`
+		Streams []struct {
+			Channel struct {
+				Name string `json:"name"`
+			} `json:"channel"`
+		} `json:"streams"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]bool)
+	for _, stream := range reply.Streams {
+		live[strings.ToLower(stream.Channel.Name)] = true
 	}
+	return live, nil
+}
+
+// UpdateStreamingStatus sets whether players who have connected their twitch
+// account are currently streaming Team Fortress 2. It's run periodically as a
+// job, so that decorating players doesn't wait for twitch.
+func UpdateStreamingStatus() error {
+	var players []*Player
+	db.DB.Select("id, twitch_name").Where("twitch_name <> ''").Find(&players)
+
+	for len(players) != 0 {
+		n := len(players)
+		if n > 100 {
+			n = 100
+		}
+		batch := players[:n]
+		players = players[n:]
 
-	json.NewDecoder(resp.Body).Decode(&reply)
-	p.StreamStatusUpdatedAt = time.Now()
-	p.IsStreaming = reply.Total != 0
-	p.Save()
+		channels := make([]string, len(batch))
+		for i, p := range batch {
+			channels[i] = strings.ToLower(p.TwitchName)
+		}
+		live, err := streamingChannels(channels)
+		if err != nil {
+			return err
+		}
+
+		ids := map[bool][]uint{}
+		for i, p := range batch {
+			ids[live[channels[i]]] = append(ids[live[channels[i]]], p.ID)
+		}
+		for streaming, ids := range ids {
+			db.DB.Model(&Player{}).Where("id IN (?)", ids).UpdateColumns(map[string]interface{}{
+				"is_streaming":             streaming,
+				"stream_status_updated_at": time.Now(),
+			})
+		}
+	}
+	return nil
 }
@@ -22,6 +22,12 @@ func (p *Player) DecoratePlayerTags() []string {
 
 func (p *Player) setJSONFields(stats, lobbies, streaming, bans bool) {
 	db.DB.Preload("Stats").First(p, p.ID)
+	p.setLoadedJSONFields(stats, bans)
+}
+
+// setLoadedJSONFields is setJSONFields for players which have been loaded
+// along with their stats
+func (p *Player) setLoadedJSONFields(stats, bans bool) {
 	p.PlaceholderLobbiesPlayed = new(int)
 	*p.PlaceholderLobbiesPlayed = p.Stats.TotalLobbies()
 
@@ -56,7 +62,6 @@ func (p *Player) setJSONFields(stats, lobbies, streaming, bans bool) {
 		p.ExternalLinks["twitch"] = &twitchURL
 	}
 
-	p.setStreamingStatus()
 	if bans {
 		p.PlaceholderBans, _ = p.GetActiveBans()
 	}
@@ -78,3 +83,29 @@ func (p *Player) SetPlayerSummary() {
 	p.setJSONFields(false, false, false, false)
 	p.ExternalLinks = nil
 }
+
+// GetPlayerSummaries returns the players with the given IDs or SteamIDs, with
+// the same fields set as SetPlayerSummary does. Players and their stats are
+// loaded in two queries, however many players there are.
+func GetPlayerSummaries(ids []uint, steamIDs []string) []*Player {
+	var players []*Player
+
+	query := db.DB.Preload("Stats")
+	switch {
+	case len(ids) != 0 && len(steamIDs) != 0:
+		query = query.Where("id IN (?) OR steam_id IN (?)", ids, steamIDs)
+	case len(ids) != 0:
+		query = query.Where("id IN (?)", ids)
+	case len(steamIDs) != 0:
+		query = query.Where("steam_id IN (?)", steamIDs)
+	default:
+		return nil
+	}
+	query.Find(&players)
+
+	for _, p := range players {
+		p.setLoadedJSONFields(false, false)
+		p.ExternalLinks = nil
+	}
+	return players
+}
@@ -59,19 +59,26 @@ func (ps *PlayerStats) TotalLobbies() int {
 }
 
 func (ps *PlayerStats) PlayedCountIncrease(lt format.Format) {
-	switch lt {
-	case format.Sixes:
+	info, ok := format.Get(lt)
+	if !ok {
+		return
+	}
+
+	switch info.PlayedStat {
+	case "sixes":
 		ps.PlayedSixesCount++
-	case format.Highlander:
+	case "highlander":
 		ps.PlayedHighlanderCount++
-	case format.Fours:
+	case "fours":
 		ps.PlayedFoursCount++
-	case format.Bball:
+	case "bball":
 		ps.PlayedBballCount++
-	case format.Ultiduo:
+	case "ultiduo":
 		ps.PlayedUltiduoCount++
-	case format.Prolander:
+	case "prolander":
 		ps.PlayedProlanderCount++
+	default:
+		return
 	}
 	database.DB.Save(ps)
 }
@@ -82,11 +89,16 @@ func (ps *PlayerStats) IncreaseSubCount() {
 }
 
 func (ps *PlayerStats) IncreaseClassCount(f format.Format, slot int) {
+	info, ok := format.Get(f)
+	if !ok {
+		return
+	}
 	_, class, _ := format.GetSlotTeamClass(f, slot)
-	switch class {
-	case "scout", "scout1", "scout2":
+
+	switch info.Stats[class] {
+	case "scout":
 		ps.Scout++
-	case "roamer", "pocket", "soldier", "soldier1", "soldier2":
+	case "soldier":
 		ps.Soldier++
 	case "pyro":
 		ps.Pyro++
@@ -102,8 +114,8 @@ func (ps *PlayerStats) IncreaseClassCount(f format.Format, slot int) {
 		ps.Medic++
 	case "spy":
 		ps.Spy++
+	default:
+		return
 	}
-	if class != "flex1" && class != "flex2" {
-		database.DB.Save(ps)
-	}
+	database.DB.Save(ps)
 }
@@ -0,0 +1,252 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package player
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrSessionNotFound = errors.New("Session not found or expired")
+
+// Access tokens are checked on every request, so active sessions are cached
+// for a while. Revoked sessions are removed from the cache on every instance.
+const sessionCacheTTL = time.Minute
+
+type cachedSession struct {
+	playerID uint
+	steamID  string
+	expires  time.Time
+}
+
+type sessionsRevoked struct {
+	PlayerID  uint `json:"playerID"`
+	SessionID uint `json:"sessionID"` // 0 if all of the player's sessions were revoked
+}
+
+var (
+	sessionCacheMu sync.Mutex
+	sessionCache   = make(map[uint]cachedSession)
+	// incremented every time sessions are revoked, so that sessions checked
+	// before that aren't cached after it
+	sessionCacheGen uint64
+)
+
+func init() {
+	broadcaster.HandleNotification("sessionsRevoked", func(data json.RawMessage) {
+		var revoked sessionsRevoked
+		if err := json.Unmarshal(data, &revoked); err != nil {
+			logrus.Error(err)
+			return
+		}
+		forgetSessions(revoked)
+	})
+}
+
+func forgetSessions(revoked sessionsRevoked) {
+	sessionCacheMu.Lock()
+	defer sessionCacheMu.Unlock()
+
+	sessionCacheGen++
+	for id, session := range sessionCache {
+		if id == revoked.SessionID || (revoked.SessionID == 0 && session.playerID == revoked.PlayerID) {
+			delete(sessionCache, id)
+		}
+	}
+}
+
+// notifySessionsRevoked removes the revoked sessions from the cache, on every
+// instance
+func notifySessionsRevoked(playerID, sessionID uint) {
+	revoked := sessionsRevoked{playerID, sessionID}
+	forgetSessions(revoked)
+	broadcaster.Notify("sessionsRevoked", revoked)
+}
+
+// Session is a login session. Short-lived access tokens are issued for a
+// session using its refresh token (stored in the auth-refresh cookie), until
+// the session expires or is revoked.
+type Session struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	PlayerID  uint `sql:"index"`
+
+	RefreshHash string `sql:"not null;unique"` // SHA256 hash of the refresh token
+	UserAgent   string
+	IPAddr      string
+
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	Revoked    bool `sql:"default:false"`
+}
+
+// NewSession starts a new login session for the player, returning it along
+// with its refresh token.
+func (player *Player) NewSession(userAgent, ipaddr string) (*Session, string, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &Session{
+		PlayerID:    player.ID,
+		RefreshHash: hashToken(refreshToken),
+		UserAgent:   userAgent,
+		IPAddr:      ipaddr,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(config.Constants.SessionLifetime),
+	}
+
+	err = db.DB.Create(session).Error
+	return session, refreshToken, err
+}
+
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// GetSessionByRefreshToken returns the active session with the given refresh
+// token.
+func GetSessionByRefreshToken(refreshToken string) (*Session, error) {
+	session := &Session{}
+	err := db.DB.Where("refresh_hash = ? AND revoked = FALSE AND expires_at > now()", hashToken(refreshToken)).
+		First(session).Error
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	return session, nil
+}
+
+// IsSessionActive returns true if the session exists, belongs to the player with
+// the given ID and SteamID, and hasn't expired or been revoked.
+func IsSessionActive(sessionID, playerID uint, steamID string) bool {
+	now := time.Now()
+
+	sessionCacheMu.Lock()
+	cached, ok := sessionCache[sessionID]
+	gen := sessionCacheGen
+	sessionCacheMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.playerID == playerID && cached.steamID == steamID
+	}
+
+	var expires []time.Time
+	db.DB.Table("sessions").
+		Joins("INNER JOIN players ON players.id = sessions.player_id").
+		Where("sessions.id = ? AND players.id = ? AND players.steam_id = ? AND sessions.revoked = FALSE AND sessions.expires_at > now()",
+			sessionID, playerID, steamID).
+		Pluck("sessions.expires_at", &expires)
+	if len(expires) == 0 {
+		return false
+	}
+
+	cached = cachedSession{playerID, steamID, now.Add(sessionCacheTTL)}
+	if expires[0].Before(cached.expires) {
+		cached.expires = expires[0]
+	}
+	sessionCacheMu.Lock()
+	if sessionCacheGen == gen {
+		sessionCache[sessionID] = cached
+	}
+	sessionCacheMu.Unlock()
+	return true
+}
+
+// Rotate replaces the session's refresh token with a new one, which is
+// returned, and extends the session's expiry. The old refresh token stops
+// working, and if it has been used to rotate the session concurrently, only
+// one of the callers gets a new token.
+func (s *Session) Rotate(ipaddr string) (string, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(config.Constants.SessionLifetime)
+	hash := hashToken(refreshToken)
+	res := db.DB.Model(&Session{}).Where("id = ? AND refresh_hash = ? AND revoked = FALSE", s.ID, s.RefreshHash).
+		UpdateColumns(map[string]interface{}{
+			"refresh_hash": hash,
+			"last_used_at": now,
+			"expires_at":   expiresAt,
+			"ip_addr":      ipaddr,
+		})
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", ErrSessionNotFound
+	}
+
+	s.RefreshHash = hash
+	s.LastUsedAt = now
+	s.ExpiresAt = expiresAt
+	s.IPAddr = ipaddr
+	return refreshToken, nil
+}
+
+// Revoke ends the session, access tokens issued for it stop working immediately.
+func (s *Session) Revoke() error {
+	s.Revoked = true
+	err := db.DB.Model(&Session{}).Where("id = ?", s.ID).Update("revoked", true).Error
+	notifySessionsRevoked(s.PlayerID, s.ID)
+	return err
+}
+
+// GetSessions returns the player's active sessions
+func (player *Player) GetSessions() ([]*Session, error) {
+	var sessions []*Session
+	err := db.DB.Where("player_id = ? AND revoked = FALSE AND expires_at > now()", player.ID).
+		Order("last_used_at desc").Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession revokes one of the player's sessions
+func (player *Player) RevokeSession(id uint) error {
+	res := db.DB.Model(&Session{}).Where("id = ? AND player_id = ? AND revoked = FALSE", id, player.ID).Update("revoked", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	notifySessionsRevoked(player.ID, id)
+	return nil
+}
+
+// RevokeAllSessions logs the player out everywhere
+func (player *Player) RevokeAllSessions() error {
+	err := db.DB.Model(&Session{}).Where("player_id = ? AND revoked = FALSE", player.ID).Update("revoked", true).Error
+	notifySessionsRevoked(player.ID, 0)
+	return err
+}
+
+func (s *Session) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID         uint      `json:"id"`
+		UserAgent  string    `json:"userAgent"`
+		IPAddr     string    `json:"ipAddr"`
+		CreatedAt  time.Time `json:"createdAt"`
+		LastUsedAt time.Time `json:"lastUsedAt"`
+		ExpiresAt  time.Time `json:"expiresAt"`
+	}{s.ID, s.UserAgent, s.IPAddr, s.CreatedAt, s.LastUsedAt, s.ExpiresAt})
+}
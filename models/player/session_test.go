@@ -0,0 +1,92 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package player_test
+
+import (
+	"testing"
+
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	. "github.com/TF2Stadium/Helen/models/player"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	testhelpers.CleanupDB()
+}
+
+func TestNewSession(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+
+	session, refreshToken, err := player.NewSession("test", "127.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, IsSessionActive(session.ID, player.ID, player.SteamID))
+
+	session2, err := GetSessionByRefreshToken(refreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, session2.ID)
+
+	_, err = GetSessionByRefreshToken(refreshToken + "x")
+	assert.Equal(t, ErrSessionNotFound, err)
+
+	other := testhelpers.CreatePlayer()
+	assert.False(t, IsSessionActive(session.ID, other.ID, other.SteamID))
+}
+
+func TestRotateSession(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+
+	session, refreshToken, err := player.NewSession("test", "127.0.0.1")
+	require.NoError(t, err)
+
+	newToken, err := session.Rotate("127.0.0.2")
+	require.NoError(t, err)
+	assert.NotEqual(t, refreshToken, newToken)
+
+	// the old refresh token can't be used anymore
+	_, err = GetSessionByRefreshToken(refreshToken)
+	assert.Equal(t, ErrSessionNotFound, err)
+
+	session2, err := GetSessionByRefreshToken(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, session2.ID)
+	assert.Equal(t, "127.0.0.2", session2.IPAddr)
+
+	// a concurrent rotation with the old token loses
+	stale := *session2
+	_, err = session2.Rotate("127.0.0.1")
+	require.NoError(t, err)
+	_, err = stale.Rotate("127.0.0.1")
+	assert.Equal(t, ErrSessionNotFound, err)
+}
+
+func TestRevokeSession(t *testing.T) {
+	t.Parallel()
+	player := testhelpers.CreatePlayer()
+
+	session, refreshToken, err := player.NewSession("test", "127.0.0.1")
+	require.NoError(t, err)
+	session2, _, err := player.NewSession("test", "127.0.0.1")
+	require.NoError(t, err)
+
+	sessions, err := player.GetSessions()
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	require.NoError(t, player.RevokeSession(session.ID))
+	assert.False(t, IsSessionActive(session.ID, player.ID, player.SteamID))
+	assert.True(t, IsSessionActive(session2.ID, player.ID, player.SteamID))
+	_, err = GetSessionByRefreshToken(refreshToken)
+	assert.Equal(t, ErrSessionNotFound, err)
+
+	assert.Equal(t, ErrSessionNotFound, player.RevokeSession(session.ID))
+
+	require.NoError(t, player.RevokeAllSessions())
+	sessions, err = player.GetSessions()
+	require.NoError(t, err)
+	assert.Len(t, sessions, 0)
+}
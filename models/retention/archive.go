@@ -0,0 +1,258 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package retention
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/models/gameserver"
+	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/player"
+	"github.com/jinzhu/gorm"
+)
+
+// chatMessage is a chat.ChatMessage, without the JSON encoding used for
+// sending messages to clients
+type chatMessage struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	PlayerID  uint      `json:"playerId"`
+	Room      int       `json:"room"`
+	Message   string    `json:"message"`
+	Deleted   bool      `json:"deleted"`
+	Bot       bool      `json:"bot"`
+	InGame    bool      `json:"ingame"`
+}
+
+func (chatMessage) TableName() string { return "chat_messages" }
+
+type lobbyPlayer struct {
+	LobbyID  uint `json:"-"`
+	PlayerID uint `json:"playerId"`
+}
+
+type requirement struct {
+	LobbyID     uint    `json:"-"`
+	Slot        int     `json:"slot"`
+	Hours       int     `json:"hours"`
+	Lobbies     int     `json:"lobbies"`
+	Reliability float64 `json:"reliability"`
+}
+
+// lobbyExport is a line in a lobby export file
+type lobbyExport struct {
+	Lobby        *lobby.Lobby       `json:"lobby"` // without the server info
+	Slots        []lobby.LobbySlot  `json:"slots"`
+	Requirements []requirement      `json:"requirements"`
+	Spectators   []lobbyPlayer      `json:"spectators"`
+	Banned       []lobbyPlayer      `json:"banned"`
+	Reports      []player.Report    `json:"reports"`
+	Chat         []chatMessage      `json:"chat"`
+	Series       []lobby.SeriesGame `json:"series,omitempty"` // results of the games of series lobbies
+}
+
+func archivable(q *gorm.DB, cutoff time.Time) *gorm.DB {
+	// lobbies deleted because their server couldn't be set up never end
+	return q.Unscoped().Model(&lobby.Lobby{}).
+		Where("(state = ? OR deleted_at IS NOT NULL) AND updated_at < ?", lobby.Ended, cutoff)
+}
+
+func globalChat(cutoff time.Time) *gorm.DB {
+	return db.DB.Model(&chatMessage{}).Where("room = 0 AND created_at < ?", cutoff)
+}
+
+func deletedChat(cutoff time.Time) *gorm.DB {
+	return db.DB.Model(&chatMessage{}).Where("deleted = TRUE AND created_at < ?", cutoff)
+}
+
+// archiveLobbies archives the oldest batch of archivable lobbies, returning
+// how many were archived
+func archiveLobbies(cutoff time.Time) (int, error) {
+	var lobbies []*lobby.Lobby
+	err := archivable(db.DB, cutoff).Order("id").Limit(batchSize).Find(&lobbies).Error
+	if err != nil || len(lobbies) == 0 {
+		return 0, err
+	}
+
+	ids := make([]uint, len(lobbies))
+	var serverIDs []uint
+	exports := make(map[uint]*lobbyExport)
+	for i, lob := range lobbies {
+		ids[i] = lob.ID
+		serverIDs = append(serverIDs, lob.ServerInfoID)
+		exports[lob.ID] = &lobbyExport{Lobby: lob}
+	}
+
+	var (
+		slots        []lobby.LobbySlot
+		requirements []requirement
+		spectators   []lobbyPlayer
+		banned       []lobbyPlayer
+		reports      []player.Report
+		messages     []chatMessage
+		games        []lobby.SeriesGame
+	)
+	queries := []*gorm.DB{
+		db.DB.Where("lobby_id IN (?)", ids).Order("slot").Find(&slots),
+		db.DB.Table("requirements").Where("lobby_id IN (?)", ids).Find(&requirements),
+		db.DB.Table("spectators_players_lobbies").Where("lobby_id IN (?)", ids).Find(&spectators),
+		db.DB.Table("banned_players_lobbies").Where("lobby_id IN (?)", ids).Find(&banned),
+		db.DB.Where("lobby_id IN (?)", ids).Order("id").Find(&reports),
+		db.DB.Where("room IN (?)", ids).Order("id").Find(&messages),
+		db.DB.Where("lobby_id IN (?)", ids).Order("number").Find(&games),
+	}
+	for _, q := range queries {
+		if q.Error != nil {
+			return 0, q.Error
+		}
+	}
+
+	for _, s := range slots {
+		exports[s.LobbyID].Slots = append(exports[s.LobbyID].Slots, s)
+	}
+	for _, r := range requirements {
+		exports[r.LobbyID].Requirements = append(exports[r.LobbyID].Requirements, r)
+	}
+	for _, s := range spectators {
+		exports[s.LobbyID].Spectators = append(exports[s.LobbyID].Spectators, s)
+	}
+	for _, b := range banned {
+		exports[b.LobbyID].Banned = append(exports[b.LobbyID].Banned, b)
+	}
+	for _, r := range reports {
+		exports[r.LobbyID].Reports = append(exports[r.LobbyID].Reports, r)
+	}
+	for _, m := range messages {
+		exports[uint(m.Room)].Chat = append(exports[uint(m.Room)].Chat, m)
+	}
+	for _, g := range games {
+		exports[g.LobbyID].Series = append(exports[g.LobbyID].Series, g)
+	}
+
+	name := fmt.Sprintf("lobbies-%d-%d.jsonl.gz", ids[0], ids[len(ids)-1])
+	records := make([]interface{}, len(lobbies))
+	for i, lob := range lobbies {
+		records[i] = exports[lob.ID]
+	}
+	// the export has to be written before anything is deleted
+	if err := writeExport(name, records); err != nil {
+		return 0, err
+	}
+
+	tx := db.DB.Begin()
+	now := time.Now()
+	for _, lob := range lobbies {
+		archive := &LobbyArchive{
+			ID:         lob.ID,
+			CreatedAt:  lob.CreatedAt,
+			ArchivedAt: now,
+			Type:       lob.Type,
+			Mode:       lob.Mode,
+			MapName:    lob.MapName,
+			League:     lob.League,
+			RegionCode: lob.RegionCode,
+			MatchEnded: lob.MatchEnded,
+			LogstfID:   lob.LogstfID,
+			Export:     name,
+		}
+		if err := tx.Create(archive).Error; err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	for _, s := range slots {
+		err := tx.Create(&ArchivedSlot{LobbyID: s.LobbyID, PlayerID: s.PlayerID, Slot: s.Slot, NeedsSub: s.NeedsSub}).Error
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	deletes := []*gorm.DB{
+		tx.Where("room IN (?)", ids).Delete(&chatMessage{}),
+		tx.Where("lobby_id IN (?)", ids).Delete(&lobby.LobbySlot{}),
+		tx.Where("lobby_id IN (?)", ids).Delete(&lobby.Requirement{}),
+		tx.Where("lobby_id IN (?)", ids).Delete(&lobby.MapVote{}),
+		tx.Where("lobby_id IN (?)", ids).Delete(&lobby.SeriesGame{}),
+		tx.Where("lobby_id IN (?)", ids).Delete(&lobby.RematchServer{}),
+		tx.Where("lobby_id IN (?)", ids).Delete(&lobby.LobbyInvite{}),
+		tx.Where("lobby_id IN (?)", ids).Delete(&lobby.SlotHold{}),
+		tx.Where("lobby_id IN (?)", ids).Delete(&player.Report{}),
+		tx.Exec("DELETE FROM spectators_players_lobbies WHERE lobby_id IN (?)", ids),
+		tx.Exec("DELETE FROM banned_players_lobbies WHERE lobby_id IN (?)", ids),
+		tx.Where("id IN (?)", serverIDs).Delete(&gameserver.ServerRecord{}),
+		tx.Unscoped().Where("id IN (?)", ids).Delete(&lobby.Lobby{}),
+	}
+	for _, q := range deletes {
+		if q.Error != nil {
+			tx.Rollback()
+			return 0, q.Error
+		}
+	}
+
+	return len(lobbies), tx.Commit().Error
+}
+
+// archiveGlobalChat exports and removes the oldest batch of global chat
+// messages older than cutoff
+func archiveGlobalChat(cutoff time.Time) (int, error) {
+	var messages []chatMessage
+	err := globalChat(cutoff).Order("id").Limit(batchSize * 10).Find(&messages).Error
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	first, last := messages[0].ID, messages[len(messages)-1].ID
+	records := make([]interface{}, len(messages))
+	for i := range messages {
+		records[i] = messages[i]
+	}
+	if err := writeExport(fmt.Sprintf("chat-%d-%d.jsonl.gz", first, last), records); err != nil {
+		return 0, err
+	}
+
+	err = db.DB.Where("room = 0 AND id BETWEEN ? AND ?", first, last).Delete(&chatMessage{}).Error
+	return len(messages), err
+}
+
+// writeExport writes the records to a gzipped file in the export directory,
+// one JSON object per line. The file only appears once it has been written
+// completely.
+func writeExport(name string, records []interface{}) error {
+	dir := config.Constants.RetentionExportDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	enc := json.NewEncoder(gz)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const usage = `usage: helen retention <command>
+
+commands:
+  status    print how many lobbies and chat messages the next run would
+            archive or purge
+  run       archive and purge them now`
+
+// Command runs the `helen retention` subcommand with the given arguments
+func Command(args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "status":
+		res, err := Eligible()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "lobbies to archive:          %d\n", res.Lobbies)
+		fmt.Fprintf(w, "global messages to archive:  %d\n", res.GlobalMessages)
+		fmt.Fprintf(w, "deleted messages to purge:   %d\n", res.DeletedMessages)
+		return nil
+	case "run":
+		res, err := Run()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "archived %d lobbies and %d global messages, purged %d deleted messages\n",
+			res.Lobbies, res.GlobalMessages, res.DeletedMessages)
+		return nil
+	}
+
+	return errors.New(usage)
+}
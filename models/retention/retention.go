@@ -0,0 +1,160 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+// Package retention archives old lobbies and chat, so that the tables
+// queried while lobbies are being played don't grow forever.
+//
+// Ended lobbies older than HELEN_RETENTION_AGE are exported, along with their
+// slots, requirements, spectators, bans, reports and chat, to gzipped JSON
+// files in HELEN_RETENTION_EXPORT_DIR, and removed from the database. A short
+// summary of every archived lobby, and the players who played in it, is kept
+// in the lobby_archives and archived_slots tables. Global chat older than
+// HELEN_RETENTION_AGE is exported and removed the same way, and deleted chat
+// messages are purged after HELEN_RETENTION_DELETED_CHAT_AGE.
+//
+// Player stats are stored separately, and aren't changed by archiving.
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	db "github.com/TF2Stadium/Helen/database"
+	"github.com/TF2Stadium/Helen/models/job"
+	"github.com/TF2Stadium/Helen/models/lobby/format"
+	"github.com/sirupsen/logrus"
+)
+
+const batchSize = 100
+
+// only one instance archives at a time
+const lockRetention = "SELECT pg_try_advisory_xact_lock(3, 0)"
+
+var ErrDisabled = errors.New("retention is disabled, set HELEN_RETENTION_AGE")
+
+// LobbyArchive is what's left of a lobby once it has been archived
+type LobbyArchive struct {
+	ID         uint      `gorm:"primary_key"` // the lobby's ID
+	CreatedAt  time.Time // when the lobby was created
+	ArchivedAt time.Time
+
+	Type       format.Format
+	Mode       string
+	MapName    string
+	League     string
+	RegionCode string
+	MatchEnded bool
+	LogstfID   int
+
+	Export string // name of the file in the export directory the lobby was written to
+}
+
+// ArchivedSlot is a player who played in an archived lobby
+type ArchivedSlot struct {
+	ID       uint `gorm:"primary_key"`
+	LobbyID  uint `sql:"index"`
+	PlayerID uint `sql:"index"`
+	Slot     int
+	NeedsSub bool
+}
+
+// Result is the number of rows archived (or which would be archived) by a run
+type Result struct {
+	Lobbies         int
+	GlobalMessages  int
+	DeletedMessages int
+}
+
+func cutoffs() (time.Time, time.Time, error) {
+	if config.Constants.RetentionAge <= 0 {
+		return time.Time{}, time.Time{}, ErrDisabled
+	}
+
+	now := time.Now()
+	return now.Add(-config.Constants.RetentionAge), now.Add(-config.Constants.RetentionDeletedChatAge), nil
+}
+
+// Eligible returns the number of rows the next run would archive or purge
+func Eligible() (Result, error) {
+	var res Result
+
+	cutoff, deletedCutoff, err := cutoffs()
+	if err != nil {
+		return res, err
+	}
+
+	archivable(db.DB, cutoff).Count(&res.Lobbies)
+	globalChat(cutoff).Count(&res.GlobalMessages)
+	deletedChat(deletedCutoff).Count(&res.DeletedMessages)
+	return res, nil
+}
+
+// Run archives everything older than the configured ages. If another
+// instance is archiving already, it returns without doing anything.
+func Run() (Result, error) {
+	var res Result
+
+	cutoff, deletedCutoff, err := cutoffs()
+	if err != nil {
+		return res, err
+	}
+
+	lock := db.DB.Begin()
+	defer lock.Commit()
+	var locked bool
+	if err := lock.Raw(lockRetention).Row().Scan(&locked); err != nil {
+		return res, err
+	}
+	if !locked {
+		logrus.Info("Another instance is archiving old lobbies")
+		return res, nil
+	}
+
+	for {
+		n, err := archiveLobbies(cutoff)
+		res.Lobbies += n
+		if err != nil {
+			return res, err
+		}
+		if n < batchSize {
+			break
+		}
+	}
+
+	for {
+		n, err := archiveGlobalChat(cutoff)
+		res.GlobalMessages += n
+		if err != nil {
+			return res, err
+		}
+		if n < batchSize*10 {
+			break
+		}
+	}
+
+	deleted := deletedChat(deletedCutoff).Delete(&chatMessage{})
+	if deleted.Error != nil {
+		return res, deleted.Error
+	}
+	res.DeletedMessages = int(deleted.RowsAffected)
+
+	logrus.Info(fmt.Sprintf("Archived %d lobbies and %d global chat messages, purged %d deleted messages",
+		res.Lobbies, res.GlobalMessages, res.DeletedMessages))
+	return res, nil
+}
+
+// Schedule registers a job which runs Run every HELEN_RETENTION_INTERVAL, if
+// retention is enabled
+func Schedule() {
+	if config.Constants.RetentionAge <= 0 {
+		return
+	}
+
+	job.Every("archiveOldLobbies", config.Constants.RetentionInterval, func() error {
+		_, err := Run()
+		return err
+	})
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2015  TF2Stadium
+// Use of this source code is governed by the GPLv3
+// that can be found in the COPYING file.
+
+package retention_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TF2Stadium/Helen/config"
+	db "github.com/TF2Stadium/Helen/database"
+	_ "github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/testhelpers"
+	"github.com/TF2Stadium/Helen/models/chat"
+	"github.com/TF2Stadium/Helen/models/lobby"
+	"github.com/TF2Stadium/Helen/models/player"
+	. "github.com/TF2Stadium/Helen/models/retention"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	testhelpers.CleanupDB()
+}
+
+// setup enables retention with a temporary export directory
+func setup(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "helen-archive")
+	require.NoError(t, err)
+
+	config.Constants.RetentionAge = 24 * time.Hour
+	config.Constants.RetentionDeletedChatAge = time.Hour
+	config.Constants.RetentionExportDir = dir
+	return dir, func() {
+		config.Constants.RetentionAge = 0
+		os.RemoveAll(dir)
+	}
+}
+
+func age(table string, id uint, column string, d time.Duration) {
+	db.DB.Exec("UPDATE "+table+" SET "+column+" = ? WHERE id = ?", time.Now().Add(-d), id)
+}
+
+func TestRetentionDisabled(t *testing.T) {
+	config.Constants.RetentionAge = 0
+
+	_, err := Run()
+	assert.Equal(t, ErrDisabled, err)
+	_, err = Eligible()
+	assert.Equal(t, ErrDisabled, err)
+}
+
+func TestArchiveLobbies(t *testing.T) {
+	dir, cleanup := setup(t)
+	defer cleanup()
+
+	p := testhelpers.CreatePlayer()
+	p.Stats.PlayedSixesCount = 1
+	db.DB.Save(&p.Stats)
+
+	old := testhelpers.CreateLobby()
+	require.NoError(t, old.AddPlayer(p, 0, ""))
+	msg := chat.NewChatMessage("gg", int(old.ID), p)
+	msg.Save()
+	require.NoError(t, db.DB.Create(&lobby.SeriesGame{LobbyID: old.ID, MapName: "cp_badlands", RedScore: 5}).Error)
+	old.Close(false, false)
+	age("lobbies", old.ID, "updated_at", 48*time.Hour)
+
+	// ended recently
+	recent := testhelpers.CreateLobby()
+	recent.Close(false, false)
+
+	// still waiting for players
+	waiting := testhelpers.CreateLobby()
+	defer waiting.Close(false, false)
+	age("lobbies", waiting.ID, "updated_at", 48*time.Hour)
+
+	eligible, err := Eligible()
+	require.NoError(t, err)
+	assert.Equal(t, 1, eligible.Lobbies)
+
+	res, err := Run()
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Lobbies)
+
+	var count int
+	db.DB.Unscoped().Model(&lobby.Lobby{}).Where("id = ?", old.ID).Count(&count)
+	assert.Zero(t, count)
+	db.DB.Model(&lobby.LobbySlot{}).Where("lobby_id = ?", old.ID).Count(&count)
+	assert.Zero(t, count)
+	db.DB.Model(&chat.ChatMessage{}).Where("room = ?", old.ID).Count(&count)
+	assert.Zero(t, count)
+	db.DB.Model(&lobby.SeriesGame{}).Where("lobby_id = ?", old.ID).Count(&count)
+	assert.Zero(t, count)
+	db.DB.Model(&lobby.Lobby{}).Where("id IN (?)", []uint{recent.ID, waiting.ID}).Count(&count)
+	assert.Equal(t, 2, count)
+
+	var archive LobbyArchive
+	require.NoError(t, db.DB.First(&archive, old.ID).Error)
+	assert.Equal(t, "cp_badlands", archive.MapName)
+	var slots []ArchivedSlot
+	db.DB.Where("lobby_id = ?", old.ID).Find(&slots)
+	require.Len(t, slots, 1)
+	assert.Equal(t, p.ID, slots[0].PlayerID)
+
+	// stats aren't touched
+	var stats player.PlayerStats
+	db.DB.First(&stats, p.StatsID)
+	assert.Equal(t, 1, stats.PlayedSixesCount)
+
+	file, err := os.Open(filepath.Join(dir, archive.Export))
+	require.NoError(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err)
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 1)
+	assert.Len(t, lines[0]["slots"], 1)
+	assert.Len(t, lines[0]["chat"], 1)
+	assert.Len(t, lines[0]["series"], 1)
+}
+
+func TestArchiveChat(t *testing.T) {
+	dir, cleanup := setup(t)
+	defer cleanup()
+
+	p := testhelpers.CreatePlayer()
+	global := chat.NewChatMessage("hello", 0, p)
+	global.Save()
+	age("chat_messages", global.ID, "created_at", 48*time.Hour)
+
+	lob := testhelpers.CreateLobby()
+	defer lob.Close(false, false)
+	deleted := chat.NewChatMessage("deleted", int(lob.ID), p)
+	deleted.Deleted = true
+	deleted.Save()
+	age("chat_messages", deleted.ID, "created_at", 2*time.Hour)
+	kept := chat.NewChatMessage("kept", int(lob.ID), p)
+	kept.Save()
+
+	res, err := Run()
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.GlobalMessages)
+	assert.Equal(t, 1, res.DeletedMessages)
+
+	var ids []uint
+	db.DB.Model(&chat.ChatMessage{}).Where("id IN (?)", []uint{global.ID, deleted.ID, kept.ID}).Pluck("id", &ids)
+	assert.Equal(t, []uint{kept.ID}, ids)
+
+	files, err := filepath.Glob(filepath.Join(dir, "chat-*.jsonl.gz"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
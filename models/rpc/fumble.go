@@ -7,7 +7,7 @@ func FumbleLobbyCreated(lobbyID uint) error {
 		return nil
 	}
 
-	err := fumble.Call("Fumble.CreateLobby", lobbyID, &struct{}{})
+	err := call(fumble, "Fumble.CreateLobby", lobbyID, &struct{}{})
 
 	if err != nil {
 		logrus.Error(err)
@@ -22,7 +22,7 @@ func FumbleLobbyEnded(lobbyID uint) {
 		return
 	}
 
-	err := fumble.Call("Fumble.EndLobby", lobbyID, &struct{}{})
+	err := call(fumble, "Fumble.EndLobby", lobbyID, &struct{}{})
 	if err != nil {
 		logrus.Error(err)
 	}
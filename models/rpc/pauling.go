@@ -25,11 +25,11 @@ type Args struct {
 
 func DisallowPlayer(lobbyId uint, steamId string, playerID uint) error {
 	if !*paulingDisabled {
-		pauling.Call("Pauling.DisallowPlayer", &Args{Id: lobbyId, SteamId: steamId}, &struct{}{})
+		call(pauling, "Pauling.DisallowPlayer", &Args{Id: lobbyId, SteamId: steamId}, &struct{}{})
 	}
 
 	if !*fumbleDisabled {
-		fumble.Call("Fumble.RemovePlayer", playerID, &struct{}{})
+		call(fumble, "Fumble.RemovePlayer", playerID, &struct{}{})
 	}
 
 	return nil
@@ -48,41 +48,41 @@ func SetupServer(lobbyId uint, info gameserver.ServerRecord, lobbyType format.Fo
 		League:    league,
 		Whitelist: whitelist,
 		Map:       mapName}
-	return pauling.Call("Pauling.SetupServer", args, &struct{}{})
+	return call(pauling, "Pauling.SetupServer", args, &struct{}{})
 }
 
 func ReExecConfig(lobbyId uint, changeMap bool) error {
 	if *paulingDisabled {
 		return nil
 	}
-	return pauling.Call("Pauling.ReExecConfig", &Args{Id: lobbyId, ChangeMap: changeMap}, &struct{}{})
+	return call(pauling, "Pauling.ReExecConfig", &Args{Id: lobbyId, ChangeMap: changeMap}, &struct{}{})
 }
 
 func VerifyInfo(info gameserver.ServerRecord) error {
 	if *paulingDisabled {
 		return nil
 	}
-	return pauling.Call("Pauling.VerifyInfo", &info, &struct{}{})
+	return call(pauling, "Pauling.VerifyInfo", &info, &struct{}{})
 }
 
 func End(lobbyId uint) {
 	if *paulingDisabled {
 		return
 	}
-	pauling.Call("Pauling.End", &Args{Id: lobbyId}, &struct{}{})
+	call(pauling, "Pauling.End", &Args{Id: lobbyId}, &struct{}{})
 }
 
 func Say(lobbyId uint, text string) {
 	if *paulingDisabled {
 		return
 	}
-	pauling.Call("Pauling.Say", &Args{Id: lobbyId, Text: text}, &struct{}{})
+	call(pauling, "Pauling.Say", &Args{Id: lobbyId, Text: text}, &struct{}{})
 }
 
 func serverExists(lobbyID uint) (exists bool) {
 	if *paulingDisabled {
 		return false
 	}
-	pauling.Call("Pauling.Exists", lobbyID, &exists)
+	call(pauling, "Pauling.Exists", lobbyID, &exists)
 	return
 }
@@ -7,9 +7,11 @@ package rpc
 import (
 	"flag"
 	"net/rpc"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/TF2Stadium/Helen/config"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 	"github.com/streadway/amqp"
 	"github.com/vibhavp/amqp-rpc"
 )
@@ -50,3 +52,11 @@ func ConnectRPC(amqpConn *amqp.Connection) {
 		twitchbot = rpc.NewClientWithCodec(codec)
 	}
 }
+
+// call calls the given method, recording its latency and whether it failed
+func call(client *rpc.Client, method string, args interface{}, reply interface{}) error {
+	start := time.Now()
+	err := client.Call(method, args, reply)
+	metrics.ObserveRPC(method, start, err)
+	return err
+}
@@ -4,14 +4,14 @@ func TwitchBotJoin(channel string) {
 	if *twitchbotDisabled {
 		return
 	}
-	twitchbot.Call("TwitchBot.Join", channel, &struct{}{})
+	call(twitchbot, "TwitchBot.Join", channel, &struct{}{})
 }
 
 func TwitchBotLeave(channel string) {
 	if *twitchbotDisabled {
 		return
 	}
-	twitchbot.Call("TwitchBot.Leave", channel, &struct{}{})
+	call(twitchbot, "TwitchBot.Leave", channel, &struct{}{})
 }
 
 func TwitchBotAnnouce(channel string, lobbyid uint) {
@@ -5,16 +5,37 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"time"
+	"sync"
 
-	"github.com/sirupsen/logrus"
 	"github.com/TF2Stadium/Helen/config"
+	"github.com/TF2Stadium/Helen/controllers/broadcaster"
 	"github.com/TF2Stadium/Helen/helpers"
 	"github.com/TF2Stadium/Helen/models/chat"
 )
 
-func TFTVStreamStatusUpdater() {
-	ticker := time.NewTicker(5 * time.Minute)
+var (
+	tftvMu        = new(sync.Mutex)
+	tftvStreaming bool
+)
+
+func init() {
+	// the job can run on a different instance every time, so the stream
+	// status is shared with the other instances
+	broadcaster.HandleNotification("tftvStreaming", func(data json.RawMessage) {
+		var streaming bool
+		if err := json.Unmarshal(data, &streaming); err != nil {
+			return
+		}
+		tftvMu.Lock()
+		tftvStreaming = streaming
+		tftvMu.Unlock()
+	})
+}
+
+// UpdateTFTVStreamStatus sends a message to the global chat when
+// twitch.tv/teamfortresstv goes live. It's meant to be run periodically, as
+// a job.
+func UpdateTFTVStreamStatus() error {
 	u := &url.URL{
 		Scheme: "https",
 		Host:   "api.twitch.tv",
@@ -34,28 +55,35 @@ func TFTVStreamStatusUpdater() {
 			} `json:"channel"`
 		} `json:"streams"`
 	}
-	var streaming bool
 
-	for {
-		req, _ := http.NewRequest("GET", u.String(), nil)
-		req.Header.Set("Accept", "application/vnd.twitchtv.v3+json")
-		req.Header.Add("Client-ID", config.Constants.TwitchClientID)
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req.Header.Set("Accept", "application/vnd.twitchtv.v3+json")
+	req.Header.Add("Client-ID", config.Constants.TwitchClientID)
 
-		resp, err := helpers.HTTPClient.Do(req)
-		if err != nil {
-			logrus.Error(err)
-			continue
-		}
+	resp, err := helpers.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-		json.NewDecoder(resp.Body).Decode(&reply)
-		if reply.Total != 0 && !streaming {
-			str := fmt.Sprintf(`twitch.tv/teamfortresstv is live with "%s"`, reply.Streams[0].Channel.Status)
-			message := chat.NewBotMessage(str, 0)
-			message.Send()
-			streaming = true
-		} else {
-			streaming = false
-		}
-		<-ticker.C
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return err
+	}
+
+	streaming := reply.Total != 0 && len(reply.Streams) != 0
+	tftvMu.Lock()
+	changed := streaming != tftvStreaming
+	tftvStreaming = streaming
+	tftvMu.Unlock()
+	if !changed {
+		return nil
+	}
+
+	broadcaster.Notify("tftvStreaming", streaming)
+	if streaming {
+		str := fmt.Sprintf(`twitch.tv/teamfortresstv is live with "%s"`, reply.Streams[0].Channel.Status)
+		message := chat.NewBotMessage(str, 0)
+		message.Send()
 	}
+	return nil
 }
@@ -10,10 +10,13 @@ import (
 	"github.com/TF2Stadium/Helen/config"
 	"github.com/TF2Stadium/Helen/controllers"
 	"github.com/TF2Stadium/Helen/controllers/admin"
+	"github.com/TF2Stadium/Helen/controllers/api"
 	chelpers "github.com/TF2Stadium/Helen/controllers/controllerhelpers"
+	"github.com/TF2Stadium/Helen/controllers/health"
 	"github.com/TF2Stadium/Helen/controllers/login"
 	"github.com/TF2Stadium/Helen/controllers/stats"
 	"github.com/TF2Stadium/Helen/helpers"
+	"github.com/TF2Stadium/Helen/internal/metrics"
 )
 
 type route struct {
@@ -26,6 +29,7 @@ var routes = []route{
 	{"/openidcallback", login.SteamLoginCallbackHandler},
 	{"/startLogin", login.SteamLoginHandler},
 	{"/logout", login.SteamLogoutHandler},
+	{"/refresh", login.RefreshHandler},
 	{"/websocket/", controllers.SocketHandler},
 	{"/startMockLogin", login.SteamMockLoginHandler},
 	{"/startTwitchLogin", login.TwitchLoginHandler},
@@ -34,13 +38,45 @@ var routes = []route{
 
 	{"/admin", chelpers.FilterHTTPRequest(helpers.ActionViewPage, admin.ServeAdminPage)},
 	{"/admin/roles", chelpers.FilterHTTPRequest(helpers.ActionViewPage, admin.ChangeRole)},
-	{"/admin/ban", chelpers.FilterHTTPRequest(helpers.ActionViewPage, admin.BanPlayer)},
+	{"/admin/ban", chelpers.FilterHTTPRequest(helpers.ActionBanPlayers, admin.BanPlayer)},
 	{"/admin/chatlogs", chelpers.FilterHTTPRequest(helpers.ActionViewLogs, admin.GetChatLogs)},
 	{"/admin/banlogs", chelpers.FilterHTTPRequest(helpers.ActionViewLogs, admin.GetBanLogs)},
 	{"/admin/server/", chelpers.FilterHTTPRequest(helpers.ModifyServers, admin.ViewServerPage)},
 	{"/admin/server/add", chelpers.FilterHTTPRequest(helpers.ModifyServers, admin.AddServer)},
 	{"/admin/server/remove", chelpers.FilterHTTPRequest(helpers.ModifyServers, admin.RemoveServer)},
 	{"/admin/lobbies", chelpers.FilterHTTPRequest(helpers.ActionViewLogs, admin.ViewOpenLobbies)},
+	{"/admin/jobs", chelpers.FilterHTTPRequest(helpers.ActionViewLogs, admin.ViewJobs)},
+	{"/admin/jobs/retry", chelpers.FilterHTTPRequest(helpers.ActionManageJobs, admin.RetryJob)},
+	{"/admin/jobs/delete", chelpers.FilterHTTPRequest(helpers.ActionManageJobs, admin.DeleteJob)},
+	{"/admin/lobbysettings", chelpers.FilterHTTPRequest(helpers.ActionModifySettings, admin.ViewLobbySettings)},
+	{"/admin/lobbysettings/save", chelpers.FilterHTTPRequest(helpers.ActionModifySettings, admin.SaveLobbySettings)},
+
+	{"/api/admin/v1/xsrf", chelpers.FilterAPIRequest("GET", helpers.ActionViewPage, admin.APIGetXSRFToken)},
+	{"/api/admin/v1/ban", chelpers.FilterAPIRequest("POST", helpers.ActionBanPlayers, admin.APIBanPlayer)},
+	{"/api/admin/v1/unban", chelpers.FilterAPIRequest("POST", helpers.ActionBanPlayers, admin.APIUnbanPlayer)},
+	{"/api/admin/v1/banlogs", chelpers.FilterAPIRequest("GET", helpers.ActionViewLogs, admin.APIGetBanLogs)},
+	{"/api/admin/v1/chatlogs", chelpers.FilterAPIRequest("GET", helpers.ActionViewLogs, admin.APIGetChatLogs)},
+	{"/api/admin/v1/roles", chelpers.FilterAPIRequest("POST", helpers.ActionChangeRole, admin.APIChangeRole)},
+	{"/api/admin/v1/servers", chelpers.FilterAPIRequest("GET", helpers.ModifyServers, admin.APIGetServers)},
+	{"/api/admin/v1/servers/add", chelpers.FilterAPIRequest("POST", helpers.ModifyServers, admin.APIAddServer)},
+	{"/api/admin/v1/servers/remove", chelpers.FilterAPIRequest("POST", helpers.ModifyServers, admin.APIRemoveServer)},
+	{"/api/admin/v1/lobbies", chelpers.FilterAPIRequest("GET", helpers.ActionViewLogs, admin.APIGetLobbies)},
+	{"/api/admin/v1/jobs", chelpers.FilterAPIRequest("GET", helpers.ActionViewLogs, admin.APIGetJobs)},
+	{"/api/admin/v1/jobs/retry", chelpers.FilterAPIRequest("POST", helpers.ActionManageJobs, admin.APIRetryJob)},
+	{"/api/admin/v1/jobs/delete", chelpers.FilterAPIRequest("POST", helpers.ActionManageJobs, admin.APIDeleteJob)},
+	{"/api/admin/v1/lobbysettings", chelpers.FilterAPIRequest("GET", helpers.ActionModifySettings, admin.APIGetLobbySettings)},
+	{"/api/admin/v1/lobbysettings/put", chelpers.FilterAPIRequest("POST", helpers.ActionModifySettings, admin.APIPutLobbySetting)},
+	{"/api/admin/v1/lobbysettings/delete", chelpers.FilterAPIRequest("POST", helpers.ActionModifySettings, admin.APIDeleteLobbySetting)},
+	{"/api/admin/v1/apitokens", chelpers.FilterAPIRequest("GET", helpers.ActionViewLogs, admin.APIGetAPITokens)},
+	{"/api/admin/v1/apitokens/revoke", chelpers.FilterAPIRequest("POST", helpers.ActionRevokeTokens, admin.APIRevokeAPIToken)},
+	{"/api/admin/v1/sessions", chelpers.FilterAPIRequest("GET", helpers.ActionViewLogs, admin.APIGetSessions)},
+	{"/api/admin/v1/sessions/revoke", chelpers.FilterAPIRequest("POST", helpers.ActionChangeRole, admin.APIRevokeSessions)},
+
+	{"/api/v1/lobbies", api.GetLobbies},
+	{"/api/v1/lobbies/", api.GetLobby},
+	{"/api/v1/players/", api.GetPlayer},
+	{"/api/v1/substitutes", api.GetSubstitutes},
+	{"/api/v1/events", api.GetEvents},
 
 	{"/stats", stats.StatsHandler},
 	{"/badge/", controllers.TwitchBadge},
@@ -51,6 +87,11 @@ func SetupHTTP(mux *http.ServeMux) {
 	for _, route := range routes {
 		mux.HandleFunc(route.pattern, route.handler)
 	}
+	mux.Handle("/metrics", metrics.Handler())
+	if config.Constants.HealthChecks {
+		mux.HandleFunc("/healthz", health.Healthz)
+		mux.HandleFunc("/readyz", health.Readyz)
+	}
 	mux.Handle("/demos/", http.StripPrefix("/demos/", http.FileServer(http.Dir(config.Constants.DemosFolder))))
 
 	if config.Constants.ServeStatic {